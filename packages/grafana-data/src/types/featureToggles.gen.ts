@@ -47,4 +47,9 @@ export interface FeatureToggles {
   annotationComments?: boolean;
   migrationLocking?: boolean;
   fileStoreApi?: boolean;
+  fileStoreFs?: boolean;
+  fileStoreS3?: boolean;
+  fileStoreGcs?: boolean;
+  fileStoreAzure?: boolean;
+  fileStoreDb?: boolean;
 }
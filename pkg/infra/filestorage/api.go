@@ -3,6 +3,11 @@ package filestorage
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
@@ -13,12 +18,72 @@ const (
 	StorageNamePublic StorageName = "public"
 )
 
+// SortBy is the field the files of a page are sorted by.
+type SortBy string
+
+const (
+	SortByName     SortBy = "name"
+	SortBySize     SortBy = "size"
+	SortByModified SortBy = "modified"
+)
+
+type Operation string
+
+const (
+	OperationGet          Operation = "get"
+	OperationDelete       Operation = "delete"
+	OperationUpsert       Operation = "upsert"
+	OperationListFiles    Operation = "list_files"
+	OperationListFolders  Operation = "list_folders"
+	OperationCreateFolder Operation = "create_folder"
+	OperationDeleteFolder Operation = "delete_folder"
+	OperationCopy         Operation = "copy"
+	OperationMove         Operation = "move"
+)
+
+var allOperations = []Operation{
+	OperationGet,
+	OperationDelete,
+	OperationUpsert,
+	OperationListFiles,
+	OperationListFolders,
+	OperationCreateFolder,
+	OperationDeleteFolder,
+	OperationCopy,
+	OperationMove,
+}
+
+var readOperations = []Operation{
+	OperationGet,
+	OperationListFiles,
+	OperationListFolders,
+}
+
 var (
 	ErrRelativePath          = errors.New("path cant be relative")
 	ErrNonCanonicalPath      = errors.New("path must be canonical")
 	ErrPathTooLong           = errors.New("path is too long")
 	ErrPathInvalid           = errors.New("path is invalid")
 	ErrPathEndsWithDelimiter = errors.New("path can not end with delimiter")
+	ErrCrossBackendOperation = errors.New("operation across different backends is not supported")
+	ErrFileTooLarge          = errors.New("file is too large")
+	ErrFolderNotEmpty        = errors.New("folder is not empty")
+	ErrFolderAlreadyExists   = errors.New("folder already exists")
+	ErrOperationNotSupported = errors.New("operation is not supported")
+	ErrFileNotFound          = errors.New("file not found")
+	ErrTrashNotEnabled       = errors.New("trash is not enabled")
+	ErrVersioningNotEnabled  = errors.New("versioning is not enabled")
+	ErrQuotaExceeded         = errors.New("storage quota exceeded")
+	ErrSignedURLUnsupported  = errors.New("signed urls are not supported by the storage")
+	ErrPreconditionFailed    = errors.New("precondition failed")
+	ErrEncryptionUnsupported = errors.New("server-side encryption is not supported by the storage")
+	ErrBackendTimeout        = errors.New("file storage backend timed out")
+	ErrConcurrentAppend      = errors.New("file was modified while appending to it")
+	ErrAlreadyExists         = errors.New("file already exists")
+	ErrInvalidProperties     = errors.New("invalid file properties")
+	ErrBackendNotFound       = errors.New("file storage backend not found")
+	ErrPathProtected         = errors.New("path is protected")
+	ErrPathNotAllowed        = errors.New("path is not allowed by the path filters")
 	Delimiter                = "/"
 )
 
@@ -27,7 +92,16 @@ func Join(parts ...string) string {
 }
 
 func belongsToStorage(path string, storageName StorageName) bool {
-	return strings.HasPrefix(path, Delimiter+string(storageName))
+	name, ok := storageNameOf(path)
+	return ok && name == string(storageName)
+}
+
+// storageNameOf returns the first segment of an absolute path, which is the name of the storage it belongs to.
+func storageNameOf(path string) (string, bool) {
+	if !strings.HasPrefix(path, Delimiter) {
+		return "", false
+	}
+	return strings.SplitN(strings.TrimPrefix(path, Delimiter), Delimiter, 2)[0], true
 }
 
 type File struct {
@@ -35,6 +109,8 @@ type File struct {
 	FileMetadata
 }
 
+// FileMetadata describes a file. Modified is the time the file was last written and Created the time it was first written.
+// Created is zero if the backend does not track it, which is the case for the fs backend.
 type FileMetadata struct {
 	Name       string
 	FullPath   string
@@ -43,12 +119,21 @@ type FileMetadata struct {
 	Created    time.Time
 	Size       int64
 	Properties map[string]string
+	// ETag identifies the version of the file contents. It is empty if the backend can not provide one.
+	ETag string
+	// Version identifies a stored version of the file. It is only set for versions returned by ListVersions and GetVersion.
+	Version string
+	// FileCount is the number of files directly in a folder. It is only set for folders listed with IncludeFileCount.
+	FileCount int
 }
 
 type ListFilesResponse struct {
 	Files    []FileMetadata
 	HasMore  bool
 	LastPath string
+	// TotalCount is the number of files matching the listing across all pages. It is only set when listing with
+	// IncludeTotalCount, and is zero otherwise.
+	TotalCount int
 }
 
 type Paging struct {
@@ -57,21 +142,73 @@ type Paging struct {
 }
 
 type UpsertFileCommand struct {
-	Path       string
-	MimeType   string
-	Contents   *[]byte
+	Path     string
+	MimeType string
+	Contents *[]byte
+	// Properties are custom key/value pairs stored along with the file, e.g. its owner. They are returned in the
+	// FileMetadata of the file. Blob backends store them as object metadata and only accept lower case keys.
 	Properties map[string]string
+	// IfMatchETag makes the upsert fail with ErrPreconditionFailed unless the file exists and its ETag matches. Blob
+	// storage backends only check it against the conditional writes of the same Grafana instance.
+	IfMatchETag string
+	// CreateOnly makes the upsert fail with ErrAlreadyExists if the file exists instead of overwriting it.
+	CreateOnly bool
 }
 
+// PathFilter decides which paths of a backend can be accessed. Paths are absolute paths within the backend.
+type PathFilter interface {
+	IsAllowed(path string) bool
+}
+
+// PathFilters is a PathFilter matching prefixes and exact paths. Unlike other filters, the prefixes and paths are
+// pushed down to the backend queries when listing.
 type PathFilters struct {
 	allowedPrefixes []string
+	allowedPaths    []string
+	deniedPrefixes  []string
+}
+
+// NewPathFilters creates filters permitting paths which match any of the allowed prefixes, or any path if there are none,
+// unless they match one of the denied prefixes. Prefixes are matched case-insensitively.
+func NewPathFilters(allowedPrefixes []string, deniedPrefixes []string) *PathFilters {
+	return &PathFilters{
+		allowedPrefixes: allowedPrefixes,
+		deniedPrefixes:  deniedPrefixes,
+	}
+}
+
+// NewPathFiltersWithAllowedPaths creates filters like NewPathFilters which also permit the allowed paths, matched
+// exactly and case-insensitively, e.g. to expose a single file with `/logo.png`. The denied prefixes still win.
+func NewPathFiltersWithAllowedPaths(allowedPrefixes []string, allowedPaths []string, deniedPrefixes []string) *PathFilters {
+	return &PathFilters{
+		allowedPrefixes: allowedPrefixes,
+		allowedPaths:    allowedPaths,
+		deniedPrefixes:  deniedPrefixes,
+	}
 }
 
-func (f *PathFilters) isAllowed(path string) bool {
-	if f == nil || f.allowedPrefixes == nil {
+func (f *PathFilters) IsAllowed(path string) bool {
+	if f == nil {
 		return true
 	}
 
+	path = strings.ToLower(path)
+	for i := range f.deniedPrefixes {
+		if strings.HasPrefix(path, strings.ToLower(f.deniedPrefixes[i])) {
+			return false
+		}
+	}
+
+	if len(f.allowedPrefixes) == 0 && len(f.allowedPaths) == 0 {
+		return true
+	}
+
+	for i := range f.allowedPaths {
+		if path == strings.ToLower(f.allowedPaths[i]) {
+			return true
+		}
+	}
+
 	for i := range f.allowedPrefixes {
 		if strings.HasPrefix(path, strings.ToLower(f.allowedPrefixes[i])) {
 			return true
@@ -81,21 +218,315 @@ func (f *PathFilters) isAllowed(path string) bool {
 	return false
 }
 
+// RegexPathFilter is a PathFilter matching regular expressions, e.g. for backends keying the files by hashed names.
+type RegexPathFilter struct {
+	allowedPatterns []*regexp.Regexp
+	deniedPatterns  []*regexp.Regexp
+}
+
+// NewRegexPathFilter creates a filter permitting paths which match any of the allowed patterns, or any path if there
+// are none, unless they match one of the denied patterns. Patterns use the `regexp` syntax, are matched
+// case-insensitively and are not anchored.
+func NewRegexPathFilter(allowedPatterns []string, deniedPatterns []string) (*RegexPathFilter, error) {
+	allowed, err := compilePathPatterns(allowedPatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	denied, err := compilePathPatterns(deniedPatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RegexPathFilter{
+		allowedPatterns: allowed,
+		deniedPatterns:  denied,
+	}, nil
+}
+
+func compilePathPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func (f *RegexPathFilter) IsAllowed(path string) bool {
+	if f == nil {
+		return true
+	}
+
+	for _, re := range f.deniedPatterns {
+		if re.MatchString(path) {
+			return false
+		}
+	}
+
+	if len(f.allowedPatterns) == 0 {
+		return true
+	}
+
+	for _, re := range f.allowedPatterns {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pathFilterChain permits the paths permitted by all of its filters.
+type pathFilterChain []PathFilter
+
+func (c pathFilterChain) IsAllowed(path string) bool {
+	for _, filter := range c {
+		if filter != nil && !filter.IsAllowed(path) {
+			return false
+		}
+	}
+	return true
+}
+
+type CreateFolderOptions struct {
+	// FailIfExists makes creating an existing folder fail with ErrFolderAlreadyExists instead of doing nothing.
+	FailIfExists bool
+	// filter holds the path filter of the backend, the missing parent folders it does not permit are not created.
+	filter PathFilter
+}
+
+// isAllowed checks the folder path against the path filter of the backend.
+func (o *CreateFolderOptions) isAllowed(path string) bool {
+	return o == nil || o.filter == nil || o.filter.IsAllowed(path)
+}
+
+type DeleteFolderOptions struct {
+	// Force deletes the folder along with all of its contents. Non-empty folders are not deleted otherwise.
+	Force bool
+}
+
 type ListOptions struct {
+	// Recursive includes the files of all nested folders. Files are listed in lexicographical order of their full paths,
+	// which is also the order the paging cursor resumes in.
 	Recursive bool
+	// DirectChildrenOnly makes explicit that only the immediate children of the folder are listed, which is also
+	// the behavior when Recursive is not set. It can not be combined with Recursive.
+	DirectChildrenOnly bool
+	// Filter is a glob pattern, e.g. `*.json` or `dash-*`, matched against the name of each listed file,
+	// i.e. the part of its path after the last delimiter. Patterns follow the `path.Match` syntax and are matched
+	// case-insensitively. Wildcards never match the delimiter, so patterns containing it do not match any file.
+	// It is applied on top of the path filters and ignored when listing folders. Empty filter matches all files.
+	Filter string
+	// MimeTypeFilter limits the listed files to the given MIME types. Entries can use a wildcard subtype, e.g. `image/*`.
+	// Parameters such as the charset are ignored. It is ignored when listing folders. Empty filter matches all files.
+	MimeTypeFilter []string
+	// MaxResults caps the number of files returned by a single ListFiles call. It applies on top of the page size
+	// of the paging cursor and `HasMore` is set if more files are left. Zero means the backend default.
+	MaxResults int
+	// IncludeFileCount sets the FileCount of the listed folders. The count is best-effort: at most 1000 files
+	// are counted per folder, so that large folders are not listed in full, and larger folders report 1000.
+	// It is ignored when listing files.
+	IncludeFileCount bool
+	// IncludeTotalCount sets the TotalCount of the response. Object stores can not count keys, so the blob storage
+	// backend walks every key under the folder for each ListFiles call, and reads the attributes of every file when
+	// MimeTypeFilter is set. It is only supported by the blob storage backend and ignored when listing folders.
+	IncludeTotalCount bool
+	// SortBy sorts the files of each page by the field, descending if SortDesc is set. Files with equal values keep
+	// the order of their paths. Pages are still cut in the order of the paths, as that is the order the paging cursor
+	// resumes in, so the order only holds within a page: the files of the next page can sort before the files of
+	// the current one. List all files in a single page to sort them all. Empty SortBy keeps the order of the paths.
+	// It is only supported by the blob storage backend and ignored when listing folders.
+	SortBy   SortBy
+	SortDesc bool
+	// MaxDepth bounds how deep a recursive listing walks: the files directly in the listed folder are at depth 1,
+	// the files of its subfolders at depth 2 and so on. `HasMore` is set if the page left out deeper folders, the
+	// next page resumes after them. A recursive ListFolders lists the folders down to the same depth, the folders
+	// directly in the listed folder being at depth 1. Zero means unlimited. It is only supported by the blob storage
+	// backend.
+	MaxDepth int
 	PathFilters
+	// filter holds the path filter of the backend which can not be pushed down as prefixes.
+	filter PathFilter
+}
+
+// isAllowed checks the path against the prefixes and the other path filter of the backend.
+func (o *ListOptions) isAllowed(path string) bool {
+	return o.PathFilters.IsAllowed(path) && (o.filter == nil || o.filter.IsAllowed(path))
+}
+
+// pageSize limits the requested number of files to MaxResults.
+func (o *ListOptions) pageSize(first int) int {
+	if o == nil || o.MaxResults <= 0 || o.MaxResults >= first {
+		return first
+	}
+	return o.MaxResults
+}
+
+func (o *ListOptions) matchesFilter(name string) bool {
+	if o == nil || o.Filter == "" {
+		return true
+	}
+
+	matched, err := path.Match(strings.ToLower(o.Filter), strings.ToLower(name))
+	return err == nil && matched
+}
+
+func (o *ListOptions) matchesMimeType(mimeType string) bool {
+	if o == nil || len(o.MimeTypeFilter) == 0 {
+		return true
+	}
+
+	mimeType = normalizeMimeType(mimeType)
+	for _, allowed := range o.MimeTypeFilter {
+		allowed = normalizeMimeType(allowed)
+		if allowed == mimeType {
+			return true
+		}
+
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func normalizeMimeType(mimeType string) string {
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(mimeType))
+}
+
+func (o *ListOptions) validate() error {
+	if o != nil && o.Recursive && o.DirectChildrenOnly {
+		return fmt.Errorf("invalid list options: Recursive and DirectChildrenOnly are mutually exclusive")
+	}
+
+	if o != nil && o.MaxDepth < 0 {
+		return fmt.Errorf("invalid list options: MaxDepth can not be negative")
+	}
+
+	if o != nil {
+		switch o.SortBy {
+		case "", SortByName, SortBySize, SortByModified:
+		default:
+			return fmt.Errorf("invalid list options: unknown sort field %q", o.SortBy)
+		}
+	}
+	return o.validateFilter()
+}
+
+// sortFiles sorts the files by the SortBy field of the options. The sort is stable, so that files with equal values
+// keep the order of their paths.
+func (o *ListOptions) sortFiles(files []FileMetadata) {
+	if o == nil || o.SortBy == "" {
+		return
+	}
+
+	less := func(a FileMetadata, b FileMetadata) bool {
+		switch o.SortBy {
+		case SortBySize:
+			return a.Size < b.Size
+		case SortByModified:
+			return a.Modified.Before(b.Modified)
+		default:
+			return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if o.SortDesc {
+			return less(files[j], files[i])
+		}
+		return less(files[i], files[j])
+	})
+}
+
+func (o *ListOptions) validateFilter() error {
+	if o == nil || o.Filter == "" {
+		return nil
+	}
+
+	if _, err := path.Match(o.Filter, ""); err != nil {
+		return fmt.Errorf("invalid filter %q: %w", o.Filter, err)
+	}
+	return nil
 }
 
 type FileStorage interface {
+	// Get returns the file along with its contents. It returns ErrFileNotFound if the file does not exist.
 	Get(ctx context.Context, path string) (*File, error)
+	// GetReader returns a reader over the contents of the file, the caller is responsible for closing it. It returns
+	// ErrFileNotFound if the file does not exist.
+	GetReader(ctx context.Context, path string) (io.ReadCloser, *FileMetadata, error)
+	// Open returns the metadata of the file along with a seekable reader over its contents, e.g. to serve HTTP range
+	// requests. Backends supporting range reads only read the requested parts, the others read the whole file into
+	// memory. The caller is responsible for closing the reader. It returns ErrFileNotFound if the file does not exist.
+	Open(ctx context.Context, path string) (*FileMetadata, io.ReadSeekCloser, error)
+	// GetMetadata returns the metadata of the file without reading its contents. It returns ErrFileNotFound if the file does not exist.
+	GetMetadata(ctx context.Context, path string) (*FileMetadata, error)
+	// SignedURL returns a URL granting direct read access to the file in the underlying bucket for the given time.
+	// It does not check whether the file exists. It returns ErrSignedURLUnsupported if the backend can not sign URLs.
+	SignedURL(ctx context.Context, path string, ttl time.Duration) (string, error)
+	// SignedUploadURL returns a URL accepting a PUT of the contents of the file for the given time. The content type
+	// has to be sent along with the upload if it is not empty. Uploads go to the underlying bucket directly, they are
+	// not seen by the listeners. It returns ErrSignedURLUnsupported if the backend can not sign upload URLs.
+	SignedUploadURL(ctx context.Context, path string, ttl time.Duration, contentType string) (string, error)
+	// Exists reports whether the file exists without reading it. A missing file is not an error.
+	Exists(ctx context.Context, path string) (bool, error)
+	// Delete deletes the file, or moves it to the trash if the trash is enabled. It returns ErrFileNotFound if the
+	// file does not exist.
 	Delete(ctx context.Context, path string) error
+	// DeleteMany deletes the files and returns the paths of the deleted ones along with the errors by path.
+	// Missing files are neither deleted nor failed.
+	DeleteMany(ctx context.Context, paths []string) (deleted []string, errs map[string]error)
 	Upsert(ctx context.Context, command *UpsertFileCommand) error
+	// UpsertStream writes the file with the contents read from the reader, without holding them all in memory if
+	// the backend can write streams. The command may be nil, it carries the other fields of the upsert, its path and
+	// contents are ignored. The reader is not closed.
+	UpsertStream(ctx context.Context, path string, r io.Reader, command *UpsertFileCommand) error
+	// Append adds the data to the end of the file, creating the file if it does not exist. Backends which can not
+	// append natively rewrite the file, and return ErrConcurrentAppend if it was modified in the meantime.
+	Append(ctx context.Context, path string, data []byte) error
+	// Touch sets the modification time of the file to the current time without changing its contents. Backends
+	// which can not update the modification time in place rewrite the file. It returns ErrFileNotFound if the file
+	// does not exist.
+	Touch(ctx context.Context, path string) error
+	Copy(ctx context.Context, srcPath string, dstPath string) error
+	Move(ctx context.Context, srcPath string, dstPath string) error
 
 	ListFiles(ctx context.Context, folderPath string, paging *Paging, options *ListOptions) (*ListFilesResponse, error)
+	// ListFolders lists the folders under the folder sorted by path. With Recursive, which is the default when the
+	// options are nil, it returns the whole tree in one call, down to MaxDepth if set.
 	ListFolders(ctx context.Context, folderPath string, options *ListOptions) ([]FileMetadata, error)
+	// WalkFiles calls the function for every file ListFiles would list, as the backend lists them rather than
+	// collecting them into pages. The files are not sorted. It stops at the first error returned by the function
+	// and returns it.
+	WalkFiles(ctx context.Context, folderPath string, options *ListOptions, fn func(FileMetadata) error) error
+
+	// CreateFolder creates the folder along with its missing parents. Creating an existing folder succeeds unless
+	// FailIfExists is set.
+	CreateFolder(ctx context.Context, path string, options *CreateFolderOptions) error
+	DeleteFolder(ctx context.Context, path string, options *DeleteFolderOptions) error
+
+	// Restore recovers the most recently trashed version of the file or folder. It returns ErrTrashNotEnabled
+	// if deleted files are not kept by the backend.
+	Restore(ctx context.Context, path string) error
+	// ListVersions returns the stored versions of the file, the most recent first. GetVersion returns the contents
+	// of one of them. Both return ErrVersioningNotEnabled if the backend does not keep versions.
+	ListVersions(ctx context.Context, path string) ([]FileMetadata, error)
+	GetVersion(ctx context.Context, path string, version string) (*File, error)
+
+	// PurgeTrash permanently deletes all trashed versions of the file or folder, or the whole trash for the root path.
+	PurgeTrash(ctx context.Context, path string) error
 
-	CreateFolder(ctx context.Context, path string) error
-	DeleteFolder(ctx context.Context, path string) error
+	// HealthCheck returns an error if the storage is not reachable.
+	HealthCheck(ctx context.Context) error
 
 	close() error
 }
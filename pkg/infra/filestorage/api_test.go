@@ -60,6 +60,12 @@ func TestFilestorageApi_belongToStorage(t *testing.T) {
 			storage:  StorageNamePublic,
 			expected: false,
 		},
+		{
+			name:     "should return false if storage name is only a prefix of the first segment",
+			path:     "/publicdata/abc/d",
+			storage:  StorageNamePublic,
+			expected: false,
+		},
 		{
 			name:     "should return false if storage name does not match",
 			path:     "/notpublic/abc/d",
@@ -73,3 +79,241 @@ func TestFilestorageApi_belongToStorage(t *testing.T) {
 		})
 	}
 }
+
+func TestFilestorageApi_matchesFilter(t *testing.T) {
+	var tests = []struct {
+		name     string
+		filter   string
+		fileName string
+		expected bool
+	}{
+		{
+			name:     "should match all files if filter is empty",
+			filter:   "",
+			fileName: "file.json",
+			expected: true,
+		},
+		{
+			name:     "should match extension wildcard",
+			filter:   "*.json",
+			fileName: "dashboard.json",
+			expected: true,
+		},
+		{
+			name:     "should not match different extension",
+			filter:   "*.json",
+			fileName: "image.png",
+			expected: false,
+		},
+		{
+			name:     "should match prefix wildcard",
+			filter:   "dash-*",
+			fileName: "dash-1.json",
+			expected: true,
+		},
+		{
+			name:     "should match case-insensitively",
+			filter:   "DASH-*.JSON",
+			fileName: "dash-1.json",
+			expected: true,
+		},
+		{
+			name:     "should not match patterns containing the delimiter",
+			filter:   "folder/*.json",
+			fileName: "file.json",
+			expected: false,
+		},
+		{
+			name:     "should not match malformed patterns",
+			filter:   "[",
+			fileName: "[",
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := &ListOptions{Filter: tt.filter}
+			require.Equal(t, tt.expected, options.matchesFilter(tt.fileName))
+		})
+	}
+}
+
+func TestFilestorageApi_isAllowed(t *testing.T) {
+	var tests = []struct {
+		name     string
+		filters  *PathFilters
+		path     string
+		expected bool
+	}{
+		{
+			name:     "should allow any path if there are no filters",
+			filters:  nil,
+			path:     "/public/secrets/key",
+			expected: true,
+		},
+		{
+			name:     "should allow any path if both lists are empty",
+			filters:  NewPathFilters(nil, nil),
+			path:     "/public/secrets/key",
+			expected: true,
+		},
+		{
+			name:     "should allow paths matching an allowed prefix",
+			filters:  NewPathFilters([]string{"/public"}, nil),
+			path:     "/public/image.png",
+			expected: true,
+		},
+		{
+			name:     "should not allow paths not matching any allowed prefix",
+			filters:  NewPathFilters([]string{"/public"}, nil),
+			path:     "/private/image.png",
+			expected: false,
+		},
+		{
+			name:     "should not allow paths matching a denied prefix if the allow list is empty",
+			filters:  NewPathFilters(nil, []string{"/public/secrets"}),
+			path:     "/public/secrets/key",
+			expected: false,
+		},
+		{
+			name:     "should allow paths matching an allowed prefix but not the overlapping denied prefix",
+			filters:  NewPathFilters([]string{"/public"}, []string{"/public/secrets"}),
+			path:     "/public/image.png",
+			expected: true,
+		},
+		{
+			name:     "should not allow paths matching both an allowed and an overlapping denied prefix",
+			filters:  NewPathFilters([]string{"/public"}, []string{"/public/secrets"}),
+			path:     "/public/secrets/key",
+			expected: false,
+		},
+		{
+			name:     "should not allow paths matching a denied prefix regardless of case",
+			filters:  NewPathFilters([]string{"/public"}, []string{"/public/secrets"}),
+			path:     "/Public/SECRETS/key",
+			expected: false,
+		},
+		{
+			name:     "should allow an exact allowed path",
+			filters:  NewPathFiltersWithAllowedPaths(nil, []string{"/logo.png"}, nil),
+			path:     "/logo.png",
+			expected: true,
+		},
+		{
+			name:     "should allow an exact allowed path regardless of case",
+			filters:  NewPathFiltersWithAllowedPaths(nil, []string{"/logo.png"}, nil),
+			path:     "/Logo.PNG",
+			expected: true,
+		},
+		{
+			name:     "should not allow paths only starting with an exact allowed path",
+			filters:  NewPathFiltersWithAllowedPaths(nil, []string{"/logo.png"}, nil),
+			path:     "/logo.png.bak",
+			expected: false,
+		},
+		{
+			name:     "should not allow paths below an exact allowed path",
+			filters:  NewPathFiltersWithAllowedPaths(nil, []string{"/public"}, nil),
+			path:     "/public/image.png",
+			expected: false,
+		},
+		{
+			name:     "should allow an exact allowed path outside of the allowed prefixes",
+			filters:  NewPathFiltersWithAllowedPaths([]string{"/public/"}, []string{"/logo.png"}, nil),
+			path:     "/logo.png",
+			expected: true,
+		},
+		{
+			name:     "should allow paths matching an allowed prefix along with exact allowed paths",
+			filters:  NewPathFiltersWithAllowedPaths([]string{"/public/"}, []string{"/logo.png"}, nil),
+			path:     "/public/image.png",
+			expected: true,
+		},
+		{
+			name:     "should not allow paths matching neither the allowed prefixes nor the exact allowed paths",
+			filters:  NewPathFiltersWithAllowedPaths([]string{"/public/"}, []string{"/logo.png"}, nil),
+			path:     "/private/image.png",
+			expected: false,
+		},
+		{
+			name:     "should not allow an exact allowed path matching a denied prefix",
+			filters:  NewPathFiltersWithAllowedPaths(nil, []string{"/private/logo.png"}, []string{"/private/"}),
+			path:     "/private/logo.png",
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.filters.IsAllowed(tt.path))
+		})
+	}
+}
+
+func TestFilestorageApi_PathFilter(t *testing.T) {
+	regexFilter, err := NewRegexPathFilter([]string{`^/objects/[0-9a-f]{8}$`}, []string{`^/objects/0{8}$`})
+	require.NoError(t, err)
+
+	_, err = NewRegexPathFilter([]string{`^/objects/(`}, nil)
+	require.Error(t, err)
+
+	var tests = []struct {
+		name     string
+		path     string
+		prefix   bool
+		regex    bool
+		combined bool
+	}{
+		{
+			name:     "hashed name",
+			path:     "/objects/4a5b6c7d",
+			prefix:   true,
+			regex:    true,
+			combined: true,
+		},
+		{
+			name:     "hashed name in upper case",
+			path:     "/OBJECTS/4A5B6C7D",
+			prefix:   true,
+			regex:    true,
+			combined: true,
+		},
+		{
+			name:     "plain name in the allowed folder",
+			path:     "/objects/readme.txt",
+			prefix:   true,
+			regex:    false,
+			combined: false,
+		},
+		{
+			name:     "denied hashed name",
+			path:     "/objects/00000000",
+			prefix:   true,
+			regex:    false,
+			combined: false,
+		},
+		{
+			name:     "hashed name in the denied folder",
+			path:     "/objects/private/4a5b6c7d",
+			prefix:   false,
+			regex:    false,
+			combined: false,
+		},
+		{
+			name:     "hashed name outside of the allowed folder",
+			path:     "/other/4a5b6c7d",
+			prefix:   false,
+			regex:    false,
+			combined: false,
+		},
+	}
+
+	prefixFilter := NewPathFilters([]string{"/objects/"}, []string{"/objects/private/"})
+	combinedFilter := pathFilterChain{prefixFilter, regexFilter}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.prefix, prefixFilter.IsAllowed(tt.path), "prefix filter")
+			require.Equal(t, tt.regex, regexFilter.IsAllowed(tt.path), "regex filter")
+			require.Equal(t, tt.combined, combinedFilter.IsAllowed(tt.path), "combined filter")
+		})
+	}
+}
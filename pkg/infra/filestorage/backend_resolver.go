@@ -0,0 +1,31 @@
+package filestorage
+
+// backendResolver finds the backend a path belongs to. The first segment of the path is matched exactly against
+// the backend names, so that a backend is not shadowed by another backend whose name is its prefix.
+type backendResolver struct {
+	names map[string]struct{}
+}
+
+func newBackendResolver(backendByName map[string]FileStorage) *backendResolver {
+	names := make(map[string]struct{}, len(backendByName))
+	for name := range backendByName {
+		names[name] = struct{}{}
+	}
+
+	return &backendResolver{
+		names: names,
+	}
+}
+
+// resolve returns the name of the backend of the path, or false if the path does not belong to any backend.
+func (r *backendResolver) resolve(path string) (string, bool) {
+	name, ok := storageNameOf(path)
+	if !ok {
+		return "", false
+	}
+
+	if _, ok := r.names[name]; !ok {
+		return "", false
+	}
+	return name, true
+}
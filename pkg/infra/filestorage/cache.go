@@ -0,0 +1,293 @@
+package filestorage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	// defaultCacheSize is the number of entries cached per backend when the size is not configured
+	defaultCacheSize = 1000
+	// maxCachedFileSize keeps large files out of the cache, it is meant for small static files
+	maxCachedFileSize = 1024 * 1024
+)
+
+var (
+	_ FileStorage = (*cachingFileStorage)(nil) // cachingFileStorage implements FileStorage
+)
+
+type cacheEntryKind int
+
+const (
+	cacheEntryFile cacheEntryKind = iota
+	cacheEntryMetadata
+)
+
+type cacheKey struct {
+	kind cacheEntryKind
+	path string
+}
+
+type cacheEntry struct {
+	file     *File
+	metadata *FileMetadata
+	expires  time.Time
+}
+
+// cachingFileStorage serves Get and GetMetadata from an in-memory LRU cache of the found files. The entries expire
+// after the TTL and are invalidated by the writes going through the cache, writes bypassing it are only seen
+// once the entries expire. Operations on folders invalidate the whole cache.
+type cachingFileStorage struct {
+	wrapped FileStorage
+	cache   *lru.Cache
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu sync.Mutex
+	// generation is incremented by every invalidation, so that reads which started before it are not cached
+	generation uint64
+}
+
+func withCache(backend FileStorage, ttl time.Duration, size int) (FileStorage, error) {
+	if ttl <= 0 {
+		return backend, nil
+	}
+
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cachingFileStorage{
+		wrapped: backend,
+		cache:   cache,
+		ttl:     ttl,
+		now:     time.Now,
+	}, nil
+}
+
+func newCacheKey(kind cacheEntryKind, path string) cacheKey {
+	return cacheKey{kind: kind, path: strings.ToLower(path)}
+}
+
+func (c *cachingFileStorage) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+func (c *cachingFileStorage) lookup(key cacheKey) (*cacheEntry, bool) {
+	value, ok := c.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	entry := value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.cache.Remove(key)
+		return nil, false
+	}
+	return entry, true
+}
+
+// store caches the entry unless the cache was invalidated since the read started.
+func (c *cachingFileStorage) store(key cacheKey, generation uint64, entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return
+	}
+
+	entry.expires = c.now().Add(c.ttl)
+	c.cache.Add(key, entry)
+}
+
+func (c *cachingFileStorage) invalidate(paths ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, path := range paths {
+		c.cache.Remove(newCacheKey(cacheEntryFile, path))
+		c.cache.Remove(newCacheKey(cacheEntryMetadata, path))
+	}
+}
+
+func (c *cachingFileStorage) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.cache.Purge()
+}
+
+func (c *cachingFileStorage) Get(ctx context.Context, path string) (*File, error) {
+	key := newCacheKey(cacheEntryFile, path)
+	if entry, ok := c.lookup(key); ok {
+		return copyFile(entry.file), nil
+	}
+
+	generation := c.currentGeneration()
+	file, err := c.wrapped.Get(ctx, path)
+	if err != nil || file == nil || len(file.Contents) > maxCachedFileSize {
+		return file, err
+	}
+
+	c.store(key, generation, &cacheEntry{file: copyFile(file)})
+	return file, nil
+}
+
+func (c *cachingFileStorage) GetReader(ctx context.Context, path string) (io.ReadCloser, *FileMetadata, error) {
+	return c.wrapped.GetReader(ctx, path)
+}
+
+func (c *cachingFileStorage) Open(ctx context.Context, path string) (*FileMetadata, io.ReadSeekCloser, error) {
+	return c.wrapped.Open(ctx, path)
+}
+
+func (c *cachingFileStorage) GetMetadata(ctx context.Context, path string) (*FileMetadata, error) {
+	key := newCacheKey(cacheEntryMetadata, path)
+	if entry, ok := c.lookup(key); ok {
+		return copyFileMetadata(entry.metadata), nil
+	}
+
+	generation := c.currentGeneration()
+	metadata, err := c.wrapped.GetMetadata(ctx, path)
+	if err != nil || metadata == nil {
+		return metadata, err
+	}
+
+	c.store(key, generation, &cacheEntry{metadata: copyFileMetadata(metadata)})
+	return metadata, nil
+}
+
+func (c *cachingFileStorage) SignedURL(ctx context.Context, path string, ttl time.Duration) (string, error) {
+	return c.wrapped.SignedURL(ctx, path, ttl)
+}
+
+// SignedUploadURL does not invalidate the path, the upload only happens later. The cached file is served until
+// it expires.
+func (c *cachingFileStorage) SignedUploadURL(ctx context.Context, path string, ttl time.Duration, contentType string) (string, error) {
+	return c.wrapped.SignedUploadURL(ctx, path, ttl, contentType)
+}
+
+func (c *cachingFileStorage) Exists(ctx context.Context, path string) (bool, error) {
+	return c.wrapped.Exists(ctx, path)
+}
+
+func (c *cachingFileStorage) Delete(ctx context.Context, path string) error {
+	defer c.invalidate(path)
+	return c.wrapped.Delete(ctx, path)
+}
+
+func (c *cachingFileStorage) DeleteMany(ctx context.Context, paths []string) ([]string, map[string]error) {
+	defer c.invalidate(paths...)
+	return c.wrapped.DeleteMany(ctx, paths)
+}
+
+func (c *cachingFileStorage) Upsert(ctx context.Context, command *UpsertFileCommand) error {
+	defer c.invalidate(command.Path)
+	return c.wrapped.Upsert(ctx, command)
+}
+
+func (c *cachingFileStorage) UpsertStream(ctx context.Context, path string, r io.Reader, command *UpsertFileCommand) error {
+	defer c.invalidate(path)
+	return c.wrapped.UpsertStream(ctx, path, r, command)
+}
+
+func (c *cachingFileStorage) Append(ctx context.Context, path string, data []byte) error {
+	defer c.invalidate(path)
+	return c.wrapped.Append(ctx, path, data)
+}
+
+func (c *cachingFileStorage) Touch(ctx context.Context, path string) error {
+	defer c.invalidate(path)
+	return c.wrapped.Touch(ctx, path)
+}
+
+func (c *cachingFileStorage) Copy(ctx context.Context, srcPath string, dstPath string) error {
+	defer c.invalidate(dstPath)
+	return c.wrapped.Copy(ctx, srcPath, dstPath)
+}
+
+// Move invalidates the whole cache, as the source path might be a folder.
+func (c *cachingFileStorage) Move(ctx context.Context, srcPath string, dstPath string) error {
+	defer c.invalidateAll()
+	return c.wrapped.Move(ctx, srcPath, dstPath)
+}
+
+func (c *cachingFileStorage) ListFiles(ctx context.Context, folderPath string, paging *Paging, options *ListOptions) (*ListFilesResponse, error) {
+	return c.wrapped.ListFiles(ctx, folderPath, paging, options)
+}
+
+func (c *cachingFileStorage) WalkFiles(ctx context.Context, folderPath string, options *ListOptions, fn func(FileMetadata) error) error {
+	return c.wrapped.WalkFiles(ctx, folderPath, options, fn)
+}
+
+func (c *cachingFileStorage) ListFolders(ctx context.Context, folderPath string, options *ListOptions) ([]FileMetadata, error) {
+	return c.wrapped.ListFolders(ctx, folderPath, options)
+}
+
+func (c *cachingFileStorage) CreateFolder(ctx context.Context, path string, options *CreateFolderOptions) error {
+	return c.wrapped.CreateFolder(ctx, path, options)
+}
+
+func (c *cachingFileStorage) DeleteFolder(ctx context.Context, path string, options *DeleteFolderOptions) error {
+	defer c.invalidateAll()
+	return c.wrapped.DeleteFolder(ctx, path, options)
+}
+
+// Restore invalidates the whole cache, as the restored path might be a folder.
+func (c *cachingFileStorage) Restore(ctx context.Context, path string) error {
+	defer c.invalidateAll()
+	return c.wrapped.Restore(ctx, path)
+}
+
+func (c *cachingFileStorage) ListVersions(ctx context.Context, path string) ([]FileMetadata, error) {
+	return c.wrapped.ListVersions(ctx, path)
+}
+
+func (c *cachingFileStorage) GetVersion(ctx context.Context, path string, version string) (*File, error) {
+	return c.wrapped.GetVersion(ctx, path, version)
+}
+
+func (c *cachingFileStorage) PurgeTrash(ctx context.Context, path string) error {
+	return c.wrapped.PurgeTrash(ctx, path)
+}
+
+func (c *cachingFileStorage) HealthCheck(ctx context.Context) error {
+	return c.wrapped.HealthCheck(ctx)
+}
+
+func (c *cachingFileStorage) close() error {
+	c.invalidateAll()
+	return c.wrapped.close()
+}
+
+// copyFile copies the file, so that the callers can not modify the cached contents and properties.
+func copyFile(file *File) *File {
+	contents := make([]byte, len(file.Contents))
+	copy(contents, file.Contents)
+	return &File{
+		Contents:     contents,
+		FileMetadata: *copyFileMetadata(&file.FileMetadata),
+	}
+}
+
+func copyFileMetadata(metadata *FileMetadata) *FileMetadata {
+	copied := *metadata
+	if metadata.Properties != nil {
+		copied.Properties = make(map[string]string, len(metadata.Properties))
+		for k, v := range metadata.Properties {
+			copied.Properties[k] = v
+		}
+	}
+	return &copied
+}
@@ -1,12 +1,28 @@
 package filestorage
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
-
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"gocloud.dev/blob"
 	"gocloud.dev/gcerrors"
@@ -14,129 +30,1602 @@ import (
 
 const (
 	originalPathAttributeKey = "__gf_original_path__"
+	// versionsFolderName is the root folder holding the stored versions of the files of versioned backends
+	versionsFolderName = ".___gf_versions___"
+	// timestampFormat has a fixed width so that trashed and stored versions sort in the order they were written.
+	timestampFormat = "20060102150405.000000000"
+
+	// contentEncodingAttributeKey marks compressed files, whose uncompressed size is stored under sizeAttributeKey
+	contentEncodingAttributeKey = "__gf_content_encoding__"
+	sizeAttributeKey            = "__gf_size__"
+	gzipContentEncoding         = "gzip"
+
+	// contentFolderName is the root folder holding the contents of content addressable backends, stored once per
+	// hash under "blobs" along with a marker under "refs" for every file referencing them. The files themselves are
+	// empty objects carrying the hash of their contents under contentHashAttributeKey.
+	contentFolderName       = ".___gf_content___"
+	contentHashAttributeKey = "__gf_content_hash__"
+
+	// maxFolderFileCount caps the files counted per folder when listing folders with IncludeFileCount
+	maxFolderFileCount = 1000
+
+	// maxPropertiesSize is the total size of the keys and values of the properties of a file, it leaves room
+	// for the internal attributes within the 2 KB of user metadata allowed by S3
+	maxPropertiesSize = 1536
 )
 
+// propertyKeyRegex matches the property keys stored unchanged as object metadata by all buckets, which might
+// lowercase the keys. Keys can not start with an underscore, which is reserved for the internal attributes.
+var propertyKeyRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// azureMetadataKeyRegex matches the metadata keys the Azure driver stores without escaping them.
+var azureMetadataKeyRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
 type cdkBlobStorage struct {
-	log        log.Logger
-	bucket     *blob.Bucket
-	rootFolder string
+	log         log.Logger
+	bucket      *blob.Bucket
+	rootFolder  string
+	trashPrefix string
+	versioned   bool
+	quota       *quotaTracker
+	// upsertLock serializes the conditional upserts and rewrites of this instance. It is a best effort: unconditional
+	// upserts and the writes of other instances sharing the bucket can still change the file between the check and the write
+	upsertLock *sync.Mutex
+	// compressContentTypes are the normalized MIME types of the files compressed before they are stored
+	compressContentTypes []string
+	sseKMSKeyID          string
+	defaultListOptions   *ListOptions
+	contentAddressable   bool
+	// contentLock serializes the changes to the references of the stored contents of content addressable backends
+	contentLock *sync.Mutex
 }
 
-func NewCdkBlobStorage(log log.Logger, bucket *blob.Bucket, rootFolder string, pathFilters *PathFilters) FileStorage {
+// CdkBlobStorageOptions configures optional limits and behaviors of a blob storage backend.
+type CdkBlobStorageOptions struct {
+	// MaxFileSize is the maximum size in bytes of a single file. Zero means unlimited.
+	MaxFileSize int64
+	// TrashPrefix is the name of the root folder deleted files are moved to, under a folder named after the time
+	// of deletion. Files are deleted permanently if it is empty. The trash can not be accessed through regular operations.
+	TrashPrefix string
+	// Versioned keeps every version written by Upsert, the latest version is also stored at the path of the file.
+	Versioned bool
+	// MaxTotalSize is the maximum size in bytes of all files, not counting the trash and the stored versions. Zero means unlimited.
+	MaxTotalSize int64
+	// CompressContentTypes are the MIME types of the files gzip-compressed before they are stored, e.g. application/json.
+	// They are decompressed when read through the backend, but signed URLs serve the compressed bytes.
+	CompressContentTypes []string
+	// SSEKMSKeyID is the KMS key used to encrypt the written objects on the server side. It is only supported by S3 buckets,
+	// writes to other buckets fail with ErrEncryptionUnsupported if it is set.
+	SSEKMSKeyID string
+	// DefaultListOptions are merged into the options of ListFiles and ListFolders. The filters, MaxResults and
+	// IncludeFileCount apply unless set by the caller, Recursive and the path filters are always up to the caller.
+	DefaultListOptions *ListOptions
+	// ContentAddressable stores the contents of the files once per SHA-256 hash, so that files with identical contents
+	// share a single object. The contents are deleted once no file references them anymore. It can not be combined
+	// with TrashPrefix, Versioned and MaxTotalSize, which are ignored when it is set.
+	ContentAddressable bool
+}
+
+func NewCdkBlobStorage(log log.Logger, bucket *blob.Bucket, rootFolder string, pathFilters PathFilter, supportedOperations []Operation, options *CdkBlobStorageOptions) FileStorage {
+	if options == nil {
+		options = &CdkBlobStorageOptions{}
+	}
+
+	trashPrefix := strings.Trim(options.TrashPrefix, Delimiter)
+	versioned := options.Versioned
+	maxTotalSize := options.MaxTotalSize
+	if options.ContentAddressable {
+		// deleted and replaced files release their contents right away, and the stored size is not tracked
+		trashPrefix, versioned, maxTotalSize = "", false, 0
+		pathFilters = withDeniedPrefix(pathFilters, Join(contentFolderName)+Delimiter)
+	}
+
+	if trashPrefix != "" {
+		pathFilters = withDeniedPrefix(pathFilters, Join(trashPrefix)+Delimiter)
+	}
+	if versioned {
+		pathFilters = withDeniedPrefix(pathFilters, Join(versionsFolderName)+Delimiter)
+	}
+
+	compressContentTypes := make([]string, 0, len(options.CompressContentTypes))
+	for _, contentType := range options.CompressContentTypes {
+		compressContentTypes = append(compressContentTypes, normalizeMimeType(contentType))
+	}
+
+	storage := &cdkBlobStorage{
+		log:                  log,
+		bucket:               bucket,
+		rootFolder:           rootFolder,
+		trashPrefix:          trashPrefix,
+		versioned:            versioned,
+		upsertLock:           &sync.Mutex{},
+		compressContentTypes: compressContentTypes,
+		sseKMSKeyID:          options.SSEKMSKeyID,
+		defaultListOptions:   options.DefaultListOptions,
+		contentAddressable:   options.ContentAddressable,
+		contentLock:          &sync.Mutex{},
+	}
+	storage.quota = newQuotaTracker(maxTotalSize, storage.computeTotalSize)
+
 	return &wrapper{
-		log: log,
-		wrapped: &cdkBlobStorage{
-			log:        log,
-			bucket:     bucket,
-			rootFolder: rootFolder,
-		},
-		pathFilters: pathFilters,
+		log:                 log,
+		wrapped:             storage,
+		pathFilters:         pathFilters,
+		supportedOperations: supportedOperations,
+		maxFileSize:         options.MaxFileSize,
+	}
+}
+
+// withDeniedPrefix adds the prefix to the denied prefixes, or chains a prefix filter denying it to any other filter.
+func withDeniedPrefix(pathFilter PathFilter, prefix string) PathFilter {
+	pathFilters, ok := pathFilter.(*PathFilters)
+	if !ok && pathFilter != nil {
+		return pathFilterChain{pathFilter, NewPathFilters(nil, []string{prefix})}
+	}
+
+	if pathFilters == nil {
+		return NewPathFilters(nil, []string{prefix})
 	}
+
+	deniedPrefixes := make([]string, 0, len(pathFilters.deniedPrefixes)+1)
+	deniedPrefixes = append(deniedPrefixes, pathFilters.deniedPrefixes...)
+	return NewPathFiltersWithAllowedPaths(pathFilters.allowedPrefixes, pathFilters.allowedPaths, append(deniedPrefixes, prefix))
 }
 
 func (c cdkBlobStorage) Get(ctx context.Context, filePath string) (*File, error) {
+	file, _, err := c.read(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if file == nil {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, filePath)
+	}
+	return file, nil
+}
+
+// read returns the decompressed file along with the size of the stored object, or nil if the file does not exist.
+func (c cdkBlobStorage) read(ctx context.Context, filePath string) (*File, int64, error) {
 	contents, err := c.bucket.ReadAll(ctx, strings.ToLower(filePath))
 	if err != nil {
 		if gcerrors.Code(err) == gcerrors.NotFound {
-			return nil, nil
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	attributes, err := c.bucket.Attributes(ctx, strings.ToLower(filePath))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if hash := contentHash(attributes); hash != "" {
+		contents, err = c.bucket.ReadAll(ctx, contentKey(hash))
+		if err != nil {
+			return nil, 0, err
 		}
+	}
+
+	file, err := c.newFile(ctx, strings.ToLower(filePath), newFileMetadata(filePath, attributes), attributes, contents)
+	if err != nil {
+		return nil, 0, err
+	}
+	return file, attributes.Size, nil
+}
+
+// newFile computes the ETag from the stored contents, so that it does not depend on how the file is read,
+// and decompresses them if needed.
+func (c cdkBlobStorage) newFile(ctx context.Context, key string, metadata FileMetadata, attributes *blob.Attributes, contents []byte) (*File, error) {
+	var err error
+	metadata.ETag, err = c.getETag(ctx, key, attributes, contents)
+	if err != nil {
 		return nil, err
 	}
+
+	if isCompressed(attributes) {
+		contents, err = decompress(contents)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress %s: %w", metadata.FullPath, err)
+		}
+	}
+
+	return &File{
+		Contents:     contents,
+		FileMetadata: metadata,
+	}, nil
+}
+
+func (c cdkBlobStorage) GetReader(ctx context.Context, filePath string) (io.ReadCloser, *FileMetadata, error) {
+	attributes, err := c.bucket.Attributes(ctx, strings.ToLower(filePath))
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil, nil, fmt.Errorf("%w: %s", ErrFileNotFound, filePath)
+		}
+		return nil, nil, err
+	}
+
+	metadata := newFileMetadata(filePath, attributes)
+	metadata.ETag, err = c.getETag(ctx, strings.ToLower(filePath), attributes, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := strings.ToLower(filePath)
+	if hash := contentHash(attributes); hash != "" {
+		key = contentKey(hash)
+	}
+
+	reader, err := c.bucket.NewReader(ctx, key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !isCompressed(attributes) {
+		return reader, &metadata, nil
+	}
+
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		_ = reader.Close()
+		return nil, nil, fmt.Errorf("failed to decompress %s: %w", filePath, err)
+	}
+
+	return &gzipReadCloser{Reader: gzipReader, stored: reader}, &metadata, nil
+}
+
+// Open reads the ranges of the file requested by the reader from the bucket. Compressed files can not be read by range,
+// they are decompressed into memory.
+func (c cdkBlobStorage) Open(ctx context.Context, filePath string) (*FileMetadata, io.ReadSeekCloser, error) {
+	attributes, err := c.bucket.Attributes(ctx, strings.ToLower(filePath))
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil, nil, fmt.Errorf("%w: %s", ErrFileNotFound, filePath)
+		}
+		return nil, nil, err
+	}
+
+	if isCompressed(attributes) {
+		reader, metadata, err := c.GetReader(ctx, filePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer func() { _ = reader.Close() }()
+
+		contents, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return metadata, newBufferedReadSeekCloser(contents), nil
+	}
+
+	metadata := newFileMetadata(filePath, attributes)
+	metadata.ETag, err = c.getETag(ctx, strings.ToLower(filePath), attributes, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := strings.ToLower(filePath)
+	if hash := contentHash(attributes); hash != "" {
+		key = contentKey(hash)
+	}
+
+	return &metadata, &blobReadSeeker{ctx: ctx, bucket: c.bucket, key: key, size: metadata.Size}, nil
+}
+
+// blobReadSeeker reads the blob from the current offset on, the range reader is reopened at the new offset after
+// every seek which moves it.
+type blobReadSeeker struct {
+	ctx    context.Context
+	bucket *blob.Bucket
+	key    string
+	size   int64
+	offset int64
+	reader *blob.Reader
+}
+
+func (r *blobReadSeeker) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.reader == nil {
+		reader, err := r.bucket.NewRangeReader(r.ctx, r.key, r.offset, -1, nil)
+		if err != nil {
+			return 0, err
+		}
+		r.reader = reader
+	}
+
+	n, err := r.reader.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *blobReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = r.offset + offset
+	case io.SeekEnd:
+		target = r.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+
+	if target < 0 {
+		return 0, fmt.Errorf("invalid offset %d: negative position", offset)
+	}
+
+	if target != r.offset {
+		if err := r.closeReader(); err != nil {
+			return 0, err
+		}
+		r.offset = target
+	}
+	return target, nil
+}
+
+func (r *blobReadSeeker) closeReader() error {
+	if r.reader == nil {
+		return nil
+	}
+
+	err := r.reader.Close()
+	r.reader = nil
+	return err
+}
+
+func (r *blobReadSeeker) Close() error {
+	return r.closeReader()
+}
+
+// gzipReadCloser decompresses a stored file and closes the underlying reader along with the decompressing one.
+type gzipReadCloser struct {
+	*gzip.Reader
+	stored io.Closer
+}
+
+func (r *gzipReadCloser) Close() error {
+	gzipErr := r.Reader.Close()
+	if err := r.stored.Close(); err != nil {
+		return err
+	}
+	return gzipErr
+}
+
+func (c cdkBlobStorage) GetMetadata(ctx context.Context, filePath string) (*FileMetadata, error) {
 	attributes, err := c.bucket.Attributes(ctx, strings.ToLower(filePath))
 	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil, fmt.Errorf("%w: %s", ErrFileNotFound, filePath)
+		}
 		return nil, err
 	}
 
+	// the contents are not hashed here, so the ETag is empty if the bucket does not expose one
+	metadata := newFileMetadata(filePath, attributes)
+	metadata.ETag = attributesETag(attributes)
+	return &metadata, nil
+}
+
+func (c cdkBlobStorage) Exists(ctx context.Context, filePath string) (bool, error) {
+	return c.bucket.Exists(ctx, strings.ToLower(filePath))
+}
+
+// SignedURL signs the object holding the contents of the file, which is shared with the files with identical
+// contents if the backend is content addressable.
+func (c cdkBlobStorage) SignedURL(ctx context.Context, filePath string, ttl time.Duration) (string, error) {
+	key := strings.ToLower(filePath)
+	if c.contentAddressable {
+		attributes, err := c.bucket.Attributes(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		if hash := contentHash(attributes); hash != "" {
+			key = contentKey(hash)
+		}
+	}
+
+	url, err := c.bucket.SignedURL(ctx, key, &blob.SignedURLOptions{
+		Expiry: ttl,
+	})
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.Unimplemented {
+			return "", ErrSignedURLUnsupported
+		}
+		return "", err
+	}
+
+	return url, nil
+}
+
+// SignedUploadURL signs a PUT of the object of the file. The uploaded object carries neither the original casing
+// of the path nor the folder markers, so the file is listed with its lower-cased path and its folders are only listed
+// once created. Backends with a quota, versioning or content addressing can not sign upload URLs, as the uploads
+// would bypass them.
+func (c cdkBlobStorage) SignedUploadURL(ctx context.Context, filePath string, ttl time.Duration, contentType string) (string, error) {
+	if c.quota != nil || c.versioned || c.contentAddressable {
+		return "", fmt.Errorf("%w: uploads would bypass the quota, the versioning or the content addressing of the storage", ErrSignedURLUnsupported)
+	}
+
+	options := &blob.SignedURLOptions{
+		Expiry:      ttl,
+		Method:      http.MethodPut,
+		ContentType: contentType,
+	}
+
+	// the upload has to send the encryption headers, which are part of the signature
+	if c.sseKMSKeyID != "" {
+		options.BeforeSign = func(asFunc func(interface{}) bool) error {
+			var input *s3.PutObjectInput
+			if !asFunc(&input) {
+				return ErrEncryptionUnsupported
+			}
+
+			input.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+			input.SSEKMSKeyId = aws.String(c.sseKMSKeyID)
+			return nil
+		}
+	}
+
+	url, err := c.bucket.SignedURL(ctx, strings.ToLower(filePath), options)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.Unimplemented {
+			return "", ErrSignedURLUnsupported
+		}
+		return "", err
+	}
+
+	return url, nil
+}
+
+// getETag prefers the MD5 hash and the ETag exposed by the bucket. The contents are hashed only if the bucket exposes neither,
+// and are read from the bucket if not passed in.
+func (c cdkBlobStorage) getETag(ctx context.Context, key string, attributes *blob.Attributes, contents []byte) (string, error) {
+	if etag := attributesETag(attributes); etag != "" {
+		return etag, nil
+	}
+
+	if contents == nil {
+		var err error
+		contents, err = c.bucket.ReadAll(ctx, key)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return contentETag(contents), nil
+}
+
+func attributesETag(attributes *blob.Attributes) string {
+	// the objects of content addressed files are empty, the hash identifies their contents
+	if hash := contentHash(attributes); hash != "" {
+		return hash
+	}
+
+	if len(attributes.MD5) > 0 {
+		return hex.EncodeToString(attributes.MD5)
+	}
+
+	return strings.Trim(attributes.ETag, `"`)
+}
+
+func newFileMetadata(filePath string, attributes *blob.Attributes) FileMetadata {
+	size := fileSize(attributes)
+
 	var originalPath string
 	var props map[string]string
 	if attributes.Metadata != nil {
-		props = attributes.Metadata
-		if path, ok := attributes.Metadata[originalPathAttributeKey]; ok {
+		// the attributes are left untouched, the ETag is read from them afterwards
+		props = make(map[string]string, len(attributes.Metadata))
+		for k, v := range attributes.Metadata {
+			props[k] = v
+		}
+		if path, ok := props[originalPathAttributeKey]; ok {
 			originalPath = path
 			delete(props, originalPathAttributeKey)
 		}
+		removeEncodingAttributes(props)
 	} else {
 		props = make(map[string]string)
 		originalPath = filePath
 	}
 
-	return &File{
-		Contents: contents,
-		FileMetadata: FileMetadata{
-			Name:       getName(originalPath),
-			FullPath:   originalPath,
-			Created:    attributes.CreateTime,
-			Properties: props,
-			Modified:   attributes.ModTime,
-			Size:       attributes.Size,
-			MimeType:   detectContentType(originalPath, attributes.ContentType),
-		},
-	}, nil
+	return FileMetadata{
+		Name:       getName(originalPath),
+		FullPath:   originalPath,
+		Created:    attributes.CreateTime,
+		Properties: props,
+		Modified:   attributes.ModTime,
+		Size:       size,
+		MimeType:   detectContentType(originalPath, attributes.ContentType),
+	}
 }
 
-func (c cdkBlobStorage) Delete(ctx context.Context, filePath string) error {
-	exists, err := c.bucket.Exists(ctx, strings.ToLower(filePath))
+func isCompressed(attributes *blob.Attributes) bool {
+	return attributes.Metadata[contentEncodingAttributeKey] == gzipContentEncoding
+}
+
+// contentHash returns the hash of the contents of a content addressed file, or an empty string for other objects.
+func contentHash(attributes *blob.Attributes) string {
+	return attributes.Metadata[contentHashAttributeKey]
+}
+
+// fileSize returns the size of the file before it was compressed, the objects of content addressed files are empty.
+func fileSize(attributes *blob.Attributes) int64 {
+	if size, ok := attributes.Metadata[sizeAttributeKey]; ok && (isCompressed(attributes) || contentHash(attributes) != "") {
+		if parsed, err := strconv.ParseInt(size, 10, 64); err == nil {
+			return parsed
+		}
+	}
+	return attributes.Size
+}
+
+// removeEncodingAttributes removes the attributes describing how the contents are stored.
+func removeEncodingAttributes(props map[string]string) {
+	delete(props, contentEncodingAttributeKey)
+	delete(props, sizeAttributeKey)
+	delete(props, contentHashAttributeKey)
+}
+
+func decompress(contents []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(contents))
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer func() {
+		_ = reader.Close()
+	}()
 
-	if !exists {
-		return nil
+	return io.ReadAll(reader)
+}
+
+// encode compresses the contents if their MIME type is listed in the options of the backend.
+// The returned metadata is a copy including the encoding attributes.
+func (c cdkBlobStorage) encode(filePath string, contents []byte, mimeType string, metadata map[string]string) ([]byte, map[string]string, error) {
+	encodedMetadata := make(map[string]string, len(metadata)+2)
+	for k, v := range metadata {
+		encodedMetadata[k] = v
 	}
+	removeEncodingAttributes(encodedMetadata)
 
-	err = c.bucket.Delete(ctx, strings.ToLower(filePath))
-	return err
+	if !c.shouldCompress(filePath, mimeType) {
+		return contents, encodedMetadata, nil
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(contents); err != nil {
+		return nil, nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	encodedMetadata[contentEncodingAttributeKey] = gzipContentEncoding
+	encodedMetadata[sizeAttributeKey] = strconv.Itoa(len(contents))
+	return buf.Bytes(), encodedMetadata, nil
 }
 
-func (c cdkBlobStorage) Upsert(ctx context.Context, command *UpsertFileCommand) error {
-	existing, err := c.Get(ctx, command.Path)
+func (c cdkBlobStorage) shouldCompress(filePath string, mimeType string) bool {
+	if len(c.compressContentTypes) == 0 {
+		return false
+	}
+
+	mimeType = normalizeMimeType(detectContentType(filePath, mimeType))
+	for _, contentType := range c.compressContentTypes {
+		if contentType == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+func (c cdkBlobStorage) Delete(ctx context.Context, filePath string) error {
+	existed, err := c.deleteFile(ctx, filePath)
 	if err != nil {
 		return err
 	}
 
-	var contents []byte
-	var metadata map[string]string
+	if !existed {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, filePath)
+	}
+	return nil
+}
 
-	if existing == nil {
-		if command.Contents == nil {
-			contents = make([]byte, 0)
-		} else {
-			contents = *command.Contents
+// DeleteMany deletes the files one by one, as the buckets do not expose batch deletes through the portable API.
+func (c cdkBlobStorage) DeleteMany(ctx context.Context, paths []string) ([]string, map[string]error) {
+	deleted := make([]string, 0, len(paths))
+	errs := make(map[string]error)
+	for _, path := range paths {
+		ok, err := c.deleteFile(ctx, path)
+		if err != nil {
+			errs[path] = err
+			continue
 		}
 
-		metadata = make(map[string]string)
-		if command.Properties != nil {
-			for k, v := range command.Properties {
-				metadata[k] = v
-			}
+		if ok {
+			deleted = append(deleted, path)
 		}
-		metadata[originalPathAttributeKey] = command.Path
-		return c.bucket.WriteAll(ctx, strings.ToLower(command.Path), contents, &blob.WriterOptions{
-			Metadata: metadata,
-		})
 	}
+	return deleted, errs
+}
 
-	contents = existing.Contents
-	if command.Contents != nil {
-		contents = *command.Contents
+// deleteFile deletes the file, or moves it to the trash, and reports whether it existed. Deleting a content addressed
+// file releases its contents.
+func (c cdkBlobStorage) deleteFile(ctx context.Context, filePath string) (bool, error) {
+	if c.contentAddressable {
+		c.contentLock.Lock()
+		defer c.contentLock.Unlock()
 	}
 
-	if command.Properties != nil {
-		metadata = make(map[string]string)
-		for k, v := range command.Properties {
-			metadata[k] = v
+	attributes, err := c.bucket.Attributes(ctx, strings.ToLower(filePath))
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return false, nil
 		}
-	} else {
-		metadata = existing.FileMetadata.Properties
+		return false, err
 	}
 
-	metadata[originalPathAttributeKey] = existing.FullPath
-	return c.bucket.WriteAll(ctx, strings.ToLower(command.Path), contents, &blob.WriterOptions{
-		Metadata: metadata,
-	})
+	if c.trashPrefix != "" {
+		originalPath, err := c.originalPath(ctx, strings.ToLower(filePath))
+		if err != nil {
+			return false, err
+		}
+		// moving the file to the trash releases its size
+		if err := c.Move(ctx, originalPath, c.trashPath(newTimestamp(), originalPath)); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	if err := c.bucket.Delete(ctx, strings.ToLower(filePath)); err != nil {
+		return false, err
+	}
+
+	if hash := contentHash(attributes); hash != "" {
+		if err := c.releaseContent(ctx, hash, filePath); err != nil {
+			return false, err
+		}
+	}
+
+	c.quota.release(attributes.Size)
+	return true, nil
+}
+
+// countsTowardQuota reports whether the size of the object counts toward the quota, the trash and the stored versions
+// do not.
+func (c cdkBlobStorage) countsTowardQuota(key string) bool {
+	skippedPrefixes := []string{strings.ToLower(c.convertFolderPathToPrefix(Join(versionsFolderName)))}
+	if c.trashPrefix != "" {
+		skippedPrefixes = append(skippedPrefixes, strings.ToLower(c.convertFolderPathToPrefix(Join(c.trashPrefix))))
+	}
+
+	for _, prefix := range skippedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// computeTotalSize sums up the sizes of all files, skipping the trash and the stored versions.
+func (c cdkBlobStorage) computeTotalSize(ctx context.Context) (int64, error) {
+	iterator := c.bucket.List(&blob.ListOptions{
+		Prefix: c.rootFolder,
+	})
+
+	var totalSize int64
+	for {
+		obj, err := iterator.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return totalSize, nil
+		}
+
+		if err != nil {
+			c.log.Error("Failed while iterating over files", "err", err)
+			return 0, err
+		}
+
+		if c.countsTowardQuota(obj.Key) {
+			totalSize += obj.Size
+		}
+	}
+}
+
+func newTimestamp() string {
+	return time.Now().UTC().Format(timestampFormat)
+}
+
+// trashPath returns the path of the file or folder in the trash folder of the given deletion time.
+func (c cdkBlobStorage) trashPath(timestamp string, path string) string {
+	if path == Delimiter {
+		return Join(c.trashPrefix, timestamp)
+	}
+	return Join(c.trashPrefix, timestamp) + path
+}
+
+func (c cdkBlobStorage) originalPath(ctx context.Context, key string) (string, error) {
+	attributes, err := c.bucket.Attributes(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	if path, ok := attributes.Metadata[originalPathAttributeKey]; ok {
+		return path, nil
+	}
+	return fixPath(key), nil
+}
+
+// listTrashTimestamps returns the deletion times of the trashed files, from the oldest to the most recent.
+func (c cdkBlobStorage) listTrashTimestamps(ctx context.Context) ([]string, error) {
+	prefix := strings.ToLower(c.convertFolderPathToPrefix(Join(c.trashPrefix)))
+	iterator := c.bucket.List(&blob.ListOptions{
+		Prefix:    prefix,
+		Delimiter: Delimiter,
+	})
+
+	timestamps := make([]string, 0)
+	for {
+		obj, err := iterator.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if obj.IsDir {
+			timestamps = append(timestamps, strings.TrimSuffix(strings.TrimPrefix(obj.Key, prefix), Delimiter))
+		}
+	}
+
+	sort.Strings(timestamps)
+	return timestamps, nil
+}
+
+func (c cdkBlobStorage) Restore(ctx context.Context, path string) error {
+	if c.trashPrefix == "" {
+		return ErrTrashNotEnabled
+	}
+
+	timestamps, err := c.listTrashTimestamps(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(timestamps) - 1; i >= 0; i-- {
+		timestamp := timestamps[i]
+		trashedPath := c.trashPath(timestamp, path)
+		restorePath := func(trashedPath string) string {
+			return strings.TrimPrefix(trashedPath, Join(c.trashPrefix, timestamp))
+		}
+
+		if path != Delimiter {
+			exists, err := c.bucket.Exists(ctx, strings.ToLower(trashedPath))
+			if err != nil {
+				return err
+			}
+
+			if exists {
+				originalPath, err := c.originalPath(ctx, strings.ToLower(trashedPath))
+				if err != nil {
+					return err
+				}
+				return c.Move(ctx, originalPath, restorePath(originalPath))
+			}
+		}
+
+		keys, err := c.listKeys(ctx, strings.ToLower(c.convertFolderPathToPrefix(trashedPath)))
+		if err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			return c.moveAll(ctx, keys, restorePath)
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrFileNotFound, path)
+}
+
+func (c cdkBlobStorage) PurgeTrash(ctx context.Context, path string) error {
+	if c.trashPrefix == "" {
+		return ErrTrashNotEnabled
+	}
+
+	if path == Delimiter {
+		return c.deleteAllWithPrefix(ctx, strings.ToLower(c.convertFolderPathToPrefix(Join(c.trashPrefix))))
+	}
+
+	timestamps, err := c.listTrashTimestamps(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, timestamp := range timestamps {
+		trashedPath := c.trashPath(timestamp, path)
+		if err := c.bucket.Delete(ctx, strings.ToLower(trashedPath)); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			return err
+		}
+
+		if err := c.deleteAllWithPrefix(ctx, strings.ToLower(c.convertFolderPathToPrefix(trashedPath))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c cdkBlobStorage) listKeys(ctx context.Context, prefix string) ([]string, error) {
+	iterator := c.bucket.List(&blob.ListOptions{
+		Prefix: prefix,
+	})
+
+	keys := make([]string, 0)
+	for {
+		obj, err := iterator.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return keys, nil
+		}
+
+		if err != nil {
+			c.log.Error("Failed while iterating over files", "err", err)
+			return nil, err
+		}
+
+		keys = append(keys, obj.Key)
+	}
+}
+
+// moveAll moves the files to the paths returned by rename for their original paths.
+func (c cdkBlobStorage) moveAll(ctx context.Context, keys []string, rename func(path string) string) error {
+	for _, key := range keys {
+		originalPath, err := c.originalPath(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		if err := c.Move(ctx, originalPath, rename(originalPath)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Upsert checks the preconditions of the command under a lock, as the portable bucket API has no conditional
+// writes. This is an in-process best effort: the preconditions are only enforced against the conditional writes of
+// this Grafana instance, not against unconditional upserts or the writes of other instances sharing the bucket.
+func (c cdkBlobStorage) Upsert(ctx context.Context, command *UpsertFileCommand) error {
+	if err := validateProperties(command.Properties); err != nil {
+		return err
+	}
+
+	if command.IfMatchETag != "" || command.CreateOnly {
+		c.upsertLock.Lock()
+		defer c.upsertLock.Unlock()
+	}
+
+	existing, existingStoredSize, err := c.read(ctx, command.Path)
+	if err != nil {
+		return err
+	}
+
+	if command.CreateOnly && existing != nil {
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, command.Path)
+	}
+
+	if command.IfMatchETag != "" && (existing == nil || existing.ETag != command.IfMatchETag) {
+		return fmt.Errorf("%w: %s does not match ETag %s", ErrPreconditionFailed, command.Path, command.IfMatchETag)
+	}
+
+	var contents []byte
+	var metadata map[string]string
+
+	if existing == nil {
+		if command.Contents == nil {
+			contents = make([]byte, 0)
+		} else {
+			contents = *command.Contents
+		}
+
+		metadata = make(map[string]string)
+		if command.Properties != nil {
+			for k, v := range command.Properties {
+				metadata[k] = v
+			}
+		}
+		metadata[originalPathAttributeKey] = command.Path
+		return c.encodeAndWrite(ctx, command.Path, 0, contents, command.MimeType, metadata)
+	}
+
+	contents = existing.Contents
+	if command.Contents != nil {
+		contents = *command.Contents
+	}
+
+	mimeType := existing.MimeType
+	if command.MimeType != "" {
+		mimeType = command.MimeType
+	}
+
+	if command.Properties != nil {
+		metadata = make(map[string]string)
+		for k, v := range command.Properties {
+			metadata[k] = v
+		}
+	} else {
+		metadata = existing.FileMetadata.Properties
+	}
+
+	metadata[originalPathAttributeKey] = existing.FullPath
+	return c.encodeAndWrite(ctx, command.Path, existingStoredSize, contents, mimeType, metadata)
+}
+
+// UpsertStream writes the file through a bucket writer as the stream is read. Streamed files are not compressed, as
+// the encoding attributes have to be known before the contents. The quota is checked once the stream was read,
+// and the write is aborted if the file does not fit. The preconditions are checked like in Upsert.
+func (c cdkBlobStorage) UpsertStream(ctx context.Context, path string, r io.Reader, command *UpsertFileCommand) error {
+	if command == nil {
+		command = &UpsertFileCommand{}
+	}
+
+	// the key of content addressed contents depends on their hash, so the stream is read before anything is written
+	if c.contentAddressable {
+		contents, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		cmd := *command
+		cmd.Path = path
+		cmd.Contents = &contents
+		return c.Upsert(ctx, &cmd)
+	}
+
+	if err := validateProperties(command.Properties); err != nil {
+		return err
+	}
+
+	if command.IfMatchETag != "" || command.CreateOnly {
+		c.upsertLock.Lock()
+		defer c.upsertLock.Unlock()
+	}
+
+	key := strings.ToLower(path)
+	attributes, err := c.bucket.Attributes(ctx, key)
+	if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return err
+	}
+	exists := err == nil
+
+	if command.CreateOnly && exists {
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, path)
+	}
+
+	if command.IfMatchETag != "" {
+		etag := ""
+		if exists {
+			etag, err = c.getETag(ctx, key, attributes, nil)
+			if err != nil {
+				return err
+			}
+		}
+		if etag != command.IfMatchETag {
+			return fmt.Errorf("%w: %s does not match ETag %s", ErrPreconditionFailed, path, command.IfMatchETag)
+		}
+	}
+
+	mimeType := command.MimeType
+	originalPath := path
+	var existingStoredSize int64
+	var properties map[string]string
+	if exists {
+		existing := newFileMetadata(path, attributes)
+		originalPath = existing.FullPath
+		existingStoredSize = attributes.Size
+		properties = existing.Properties
+		if mimeType == "" {
+			mimeType = existing.MimeType
+		}
+	}
+	if command.Properties != nil {
+		properties = command.Properties
+	}
+
+	metadata := make(map[string]string, len(properties)+1)
+	for k, v := range properties {
+		metadata[k] = v
+	}
+	removeEncodingAttributes(metadata)
+	metadata[originalPathAttributeKey] = originalPath
+	return c.writeStream(ctx, path, existingStoredSize, r, mimeType, metadata)
+}
+
+// writeStream copies the stream to the file, and the written file to a new version if the backend is versioned.
+func (c cdkBlobStorage) writeStream(ctx context.Context, filePath string, existingStoredSize int64, r io.Reader, mimeType string, metadata map[string]string) error {
+	// canceling the context of the writer before closing it aborts the write
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	writer, err := c.bucket.NewWriter(writeCtx, strings.ToLower(filePath), c.writerOptions(mimeType, metadata))
+	if err != nil {
+		return err
+	}
+
+	written, err := io.Copy(writer, r)
+	if err == nil {
+		err = c.quota.reserve(ctx, filePath, existingStoredSize, written)
+	}
+	if err != nil {
+		cancel()
+		_ = writer.Close()
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		// the reserved quota might not match what is stored anymore
+		c.quota.reset()
+		return err
+	}
+
+	if !c.versioned {
+		return nil
+	}
+
+	reader, err := c.bucket.NewReader(ctx, strings.ToLower(filePath), nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	versionMetadata := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		versionMetadata[k] = v
+	}
+	versionMetadata[originalPathAttributeKey] = c.versionPath(metadata[originalPathAttributeKey], newTimestamp())
+
+	versionCtx, cancelVersion := context.WithCancel(ctx)
+	defer cancelVersion()
+
+	versionWriter, err := c.bucket.NewWriter(versionCtx, strings.ToLower(versionMetadata[originalPathAttributeKey]), c.writerOptions(mimeType, versionMetadata))
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(versionWriter, reader); err != nil {
+		cancelVersion()
+		_ = versionWriter.Close()
+		return err
+	}
+	return versionWriter.Close()
+}
+
+func validateProperties(properties map[string]string) error {
+	size := 0
+	for k, v := range properties {
+		if !propertyKeyRegex.MatchString(k) {
+			return fmt.Errorf("%w: key %q must contain only lower case letters, digits, '-' and '_' and can not start with '-' or '_'", ErrInvalidProperties, k)
+		}
+		size += len(k) + len(v)
+	}
+
+	if size > maxPropertiesSize {
+		return fmt.Errorf("%w: properties take %d bytes, at most %d bytes are allowed", ErrInvalidProperties, size, maxPropertiesSize)
+	}
+	return nil
+}
+
+// Append rewrites the whole file, blob buckets can not append to existing objects.
+func (c cdkBlobStorage) Append(ctx context.Context, path string, data []byte) error {
+	return appendByRewriting(ctx, c, path, data)
+}
+
+// Touch rewrites the file with the same contents and properties, blob buckets can not update the modification time
+// of an object in place.
+func (c cdkBlobStorage) Touch(ctx context.Context, path string) error {
+	c.upsertLock.Lock()
+	defer c.upsertLock.Unlock()
+
+	existing, existingStoredSize, err := c.read(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	if existing == nil {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, path)
+	}
+
+	metadata := make(map[string]string, len(existing.Properties)+1)
+	for k, v := range existing.Properties {
+		metadata[k] = v
+	}
+	metadata[originalPathAttributeKey] = existing.FullPath
+	return c.encodeAndWrite(ctx, path, existingStoredSize, existing.Contents, existing.MimeType, metadata)
+}
+
+// appendByRewriting appends to the file with a read-modify-write guarded by the ETag of the read file, the first append
+// creates the file with CreateOnly. The guards are only as strong as the preconditions of the storage.
+func appendByRewriting(ctx context.Context, storage FileStorage, path string, data []byte) error {
+	existing, err := storage.Get(ctx, path)
+	if errors.Is(err, ErrFileNotFound) {
+		contents := data
+		err := storage.Upsert(ctx, &UpsertFileCommand{
+			Path:       path,
+			MimeType:   detectUpsertContentType(path, data),
+			Contents:   &contents,
+			CreateOnly: true,
+		})
+		if errors.Is(err, ErrAlreadyExists) {
+			return fmt.Errorf("%w: %s", ErrConcurrentAppend, path)
+		}
+		return err
+	}
+
+	if err != nil {
+		return err
+	}
+
+	contents := make([]byte, 0, len(existing.Contents)+len(data))
+	contents = append(contents, existing.Contents...)
+	contents = append(contents, data...)
+
+	err = storage.Upsert(ctx, &UpsertFileCommand{
+		Path:        path,
+		Contents:    &contents,
+		IfMatchETag: existing.ETag,
+	})
+	if errors.Is(err, ErrPreconditionFailed) {
+		return fmt.Errorf("%w: %s", ErrConcurrentAppend, path)
+	}
+	return err
+}
+
+// encodeAndWrite reserves the quota for the stored size of the file, which is smaller than its contents if compressed.
+func (c cdkBlobStorage) encodeAndWrite(ctx context.Context, filePath string, existingStoredSize int64, contents []byte, mimeType string, metadata map[string]string) error {
+	stored, metadata, err := c.encode(filePath, contents, mimeType, metadata)
+	if err != nil {
+		return err
+	}
+
+	if err := c.quota.reserve(ctx, filePath, existingStoredSize, int64(len(stored))); err != nil {
+		return err
+	}
+	return c.write(ctx, filePath, stored, mimeType, metadata)
+}
+
+// write stores the file, and a copy of it as a new version if the backend is versioned.
+func (c cdkBlobStorage) write(ctx context.Context, filePath string, contents []byte, mimeType string, metadata map[string]string) error {
+	if c.contentAddressable {
+		return c.writeContentAddressed(ctx, filePath, contents, mimeType, metadata)
+	}
+
+	if err := c.bucket.WriteAll(ctx, strings.ToLower(filePath), contents, c.writerOptions(mimeType, metadata)); err != nil {
+		// the reserved quota might not match what is stored anymore
+		c.quota.reset()
+		return err
+	}
+
+	if !c.versioned {
+		return nil
+	}
+
+	versionMetadata := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		versionMetadata[k] = v
+	}
+	versionMetadata[originalPathAttributeKey] = c.versionPath(metadata[originalPathAttributeKey], newTimestamp())
+	return c.bucket.WriteAll(ctx, strings.ToLower(versionMetadata[originalPathAttributeKey]), contents, c.writerOptions(mimeType, versionMetadata))
+}
+
+// writeContentAddressed stores the contents under the key of their hash unless they are stored already, and points
+// the file at them. The contents of a replaced file are released.
+func (c cdkBlobStorage) writeContentAddressed(ctx context.Context, filePath string, contents []byte, mimeType string, metadata map[string]string) error {
+	sum := sha256.Sum256(contents)
+	hash := hex.EncodeToString(sum[:])
+
+	c.contentLock.Lock()
+	defer c.contentLock.Unlock()
+
+	exists, err := c.bucket.Exists(ctx, contentKey(hash))
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		if err := c.bucket.WriteAll(ctx, contentKey(hash), contents, c.writerOptions(mimeType, nil)); err != nil {
+			return err
+		}
+	}
+
+	fileMetadata := make(map[string]string, len(metadata)+2)
+	for k, v := range metadata {
+		fileMetadata[k] = v
+	}
+	if _, ok := fileMetadata[sizeAttributeKey]; !ok {
+		fileMetadata[sizeAttributeKey] = strconv.Itoa(len(contents))
+	}
+	return c.pointAt(ctx, filePath, hash, mimeType, fileMetadata)
+}
+
+// pointAt writes the file as an empty object referencing the stored contents with the hash, and releases the
+// contents it referenced before. The metadata is modified. The caller holds the content lock.
+func (c cdkBlobStorage) pointAt(ctx context.Context, filePath string, hash string, mimeType string, metadata map[string]string) error {
+	var previousHash string
+	attributes, err := c.bucket.Attributes(ctx, strings.ToLower(filePath))
+	if err == nil {
+		previousHash = contentHash(attributes)
+	} else if gcerrors.Code(err) != gcerrors.NotFound {
+		return err
+	}
+
+	// the reference is added before the file is written, so that the contents are never unreferenced
+	if err := c.bucket.WriteAll(ctx, contentRefKey(hash, filePath), []byte(filePath), c.writerOptions("text/plain", nil)); err != nil {
+		return err
+	}
+
+	metadata[contentHashAttributeKey] = hash
+	if err := c.bucket.WriteAll(ctx, strings.ToLower(filePath), []byte{}, c.writerOptions(mimeType, metadata)); err != nil {
+		return err
+	}
+
+	if previousHash == "" || previousHash == hash {
+		return nil
+	}
+	return c.releaseContent(ctx, previousHash, filePath)
+}
+
+// releaseContent removes the reference of the file to the stored contents with the hash, and deletes the contents
+// once no file references them. The caller holds the content lock.
+func (c cdkBlobStorage) releaseContent(ctx context.Context, hash string, filePath string) error {
+	if err := c.bucket.Delete(ctx, contentRefKey(hash, filePath)); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return err
+	}
+
+	refs, _, err := c.bucket.ListPage(ctx, blob.FirstPageToken, 1, &blob.ListOptions{
+		Prefix: contentRefKey(hash, ""),
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(refs) > 0 {
+		return nil
+	}
+
+	if err := c.bucket.Delete(ctx, contentKey(hash)); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return err
+	}
+	return nil
+}
+
+// contentKey is the key of the stored contents with the hash.
+func contentKey(hash string) string {
+	return strings.ToLower(Join(contentFolderName, "blobs", hash))
+}
+
+// contentRefKey is the key of the marker of the reference of the file to the stored contents with the hash. The path
+// is hashed, so that the references of a file are found by its path regardless of its nesting. An empty path
+// returns the prefix of all references to the contents.
+func contentRefKey(hash string, filePath string) string {
+	prefix := strings.ToLower(Join(contentFolderName, "refs", hash)) + Delimiter
+	if filePath == "" {
+		return prefix
+	}
+
+	sum := sha256.Sum256([]byte(strings.ToLower(filePath)))
+	return prefix + hex.EncodeToString(sum[:])
+}
+
+// writerOptions requests server-side encryption of the written object if a KMS key is configured.
+func (c cdkBlobStorage) writerOptions(mimeType string, metadata map[string]string) *blob.WriterOptions {
+	options := &blob.WriterOptions{
+		ContentType: mimeType,
+		Metadata:    metadata,
+	}
+
+	if c.sseKMSKeyID != "" {
+		options.BeforeWrite = func(asFunc func(interface{}) bool) error {
+			var input *s3manager.UploadInput
+			if !asFunc(&input) {
+				return ErrEncryptionUnsupported
+			}
+
+			input.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+			input.SSEKMSKeyId = aws.String(c.sseKMSKeyID)
+			return nil
+		}
+	}
+
+	return options
+}
+
+func (c cdkBlobStorage) versionPath(filePath string, version string) string {
+	return Join(versionsFolderName) + filePath + Delimiter + version
+}
+
+func (c cdkBlobStorage) ListVersions(ctx context.Context, filePath string) ([]FileMetadata, error) {
+	if !c.versioned {
+		return nil, ErrVersioningNotEnabled
+	}
+
+	prefix := strings.ToLower(c.convertFolderPathToPrefix(Join(versionsFolderName) + filePath))
+	iterator := c.bucket.List(&blob.ListOptions{
+		Prefix:    prefix,
+		Delimiter: Delimiter,
+	})
+
+	versions := make([]FileMetadata, 0)
+	for {
+		obj, err := iterator.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			c.log.Error("Failed while iterating over versions", "path", filePath, "err", err)
+			return nil, err
+		}
+
+		if obj.IsDir {
+			continue
+		}
+
+		attributes, err := c.bucket.Attributes(ctx, obj.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		versions = append(versions, newVersionMetadata(filePath, strings.TrimPrefix(obj.Key, prefix), attributes))
+	}
+
+	// most recent first
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version > versions[j].Version
+	})
+	return versions, nil
+}
+
+func (c cdkBlobStorage) GetVersion(ctx context.Context, filePath string, version string) (*File, error) {
+	if !c.versioned {
+		return nil, ErrVersioningNotEnabled
+	}
+
+	if version == "" || strings.Contains(version, Delimiter) {
+		return nil, fmt.Errorf("%w: %s version %q", ErrFileNotFound, filePath, version)
+	}
+
+	key := strings.ToLower(c.versionPath(filePath, version))
+	contents, err := c.bucket.ReadAll(ctx, key)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil, fmt.Errorf("%w: %s version %q", ErrFileNotFound, filePath, version)
+		}
+		return nil, err
+	}
+
+	attributes, err := c.bucket.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.newFile(ctx, key, newVersionMetadata(filePath, version, attributes), attributes, contents)
+}
+
+// newVersionMetadata describes a stored version with the path of the file it is a version of.
+func newVersionMetadata(filePath string, version string, attributes *blob.Attributes) FileMetadata {
+	if versionPath, ok := attributes.Metadata[originalPathAttributeKey]; ok {
+		filePath = strings.TrimSuffix(strings.TrimPrefix(versionPath, Join(versionsFolderName)), Delimiter+version)
+	}
+
+	metadata := newFileMetadata(filePath, attributes)
+	metadata.Name = getName(filePath)
+	metadata.FullPath = filePath
+	metadata.Version = version
+	return metadata
+}
+
+func (c cdkBlobStorage) Copy(ctx context.Context, srcPath string, dstPath string) error {
+	return c.copy(ctx, srcPath, dstPath, false)
+}
+
+// copy reserves the stored size of the source at the destination before copying it. The size of a moved source is
+// released by the same reservation, so that moving a file does not count it twice.
+func (c cdkBlobStorage) copy(ctx context.Context, srcPath string, dstPath string, moved bool) error {
+	attributes, err := c.bucket.Attributes(ctx, strings.ToLower(srcPath))
+	if err != nil {
+		return err
+	}
+
+	metadata := make(map[string]string)
+	for k, v := range attributes.Metadata {
+		metadata[k] = v
+	}
+	metadata[originalPathAttributeKey] = dstPath
+
+	// the copy of a content addressed file references the same contents
+	if hash := contentHash(attributes); hash != "" {
+		c.contentLock.Lock()
+		defer c.contentLock.Unlock()
+		return c.pointAt(ctx, dstPath, hash, attributes.ContentType, metadata)
+	}
+
+	if err := c.reserveCopy(ctx, srcPath, dstPath, attributes.Size, moved); err != nil {
+		return err
+	}
+
+	if err := c.copyObject(ctx, srcPath, dstPath, attributes.ContentType, metadata); err != nil {
+		// the reserved quota might not match what is stored anymore
+		c.quota.reset()
+		return err
+	}
+	return nil
+}
+
+// reserveCopy accounts for the file stored at the destination being replaced with a copy of size bytes.
+func (c cdkBlobStorage) reserveCopy(ctx context.Context, srcPath string, dstPath string, size int64, moved bool) error {
+	if c.quota == nil {
+		return nil
+	}
+
+	var existingSize, newSize int64
+	if dstKey := strings.ToLower(dstPath); c.countsTowardQuota(dstKey) {
+		newSize = size
+		existing, err := c.bucket.Attributes(ctx, dstKey)
+		if err == nil {
+			existingSize = existing.Size
+		} else if gcerrors.Code(err) != gcerrors.NotFound {
+			return err
+		}
+	}
+
+	if moved && c.countsTowardQuota(strings.ToLower(srcPath)) {
+		existingSize += size
+	}
+	return c.quota.reserve(ctx, dstPath, existingSize, newSize)
+}
+
+// copyObject copies the object within the bucket if it can replace the metadata of the copy, and streams the contents
+// into a new object otherwise.
+func (c cdkBlobStorage) copyObject(ctx context.Context, srcPath string, dstPath string, contentType string, metadata map[string]string) error {
+	if c.replacesCopyMetadata() {
+		err := c.bucket.Copy(ctx, strings.ToLower(dstPath), strings.ToLower(srcPath), c.copyOptions(contentType, metadata))
+		if err == nil {
+			return nil
+		}
+		if gcerrors.Code(err) != gcerrors.Unimplemented && !errors.Is(err, errCopyMetadataUnsupported) {
+			return err
+		}
+	}
+
+	// the bucket can not copy the object with updated metadata, so the contents are streamed into a new object
+	reader, err := c.bucket.NewReader(ctx, strings.ToLower(srcPath), nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			c.log.Error("Failed to close reader", "path", srcPath, "err", err)
+		}
+	}()
+
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	writer, err := c.bucket.NewWriter(writeCtx, strings.ToLower(dstPath), c.writerOptions(contentType, metadata))
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(writer, reader); err != nil {
+		// cancelling the context before closing the writer discards the partially written object
+		cancel()
+		_ = writer.Close()
+		return err
+	}
+
+	return writer.Close()
+}
+
+// replacesCopyMetadata reports whether the bucket is backed by S3, GCS or Azure, whose drivers let copyOptions
+// replace the metadata of the copied object. The mem and file drivers can not replace it.
+func (c cdkBlobStorage) replacesCopyMetadata() bool {
+	var s3Client *s3.S3
+	var gcsClient *storage.Client
+	var containerURL *azblob.ContainerURL
+	return c.bucket.As(&s3Client) || c.bucket.As(&gcsClient) || c.bucket.As(&containerURL)
+}
+
+// errCopyMetadataUnsupported is returned before copying when the bucket can not replace the metadata of the copy.
+var errCopyMetadataUnsupported = errors.New("replacing the metadata of copied objects is not supported")
+
+// copyOptions replace the metadata of the object copied by the bucket, which would carry over the original path of
+// the source file otherwise. The metadata is escaped the way the S3 and Azure drivers escape it when writing, keys
+// they would hex-escape are not supported.
+func (c cdkBlobStorage) copyOptions(mimeType string, metadata map[string]string) *blob.CopyOptions {
+	return &blob.CopyOptions{
+		BeforeCopy: func(asFunc func(interface{}) bool) error {
+			var s3Input *s3.CopyObjectInput
+			if asFunc(&s3Input) {
+				escapedMetadata := make(map[string]*string, len(metadata))
+				for k, v := range metadata {
+					key := url.PathEscape(k)
+					if strings.ContainsAny(key, "@:=") {
+						return errCopyMetadataUnsupported
+					}
+					escapedMetadata[key] = aws.String(url.PathEscape(v))
+				}
+
+				s3Input.MetadataDirective = aws.String(s3.MetadataDirectiveReplace)
+				s3Input.ContentType = aws.String(mimeType)
+				s3Input.Metadata = escapedMetadata
+				if c.sseKMSKeyID != "" {
+					s3Input.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+					s3Input.SSEKMSKeyId = aws.String(c.sseKMSKeyID)
+				}
+				return nil
+			}
+
+			if c.sseKMSKeyID != "" {
+				return ErrEncryptionUnsupported
+			}
+
+			var gcsCopier *storage.Copier
+			if asFunc(&gcsCopier) {
+				gcsCopier.ContentType = mimeType
+				gcsCopier.Metadata = metadata
+				return nil
+			}
+
+			var azureMetadata azblob.Metadata
+			if asFunc(&azureMetadata) {
+				for k, v := range metadata {
+					if !azureMetadataKeyRegex.MatchString(k) {
+						return errCopyMetadataUnsupported
+					}
+					azureMetadata[k] = url.PathEscape(v)
+				}
+				return nil
+			}
+
+			return errCopyMetadataUnsupported
+		},
+	}
+}
+
+func (c cdkBlobStorage) Move(ctx context.Context, srcPath string, dstPath string) error {
+	if strings.EqualFold(srcPath, dstPath) {
+		return c.Copy(ctx, srcPath, dstPath)
+	}
+
+	// the source is only removed once the copy succeeded
+	if err := c.copy(ctx, srcPath, dstPath, true); err != nil {
+		return err
+	}
+
+	if c.contentAddressable {
+		// deleting the source releases its reference to the contents, there is no trash in content addressable backends
+		_, err := c.deleteFile(ctx, srcPath)
+		return err
+	}
+
+	if err := c.bucket.Delete(ctx, strings.ToLower(srcPath)); err != nil {
+		// the size of the source was released with the copy
+		c.quota.reset()
+		return err
+	}
+	return nil
 }
 
-func (c cdkBlobStorage) listFiles(ctx context.Context, folderPath string, paging *Paging, options *ListOptions) (*ListFilesResponse, error) {
+// listFiles lists the files of the folder at the given depth, the listed folder being at depth 1. Folders beyond
+// the max depth are not walked and HasMore is set. If the page ends with such a folder, its path is the last path
+// of the page, so that the next page resumes after it.
+func (c cdkBlobStorage) listFiles(ctx context.Context, folderPath string, paging *Paging, options *ListOptions, depth int) (*ListFilesResponse, error) {
 	iterator := c.bucket.List(&blob.ListOptions{
 		Prefix:    strings.ToLower(folderPath),
 		Delimiter: Delimiter,
@@ -144,7 +1633,7 @@ func (c cdkBlobStorage) listFiles(ctx context.Context, folderPath string, paging
 
 	recursive := options.Recursive
 
-	pageSize := paging.First
+	pageSize := options.pageSize(paging.First)
 
 	foundCursor := true
 	if paging.After != "" {
@@ -152,6 +1641,10 @@ func (c cdkBlobStorage) listFiles(ctx context.Context, folderPath string, paging
 	}
 
 	hasMore := true
+	// truncated is set once a folder is left out for being too deep, truncatedPath holds its path until a file
+	// is listed after it
+	truncated := false
+	truncatedPath := ""
 	files := make([]FileMetadata, 0)
 	for {
 		obj, err := iterator.Next(ctx)
@@ -179,6 +1672,20 @@ func (c cdkBlobStorage) listFiles(ctx context.Context, folderPath string, paging
 
 		allowed := options.isAllowed(obj.Key)
 		if obj.IsDir && recursive {
+			// skip folders which were fully listed in previous pages
+			if !foundCursor && obj.Key < paging.After && !strings.HasPrefix(paging.After, obj.Key) {
+				continue
+			}
+
+			if options.MaxDepth > 0 && depth >= options.MaxDepth {
+				// the folder is the cursor if a previous page ended with it
+				if foundCursor || strings.TrimSuffix(obj.Key, Delimiter) > paging.After {
+					truncated = true
+					truncatedPath = fixPath(obj.Key)
+				}
+				continue
+			}
+
 			newPaging := &Paging{
 				First: pageSize - len(files),
 			}
@@ -186,20 +1693,27 @@ func (c cdkBlobStorage) listFiles(ctx context.Context, folderPath string, paging
 				newPaging.After = paging.After
 			}
 
-			resp, err := c.listFiles(ctx, path, newPaging, options)
+			resp, err := c.listFiles(ctx, path, newPaging, options, depth+1)
 
 			if err != nil {
 				return nil, err
 			}
 
-			if len(files) > 0 {
+			// files listed from the nested folder come after the cursor
+			if len(resp.Files) > 0 {
 				foundCursor = true
+				truncatedPath = ""
 			}
 
 			files = append(files, resp.Files...)
-			if len(files) >= pageSize {
-				//nolint: staticcheck
-				hasMore = resp.HasMore
+			if len(files) >= pageSize && resp.HasMore {
+				break
+			}
+
+			// a nested page which is not full only has more if it left out folders which are too deep
+			if resp.HasMore {
+				truncated = true
+				truncatedPath = resp.LastPath
 			}
 		} else if !obj.IsDir && allowed {
 			if !foundCursor {
@@ -214,34 +1728,17 @@ func (c cdkBlobStorage) listFiles(ctx context.Context, folderPath string, paging
 				}
 			}
 
-			attributes, err := c.bucket.Attributes(ctx, strings.ToLower(path))
+			metadata, err := c.listedFileMetadata(ctx, path, options)
 			if err != nil {
-				c.log.Error("Failed while retrieving attributes", "path", path, "err", err)
 				return nil, err
 			}
 
-			var originalPath string
-			var props map[string]string
-			if attributes.Metadata != nil {
-				props = attributes.Metadata
-				if path, ok := attributes.Metadata[originalPathAttributeKey]; ok {
-					originalPath = path
-					delete(props, originalPathAttributeKey)
-				}
-			} else {
-				props = make(map[string]string)
-				originalPath = fixPath(path)
+			if metadata == nil {
+				continue
 			}
 
-			files = append(files, FileMetadata{
-				Name:       getName(originalPath),
-				FullPath:   originalPath,
-				Created:    attributes.CreateTime,
-				Properties: props,
-				Modified:   attributes.ModTime,
-				Size:       attributes.Size,
-				MimeType:   detectContentType(originalPath, attributes.ContentType),
-			})
+			files = append(files, *metadata)
+			truncatedPath = ""
 		}
 	}
 
@@ -250,6 +1747,13 @@ func (c cdkBlobStorage) listFiles(ctx context.Context, folderPath string, paging
 		lastPath = files[len(files)-1].FullPath
 	}
 
+	if truncated {
+		hasMore = true
+	}
+	if truncatedPath != "" {
+		lastPath = truncatedPath
+	}
+
 	return &ListFilesResponse{
 		Files:    files,
 		HasMore:  hasMore,
@@ -287,22 +1791,267 @@ func fixPath(path string) string {
 }
 
 func (c cdkBlobStorage) convertListOptions(options *ListOptions) *ListOptions {
-	if options == nil || options.allowedPrefixes == nil || len(options.allowedPrefixes) == 0 {
+	if options == nil {
 		return options
 	}
 
-	newPrefixes := make([]string, len(options.allowedPrefixes))
-	for i, prefix := range options.allowedPrefixes {
-		newPrefixes[i] = c.fixInputPrefix(prefix)
+	if options.filter != nil {
+		options.filter = keyPathFilter{rootFolder: c.rootFolder, filter: options.filter}
+	}
+
+	if len(options.allowedPrefixes) == 0 && len(options.allowedPaths) == 0 && len(options.deniedPrefixes) == 0 {
+		return options
 	}
 
-	options.PathFilters.allowedPrefixes = newPrefixes
+	options.PathFilters.allowedPrefixes = c.fixInputPrefixes(options.allowedPrefixes)
+	options.PathFilters.allowedPaths = c.fixInputPrefixes(options.allowedPaths)
+	options.PathFilters.deniedPrefixes = c.fixInputPrefixes(options.deniedPrefixes)
 	return options
 }
 
+// keyPathFilter applies a path filter to the keys of the bucket, which are prefixed with the root folder.
+type keyPathFilter struct {
+	rootFolder string
+	filter     PathFilter
+}
+
+func (f keyPathFilter) IsAllowed(key string) bool {
+	return f.filter.IsAllowed(Delimiter + strings.TrimPrefix(strings.TrimPrefix(key, f.rootFolder), Delimiter))
+}
+
+func (c cdkBlobStorage) fixInputPrefixes(prefixes []string) []string {
+	if len(prefixes) == 0 {
+		return prefixes
+	}
+
+	newPrefixes := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		newPrefixes[i] = c.fixInputPrefix(prefix)
+	}
+	return newPrefixes
+}
+
+// withDefaultListOptions merges the default list options of the backend into a copy of the options,
+// the values set by the caller win.
+func (c cdkBlobStorage) withDefaultListOptions(options *ListOptions) *ListOptions {
+	if c.defaultListOptions == nil {
+		return options
+	}
+
+	merged := &ListOptions{}
+	if options != nil {
+		*merged = *options
+	}
+
+	if merged.Filter == "" {
+		merged.Filter = c.defaultListOptions.Filter
+	}
+	if len(merged.MimeTypeFilter) == 0 {
+		merged.MimeTypeFilter = c.defaultListOptions.MimeTypeFilter
+	}
+	if merged.MaxResults <= 0 {
+		merged.MaxResults = c.defaultListOptions.MaxResults
+	}
+	merged.IncludeFileCount = merged.IncludeFileCount || c.defaultListOptions.IncludeFileCount
+	return merged
+}
+
+// listedFileMetadata reads the metadata of the listed file, or returns nil if the file does not match the name or
+// MIME type filters of the options.
+func (c cdkBlobStorage) listedFileMetadata(ctx context.Context, key string, options *ListOptions) (*FileMetadata, error) {
+	if !options.matchesFilter(getName(key)) {
+		return nil, nil
+	}
+
+	attributes, err := c.bucket.Attributes(ctx, strings.ToLower(key))
+	if err != nil {
+		c.log.Error("Failed while retrieving attributes", "path", key, "err", err)
+		return nil, err
+	}
+
+	size := fileSize(attributes)
+
+	var originalPath string
+	var props map[string]string
+	if attributes.Metadata != nil {
+		// the attributes are left untouched, the ETag is read from them afterwards
+		props = make(map[string]string, len(attributes.Metadata))
+		for k, v := range attributes.Metadata {
+			props[k] = v
+		}
+		if path, ok := props[originalPathAttributeKey]; ok {
+			originalPath = path
+			delete(props, originalPathAttributeKey)
+		}
+		removeEncodingAttributes(props)
+	} else {
+		props = make(map[string]string)
+		originalPath = fixPath(key)
+	}
+
+	mimeType := detectContentType(originalPath, attributes.ContentType)
+	if !options.matchesMimeType(mimeType) {
+		return nil, nil
+	}
+
+	etag, err := c.getETag(ctx, strings.ToLower(key), attributes, nil)
+	if err != nil {
+		c.log.Error("Failed while retrieving ETag", "path", key, "err", err)
+		return nil, err
+	}
+
+	return &FileMetadata{
+		Name:       getName(originalPath),
+		FullPath:   originalPath,
+		Created:    attributes.CreateTime,
+		Properties: props,
+		Modified:   attributes.ModTime,
+		Size:       size,
+		MimeType:   mimeType,
+		ETag:       etag,
+	}, nil
+}
+
 func (c cdkBlobStorage) ListFiles(ctx context.Context, folderPath string, paging *Paging, options *ListOptions) (*ListFilesResponse, error) {
-	paging.After = c.fixInputPrefix(paging.After)
-	return c.listFiles(ctx, c.convertFolderPathToPrefix(folderPath), paging, c.convertListOptions(options))
+	options = c.withDefaultListOptions(options)
+
+	// the cursor is the full path of the last listed file. Keys are listed in lexicographical order at every level,
+	// so comparing keys with the cursor is enough to resume a walk through nested folders.
+	paging.After = strings.ToLower(c.fixInputPrefix(paging.After))
+	prefix := c.convertFolderPathToPrefix(folderPath)
+	options = c.convertListOptions(options)
+	resp, err := c.listFiles(ctx, prefix, paging, options, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	// the last path of the page is the cursor, it is kept as listed
+	options.sortFiles(resp.Files)
+	if options == nil || !options.IncludeTotalCount {
+		return resp, nil
+	}
+
+	if resp.TotalCount, err = c.countFiles(ctx, prefix, options, 1); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// WalkFiles calls the function for the files in the order the bucket lists their keys, as they are listed.
+func (c cdkBlobStorage) WalkFiles(ctx context.Context, folderPath string, options *ListOptions, fn func(FileMetadata) error) error {
+	options = c.convertListOptions(c.withDefaultListOptions(options))
+	return c.walkFiles(ctx, c.convertFolderPathToPrefix(folderPath), options, 1, fn)
+}
+
+func (c cdkBlobStorage) walkFiles(ctx context.Context, folderPath string, options *ListOptions, depth int, fn func(FileMetadata) error) error {
+	iterator := c.bucket.List(&blob.ListOptions{
+		Prefix:    strings.ToLower(folderPath),
+		Delimiter: Delimiter,
+	})
+
+	for {
+		obj, err := iterator.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			c.log.Error("Failed while walking files", "path", folderPath, "err", err)
+			return err
+		}
+
+		if strings.HasSuffix(obj.Key, directoryMarker) {
+			continue
+		}
+
+		if obj.IsDir {
+			if options.Recursive && (options.MaxDepth <= 0 || depth < options.MaxDepth) {
+				if err := c.walkFiles(ctx, obj.Key, options, depth+1, fn); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if !options.isAllowed(obj.Key) {
+			continue
+		}
+
+		metadata, err := c.listedFileMetadata(ctx, obj.Key, options)
+		if err != nil {
+			return err
+		}
+
+		if metadata == nil {
+			continue
+		}
+
+		if err := fn(*metadata); err != nil {
+			return err
+		}
+	}
+}
+
+// countFiles counts the files listFiles lists without paging. It walks all the keys under the folder and, if
+// the MIME types are filtered, reads the attributes of every file.
+func (c cdkBlobStorage) countFiles(ctx context.Context, folderPath string, options *ListOptions, depth int) (int, error) {
+	iterator := c.bucket.List(&blob.ListOptions{
+		Prefix:    strings.ToLower(folderPath),
+		Delimiter: Delimiter,
+	})
+
+	count := 0
+	for {
+		obj, err := iterator.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			c.log.Error("Failed while counting files", "path", folderPath, "err", err)
+			return 0, err
+		}
+
+		if strings.HasSuffix(obj.Key, directoryMarker) {
+			continue
+		}
+
+		if obj.IsDir {
+			if options.Recursive && (options.MaxDepth <= 0 || depth < options.MaxDepth) {
+				nested, err := c.countFiles(ctx, obj.Key, options, depth+1)
+				if err != nil {
+					return 0, err
+				}
+				count += nested
+			}
+			continue
+		}
+
+		if !options.isAllowed(obj.Key) || !options.matchesFilter(getName(obj.Key)) {
+			continue
+		}
+
+		if len(options.MimeTypeFilter) > 0 {
+			attributes, err := c.bucket.Attributes(ctx, obj.Key)
+			if err != nil {
+				c.log.Error("Failed while retrieving attributes", "path", obj.Key, "err", err)
+				return 0, err
+			}
+
+			originalPath, ok := attributes.Metadata[originalPathAttributeKey]
+			if !ok {
+				originalPath = fixPath(obj.Key)
+			}
+
+			if !options.matchesMimeType(detectContentType(originalPath, attributes.ContentType)) {
+				continue
+			}
+		}
+
+		count++
+	}
+
+	return count, nil
 }
 
 func (c cdkBlobStorage) listFolderPaths(ctx context.Context, parentFolderPath string, options *ListOptions) ([]string, error) {
@@ -361,11 +2110,19 @@ func (c cdkBlobStorage) listFolderPaths(ctx context.Context, parentFolderPath st
 }
 
 func (c cdkBlobStorage) ListFolders(ctx context.Context, prefix string, options *ListOptions) ([]FileMetadata, error) {
+	options = c.withDefaultListOptions(options)
 	foundPaths, err := c.listFolderPaths(ctx, c.convertFolderPathToPrefix(prefix), c.convertListOptions(options))
 	if err != nil {
 		return nil, err
 	}
 
+	// the whole tree is walked, as the folders can only be told apart from the files found in them
+	if options != nil && options.Recursive && options.MaxDepth > 0 {
+		for i := range foundPaths {
+			foundPaths[i] = truncateFolderPath(prefix, foundPaths[i], options.MaxDepth)
+		}
+	}
+
 	folders := make([]FileMetadata, 0)
 	mem := make(map[string]bool)
 	for i := 0; i < len(foundPaths); i++ {
@@ -392,9 +2149,64 @@ func (c cdkBlobStorage) ListFolders(ctx context.Context, prefix string, options
 		}
 	}
 
+	sort.Slice(folders, func(i, j int) bool {
+		return folders[i].FullPath < folders[j].FullPath
+	})
+
+	if options != nil && options.IncludeFileCount {
+		for i := range folders {
+			if folders[i].FileCount, err = c.countFolderFiles(ctx, folders[i].FullPath, options); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	return folders, err
 }
 
+// truncateFolderPath cuts the path of a folder nested in the parent folder to at most maxDepth folders below it.
+func truncateFolderPath(parentFolderPath string, path string, maxDepth int) string {
+	base := strings.TrimSuffix(parentFolderPath, Delimiter)
+	if len(path) <= len(base) || !strings.EqualFold(path[:len(base)], base) {
+		return path
+	}
+
+	parts := strings.Split(strings.TrimPrefix(path[len(base):], Delimiter), Delimiter)
+	if len(parts) <= maxDepth {
+		return path
+	}
+	return path[:len(base)] + Delimiter + strings.Join(parts[:maxDepth], Delimiter)
+}
+
+// countFolderFiles counts the files directly in the folder, stopping at maxFolderFileCount so that large folders
+// are not listed in full.
+func (c cdkBlobStorage) countFolderFiles(ctx context.Context, folderPath string, options *ListOptions) (int, error) {
+	iterator := c.bucket.List(&blob.ListOptions{
+		Prefix:    strings.ToLower(c.convertFolderPathToPrefix(folderPath)),
+		Delimiter: Delimiter,
+	})
+
+	count := 0
+	for count < maxFolderFileCount {
+		obj, err := iterator.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			c.log.Error("Failed while counting files", "path", folderPath, "err", err)
+			return 0, err
+		}
+
+		if obj.IsDir || strings.HasSuffix(obj.Key, directoryMarker) || !options.isAllowed(obj.Key) {
+			continue
+		}
+		count++
+	}
+
+	return count, nil
+}
+
 func precedingFolders(path string) []string {
 	parts := strings.Split(path, Delimiter)
 	if len(parts) == 0 {
@@ -421,9 +2233,21 @@ func precedingFolders(path string) []string {
 	return res
 }
 
-func (c cdkBlobStorage) CreateFolder(ctx context.Context, path string) error {
+func (c cdkBlobStorage) CreateFolder(ctx context.Context, path string, options *CreateFolderOptions) error {
 	c.log.Info("Creating folder", "path", path)
 
+	if options != nil && options.FailIfExists {
+		// not atomic, a folder created concurrently after the check is not detected
+		exists, err := c.bucket.Exists(ctx, strings.ToLower(path+Delimiter+directoryMarker))
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			return fmt.Errorf("%w: %s", ErrFolderAlreadyExists, path)
+		}
+	}
+
 	precedingFolders := precedingFolders(path)
 	folderToOriginalCasing := make(map[string]string)
 	foundFolderIndex := -1
@@ -450,6 +2274,10 @@ func (c cdkBlobStorage) CreateFolder(ctx context.Context, path string) error {
 
 	for i := foundFolderIndex + 1; i < len(precedingFolders); i++ {
 		currentFolder := precedingFolders[i]
+		if i < len(precedingFolders)-1 && !options.isAllowed(currentFolder) {
+			// parents which are not permitted are not marked, they are still listed as parents of the created folder
+			continue
+		}
 
 		previousFolderOriginalCasing := ""
 		if i > 0 {
@@ -459,9 +2287,7 @@ func (c cdkBlobStorage) CreateFolder(ctx context.Context, path string) error {
 		metadata := make(map[string]string)
 		currentFolderWithOriginalCasing := previousFolderOriginalCasing + Delimiter + getName(currentFolder)
 		metadata[originalPathAttributeKey] = currentFolderWithOriginalCasing + Delimiter + directoryMarker
-		if err := c.bucket.WriteAll(ctx, strings.ToLower(metadata[originalPathAttributeKey]), make([]byte, 0), &blob.WriterOptions{
-			Metadata: metadata,
-		}); err != nil {
+		if err := c.bucket.WriteAll(ctx, strings.ToLower(metadata[originalPathAttributeKey]), make([]byte, 0), c.writerOptions("", metadata)); err != nil {
 			return err
 		}
 		c.log.Info("Created folder", "path", currentFolderWithOriginalCasing, "marker", metadata[originalPathAttributeKey])
@@ -470,7 +2296,31 @@ func (c cdkBlobStorage) CreateFolder(ctx context.Context, path string) error {
 	return nil
 }
 
-func (c cdkBlobStorage) DeleteFolder(ctx context.Context, folderPath string) error {
+func (c cdkBlobStorage) DeleteFolder(ctx context.Context, folderPath string, options *DeleteFolderOptions) error {
+	prefix := strings.ToLower(c.convertFolderPathToPrefix(folderPath))
+	force := options != nil && options.Force
+	if !force {
+		isEmpty, err := c.isFolderEmpty(ctx, prefix)
+		if err != nil {
+			return err
+		}
+
+		if !isEmpty {
+			return fmt.Errorf("%w: %s", ErrFolderNotEmpty, folderPath)
+		}
+	}
+
+	if c.trashPrefix != "" {
+		return c.moveFolderToTrash(ctx, prefix)
+	}
+
+	if force {
+		err := c.deleteAllWithPrefix(ctx, prefix)
+		// the size of the deleted files is not known
+		c.quota.reset()
+		return err
+	}
+
 	directoryMarkerPath := fmt.Sprintf("%s%s%s", folderPath, Delimiter, directoryMarker)
 	exists, err := c.bucket.Exists(ctx, strings.ToLower(directoryMarkerPath))
 
@@ -486,6 +2336,87 @@ func (c cdkBlobStorage) DeleteFolder(ctx context.Context, folderPath string) err
 	return err
 }
 
+// moveFolderToTrash moves the whole subtree of the folder, including its directory markers, to the trash.
+func (c cdkBlobStorage) moveFolderToTrash(ctx context.Context, prefix string) error {
+	keys, err := c.listKeys(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	trashKeyPrefix := strings.ToLower(c.convertFolderPathToPrefix(Join(c.trashPrefix)))
+	untrashedKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !strings.HasPrefix(key, trashKeyPrefix) {
+			untrashedKeys = append(untrashedKeys, key)
+		}
+	}
+
+	timestamp := newTimestamp()
+	return c.moveAll(ctx, untrashedKeys, func(path string) string {
+		return c.trashPath(timestamp, path)
+	})
+}
+
+// isFolderEmpty fetches a single page of two objects - enough to find a child other than the folder's own directory marker.
+func (c cdkBlobStorage) isFolderEmpty(ctx context.Context, prefix string) (bool, error) {
+	objects, _, err := c.bucket.ListPage(ctx, blob.FirstPageToken, 2, &blob.ListOptions{
+		Prefix: prefix,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, obj := range objects {
+		if strings.TrimPrefix(obj.Key, prefix) != directoryMarker {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (c cdkBlobStorage) deleteAllWithPrefix(ctx context.Context, prefix string) error {
+	iterator := c.bucket.List(&blob.ListOptions{
+		Prefix: prefix,
+	})
+
+	for {
+		obj, err := iterator.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			c.log.Error("Failed while iterating over files", "err", err)
+			return err
+		}
+
+		if c.contentAddressable {
+			// deleting the files one by one releases their contents
+			if _, err := c.deleteFile(ctx, obj.Key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := c.bucket.Delete(ctx, obj.Key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			return err
+		}
+	}
+}
+
+func (c cdkBlobStorage) HealthCheck(ctx context.Context) error {
+	accessible, err := c.bucket.IsAccessible(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !accessible {
+		return errors.New("bucket is not accessible")
+	}
+	return nil
+}
+
 func (c cdkBlobStorage) close() error {
 	return c.bucket.Close()
 }
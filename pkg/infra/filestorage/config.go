@@ -0,0 +1,506 @@
+package filestorage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/setting"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	sectionName          = "file_storage"
+	backendSectionPrefix = "file_storage.backend."
+
+	backendTypeFS    = "fs"
+	backendTypeS3    = "s3"
+	backendTypeGCS   = "gcs"
+	backendTypeAzure = "azure"
+	backendTypeDB    = "db"
+	backendTypeMem   = "mem"
+
+	// defaultMaxConcurrency bounds the backend operations of the bulk methods when max_concurrency is not set
+	defaultMaxConcurrency = 16
+)
+
+type backendConfig struct {
+	Name            string
+	AllowedPrefixes []string
+	// AllowedPaths are single files permitted on top of the allowed prefixes, the denied prefixes still win.
+	AllowedPaths   []string
+	DeniedPrefixes []string
+	// AllowedPathPatterns and DeniedPathPatterns are regular expressions filtering the paths on top of the prefixes.
+	// They are configured as comma separated lists, so the patterns can not contain commas.
+	AllowedPathPatterns []string
+	DeniedPathPatterns  []string
+	SupportedOperations []Operation
+	// ReadOnly limits the backend to read operations.
+	ReadOnly bool
+	// OperationTimeout bounds the duration of every operation on the backend. Zero means no limit.
+	OperationTimeout time.Duration
+	// CacheTTL enables caching the files and metadata read from the backend for the given time. Zero disables the cache.
+	CacheTTL time.Duration
+	// CacheSize is the number of cached entries, it defaults to 1000.
+	CacheSize int
+	// MaxRetries is the number of times Get, Upsert, Delete and ListFiles are retried after a transient error.
+	// Zero disables the retries.
+	MaxRetries int
+	// RetryBaseDelay is the delay before the first retry, it doubles with every retry. It defaults to 100ms.
+	RetryBaseDelay time.Duration
+}
+
+// blobBackendConfig holds the settings shared by all backends built on top of a blob bucket.
+type blobBackendConfig struct {
+	backendConfig
+	MaxFileSize  int64
+	TrashPrefix  string
+	Versioned    bool
+	MaxTotalSize int64
+	// CompressContentTypes are the MIME types of the files stored gzip-compressed.
+	CompressContentTypes []string
+	// ContentAddressable stores identical contents once, it can not be combined with the trash, the versioning
+	// and the total size limit.
+	ContentAddressable bool
+	// DefaultListOptions apply to the listings of the backend unless set by the caller.
+	DefaultListOptions ListOptions
+}
+
+type fsBackendConfig struct {
+	blobBackendConfig
+	Path string
+}
+
+type s3BackendConfig struct {
+	blobBackendConfig
+	Bucket   string
+	Region   string
+	Endpoint string
+	// SSEKMSKeyID is the KMS key used to encrypt the stored objects, they are not encrypted with KMS if it is empty.
+	SSEKMSKeyID string
+}
+
+type gcsBackendConfig struct {
+	blobBackendConfig
+	Bucket string
+	// CredentialsFile is the path of a JSON credentials file, the application default credentials are used if it is empty.
+	CredentialsFile string
+	// AccessID and PrivateKeyPath identify the service account signing the URLs of the files.
+	AccessID       string
+	PrivateKeyPath string
+}
+
+type azureBackendConfig struct {
+	blobBackendConfig
+	AccountName string
+	Container   string
+	// AccountKey signs the requests with the shared key of the account. SASToken grants access to the container
+	// otherwise, the container is accessed anonymously if neither is set.
+	AccountKey string
+	SASToken   string
+	// StorageDomain and Protocol default to "blob.core.windows.net" and "https".
+	StorageDomain string
+	Protocol      string
+}
+
+type dbBackendConfig struct {
+	backendConfig
+}
+
+// memBackendConfig configures an in-memory backend, its files are lost when Grafana stops.
+type memBackendConfig struct {
+	blobBackendConfig
+}
+
+type backendsConfig struct {
+	FS    []fsBackendConfig
+	S3    []s3BackendConfig
+	GCS   []gcsBackendConfig
+	Azure []azureBackendConfig
+	DB    []dbBackendConfig
+	Mem   []memBackendConfig
+}
+
+type filestorageConfig struct {
+	Backends backendsConfig
+	// SlowLogThreshold is the duration above which the operations are logged as slow. Zero disables the logging.
+	SlowLogThreshold time.Duration
+	// StrictBackendResolution fails the operations on paths which do not belong to any backend with
+	// ErrBackendNotFound, instead of silently ignoring them.
+	StrictBackendResolution bool
+	// MaxConcurrency bounds the backend operations the bulk methods, e.g. DeleteMany or ExportTo, run at once.
+	MaxConcurrency int
+	// ProtectedPaths are the virtual paths, including the backend name, which can not be written to. Writes to
+	// the paths nested in them fail too, reads are allowed.
+	ProtectedPaths []string
+}
+
+// newConfig reads the service settings from the `[file_storage]` section and the named file storage backends
+// from `[file_storage.backend.<name>]` sections, e.g.:
+//
+//	[file_storage]
+//	slow_log_threshold = 1s
+//	strict_backend_resolution = true
+//	max_concurrency = 16
+//	protected_paths = /system,/resources/dashboards/provisioned
+//
+//	[file_storage.backend.resources]
+//	type = s3
+//	bucket = grafana-resources
+//	region = us-east-1
+//	sse_kms_key_id = arn:aws:kms:us-east-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab
+//	allowed_prefixes = images/,dashboards/
+//	allowed_paths = /logo.png
+//	denied_prefixes = dashboards/private/
+//	allowed_path_patterns = ^/images/[0-9a-f]{64}\.png$
+//	denied_path_patterns = \.tmp$
+//	supported_operations = get,list_files,list_folders
+//	read_only = true
+//	operation_timeout = 30s
+//	cache_ttl = 5m
+//	cache_size = 1000
+//	max_retries = 3
+//	retry_base_delay = 100ms
+//	max_file_size = 10485760
+//	max_total_size = 1073741824
+//	trash_prefix = .trash
+//	versioned = true
+//	compress_content_types = application/json,text/plain
+//	content_addressable = false
+//	default_list_filter = *.png
+//	default_list_mime_types = image/*
+//	default_list_max_results = 50
+//	default_list_include_file_count = true
+//
+// GCS backends take the same settings as S3 backends besides the S3 specific ones, e.g.:
+//
+//	[file_storage.backend.assets]
+//	type = gcs
+//	bucket = grafana-assets
+//	credentials_file = /etc/grafana/gcs-credentials.json
+//	access_id = grafana@project.iam.gserviceaccount.com
+//	private_key_path = /etc/grafana/gcs-private-key.pem
+//
+// Azure backends take the same settings too, e.g.:
+//
+//	[file_storage.backend.reports]
+//	type = azure
+//	account_name = grafanastorage
+//	container = reports
+//	account_key = <base64 encoded key>
+//	sas_token = <token>
+//	storage_domain = blob.core.windows.net
+//	protocol = https
+func newConfig(cfg *setting.Cfg) (*filestorageConfig, error) {
+	config := &filestorageConfig{MaxConcurrency: defaultMaxConcurrency}
+	if cfg == nil || cfg.Raw == nil {
+		return config, nil
+	}
+
+	slowLogThreshold := cfg.Raw.Section(sectionName).Key("slow_log_threshold").MustDuration(0)
+	if slowLogThreshold < 0 {
+		return nil, fmt.Errorf("invalid file storage settings: slow_log_threshold can not be negative")
+	}
+	config.SlowLogThreshold = slowLogThreshold
+	config.StrictBackendResolution = cfg.Raw.Section(sectionName).Key("strict_backend_resolution").MustBool(false)
+
+	config.MaxConcurrency = cfg.Raw.Section(sectionName).Key("max_concurrency").MustInt(defaultMaxConcurrency)
+	if config.MaxConcurrency <= 0 {
+		return nil, fmt.Errorf("invalid file storage settings: max_concurrency must be positive")
+	}
+
+	config.ProtectedPaths = splitList(cfg.Raw.Section(sectionName).Key("protected_paths").String())
+	for _, path := range config.ProtectedPaths {
+		if !strings.HasPrefix(path, Delimiter) {
+			return nil, fmt.Errorf("invalid file storage settings: protected path %q must start with %q", path, Delimiter)
+		}
+	}
+
+	for _, section := range cfg.Raw.Sections() {
+		if !strings.HasPrefix(section.Name(), backendSectionPrefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(section.Name(), backendSectionPrefix)
+		operations, err := parseOperations(section.Key("supported_operations").String())
+		if err != nil {
+			return nil, fmt.Errorf("invalid file storage backend %s: %w", name, err)
+		}
+
+		readOnly := section.Key("read_only").MustBool(false)
+		if readOnly {
+			for _, op := range operations {
+				if !containsOperation(readOperations, op) {
+					return nil, fmt.Errorf("invalid file storage backend %s: read only backend can not support operation %s", name, op)
+				}
+			}
+		}
+
+		operationTimeout := section.Key("operation_timeout").MustDuration(0)
+		if operationTimeout < 0 {
+			return nil, fmt.Errorf("invalid file storage backend %s: operation_timeout can not be negative", name)
+		}
+
+		cacheTTL := section.Key("cache_ttl").MustDuration(0)
+		if cacheTTL < 0 {
+			return nil, fmt.Errorf("invalid file storage backend %s: cache_ttl can not be negative", name)
+		}
+
+		cacheSize := section.Key("cache_size").MustInt(0)
+		if cacheSize < 0 {
+			return nil, fmt.Errorf("invalid file storage backend %s: cache_size can not be negative", name)
+		}
+
+		maxRetries := section.Key("max_retries").MustInt(0)
+		if maxRetries < 0 {
+			return nil, fmt.Errorf("invalid file storage backend %s: max_retries can not be negative", name)
+		}
+
+		retryBaseDelay := section.Key("retry_base_delay").MustDuration(0)
+		if retryBaseDelay < 0 {
+			return nil, fmt.Errorf("invalid file storage backend %s: retry_base_delay can not be negative", name)
+		}
+
+		allowedPathPatterns := splitList(section.Key("allowed_path_patterns").String())
+		deniedPathPatterns := splitList(section.Key("denied_path_patterns").String())
+		if _, err := NewRegexPathFilter(allowedPathPatterns, deniedPathPatterns); err != nil {
+			return nil, fmt.Errorf("invalid file storage backend %s: %w", name, err)
+		}
+
+		backend := backendConfig{
+			Name:                name,
+			AllowedPrefixes:     splitPaths(section.Key("allowed_prefixes").String()),
+			AllowedPaths:        splitPaths(section.Key("allowed_paths").String()),
+			DeniedPrefixes:      splitPaths(section.Key("denied_prefixes").String()),
+			AllowedPathPatterns: allowedPathPatterns,
+			DeniedPathPatterns:  deniedPathPatterns,
+			SupportedOperations: operations,
+			ReadOnly:            readOnly,
+			OperationTimeout:    operationTimeout,
+			CacheTTL:            cacheTTL,
+			CacheSize:           cacheSize,
+			MaxRetries:          maxRetries,
+			RetryBaseDelay:      retryBaseDelay,
+		}
+
+		switch backendType := section.Key("type").String(); backendType {
+		case backendTypeFS:
+			path := section.Key("path").String()
+			if path == "" {
+				return nil, fmt.Errorf("invalid file storage backend %s: path is required", name)
+			}
+			blobBackend, err := newBlobBackendConfig(section, backend)
+			if err != nil {
+				return nil, err
+			}
+			config.Backends.FS = append(config.Backends.FS, fsBackendConfig{
+				blobBackendConfig: blobBackend,
+				Path:              path,
+			})
+		case backendTypeS3:
+			bucket := section.Key("bucket").String()
+			if bucket == "" {
+				return nil, fmt.Errorf("invalid file storage backend %s: bucket is required", name)
+			}
+			blobBackend, err := newBlobBackendConfig(section, backend)
+			if err != nil {
+				return nil, err
+			}
+			config.Backends.S3 = append(config.Backends.S3, s3BackendConfig{
+				blobBackendConfig: blobBackend,
+				Bucket:            bucket,
+				Region:            section.Key("region").String(),
+				Endpoint:          section.Key("endpoint").String(),
+				SSEKMSKeyID:       section.Key("sse_kms_key_id").String(),
+			})
+		case backendTypeGCS:
+			bucket := section.Key("bucket").String()
+			if bucket == "" {
+				return nil, fmt.Errorf("invalid file storage backend %s: bucket is required", name)
+			}
+			blobBackend, err := newBlobBackendConfig(section, backend)
+			if err != nil {
+				return nil, err
+			}
+			config.Backends.GCS = append(config.Backends.GCS, gcsBackendConfig{
+				blobBackendConfig: blobBackend,
+				Bucket:            bucket,
+				CredentialsFile:   section.Key("credentials_file").String(),
+				AccessID:          section.Key("access_id").String(),
+				PrivateKeyPath:    section.Key("private_key_path").String(),
+			})
+		case backendTypeAzure:
+			accountName := section.Key("account_name").String()
+			container := section.Key("container").String()
+			if accountName == "" || container == "" {
+				return nil, fmt.Errorf("invalid file storage backend %s: account_name and container are required", name)
+			}
+			protocol := section.Key("protocol").String()
+			if protocol != "" && protocol != "https" && protocol != "http" {
+				return nil, fmt.Errorf("invalid file storage backend %s: protocol must be http or https", name)
+			}
+			blobBackend, err := newBlobBackendConfig(section, backend)
+			if err != nil {
+				return nil, err
+			}
+			config.Backends.Azure = append(config.Backends.Azure, azureBackendConfig{
+				blobBackendConfig: blobBackend,
+				AccountName:       accountName,
+				Container:         container,
+				AccountKey:        section.Key("account_key").String(),
+				SASToken:          section.Key("sas_token").String(),
+				StorageDomain:     section.Key("storage_domain").String(),
+				Protocol:          protocol,
+			})
+		case backendTypeDB:
+			config.Backends.DB = append(config.Backends.DB, dbBackendConfig{
+				backendConfig: backend,
+			})
+		case backendTypeMem:
+			blobBackend, err := newBlobBackendConfig(section, backend)
+			if err != nil {
+				return nil, err
+			}
+			config.Backends.Mem = append(config.Backends.Mem, memBackendConfig{
+				blobBackendConfig: blobBackend,
+			})
+		default:
+			return nil, fmt.Errorf("invalid file storage backend %s: unknown type %q", name, backendType)
+		}
+	}
+
+	return config, nil
+}
+
+func newBlobBackendConfig(section *ini.Section, backend backendConfig) (blobBackendConfig, error) {
+	maxFileSize := section.Key("max_file_size").MustInt64(0)
+	if maxFileSize < 0 {
+		return blobBackendConfig{}, fmt.Errorf("invalid file storage backend %s: max_file_size can not be negative", backend.Name)
+	}
+
+	maxTotalSize := section.Key("max_total_size").MustInt64(0)
+	if maxTotalSize < 0 {
+		return blobBackendConfig{}, fmt.Errorf("invalid file storage backend %s: max_total_size can not be negative", backend.Name)
+	}
+
+	trashPrefix := strings.Trim(section.Key("trash_prefix").String(), Delimiter)
+	if trashPrefix != "" && (strings.Contains(trashPrefix, Delimiter) || validatePath(Delimiter+trashPrefix) != nil) {
+		return blobBackendConfig{}, fmt.Errorf("invalid file storage backend %s: trash_prefix must be a single folder name", backend.Name)
+	}
+
+	versioned := section.Key("versioned").MustBool(false)
+	contentAddressable := section.Key("content_addressable").MustBool(false)
+	if contentAddressable && (trashPrefix != "" || versioned || maxTotalSize > 0) {
+		return blobBackendConfig{}, fmt.Errorf("invalid file storage backend %s: content_addressable can not be combined with trash_prefix, versioned or max_total_size", backend.Name)
+	}
+
+	defaultListOptions := ListOptions{
+		Filter:           section.Key("default_list_filter").String(),
+		MimeTypeFilter:   splitList(section.Key("default_list_mime_types").String()),
+		MaxResults:       section.Key("default_list_max_results").MustInt(0),
+		IncludeFileCount: section.Key("default_list_include_file_count").MustBool(false),
+	}
+	if err := defaultListOptions.validateFilter(); err != nil {
+		return blobBackendConfig{}, fmt.Errorf("invalid file storage backend %s: %w", backend.Name, err)
+	}
+	if defaultListOptions.MaxResults < 0 {
+		return blobBackendConfig{}, fmt.Errorf("invalid file storage backend %s: default_list_max_results can not be negative", backend.Name)
+	}
+
+	return blobBackendConfig{
+		backendConfig:        backend,
+		MaxFileSize:          maxFileSize,
+		TrashPrefix:          trashPrefix,
+		Versioned:            versioned,
+		MaxTotalSize:         maxTotalSize,
+		CompressContentTypes: splitList(section.Key("compress_content_types").String()),
+		ContentAddressable:   contentAddressable,
+		DefaultListOptions:   defaultListOptions,
+	}, nil
+}
+
+// pathFilter returns the prefix filters of the backend, chained with the regex filter if patterns are configured.
+func (c backendConfig) pathFilter() (PathFilter, error) {
+	prefixFilters := NewPathFiltersWithAllowedPaths(c.AllowedPrefixes, c.AllowedPaths, c.DeniedPrefixes)
+	if len(c.AllowedPathPatterns) == 0 && len(c.DeniedPathPatterns) == 0 {
+		return prefixFilters, nil
+	}
+
+	regexFilter, err := NewRegexPathFilter(c.AllowedPathPatterns, c.DeniedPathPatterns)
+	if err != nil {
+		return nil, err
+	}
+	return pathFilterChain{prefixFilters, regexFilter}, nil
+}
+
+func (c blobBackendConfig) cdkBlobStorageOptions() *CdkBlobStorageOptions {
+	defaultListOptions := c.DefaultListOptions
+	return &CdkBlobStorageOptions{
+		MaxFileSize:          c.MaxFileSize,
+		TrashPrefix:          c.TrashPrefix,
+		Versioned:            c.Versioned,
+		MaxTotalSize:         c.MaxTotalSize,
+		CompressContentTypes: c.CompressContentTypes,
+		DefaultListOptions:   &defaultListOptions,
+		ContentAddressable:   c.ContentAddressable,
+	}
+}
+
+func (c s3BackendConfig) cdkBlobStorageOptions() *CdkBlobStorageOptions {
+	options := c.blobBackendConfig.cdkBlobStorageOptions()
+	options.SSEKMSKeyID = c.SSEKMSKeyID
+	return options
+}
+
+func splitList(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// splitPaths anchors the prefixes or paths at the root of the backend, the paths they are matched against start
+// with the delimiter. `images/` and `/images/` are the same prefix.
+func splitPaths(value string) []string {
+	paths := splitList(value)
+	for i, path := range paths {
+		if !strings.HasPrefix(path, Delimiter) {
+			paths[i] = Delimiter + path
+		}
+	}
+	return paths
+}
+
+func parseOperations(value string) ([]Operation, error) {
+	items := splitList(value)
+	if items == nil {
+		return nil, nil
+	}
+
+	operations := make([]Operation, 0, len(items))
+	for _, item := range items {
+		op := Operation(item)
+		if !containsOperation(allOperations, op) {
+			return nil, fmt.Errorf("unknown operation %q", item)
+		}
+		operations = append(operations, op)
+	}
+	return operations, nil
+}
+
+func containsOperation(operations []Operation, operation Operation) bool {
+	for _, op := range operations {
+		if op == operation {
+			return true
+		}
+	}
+	return false
+}
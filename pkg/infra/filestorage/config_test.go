@@ -0,0 +1,531 @@
+package filestorage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/services/featuremgmt"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func newTestCfg(t *testing.T, contents string) *setting.Cfg {
+	t.Helper()
+
+	raw, err := ini.Load([]byte(contents))
+	require.NoError(t, err)
+
+	cfg := setting.NewCfg()
+	cfg.Raw = raw
+	return cfg
+}
+
+func TestFilestorageConfig_S3Backends(t *testing.T) {
+	cfg := newTestCfg(t, `
+[file_storage.backend.resources]
+type = s3
+bucket = grafana-resources
+region = eu-west-1
+endpoint = http://localhost:9000
+allowed_prefixes = images/, dashboards/
+denied_prefixes = /dashboards/private/
+supported_operations = get,list_files
+max_file_size = 1024
+trash_prefix = /.trash/
+versioned = true
+max_total_size = 4096
+compress_content_types = application/json, text/plain
+`)
+
+	fsConfig, err := newConfig(cfg)
+	require.NoError(t, err)
+	require.Len(t, fsConfig.Backends.S3, 1)
+	require.Empty(t, fsConfig.Backends.FS)
+
+	backend := fsConfig.Backends.S3[0]
+	require.Equal(t, "resources", backend.Name)
+	require.Equal(t, "grafana-resources", backend.Bucket)
+	require.Equal(t, "eu-west-1", backend.Region)
+	require.Equal(t, "http://localhost:9000", backend.Endpoint)
+	require.Equal(t, []string{"/images/", "/dashboards/"}, backend.AllowedPrefixes)
+	require.Equal(t, []string{"/dashboards/private/"}, backend.DeniedPrefixes)
+	require.Equal(t, []Operation{OperationGet, OperationListFiles}, backend.SupportedOperations)
+	require.Equal(t, int64(1024), backend.MaxFileSize)
+	require.Equal(t, ".trash", backend.TrashPrefix)
+	require.True(t, backend.Versioned)
+	require.Equal(t, int64(4096), backend.MaxTotalSize)
+	require.Equal(t, []string{"application/json", "text/plain"}, backend.CompressContentTypes)
+}
+
+func TestFilestorageConfig_Invalid(t *testing.T) {
+	var tests = []struct {
+		name     string
+		contents string
+	}{
+		{
+			name:     "should fail if s3 bucket is missing",
+			contents: "[file_storage.backend.resources]\ntype = s3\nregion = eu-west-1",
+		},
+		{
+			name:     "should fail if gcs bucket is missing",
+			contents: "[file_storage.backend.assets]\ntype = gcs",
+		},
+		{
+			name:     "should fail if azure container is missing",
+			contents: "[file_storage.backend.reports]\ntype = azure\naccount_name = grafanastorage",
+		},
+		{
+			name:     "should fail if azure protocol is unknown",
+			contents: "[file_storage.backend.reports]\ntype = azure\naccount_name = grafanastorage\ncontainer = reports\nprotocol = ftp",
+		},
+		{
+			name:     "should fail if fs path is missing",
+			contents: "[file_storage.backend.local]\ntype = fs",
+		},
+		{
+			name:     "should fail if backend type is unknown",
+			contents: "[file_storage.backend.local]\ntype = ftp",
+		},
+		{
+			name:     "should fail if max file size is negative",
+			contents: "[file_storage.backend.local]\ntype = fs\npath = /tmp\nmax_file_size = -1",
+		},
+		{
+			name:     "should fail if a read only backend supports write operations",
+			contents: "[file_storage.backend.local]\ntype = fs\npath = /tmp\nread_only = true\nsupported_operations = get,upsert",
+		},
+		{
+			name:     "should fail if max total size is negative",
+			contents: "[file_storage.backend.local]\ntype = fs\npath = /tmp\nmax_total_size = -1",
+		},
+		{
+			name:     "should fail if the trash prefix is nested",
+			contents: "[file_storage.backend.local]\ntype = fs\npath = /tmp\ntrash_prefix = deleted/files",
+		},
+		{
+			name:     "should fail if a content addressable backend has a trash",
+			contents: "[file_storage.backend.local]\ntype = fs\npath = /tmp\ncontent_addressable = true\ntrash_prefix = .trash",
+		},
+		{
+			name:     "should fail if a content addressable backend is versioned",
+			contents: "[file_storage.backend.local]\ntype = fs\npath = /tmp\ncontent_addressable = true\nversioned = true",
+		},
+		{
+			name:     "should fail if the operation timeout is negative",
+			contents: "[file_storage.backend.local]\ntype = fs\npath = /tmp\noperation_timeout = -1s",
+		},
+		{
+			name:     "should fail if the default list filter is malformed",
+			contents: "[file_storage.backend.local]\ntype = fs\npath = /tmp\ndefault_list_filter = [",
+		},
+		{
+			name:     "should fail if the slow log threshold is negative",
+			contents: "[file_storage]\nslow_log_threshold = -1s",
+		},
+		{
+			name:     "should fail if the max concurrency is zero",
+			contents: "[file_storage]\nmax_concurrency = 0",
+		},
+		{
+			name:     "should fail if the max concurrency is negative",
+			contents: "[file_storage]\nmax_concurrency = -2",
+		},
+		{
+			name:     "should fail if the cache ttl is negative",
+			contents: "[file_storage.backend.local]\ntype = fs\npath = /tmp\ncache_ttl = -1s",
+		},
+		{
+			name:     "should fail if max retries is negative",
+			contents: "[file_storage.backend.local]\ntype = fs\npath = /tmp\nmax_retries = -1",
+		},
+		{
+			name:     "should fail if the retry base delay is negative",
+			contents: "[file_storage.backend.local]\ntype = fs\npath = /tmp\nretry_base_delay = -1s",
+		},
+		{
+			name:     "should fail if a path pattern is malformed",
+			contents: "[file_storage.backend.local]\ntype = fs\npath = /tmp\nallowed_path_patterns = ^/images/(",
+		},
+		{
+			name:     "should fail if an operation is unknown",
+			contents: "[file_storage.backend.local]\ntype = fs\npath = /tmp\nsupported_operations = get,rename",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newConfig(newTestCfg(t, tt.contents))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestFilestorageConfig_Cache(t *testing.T) {
+	cfg := newTestCfg(t, `
+[file_storage.backend.static]
+type = mem
+cache_ttl = 5m
+cache_size = 100
+`)
+
+	fsConfig, err := newConfig(cfg)
+	require.NoError(t, err)
+	require.Len(t, fsConfig.Backends.Mem, 1)
+	require.Equal(t, 5*time.Minute, fsConfig.Backends.Mem[0].CacheTTL)
+	require.Equal(t, 100, fsConfig.Backends.Mem[0].CacheSize)
+
+	s := newTestService(map[string]FileStorage{})
+	require.NoError(t, s.registerBackends(context.Background(), fsConfig, nil, featuremgmt.WithFeatures()))
+	t.Cleanup(func() {
+		_ = s.close()
+	})
+	require.IsType(t, &cachingFileStorage{}, s.backendByName["static"])
+}
+
+func TestFilestorageConfig_Retries(t *testing.T) {
+	cfg := newTestCfg(t, `
+[file_storage.backend.static]
+type = mem
+max_retries = 3
+retry_base_delay = 50ms
+`)
+
+	fsConfig, err := newConfig(cfg)
+	require.NoError(t, err)
+	require.Len(t, fsConfig.Backends.Mem, 1)
+	require.Equal(t, 3, fsConfig.Backends.Mem[0].MaxRetries)
+	require.Equal(t, 50*time.Millisecond, fsConfig.Backends.Mem[0].RetryBaseDelay)
+
+	s := newTestService(map[string]FileStorage{})
+	require.NoError(t, s.registerBackends(context.Background(), fsConfig, nil, featuremgmt.WithFeatures()))
+	t.Cleanup(func() {
+		_ = s.close()
+	})
+	require.IsType(t, &retryingFileStorage{}, s.backendByName["static"])
+}
+
+func TestFilestorageConfig_DBBackends(t *testing.T) {
+	cfg := newTestCfg(t, `
+[file_storage.backend.shared]
+type = db
+allowed_prefixes = dashboards/
+denied_path_patterns = \.tmp$
+supported_operations = get,upsert,list_files
+operation_timeout = 30s
+`)
+
+	fsConfig, err := newConfig(cfg)
+	require.NoError(t, err)
+	require.Len(t, fsConfig.Backends.DB, 1)
+
+	backend := fsConfig.Backends.DB[0]
+	require.Equal(t, "shared", backend.Name)
+	require.Equal(t, []string{"/dashboards/"}, backend.AllowedPrefixes)
+	require.Equal(t, []Operation{OperationGet, OperationUpsert, OperationListFiles}, backend.SupportedOperations)
+	require.Equal(t, 30*time.Second, backend.OperationTimeout)
+
+	filter, err := backend.pathFilter()
+	require.NoError(t, err)
+	require.True(t, filter.IsAllowed("/dashboards/home.json"))
+	require.False(t, filter.IsAllowed("/dashboards/home.json.tmp"))
+	require.False(t, filter.IsAllowed("/images/logo.png"))
+}
+
+func TestFilestorageConfig_BackendFeatureFlags(t *testing.T) {
+	cfg := newTestCfg(t, fmt.Sprintf(`
+[file_storage.backend.local]
+type = fs
+path = %s
+
+[file_storage.backend.shared]
+type = db
+
+[file_storage.backend.static]
+type = mem
+`, t.TempDir()))
+
+	fsConfig, err := newConfig(cfg)
+	require.NoError(t, err)
+
+	t.Run("should skip the backends whose flag is disabled", func(t *testing.T) {
+		s := newTestService(map[string]FileStorage{})
+		// the db backend would fail to register without a database
+		require.NoError(t, s.registerBackends(context.Background(), fsConfig, nil, featuremgmt.WithFeatures()))
+		t.Cleanup(func() {
+			_ = s.close()
+		})
+		require.NotContains(t, s.backendByName, "local")
+		require.NotContains(t, s.backendByName, "shared")
+		require.Contains(t, s.backendByName, "static")
+	})
+
+	t.Run("should register the backends whose flag is enabled", func(t *testing.T) {
+		s := newTestService(map[string]FileStorage{})
+		require.NoError(t, s.registerBackends(context.Background(), fsConfig, nil, featuremgmt.WithFeatures(featuremgmt.FlagFileStoreFs, true)))
+		t.Cleanup(func() {
+			_ = s.close()
+		})
+		require.Contains(t, s.backendByName, "local")
+		require.NotContains(t, s.backendByName, "shared")
+	})
+
+	t.Run("should fail to register a db backend without a database once its flag is enabled", func(t *testing.T) {
+		s := newTestService(map[string]FileStorage{})
+		err := s.registerBackends(context.Background(), fsConfig, nil, featuremgmt.WithFeatures(featuremgmt.FlagFileStoreDb, true))
+		t.Cleanup(func() {
+			_ = s.close()
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "requires a database")
+	})
+}
+
+func TestFilestorageConfig_GCSBackends(t *testing.T) {
+	credentialsFile := filepath.Join(t.TempDir(), "credentials.json")
+	require.NoError(t, os.WriteFile(credentialsFile, []byte(`{
+  "type": "authorized_user",
+  "client_id": "client-id",
+  "client_secret": "client-secret",
+  "refresh_token": "refresh-token"
+}`), 0600))
+
+	cfg := newTestCfg(t, fmt.Sprintf(`
+[file_storage.backend.assets]
+type = gcs
+bucket = grafana-assets
+credentials_file = %s
+access_id = grafana@project.iam.gserviceaccount.com
+allowed_prefixes = images/
+supported_operations = get,list_files
+`, credentialsFile))
+
+	fsConfig, err := newConfig(cfg)
+	require.NoError(t, err)
+	require.Len(t, fsConfig.Backends.GCS, 1)
+
+	backend := fsConfig.Backends.GCS[0]
+	require.Equal(t, "assets", backend.Name)
+	require.Equal(t, "grafana-assets", backend.Bucket)
+	require.Equal(t, credentialsFile, backend.CredentialsFile)
+	require.Equal(t, []string{"/images/"}, backend.AllowedPrefixes)
+	require.Equal(t, []Operation{OperationGet, OperationListFiles}, backend.SupportedOperations)
+
+	t.Run("should compose the bucket url", func(t *testing.T) {
+		require.Equal(t, "gs://grafana-assets?access_id=grafana%40project.iam.gserviceaccount.com", gcsBucketURL(backend).String())
+
+		backend := backend
+		backend.AccessID = ""
+		require.Equal(t, "gs://grafana-assets", gcsBucketURL(backend).String())
+
+		backend.PrivateKeyPath = "/etc/grafana/key.pem"
+		require.Equal(t, "gs://grafana-assets?private_key_path=%2Fetc%2Fgrafana%2Fkey.pem", gcsBucketURL(backend).String())
+	})
+
+	t.Run("should register the backend", func(t *testing.T) {
+		s := newTestService(map[string]FileStorage{})
+		require.NoError(t, s.registerBackends(context.Background(), fsConfig, nil, featuremgmt.WithFeatures(featuremgmt.FlagFileStoreGcs, true)))
+		t.Cleanup(func() {
+			_ = s.close()
+		})
+		require.Contains(t, s.backendByName, "assets")
+	})
+
+	t.Run("should return an error if the credentials can not be read", func(t *testing.T) {
+		backend := backend
+		backend.CredentialsFile = filepath.Join(t.TempDir(), "missing.json")
+		_, err := openGCSBucket(context.Background(), backend)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to retrieve GCP credentials for bucket grafana-assets")
+	})
+}
+
+func TestFilestorageConfig_AzureBackends(t *testing.T) {
+	cfg := newTestCfg(t, `
+[file_storage.backend.reports]
+type = azure
+account_name = grafanastorage
+container = reports
+account_key = c2VjcmV0LWtleQ==
+storage_domain = blob.core.chinacloudapi.cn
+denied_prefixes = private/
+supported_operations = get,upsert
+`)
+
+	fsConfig, err := newConfig(cfg)
+	require.NoError(t, err)
+	require.Len(t, fsConfig.Backends.Azure, 1)
+
+	backend := fsConfig.Backends.Azure[0]
+	require.Equal(t, "reports", backend.Name)
+	require.Equal(t, "grafanastorage", backend.AccountName)
+	require.Equal(t, "reports", backend.Container)
+	require.Equal(t, "c2VjcmV0LWtleQ==", backend.AccountKey)
+	require.Equal(t, []string{"/private/"}, backend.DeniedPrefixes)
+	require.Equal(t, []Operation{OperationGet, OperationUpsert}, backend.SupportedOperations)
+
+	t.Run("should compose the container url", func(t *testing.T) {
+		require.Equal(t, "azblob://reports?domain=blob.core.chinacloudapi.cn", azureBucketURL(backend).String())
+
+		backend := backend
+		backend.StorageDomain = ""
+		require.Equal(t, "azblob://reports", azureBucketURL(backend).String())
+
+		backend.StorageDomain = "127.0.0.1:10000"
+		backend.Protocol = "http"
+		require.Equal(t, "azblob://reports?domain=127.0.0.1%3A10000&protocol=http", azureBucketURL(backend).String())
+	})
+
+	t.Run("should register the backend", func(t *testing.T) {
+		s := newTestService(map[string]FileStorage{})
+		require.NoError(t, s.registerBackends(context.Background(), fsConfig, nil, featuremgmt.WithFeatures(featuremgmt.FlagFileStoreAzure, true)))
+		t.Cleanup(func() {
+			_ = s.close()
+		})
+		require.Contains(t, s.backendByName, "reports")
+	})
+
+	t.Run("should return an error if the account key is invalid", func(t *testing.T) {
+		backend := backend
+		backend.AccountKey = "not base64"
+		_, err := openAzureBucket(context.Background(), backend)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid Azure credentials for account grafanastorage")
+	})
+}
+
+func TestFilestorageConfig_ReadOnlyBackends(t *testing.T) {
+	cfg := newTestCfg(t, `
+[file_storage.backend.archive]
+type = fs
+path = /tmp/archive
+read_only = true
+`)
+
+	fsConfig, err := newConfig(cfg)
+	require.NoError(t, err)
+	require.Len(t, fsConfig.Backends.FS, 1)
+
+	backend := fsConfig.Backends.FS[0]
+	require.True(t, backend.ReadOnly)
+	require.Empty(t, backend.SupportedOperations)
+	require.Equal(t, []Operation{OperationGet, OperationListFiles, OperationListFolders}, backend.operations())
+}
+
+func TestFilestorageConfig_SlowLogThreshold(t *testing.T) {
+	fsConfig, err := newConfig(newTestCfg(t, ""))
+	require.NoError(t, err)
+	require.Zero(t, fsConfig.SlowLogThreshold)
+
+	fsConfig, err = newConfig(newTestCfg(t, "[file_storage]\nslow_log_threshold = 500ms"))
+	require.NoError(t, err)
+	require.Equal(t, 500*time.Millisecond, fsConfig.SlowLogThreshold)
+}
+
+func TestFilestorageConfig_MaxConcurrency(t *testing.T) {
+	fsConfig, err := newConfig(newTestCfg(t, ""))
+	require.NoError(t, err)
+	require.Equal(t, defaultMaxConcurrency, fsConfig.MaxConcurrency)
+
+	fsConfig, err = newConfig(newTestCfg(t, "[file_storage]\nmax_concurrency = 4"))
+	require.NoError(t, err)
+	require.Equal(t, 4, fsConfig.MaxConcurrency)
+}
+
+func TestFilestorageConfig_MemBackends(t *testing.T) {
+	cfg := newTestCfg(t, `
+[file_storage.backend.scratch]
+type = mem
+allowed_prefixes = tmp/
+supported_operations = get,upsert
+max_file_size = 1024
+content_addressable = true
+`)
+
+	fsConfig, err := newConfig(cfg)
+	require.NoError(t, err)
+	require.Len(t, fsConfig.Backends.Mem, 1)
+
+	backend := fsConfig.Backends.Mem[0]
+	require.Equal(t, "scratch", backend.Name)
+	require.Equal(t, []string{"/tmp/"}, backend.AllowedPrefixes)
+	require.Equal(t, []Operation{OperationGet, OperationUpsert}, backend.SupportedOperations)
+	require.Equal(t, int64(1024), backend.MaxFileSize)
+	require.True(t, backend.ContentAddressable)
+}
+
+func TestFilestorageConfig_DefaultListOptions(t *testing.T) {
+	cfg := newTestCfg(t, `
+[file_storage.backend.images]
+type = mem
+default_list_filter = *.png
+default_list_mime_types = image/png, image/jpeg
+default_list_max_results = 50
+default_list_include_file_count = true
+`)
+
+	fsConfig, err := newConfig(cfg)
+	require.NoError(t, err)
+	require.Len(t, fsConfig.Backends.Mem, 1)
+
+	options := fsConfig.Backends.Mem[0].cdkBlobStorageOptions()
+	require.Equal(t, &ListOptions{
+		Filter:           "*.png",
+		MimeTypeFilter:   []string{"image/png", "image/jpeg"},
+		MaxResults:       50,
+		IncludeFileCount: true,
+	}, options.DefaultListOptions)
+}
+
+func TestFilestorageConfig_AllowedPaths(t *testing.T) {
+	cfg := newTestCfg(t, `
+[file_storage.backend.public]
+type = mem
+allowed_prefixes = images/
+allowed_paths = logo.png,/docs/README.md
+denied_prefixes = images/private/
+`)
+
+	fsConfig, err := newConfig(cfg)
+	require.NoError(t, err)
+	require.Len(t, fsConfig.Backends.Mem, 1)
+
+	backend := fsConfig.Backends.Mem[0]
+	require.Equal(t, []string{"/logo.png", "/docs/README.md"}, backend.AllowedPaths)
+
+	filter, err := backend.pathFilter()
+	require.NoError(t, err)
+	require.True(t, filter.IsAllowed("/images/icon.png"))
+	require.True(t, filter.IsAllowed("/logo.png"))
+	require.True(t, filter.IsAllowed("/docs/readme.md"))
+	require.False(t, filter.IsAllowed("/docs/CHANGELOG.md"))
+	require.False(t, filter.IsAllowed("/logo.png.bak"))
+	require.False(t, filter.IsAllowed("/images/private/icon.png"))
+}
+
+func TestFilestorageConfig_PathPatterns(t *testing.T) {
+	cfg := newTestCfg(t, `
+[file_storage.backend.hashed]
+type = mem
+allowed_prefixes = /objects/
+allowed_path_patterns = ^/objects/[0-9a-f]{8}$
+denied_path_patterns = 0000
+`)
+
+	fsConfig, err := newConfig(cfg)
+	require.NoError(t, err)
+	require.Len(t, fsConfig.Backends.Mem, 1)
+
+	backend := fsConfig.Backends.Mem[0]
+	require.Equal(t, []string{"^/objects/[0-9a-f]{8}$"}, backend.AllowedPathPatterns)
+	require.Equal(t, []string{"0000"}, backend.DeniedPathPatterns)
+
+	filter, err := backend.pathFilter()
+	require.NoError(t, err)
+	require.True(t, filter.IsAllowed("/objects/4a5b6c7d"))
+	require.False(t, filter.IsAllowed("/objects/4a5b0000"))
+	require.False(t, filter.IsAllowed("/objects/readme.txt"))
+}
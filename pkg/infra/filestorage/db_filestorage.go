@@ -1,8 +1,12 @@
 package filestorage
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"strings"
 	"time"
 
@@ -32,14 +36,15 @@ type dbFileStorage struct {
 	log log.Logger
 }
 
-func NewDbStorage(log log.Logger, db *sqlstore.SQLStore, pathFilters *PathFilters) FileStorage {
+func NewDbStorage(log log.Logger, db *sqlstore.SQLStore, pathFilter PathFilter, supportedOperations []Operation) FileStorage {
 	return &wrapper{
 		log: log,
 		wrapped: &dbFileStorage{
 			log: log,
 			db:  db,
 		},
-		pathFilters: pathFilters,
+		pathFilters:         pathFilter,
+		supportedOperations: supportedOperations,
 	}
 }
 
@@ -66,8 +71,12 @@ func (s dbFileStorage) Get(ctx context.Context, filePath string) (*File, error)
 	err := s.db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
 		table := &file{}
 		exists, err := sess.Table("file").Where("LOWER(path) = ?", strings.ToLower(filePath)).Get(table)
+		if err != nil {
+			return err
+		}
+
 		if !exists {
-			return nil
+			return fmt.Errorf("%w: %s", ErrFileNotFound, filePath)
 		}
 
 		var meta = make([]*fileMeta, 0)
@@ -96,6 +105,7 @@ func (s dbFileStorage) Get(ctx context.Context, filePath string) (*File, error)
 				Modified:   table.Updated,
 				Size:       table.Size,
 				MimeType:   table.MimeType,
+				ETag:       contentETag(contents),
 			},
 		}
 		return err
@@ -104,7 +114,115 @@ func (s dbFileStorage) Get(ctx context.Context, filePath string) (*File, error)
 	return result, err
 }
 
+func (s dbFileStorage) GetReader(ctx context.Context, filePath string) (io.ReadCloser, *FileMetadata, error) {
+	file, err := s.Get(ctx, filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(file.Contents)), &file.FileMetadata, nil
+}
+
+// Open reads the whole file, the contents are stored in a single column.
+func (s dbFileStorage) Open(ctx context.Context, filePath string) (*FileMetadata, io.ReadSeekCloser, error) {
+	file, err := s.Get(ctx, filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &file.FileMetadata, newBufferedReadSeekCloser(file.Contents), nil
+}
+
+func (s dbFileStorage) GetMetadata(ctx context.Context, filePath string) (*FileMetadata, error) {
+	var result *FileMetadata
+	err := s.db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		table := &file{}
+		exists, err := sess.Table("file").Omit("contents").Where("LOWER(path) = ?", strings.ToLower(filePath)).Get(table)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return fmt.Errorf("%w: %s", ErrFileNotFound, filePath)
+		}
+
+		var meta = make([]*fileMeta, 0)
+		if err := sess.Table("file_meta").Where("path = ?", strings.ToLower(filePath)).Find(&meta); err != nil {
+			return err
+		}
+
+		var metaProperties = make(map[string]string, len(meta))
+		for i := range meta {
+			metaProperties[meta[i].Key] = meta[i].Value
+		}
+
+		// the ETag is derived from the contents, which are not read here
+		result = &FileMetadata{
+			Name:       getName(table.Path),
+			FullPath:   table.Path,
+			Created:    table.Created,
+			Properties: metaProperties,
+			Modified:   table.Updated,
+			Size:       table.Size,
+			MimeType:   table.MimeType,
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+func (s dbFileStorage) SignedURL(ctx context.Context, path string, ttl time.Duration) (string, error) {
+	return "", ErrSignedURLUnsupported
+}
+
+func (s dbFileStorage) SignedUploadURL(ctx context.Context, path string, ttl time.Duration, contentType string) (string, error) {
+	return "", ErrSignedURLUnsupported
+}
+
+func (s dbFileStorage) Exists(ctx context.Context, filePath string) (bool, error) {
+	var exists bool
+	err := s.db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		var err error
+		exists, err = sess.Table("file").Where("LOWER(path) = ?", strings.ToLower(filePath)).Exist()
+		return err
+	})
+
+	return exists, err
+}
+
 func (s dbFileStorage) Delete(ctx context.Context, filePath string) error {
+	existed, err := s.deleteFile(ctx, filePath)
+	if err != nil {
+		return err
+	}
+
+	if !existed {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, filePath)
+	}
+	return nil
+}
+
+func (s dbFileStorage) DeleteMany(ctx context.Context, paths []string) ([]string, map[string]error) {
+	deleted := make([]string, 0, len(paths))
+	errs := make(map[string]error)
+	for _, path := range paths {
+		ok, err := s.deleteFile(ctx, path)
+		if err != nil {
+			errs[path] = err
+			continue
+		}
+
+		if ok {
+			deleted = append(deleted, path)
+		}
+	}
+	return deleted, errs
+}
+
+// deleteFile deletes the file along with its metadata and reports whether it existed.
+func (s dbFileStorage) deleteFile(ctx context.Context, filePath string) (bool, error) {
+	existed := false
 	err := s.db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
 		table := &file{}
 		exists, innerErr := sess.Table("file").Where("LOWER(path) = ?", strings.ToLower(filePath)).Get(table)
@@ -115,6 +233,7 @@ func (s dbFileStorage) Delete(ctx context.Context, filePath string) error {
 		if !exists {
 			return nil
 		}
+		existed = true
 
 		number, innerErr := sess.Table("file").Where("LOWER(path) = ?", strings.ToLower(filePath)).Delete(table)
 		if innerErr != nil {
@@ -131,7 +250,7 @@ func (s dbFileStorage) Delete(ctx context.Context, filePath string) error {
 		return innerErr
 	})
 
-	return err
+	return existed, err
 }
 
 func (s dbFileStorage) Upsert(ctx context.Context, cmd *UpsertFileCommand) error {
@@ -143,6 +262,14 @@ func (s dbFileStorage) Upsert(ctx context.Context, cmd *UpsertFileCommand) error
 			return err
 		}
 
+		if cmd.CreateOnly && exists {
+			return fmt.Errorf("%w: %s", ErrAlreadyExists, cmd.Path)
+		}
+
+		if cmd.IfMatchETag != "" && (!exists || contentETag(existing.Contents) != cmd.IfMatchETag) {
+			return fmt.Errorf("%w: %s does not match ETag %s", ErrPreconditionFailed, cmd.Path, cmd.IfMatchETag)
+		}
+
 		if exists {
 			existing.Updated = now
 			if cmd.Contents != nil {
@@ -192,6 +319,127 @@ func (s dbFileStorage) Upsert(ctx context.Context, cmd *UpsertFileCommand) error
 	return err
 }
 
+// UpsertStream reads the whole stream into memory, the contents of a file are stored in a single column.
+func (s dbFileStorage) UpsertStream(ctx context.Context, path string, r io.Reader, command *UpsertFileCommand) error {
+	contents, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	cmd := UpsertFileCommand{}
+	if command != nil {
+		cmd = *command
+	}
+	cmd.Path = path
+	cmd.Contents = &contents
+	return s.Upsert(ctx, &cmd)
+}
+
+// Append reads the file and writes it back with the data appended. The write is conditional on the contents read,
+// so that an append racing with another write fails with ErrConcurrentAppend instead of overwriting it.
+func (s dbFileStorage) Append(ctx context.Context, path string, data []byte) error {
+	var existing *file
+	err := s.db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		table := &file{}
+		exists, err := sess.Table("file").Where("LOWER(path) = ?", strings.ToLower(path)).Get(table)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			existing = table
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.appendTo(ctx, path, existing, data)
+}
+
+// appendTo writes the existing file with the data appended, existing is nil if the file did not exist when it was read.
+func (s dbFileStorage) appendTo(ctx context.Context, path string, existing *file, data []byte) error {
+	now := time.Now()
+	return s.db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		if existing == nil {
+			_, err := sess.Insert(&file{
+				Path:             path,
+				ParentFolderPath: getParentFolderPath(path),
+				Contents:         data,
+				MimeType:         detectUpsertContentType(path, data),
+				Size:             int64(len(data)),
+				Updated:          now,
+				Created:          now,
+			})
+			if err != nil && s.db.Dialect.IsUniqueConstraintViolation(err) {
+				return fmt.Errorf("%w: %s", ErrConcurrentAppend, path)
+			}
+			return err
+		}
+
+		if len(data) == 0 {
+			return nil
+		}
+
+		contents := make([]byte, 0, len(existing.Contents)+len(data))
+		contents = append(append(contents, existing.Contents...), data...)
+		updated, err := sess.Table("file").
+			Where("LOWER(path) = ? AND size = ? AND contents = ?", strings.ToLower(path), existing.Size, existing.Contents).
+			Cols("contents", "size", "updated").
+			Update(&file{Contents: contents, Size: int64(len(contents)), Updated: now})
+		if err != nil {
+			return err
+		}
+
+		if updated == 0 {
+			return fmt.Errorf("%w: %s", ErrConcurrentAppend, path)
+		}
+		return nil
+	})
+}
+
+// Touch updates the modification time in place, the contents are not rewritten.
+func (s dbFileStorage) Touch(ctx context.Context, path string) error {
+	return s.db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		updated, err := sess.Table("file").Where("LOWER(path) = ?", strings.ToLower(path)).Cols("updated").Update(&file{Updated: time.Now()})
+		if err != nil {
+			return err
+		}
+
+		if updated == 0 {
+			return fmt.Errorf("%w: %s", ErrFileNotFound, path)
+		}
+		return nil
+	})
+}
+
+func (s dbFileStorage) Copy(ctx context.Context, srcPath string, dstPath string) error {
+	existing, err := s.Get(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+
+	return s.Upsert(ctx, &UpsertFileCommand{
+		Path:       dstPath,
+		MimeType:   existing.MimeType,
+		Contents:   &existing.Contents,
+		Properties: existing.Properties,
+	})
+}
+
+func (s dbFileStorage) Move(ctx context.Context, srcPath string, dstPath string) error {
+	if err := s.Copy(ctx, srcPath, dstPath); err != nil {
+		return err
+	}
+
+	if strings.EqualFold(srcPath, dstPath) {
+		return nil
+	}
+
+	return s.Delete(ctx, srcPath)
+}
+
 func upsertProperties(sess *sqlstore.DBSession, now time.Time, cmd *UpsertFileCommand) error {
 	fileMeta := &fileMeta{}
 	_, err := sess.Table("file_meta").Where("path = ?", strings.ToLower(cmd.Path)).Delete(fileMeta)
@@ -228,41 +476,48 @@ func upsertProperty(sess *sqlstore.DBSession, now time.Time, path string, key st
 }
 
 func (s dbFileStorage) ListFiles(ctx context.Context, folderPath string, paging *Paging, options *ListOptions) (*ListFilesResponse, error) {
+	if options.Filter != "" {
+		return nil, errors.New("filters are not supported by the database file storage")
+	}
+
 	var resp *ListFilesResponse
 
 	err := s.db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
 		var foundFiles = make([]*file, 0)
 
-		sess.Table("file")
-		lowerFolderPath := strings.ToLower(folderPath)
-		if options.Recursive {
-			var nestedFolders string
-			if folderPath == Delimiter {
-				nestedFolders = "%"
-			} else {
-				nestedFolders = fmt.Sprintf("%s%s%s", lowerFolderPath, Delimiter, "%")
-			}
-			sess.Where("(LOWER(parent_folder_path) = ?) OR (LOWER(parent_folder_path) LIKE ?)", lowerFolderPath, nestedFolders)
-		} else {
-			sess.Where("LOWER(parent_folder_path) = ?", lowerFolderPath)
+		pageSize := options.pageSize(paging.First)
+		after := ""
+		if paging != nil {
+			after = paging.After
 		}
-		sess.Where("LOWER(path) NOT LIKE ?", fmt.Sprintf("%s%s%s", "%", Delimiter, directoryMarker))
 
-		for _, prefix := range options.PathFilters.allowedPrefixes {
-			sess.Where("LOWER(path) LIKE ?", fmt.Sprintf("%s%s", strings.ToLower(prefix), "%"))
-		}
+		// the path filter of the backend which can not be pushed down to the query is applied to the found files,
+		// the files are read in batches until the page is full
+		for len(foundFiles) <= pageSize {
+			batch := make([]*file, 0)
 
-		sess.OrderBy("path")
+			filterFiles(sess, folderPath, options)
+			sess.OrderBy("path")
+			sess.Limit(pageSize + 1)
 
-		pageSize := paging.First
-		sess.Limit(pageSize + 1)
+			if after != "" {
+				sess.Where("path > ?", after)
+			}
 
-		if paging != nil && paging.After != "" {
-			sess.Where("path > ?", paging.After)
-		}
+			if err := sess.Find(&batch); err != nil {
+				return err
+			}
 
-		if err := sess.Find(&foundFiles); err != nil {
-			return err
+			for _, found := range batch {
+				if options.filter == nil || options.filter.IsAllowed(found.Path) {
+					foundFiles = append(foundFiles, found)
+				}
+			}
+
+			if len(batch) <= pageSize {
+				break
+			}
+			after = batch[len(batch)-1].Path
 		}
 
 		foundLength := len(foundFiles)
@@ -297,6 +552,7 @@ func (s dbFileStorage) ListFiles(ctx context.Context, folderPath string, paging
 				Modified:   foundFiles[i].Updated,
 				Size:       foundFiles[i].Size,
 				MimeType:   foundFiles[i].MimeType,
+				ETag:       contentETag(foundFiles[i].Contents),
 			})
 		}
 
@@ -308,7 +564,7 @@ func (s dbFileStorage) ListFiles(ctx context.Context, folderPath string, paging
 		resp = &ListFilesResponse{
 			Files:    files,
 			LastPath: lastPath,
-			HasMore:  len(foundFiles) == pageSize+1,
+			HasMore:  len(foundFiles) > pageSize,
 		}
 		return nil
 	})
@@ -316,13 +572,110 @@ func (s dbFileStorage) ListFiles(ctx context.Context, folderPath string, paging
 	return resp, err
 }
 
+// filterFiles adds the conditions of the listed folder and of the list options to the query of the files.
+func filterFiles(sess *sqlstore.DBSession, folderPath string, options *ListOptions) {
+	sess.Table("file")
+	lowerFolderPath := strings.ToLower(folderPath)
+	if options.Recursive {
+		var nestedFolders string
+		if folderPath == Delimiter {
+			nestedFolders = "%"
+		} else {
+			nestedFolders = fmt.Sprintf("%s%s%s", lowerFolderPath, Delimiter, "%")
+		}
+		sess.Where("(LOWER(parent_folder_path) = ?) OR (LOWER(parent_folder_path) LIKE ?)", lowerFolderPath, nestedFolders)
+	} else {
+		sess.Where("LOWER(parent_folder_path) = ?", lowerFolderPath)
+	}
+	sess.Where("LOWER(path) NOT LIKE ?", fmt.Sprintf("%s%s%s", "%", Delimiter, directoryMarker))
+
+	if condition, args := allowedPathsCondition("path", options.allowedPrefixes, options.allowedPaths); condition != "" {
+		sess.Where(condition, args...)
+	}
+
+	for _, prefix := range options.PathFilters.deniedPrefixes {
+		sess.Where("LOWER(path) NOT LIKE ?", fmt.Sprintf("%s%s", strings.ToLower(prefix), "%"))
+	}
+
+	if len(options.MimeTypeFilter) > 0 {
+		conditions := make([]string, 0, len(options.MimeTypeFilter))
+		args := make([]interface{}, 0, len(options.MimeTypeFilter))
+		for _, mimeType := range options.MimeTypeFilter {
+			mimeType = normalizeMimeType(mimeType)
+			if strings.HasSuffix(mimeType, "/*") {
+				conditions = append(conditions, "LOWER(mime_type) LIKE ?")
+				args = append(args, strings.TrimSuffix(mimeType, "*")+"%")
+			} else {
+				conditions = append(conditions, "LOWER(mime_type) = ? OR LOWER(mime_type) LIKE ?")
+				args = append(args, mimeType, mimeType+";%")
+			}
+		}
+		sess.Where("("+strings.Join(conditions, " OR ")+")", args...)
+	}
+}
+
+// walkPageSize is the number of files read at once when walking the files of the database.
+const walkPageSize = 100
+
+// WalkFiles reads the files page by page, as the database sessions are not kept open while the function runs.
+func (s dbFileStorage) WalkFiles(ctx context.Context, folderPath string, options *ListOptions, fn func(FileMetadata) error) error {
+	paging := &Paging{First: walkPageSize}
+	for {
+		resp, err := s.ListFiles(ctx, folderPath, paging, options)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range resp.Files {
+			if err := fn(file); err != nil {
+				return err
+			}
+		}
+
+		if !resp.HasMore || resp.LastPath == "" {
+			return nil
+		}
+		paging = &Paging{First: walkPageSize, After: resp.LastPath}
+	}
+}
+
+// allowedPathsCondition returns the condition pushing the allowed prefixes and paths down to the column, or an empty
+// condition if there are none. A row passes if it matches one of the paths or one of the prefixes, like
+// PathFilters.IsAllowed.
+func allowedPathsCondition(column string, allowedPrefixes []string, allowedPaths []string) (string, []interface{}) {
+	args := make([]interface{}, 0, len(allowedPrefixes)+len(allowedPaths))
+	conditions := make([]string, 0, 2)
+
+	if len(allowedPaths) > 0 {
+		placeholders := make([]string, 0, len(allowedPaths))
+		for _, path := range allowedPaths {
+			placeholders = append(placeholders, "?")
+			args = append(args, strings.ToLower(path))
+		}
+		conditions = append(conditions, fmt.Sprintf("LOWER(%s) IN (%s)", column, strings.Join(placeholders, ", ")))
+	}
+
+	if len(allowedPrefixes) > 0 {
+		prefixConditions := make([]string, 0, len(allowedPrefixes))
+		for _, prefix := range allowedPrefixes {
+			prefixConditions = append(prefixConditions, fmt.Sprintf("LOWER(%s) LIKE ?", column))
+			args = append(args, fmt.Sprintf("%s%s", strings.ToLower(prefix), "%"))
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s)", strings.Join(prefixConditions, " OR ")))
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("(%s)", strings.Join(conditions, " OR ")), args
+}
+
 func (s dbFileStorage) ListFolders(ctx context.Context, parentFolderPath string, options *ListOptions) ([]FileMetadata, error) {
 	folders := make([]FileMetadata, 0)
 	err := s.db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
 		var foundPaths []string
 
 		sess.Table("file")
-		sess.Distinct("parent_folder_path")
 
 		if options.Recursive {
 			sess.Where("LOWER(parent_folder_path) > ?", strings.ToLower(parentFolderPath))
@@ -330,15 +683,40 @@ func (s dbFileStorage) ListFolders(ctx context.Context, parentFolderPath string,
 			sess.Where("LOWER(parent_folder_path) = ?", strings.ToLower(parentFolderPath))
 		}
 
-		for _, prefix := range options.PathFilters.allowedPrefixes {
-			sess.Where("LOWER(parent_folder_path) LIKE ?", fmt.Sprintf("%s%s", strings.ToLower(prefix), "%"))
+		// the folders of the allowed files are listed
+		if condition, args := allowedPathsCondition("path", options.allowedPrefixes, options.allowedPaths); condition != "" {
+			sess.Where(condition, args...)
+		}
+
+		for _, prefix := range options.PathFilters.deniedPrefixes {
+			sess.Where("LOWER(path) NOT LIKE ?", fmt.Sprintf("%s%s", strings.ToLower(prefix), "%"))
 		}
 
 		sess.OrderBy("parent_folder_path")
-		sess.Cols("parent_folder_path")
 
-		if err := sess.Find(&foundPaths); err != nil {
-			return err
+		if options.filter != nil {
+			// the path filter of the backend can not be pushed down to the query, the folders of the files it
+			// permits are listed
+			var foundFiles []*file
+			sess.Cols("path", "parent_folder_path")
+			if err := sess.Find(&foundFiles); err != nil {
+				return err
+			}
+
+			seen := make(map[string]bool)
+			for _, found := range foundFiles {
+				if !seen[found.ParentFolderPath] && options.filter.IsAllowed(found.Path) {
+					seen[found.ParentFolderPath] = true
+					foundPaths = append(foundPaths, found.ParentFolderPath)
+				}
+			}
+		} else {
+			sess.Distinct("parent_folder_path")
+			sess.Cols("parent_folder_path")
+
+			if err := sess.Find(&foundPaths); err != nil {
+				return err
+			}
 		}
 
 		mem := make(map[string]bool)
@@ -371,7 +749,7 @@ func (s dbFileStorage) ListFolders(ctx context.Context, parentFolderPath string,
 	return folders, err
 }
 
-func (s dbFileStorage) CreateFolder(ctx context.Context, path string) error {
+func (s dbFileStorage) CreateFolder(ctx context.Context, path string, options *CreateFolderOptions) error {
 	now := time.Now()
 	precedingFolders := precedingFolders(path)
 
@@ -383,6 +761,10 @@ func (s dbFileStorage) CreateFolder(ctx context.Context, path string) error {
 			existing := &file{}
 			directoryMarkerParentPath := previousFolder + Delimiter + getName(precedingFolders[i])
 			previousFolder = directoryMarkerParentPath
+			if i < len(precedingFolders)-1 && !options.isAllowed(precedingFolders[i]) {
+				continue
+			}
+
 			directoryMarkerPath := fmt.Sprintf("%s%s%s", directoryMarkerParentPath, Delimiter, directoryMarker)
 			lower := strings.ToLower(directoryMarkerPath)
 			exists, err := sess.Table("file").Where("LOWER(path) = ?", lower).Get(existing)
@@ -392,6 +774,10 @@ func (s dbFileStorage) CreateFolder(ctx context.Context, path string) error {
 			}
 
 			if exists {
+				if i == len(precedingFolders)-1 && options != nil && options.FailIfExists {
+					insertErr = fmt.Errorf("%w: %s", ErrFolderAlreadyExists, path)
+					break
+				}
 				previousFolder = existing.ParentFolderPath
 				continue
 			}
@@ -424,11 +810,32 @@ func (s dbFileStorage) CreateFolder(ctx context.Context, path string) error {
 	return err
 }
 
-func (s dbFileStorage) DeleteFolder(ctx context.Context, folderPath string) error {
-	err := s.db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+func (s dbFileStorage) DeleteFolder(ctx context.Context, folderPath string, options *DeleteFolderOptions) error {
+	directoryMarkerPath := strings.ToLower(fmt.Sprintf("%s%s%s", folderPath, Delimiter, directoryMarker))
+	nestedPaths := fmt.Sprintf("%s%s%s", strings.ToLower(folderPath), Delimiter, "%")
+
+	err := s.db.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		if options != nil && options.Force {
+			if _, err := sess.Table("file_meta").Where("path LIKE ?", nestedPaths).Delete(&fileMeta{}); err != nil {
+				return err
+			}
+
+			number, err := sess.Table("file").Where("LOWER(path) LIKE ?", nestedPaths).Delete(&file{})
+			s.log.Info("Deleted folder", "path", folderPath, "affectedRecords", number)
+			return err
+		}
+
+		children := make([]*file, 0)
+		if err := sess.Table("file").Where("LOWER(path) LIKE ? AND LOWER(path) != ?", nestedPaths, directoryMarkerPath).Limit(1).Find(&children); err != nil {
+			return err
+		}
+
+		if len(children) > 0 {
+			return fmt.Errorf("%w: %s", ErrFolderNotEmpty, folderPath)
+		}
+
 		existing := &file{}
-		directoryMarkerPath := fmt.Sprintf("%s%s%s", folderPath, Delimiter, directoryMarker)
-		exists, err := sess.Table("file").Where("LOWER(path) = ?", strings.ToLower(directoryMarkerPath)).Get(existing)
+		exists, err := sess.Table("file").Where("LOWER(path) = ?", directoryMarkerPath).Get(existing)
 		if err != nil {
 			return err
 		}
@@ -437,13 +844,36 @@ func (s dbFileStorage) DeleteFolder(ctx context.Context, folderPath string) erro
 			return nil
 		}
 
-		_, err = sess.Table("file").Where("LOWER(path) = ?", strings.ToLower(directoryMarkerPath)).Delete(existing)
+		_, err = sess.Table("file").Where("LOWER(path) = ?", directoryMarkerPath).Delete(existing)
 		return err
 	})
 
 	return err
 }
 
+func (s dbFileStorage) Restore(ctx context.Context, path string) error {
+	return ErrTrashNotEnabled
+}
+
+func (s dbFileStorage) PurgeTrash(ctx context.Context, path string) error {
+	return ErrTrashNotEnabled
+}
+
+func (s dbFileStorage) ListVersions(ctx context.Context, path string) ([]FileMetadata, error) {
+	return nil, ErrVersioningNotEnabled
+}
+
+func (s dbFileStorage) GetVersion(ctx context.Context, path string, version string) (*File, error) {
+	return nil, ErrVersioningNotEnabled
+}
+
+func (s dbFileStorage) HealthCheck(ctx context.Context) error {
+	return s.db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		files := make([]*file, 0)
+		return sess.Table("file").Cols("path").Limit(1).Find(&files)
+	})
+}
+
 func (s dbFileStorage) close() error {
 	return nil
 }
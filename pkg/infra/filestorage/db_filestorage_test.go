@@ -0,0 +1,139 @@
+package filestorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/services/sqlstore/migrations"
+	"github.com/grafana/grafana/pkg/services/sqlstore/migrator"
+	"github.com/stretchr/testify/require"
+)
+
+// fileTablesMigrator adds the tables of the DB backend to the OSS migrations, their migration is not registered yet.
+type fileTablesMigrator struct {
+	migrations.OSSMigrations
+}
+
+func (m *fileTablesMigrator) AddMigration(mg *migrator.Migrator) {
+	m.OSSMigrations.AddMigration(mg)
+
+	filesTable := migrator.Table{
+		Name: "file",
+		Columns: []*migrator.Column{
+			{Name: "path", Type: migrator.DB_NVarchar, Length: 1024, Nullable: false},
+			{Name: "parent_folder_path", Type: migrator.DB_NVarchar, Length: 1024, Nullable: false},
+			{Name: "contents", Type: migrator.DB_Blob, Nullable: false},
+			{Name: "updated", Type: migrator.DB_DateTime, Nullable: false},
+			{Name: "created", Type: migrator.DB_DateTime, Nullable: false},
+			{Name: "size", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "mime_type", Type: migrator.DB_NVarchar, Length: 255, Nullable: false},
+		},
+		Indices: []*migrator.Index{
+			{Cols: []string{"path"}, Type: migrator.UniqueIndex},
+		},
+	}
+	mg.AddMigration("create file table", migrator.NewAddTableMigration(filesTable))
+	mg.AddMigration("file table idx: path natural pk", migrator.NewAddIndexMigration(filesTable, filesTable.Indices[0]))
+
+	fileMetaTable := migrator.Table{
+		Name: "file_meta",
+		Columns: []*migrator.Column{
+			{Name: "path", Type: migrator.DB_NVarchar, Length: 1024, Nullable: false},
+			{Name: "key", Type: migrator.DB_NVarchar, Length: 1024, Nullable: false},
+			{Name: "value", Type: migrator.DB_NVarchar, Length: 1024, Nullable: false},
+		},
+		Indices: []*migrator.Index{
+			{Cols: []string{"path", "key"}, Type: migrator.UniqueIndex},
+		},
+	}
+	mg.AddMigration("create file_meta table", migrator.NewAddTableMigration(fileMetaTable))
+	mg.AddMigration("file table idx: path key", migrator.NewAddIndexMigration(fileMetaTable, fileMetaTable.Indices[0]))
+}
+
+func newTestDbStorage(t *testing.T) *dbFileStorage {
+	t.Helper()
+	return &dbFileStorage{
+		db:  sqlstore.InitTestDBWithMigration(t, &fileTablesMigrator{}),
+		log: log.New("test-db-filestorage"),
+	}
+}
+
+func TestDbFileStorage_Append(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should append to the file", func(t *testing.T) {
+		s := newTestDbStorage(t)
+		require.NoError(t, s.Append(ctx, "/file.log", []byte("a")))
+		require.NoError(t, s.Append(ctx, "/file.log", []byte("b")))
+
+		file, err := s.Get(ctx, "/file.log")
+		require.NoError(t, err)
+		require.Equal(t, "ab", string(file.Contents))
+		require.Equal(t, int64(2), file.Size)
+	})
+
+	t.Run("should fail if the file is modified concurrently", func(t *testing.T) {
+		s := newTestDbStorage(t)
+		original := []byte("original")
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/file.log", Contents: &original}))
+		read := &file{Contents: original, Size: int64(len(original))}
+
+		overwritten := []byte("overwritten")
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/file.log", Contents: &overwritten}))
+
+		err := s.appendTo(ctx, "/file.log", read, []byte(" appended"))
+		require.ErrorIs(t, err, ErrConcurrentAppend)
+
+		file, err := s.Get(ctx, "/file.log")
+		require.NoError(t, err)
+		require.Equal(t, "overwritten", string(file.Contents))
+	})
+
+	t.Run("should fail if the file is created concurrently", func(t *testing.T) {
+		s := newTestDbStorage(t)
+		created := []byte("created")
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/file.log", Contents: &created}))
+
+		err := s.appendTo(ctx, "/file.log", nil, []byte("appended"))
+		require.ErrorIs(t, err, ErrConcurrentAppend)
+
+		file, err := s.Get(ctx, "/file.log")
+		require.NoError(t, err)
+		require.Equal(t, "created", string(file.Contents))
+	})
+}
+
+func TestDbFileStorage_ListWithAllowedPaths(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDbStorage(t)
+	for _, path := range []string{"/a/1.txt", "/b/2.txt", "/c/exact.txt", "/c/other.txt", "/d/3.txt"} {
+		contents := []byte(path)
+		require.NoError(t, db.Upsert(ctx, &UpsertFileCommand{Path: path, Contents: &contents}))
+	}
+
+	s := NewDbStorage(log.New("test-db-filestorage"), db.db, NewPathFiltersWithAllowedPaths([]string{"/a/", "/b/"}, []string{"/c/exact.txt"}, nil), nil)
+
+	t.Run("should list the files matching any of the prefixes or the paths", func(t *testing.T) {
+		resp, err := s.ListFiles(ctx, "/", nil, &ListOptions{Recursive: true})
+		require.NoError(t, err)
+
+		paths := make([]string, 0, len(resp.Files))
+		for _, f := range resp.Files {
+			paths = append(paths, f.FullPath)
+		}
+		require.ElementsMatch(t, []string{"/a/1.txt", "/b/2.txt", "/c/exact.txt"}, paths)
+	})
+
+	t.Run("should list the folders of the prefixes and the paths", func(t *testing.T) {
+		folders, err := s.ListFolders(ctx, "/", nil)
+		require.NoError(t, err)
+
+		paths := make([]string, 0, len(folders))
+		for _, f := range folders {
+			paths = append(paths, f.FullPath)
+		}
+		require.ElementsMatch(t, []string{"/a", "/b", "/c"}, paths)
+	})
+}
@@ -2,6 +2,8 @@ package filestorage
 
 import (
 	"context"
+	"io"
+	"time"
 
 	_ "gocloud.dev/blob/fileblob"
 	_ "gocloud.dev/blob/memblob"
@@ -15,37 +17,109 @@ type dummyFileStorage struct {
 }
 
 func (d dummyFileStorage) Get(ctx context.Context, path string) (*File, error) {
-	return nil, nil
+	return nil, ErrFileNotFound
+}
+
+func (d dummyFileStorage) GetReader(ctx context.Context, path string) (io.ReadCloser, *FileMetadata, error) {
+	return nil, nil, ErrFileNotFound
+}
+
+func (d dummyFileStorage) Open(ctx context.Context, path string) (*FileMetadata, io.ReadSeekCloser, error) {
+	return nil, nil, ErrFileNotFound
+}
+
+func (d dummyFileStorage) GetMetadata(ctx context.Context, path string) (*FileMetadata, error) {
+	return nil, ErrFileNotFound
+}
+
+func (d dummyFileStorage) SignedURL(ctx context.Context, path string, ttl time.Duration) (string, error) {
+	return "", ErrSignedURLUnsupported
+}
+
+func (d dummyFileStorage) SignedUploadURL(ctx context.Context, path string, ttl time.Duration, contentType string) (string, error) {
+	return "", ErrSignedURLUnsupported
+}
+
+func (d dummyFileStorage) Exists(ctx context.Context, path string) (bool, error) {
+	return false, nil
 }
 
 func (d dummyFileStorage) Delete(ctx context.Context, path string) error {
-	return nil
+	return ErrFileNotFound
+}
+
+func (d dummyFileStorage) DeleteMany(ctx context.Context, paths []string) ([]string, map[string]error) {
+	return nil, nil
 }
 
 func (d dummyFileStorage) Upsert(ctx context.Context, file *UpsertFileCommand) error {
 	return nil
 }
 
+func (d dummyFileStorage) UpsertStream(ctx context.Context, path string, r io.Reader, command *UpsertFileCommand) error {
+	return nil
+}
+
+func (d dummyFileStorage) Append(ctx context.Context, path string, data []byte) error {
+	return nil
+}
+
+func (d dummyFileStorage) Touch(ctx context.Context, path string) error {
+	return ErrFileNotFound
+}
+
+func (d dummyFileStorage) Copy(ctx context.Context, srcPath string, dstPath string) error {
+	return nil
+}
+
+func (d dummyFileStorage) Move(ctx context.Context, srcPath string, dstPath string) error {
+	return nil
+}
+
 func (d dummyFileStorage) ListFiles(ctx context.Context, path string, cursor *Paging, options *ListOptions) (*ListFilesResponse, error) {
 	return nil, nil
 }
 
+func (d dummyFileStorage) WalkFiles(ctx context.Context, path string, options *ListOptions, fn func(FileMetadata) error) error {
+	return nil
+}
+
 func (d dummyFileStorage) ListFolders(ctx context.Context, path string, options *ListOptions) ([]FileMetadata, error) {
 	return nil, nil
 }
 
-func (d dummyFileStorage) CreateFolder(ctx context.Context, path string) error {
+func (d dummyFileStorage) CreateFolder(ctx context.Context, path string, options *CreateFolderOptions) error {
+	return nil
+}
+
+func (d dummyFileStorage) DeleteFolder(ctx context.Context, path string, options *DeleteFolderOptions) error {
 	return nil
 }
 
-func (d dummyFileStorage) DeleteFolder(ctx context.Context, path string) error {
+func (d dummyFileStorage) Restore(ctx context.Context, path string) error {
 	return nil
 }
 
+func (d dummyFileStorage) PurgeTrash(ctx context.Context, path string) error {
+	return nil
+}
+
+func (d dummyFileStorage) ListVersions(ctx context.Context, path string) ([]FileMetadata, error) {
+	return nil, nil
+}
+
+func (d dummyFileStorage) GetVersion(ctx context.Context, path string, version string) (*File, error) {
+	return nil, nil
+}
+
 func (d dummyFileStorage) IsFolderEmpty(ctx context.Context, path string) (bool, error) {
 	return true, nil
 }
 
+func (d dummyFileStorage) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
 func (d dummyFileStorage) close() error {
 	return nil
 }
@@ -0,0 +1,174 @@
+package filestorage
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	exportPageSize = 100
+
+	// the metadata of the exported files is kept in vendor specific PAX records
+	paxMimeTypeKey       = "GRAFANA.mime_type"
+	paxPropertyKeyPrefix = "GRAFANA.property."
+)
+
+// ExportTo writes a tar archive of all files under the folder to dst. The files are named by their path relative
+// to the folder and keep their MIME type and properties. The files are listed page by page and streamed one by one,
+// so that the whole folder is never held in memory.
+func (b service) ExportTo(ctx context.Context, path string, dst io.Writer) error {
+	folderPath := strings.TrimSuffix(normalizePath(path), Delimiter)
+	_, backendFolderPath, err := b.getBackend(folderPath)
+	if err != nil {
+		return err
+	}
+
+	writer := tar.NewWriter(dst)
+	paging := &Paging{First: exportPageSize}
+	for {
+		var resp *ListFilesResponse
+		err := b.limiter.run(ctx, func() error {
+			var err error
+			resp, err = b.ListFiles(ctx, folderPath, paging, &ListOptions{Recursive: true})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, file := range resp.Files {
+			name, ok := relativePath(backendFolderPath, file.FullPath)
+			if !ok {
+				return fmt.Errorf("listed file %s is not in folder %s", file.FullPath, backendFolderPath)
+			}
+
+			if err := b.limiter.run(ctx, func() error {
+				return b.exportFile(ctx, writer, folderPath+Delimiter+name, name)
+			}); err != nil {
+				return err
+			}
+		}
+
+		if !resp.HasMore || resp.LastPath == "" {
+			break
+		}
+		paging = &Paging{First: exportPageSize, After: resp.LastPath}
+	}
+
+	return writer.Close()
+}
+
+func (b service) exportFile(ctx context.Context, writer *tar.Writer, path string, name string) error {
+	reader, metadata, err := b.GetReader(ctx, path)
+	// deleted since it was listed
+	if errors.Is(err, ErrFileNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			b.log.Error("Failed to close reader", "path", path, "err", err)
+		}
+	}()
+
+	records := map[string]string{
+		paxMimeTypeKey: metadata.MimeType,
+	}
+	for k, v := range metadata.Properties {
+		records[paxPropertyKeyPrefix+k] = v
+	}
+
+	if err := writer.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       name,
+		Size:       metadata.Size,
+		Mode:       0644,
+		ModTime:    metadata.Modified,
+		PAXRecords: records,
+		Format:     tar.FormatPAX,
+	}); err != nil {
+		return fmt.Errorf("failed to export %s: %w", path, err)
+	}
+
+	if _, err := io.Copy(writer, reader); err != nil {
+		return fmt.Errorf("failed to export %s: %w", path, err)
+	}
+	return nil
+}
+
+// ImportFrom unpacks a tar archive written by ExportTo into the folder, replacing the existing files.
+// Entries which would end up outside of the folder are rejected.
+func (b service) ImportFrom(ctx context.Context, path string, src io.Reader) error {
+	folderPath := strings.TrimSuffix(normalizePath(path), Delimiter)
+	reader := tar.NewReader(src)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		name := strings.Trim(header.Name, Delimiter)
+		if name == "" {
+			continue
+		}
+
+		filePath := folderPath + Delimiter + name
+		if err := validatePath(filePath); err != nil {
+			return fmt.Errorf("invalid archive entry %q: %w", header.Name, err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := b.limiter.run(ctx, func() error {
+				return b.CreateFolder(ctx, filePath, nil)
+			}); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			contents, err := io.ReadAll(reader)
+			if err != nil {
+				return err
+			}
+
+			properties := make(map[string]string)
+			for k, v := range header.PAXRecords {
+				if strings.HasPrefix(k, paxPropertyKeyPrefix) {
+					properties[strings.TrimPrefix(k, paxPropertyKeyPrefix)] = v
+				}
+			}
+
+			if err := b.limiter.run(ctx, func() error {
+				return b.Upsert(ctx, &UpsertFileCommand{
+					Path:       filePath,
+					MimeType:   header.PAXRecords[paxMimeTypeKey],
+					Contents:   &contents,
+					Properties: properties,
+				})
+			}); err != nil {
+				return err
+			}
+		default:
+			b.log.Warn("Skipping unsupported archive entry", "name", header.Name, "type", header.Typeflag)
+		}
+	}
+}
+
+// relativePath returns the path of the file relative to the folder. Paths are compared case insensitively,
+// as the listed files keep their original casing.
+func relativePath(folderPath string, filePath string) (string, bool) {
+	prefix := strings.TrimSuffix(folderPath, Delimiter) + Delimiter
+	if len(filePath) <= len(prefix) || !strings.EqualFold(filePath[:len(prefix)], prefix) {
+		return "", false
+	}
+	return filePath[len(prefix):], true
+}
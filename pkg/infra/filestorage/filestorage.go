@@ -4,13 +4,28 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/services/featuremgmt"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
 	"github.com/grafana/grafana/pkg/setting"
 	"gocloud.dev/blob"
+	"gocloud.dev/blob/azureblob"
+	"gocloud.dev/blob/gcsblob"
+	"gocloud.dev/blob/s3blob"
+	"gocloud.dev/gcp"
+	"golang.org/x/oauth2/google"
 
 	_ "gocloud.dev/blob/fileblob"
 	_ "gocloud.dev/blob/memblob"
@@ -18,9 +33,15 @@ import (
 
 const (
 	ServiceName = "FileStorage"
+
+	healthCheckTimeout = 5 * time.Second
+	// closeGracePeriod is how long closing the service waits for the operations in flight
+	closeGracePeriod = 10 * time.Second
+	// deletePlanPageSize is the number of files listed at once when planning the deletion of a folder
+	deletePlanPageSize = 100
 )
 
-func ProvideService(features featuremgmt.FeatureToggles, cfg *setting.Cfg) (FileStorage, error) {
+func ProvideService(features featuremgmt.FeatureToggles, cfg *setting.Cfg, sqlStore *sqlstore.SQLStore) (FileStorage, error) {
 	grafanaDsStorageLogger := log.New("grafanaDsStorage")
 
 	path := fmt.Sprintf("file://%s", cfg.StaticRootPath)
@@ -41,120 +62,1194 @@ func ProvideService(features featuremgmt.FeatureToggles, cfg *setting.Cfg) (File
 		"upload/",
 	}
 
-	var grafanaDsStorage FileStorage
-	if features.IsEnabled(featuremgmt.FlagFileStoreApi) {
-		grafanaDsStorage = &wrapper{
-			log: grafanaDsStorageLogger,
-			wrapped: cdkBlobStorage{
-				log:        grafanaDsStorageLogger,
-				bucket:     bucket,
-				rootFolder: "",
-			},
-			pathFilters: &PathFilters{allowedPrefixes: prefixes},
+	s := &service{
+		backendByName: make(map[string]FileStorage),
+		dummyBackend:  &dummyFileStorage{},
+		log:           log.New("fileStorageService"),
+	}
+	s.listeners = newListenerDispatcher(s.log)
+	s.operations = newOperationTracker()
+
+	if !features.IsEnabled(featuremgmt.FlagFileStoreApi) {
+		s.backendByName[string(StorageNamePublic)] = &dummyFileStorage{}
+		s.resolver = newBackendResolver(s.backendByName)
+		return s, nil
+	}
+
+	s.backendByName[string(StorageNamePublic)] = &wrapper{
+		log: grafanaDsStorageLogger,
+		wrapped: cdkBlobStorage{
+			log:        grafanaDsStorageLogger,
+			bucket:     bucket,
+			rootFolder: "",
+			upsertLock: &sync.Mutex{},
+		},
+		pathFilters: NewPathFilters(prefixes, nil),
+	}
+
+	fsConfig, err := newConfig(cfg)
+	if err != nil {
+		_ = s.close()
+		return nil, err
+	}
+
+	s.slowLogThreshold = fsConfig.SlowLogThreshold
+	s.strictBackendResolution = fsConfig.StrictBackendResolution
+	s.limiter = newConcurrencyLimiter(fsConfig.MaxConcurrency)
+	s.protectedPaths = newProtectedPaths(fsConfig.ProtectedPaths)
+	if err := s.registerBackends(context.Background(), fsConfig, sqlStore, features); err != nil {
+		_ = s.close()
+		return nil, err
+	}
+
+	s.resolver = newBackendResolver(s.backendByName)
+	return s, nil
+}
+
+// registerBackends registers the configured backends whose type is enabled by its feature flag. The mem backends
+// are only meant for development and tests, they are not behind a flag.
+func (b service) registerBackends(ctx context.Context, fsConfig *filestorageConfig, sqlStore *sqlstore.SQLStore, features featuremgmt.FeatureToggles) error {
+	for _, fsBackend := range fsConfig.Backends.FS {
+		if !b.isBackendTypeEnabled(features, featuremgmt.FlagFileStoreFs, fsBackend.Name) {
+			continue
+		}
+
+		backendLogger := log.New("fileStorage", "backend", fsBackend.Name)
+		path := fmt.Sprintf("file://%s", fsBackend.Path)
+		bucket, err := blob.OpenBucket(ctx, path)
+		if err != nil {
+			backendLogger.Error("Failed to initialize file storage backend", "path", path, "error", err)
+			return err
+		}
+
+		pathFilter, err := fsBackend.pathFilter()
+		if err != nil {
+			return err
+		}
+
+		if err := b.registerBackend(fsBackend.backendConfig, NewCdkBlobStorage(backendLogger, bucket, "", pathFilter, fsBackend.operations(), fsBackend.cdkBlobStorageOptions())); err != nil {
+			return err
+		}
+	}
+
+	for _, s3Backend := range fsConfig.Backends.S3 {
+		if !b.isBackendTypeEnabled(features, featuremgmt.FlagFileStoreS3, s3Backend.Name) {
+			continue
+		}
+
+		backendLogger := log.New("fileStorage", "backend", s3Backend.Name)
+		bucket, err := openS3Bucket(ctx, s3Backend)
+		if err != nil {
+			backendLogger.Error("Failed to initialize file storage backend", "bucket", s3Backend.Bucket, "region", s3Backend.Region, "error", err)
+			return err
+		}
+
+		pathFilter, err := s3Backend.pathFilter()
+		if err != nil {
+			return err
+		}
+
+		if err := b.registerBackend(s3Backend.backendConfig, NewCdkBlobStorage(backendLogger, bucket, "", pathFilter, s3Backend.operations(), s3Backend.cdkBlobStorageOptions())); err != nil {
+			return err
+		}
+	}
+
+	for _, gcsBackend := range fsConfig.Backends.GCS {
+		if !b.isBackendTypeEnabled(features, featuremgmt.FlagFileStoreGcs, gcsBackend.Name) {
+			continue
+		}
+
+		backendLogger := log.New("fileStorage", "backend", gcsBackend.Name)
+		bucket, err := openGCSBucket(ctx, gcsBackend)
+		if err != nil {
+			backendLogger.Error("Failed to initialize file storage backend", "bucket", gcsBackend.Bucket, "error", err)
+			return err
+		}
+
+		pathFilter, err := gcsBackend.pathFilter()
+		if err != nil {
+			return err
+		}
+
+		if err := b.registerBackend(gcsBackend.backendConfig, NewCdkBlobStorage(backendLogger, bucket, "", pathFilter, gcsBackend.operations(), gcsBackend.cdkBlobStorageOptions())); err != nil {
+			return err
+		}
+	}
+
+	for _, azureBackend := range fsConfig.Backends.Azure {
+		if !b.isBackendTypeEnabled(features, featuremgmt.FlagFileStoreAzure, azureBackend.Name) {
+			continue
+		}
+
+		backendLogger := log.New("fileStorage", "backend", azureBackend.Name)
+		bucket, err := openAzureBucket(ctx, azureBackend)
+		if err != nil {
+			backendLogger.Error("Failed to initialize file storage backend", "account", azureBackend.AccountName, "container", azureBackend.Container, "error", err)
+			return err
+		}
+
+		pathFilter, err := azureBackend.pathFilter()
+		if err != nil {
+			return err
+		}
+
+		if err := b.registerBackend(azureBackend.backendConfig, NewCdkBlobStorage(backendLogger, bucket, "", pathFilter, azureBackend.operations(), azureBackend.cdkBlobStorageOptions())); err != nil {
+			return err
+		}
+	}
+
+	for _, memBackend := range fsConfig.Backends.Mem {
+		backendLogger := log.New("fileStorage", "backend", memBackend.Name)
+		bucket, err := blob.OpenBucket(ctx, "mem://")
+		if err != nil {
+			backendLogger.Error("Failed to initialize file storage backend", "error", err)
+			return err
+		}
+
+		pathFilter, err := memBackend.pathFilter()
+		if err != nil {
+			return err
+		}
+
+		if err := b.registerBackend(memBackend.backendConfig, NewCdkBlobStorage(backendLogger, bucket, "", pathFilter, memBackend.operations(), memBackend.cdkBlobStorageOptions())); err != nil {
+			return err
+		}
+	}
+
+	for _, dbBackend := range fsConfig.Backends.DB {
+		if !b.isBackendTypeEnabled(features, featuremgmt.FlagFileStoreDb, dbBackend.Name) {
+			continue
+		}
+
+		backendLogger := log.New("fileStorage", "backend", dbBackend.Name)
+		if sqlStore == nil {
+			return fmt.Errorf("file storage backend %s requires a database", dbBackend.Name)
+		}
+
+		pathFilter, err := dbBackend.pathFilter()
+		if err != nil {
+			return err
+		}
+
+		if err := b.registerBackend(dbBackend.backendConfig, NewDbStorage(backendLogger, sqlStore, pathFilter, dbBackend.operations())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isBackendTypeEnabled reports whether the feature flag of the backend type is enabled, and logs the skipped backend
+// otherwise.
+func (b service) isBackendTypeEnabled(features featuremgmt.FeatureToggles, flag string, name string) bool {
+	if features.IsEnabled(flag) {
+		return true
+	}
+
+	b.log.Warn("Skipping file storage backend, the feature flag of its type is disabled", "name", name, "flag", flag)
+	return false
+}
+
+// operations returns the operations supported by the backend, nil meaning all of them.
+func (c backendConfig) operations() []Operation {
+	if c.ReadOnly && len(c.SupportedOperations) == 0 {
+		return readOperations
+	}
+	return c.SupportedOperations
+}
+
+func (b service) registerBackend(cfg backendConfig, backend FileStorage) error {
+	if err := validateBackendName(cfg.Name); err != nil {
+		_ = backend.close()
+		return err
+	}
+
+	if _, ok := b.backendByName[cfg.Name]; ok {
+		_ = backend.close()
+		return fmt.Errorf("duplicate file storage backend name: %s", cfg.Name)
+	}
+
+	// every attempt of a retried operation gets the full operation timeout
+	retryingBackend := withRetries(withOperationTimeout(backend, cfg.OperationTimeout), cfg.MaxRetries, cfg.RetryBaseDelay)
+	cachedBackend, err := withCache(retryingBackend, cfg.CacheTTL, cfg.CacheSize)
+	if err != nil {
+		_ = backend.close()
+		return err
+	}
+
+	b.log.Info("Registered file storage backend", "name", cfg.Name)
+	b.backendByName[cfg.Name] = cachedBackend
+	return nil
+}
+
+// backendNameRegex matches the names usable as the first segment of the paths of a backend.
+var backendNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+func validateBackendName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("invalid file storage backend name: name can not be empty")
+	case strings.TrimSpace(name) != name:
+		return fmt.Errorf("invalid file storage backend name %q: name can not start or end with whitespace", name)
+	case strings.Contains(name, Delimiter):
+		return fmt.Errorf("invalid file storage backend name %q: name can not contain %q", name, Delimiter)
+	case !backendNameRegex.MatchString(name):
+		return fmt.Errorf("invalid file storage backend name %q: name must start with a letter or digit and contain only letters, digits, '_', '.' and '-'", name)
+	}
+	return nil
+}
+
+func openS3Bucket(ctx context.Context, backend s3BackendConfig) (*blob.Bucket, error) {
+	awsConfig := aws.NewConfig().WithRegion(backend.Region)
+	if backend.Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(backend.Endpoint).WithS3ForcePathStyle(true)
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+
+	if _, err := sess.Config.Credentials.GetWithContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to retrieve AWS credentials for bucket %s: %w", backend.Bucket, err)
+	}
+
+	return s3blob.OpenBucket(ctx, sess, backend.Bucket, nil)
+}
+
+// gcsScope is the OAuth scope requested for the credentials read from a file
+const gcsScope = "https://www.googleapis.com/auth/cloud-platform"
+
+func openGCSBucket(ctx context.Context, backend gcsBackendConfig) (*blob.Bucket, error) {
+	creds, err := gcsCredentials(ctx, backend.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve GCP credentials for bucket %s: %w", backend.Bucket, err)
+	}
+
+	client, err := gcp.NewHTTPClient(gcp.DefaultTransport(), gcp.CredentialsTokenSource(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+
+	opener := &gcsblob.URLOpener{Client: client}
+	return opener.OpenBucketURL(ctx, gcsBucketURL(backend))
+}
+
+// gcsCredentials reads the credentials file, or falls back to the application default credentials.
+func gcsCredentials(ctx context.Context, credentialsFile string) (*google.Credentials, error) {
+	if credentialsFile == "" {
+		return gcp.DefaultCredentials(ctx)
+	}
+
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	return google.CredentialsFromJSON(ctx, data, gcsScope)
+}
+
+// gcsBucketURL returns the URL of the bucket, including the service account signing URLs if configured.
+func gcsBucketURL(backend gcsBackendConfig) *url.URL {
+	query := url.Values{}
+	if backend.AccessID != "" {
+		query.Set("access_id", backend.AccessID)
+	}
+	if backend.PrivateKeyPath != "" {
+		query.Set("private_key_path", backend.PrivateKeyPath)
+	}
+
+	return &url.URL{
+		Scheme:   gcsblob.Scheme,
+		Host:     backend.Bucket,
+		RawQuery: query.Encode(),
+	}
+}
+
+// openAzureBucket signs the requests with the account key if configured, the requests are anonymous otherwise and
+// rely on the SAS token if there is one.
+func openAzureBucket(ctx context.Context, backend azureBackendConfig) (*blob.Bucket, error) {
+	var credential azblob.Credential = azblob.NewAnonymousCredential()
+	options := azureblob.Options{
+		SASToken: azureblob.SASToken(backend.SASToken),
+	}
+
+	if backend.AccountKey != "" {
+		sharedKeyCredential, err := azureblob.NewCredential(azureblob.AccountName(backend.AccountName), azureblob.AccountKey(backend.AccountKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid Azure credentials for account %s: %w", backend.AccountName, err)
 		}
-	} else {
-		grafanaDsStorage = &dummyFileStorage{}
+		credential = sharedKeyCredential
+		// needed to sign URLs
+		options.Credential = sharedKeyCredential
 	}
 
-	return &service{
-		grafanaDsStorage: grafanaDsStorage,
-		log:              log.New("fileStorageService"),
-	}, nil
+	opener := &azureblob.URLOpener{
+		AccountName: azureblob.AccountName(backend.AccountName),
+		Pipeline:    azureblob.NewPipeline(credential, azblob.PipelineOptions{}),
+		Options:     options,
+	}
+	return opener.OpenBucketURL(ctx, azureBucketURL(backend))
+}
+
+// azureBucketURL returns the URL of the container, the account and its credentials are not part of it.
+func azureBucketURL(backend azureBackendConfig) *url.URL {
+	query := url.Values{}
+	if backend.StorageDomain != "" {
+		query.Set("domain", backend.StorageDomain)
+	}
+	if backend.Protocol != "" {
+		query.Set("protocol", backend.Protocol)
+	}
+
+	return &url.URL{
+		Scheme:   azureblob.Scheme,
+		Host:     backend.Container,
+		RawQuery: query.Encode(),
+	}
 }
 
 type service struct {
-	log              log.Logger
-	grafanaDsStorage FileStorage
+	log           log.Logger
+	dummyBackend  FileStorage
+	backendByName map[string]FileStorage
+	resolver      *backendResolver
+	listeners     *listenerDispatcher
+	operations    *operationTracker
+	// limiter bounds the backend operations run at once by the bulk methods, a nil limiter does not limit them
+	limiter *concurrencyLimiter
+	// slowLogThreshold is the duration above which the operations are logged as slow, zero disables the logging
+	slowLogThreshold time.Duration
+	// strictBackendResolution fails the operations on paths which do not belong to any backend
+	strictBackendResolution bool
+	// protectedPaths are the lower-cased paths, without trailing delimiter, which can not be written to
+	protectedPaths []string
+}
+
+// AddListener registers a listener notified after files are upserted or deleted. The listeners are called
+// asynchronously, after the write has returned.
+func (b service) AddListener(listener FileStorageListener) {
+	b.listeners.addListener(listener)
+}
+
+// newProtectedPaths normalizes the paths for checkProtected.
+func newProtectedPaths(paths []string) []string {
+	protected := make([]string, 0, len(paths))
+	for _, path := range paths {
+		protected = append(protected, strings.ToLower(strings.TrimSuffix(normalizePath(path), Delimiter)))
+	}
+	return protected
+}
+
+// checkProtected returns ErrPathProtected if the path is a protected path or is nested in one. Protected paths
+// are virtual paths, checked regardless of the backend they belong to.
+func (b service) checkProtected(path string) error {
+	lowerPath := strings.ToLower(normalizePath(path))
+	for _, protected := range b.protectedPaths {
+		if lowerPath == protected || strings.HasPrefix(lowerPath, protected+Delimiter) {
+			return fmt.Errorf("%w: %s", ErrPathProtected, path)
+		}
+	}
+	return nil
+}
+
+// getBackend returns the backend of the path along with the path within the backend. Paths which do not belong
+// to any backend fail with ErrBackendNotFound in strict mode, and go to the dummy backend otherwise.
+func (b service) getBackend(path string) (FileStorage, string, error) {
+	path = normalizePath(path)
+	if name, ok := b.resolver.resolve(path); ok {
+		return b.backendByName[name], removeStoragePrefix(path), nil
+	}
+
+	if b.strictBackendResolution {
+		return nil, "", fmt.Errorf("%w: %s", ErrBackendNotFound, path)
+	}
+
+	b.log.Warn("Backend not found", "path", path)
+	return b.dummyBackend, path, nil
+}
+
+func (b service) Get(ctx context.Context, path string) (_ *File, err error) {
+	defer b.instrument("get", path)(&err)
+
+	backend, path, err := b.getBackend(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validatePath(path); err != nil {
+		return nil, err
+	}
+
+	return backend.Get(ctx, path)
+}
+
+func (b service) GetReader(ctx context.Context, path string) (_ io.ReadCloser, _ *FileMetadata, err error) {
+	defer b.instrument("get_reader", path)(&err)
+
+	backend, path, err := b.getBackend(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := validatePath(path); err != nil {
+		return nil, nil, err
+	}
+
+	return backend.GetReader(ctx, path)
 }
 
-func (b service) Get(ctx context.Context, path string) (*File, error) {
-	var filestorage FileStorage
-	if belongsToStorage(path, StorageNamePublic) {
-		filestorage = b.grafanaDsStorage
-		path = removeStoragePrefix(path)
+func (b service) Open(ctx context.Context, path string) (_ *FileMetadata, _ io.ReadSeekCloser, err error) {
+	defer b.instrument("open", path)(&err)
+
+	backend, path, err := b.getBackend(path)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	if err := validatePath(path); err != nil {
+		return nil, nil, err
+	}
+
+	return backend.Open(ctx, path)
+}
+
+func (b service) GetMetadata(ctx context.Context, path string) (_ *FileMetadata, err error) {
+	defer b.instrument("get_metadata", path)(&err)
+
+	backend, path, err := b.getBackend(path)
+	if err != nil {
 		return nil, err
 	}
 
-	return filestorage.Get(ctx, path)
+	if err := validatePath(path); err != nil {
+		return nil, err
+	}
+
+	return backend.GetMetadata(ctx, path)
 }
 
+func (b service) ListVersions(ctx context.Context, path string) (_ []FileMetadata, err error) {
+	defer b.instrument("list_versions", path)(&err)
+
+	backend, path, err := b.getBackend(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validatePath(path); err != nil {
+		return nil, err
+	}
+
+	return backend.ListVersions(ctx, path)
+}
+
+func (b service) GetVersion(ctx context.Context, path string, version string) (_ *File, err error) {
+	defer b.instrument("get_version", path)(&err)
+
+	backend, path, err := b.getBackend(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validatePath(path); err != nil {
+		return nil, err
+	}
+
+	return backend.GetVersion(ctx, path, version)
+}
+
+func (b service) Restore(ctx context.Context, path string) (err error) {
+	defer b.instrument("restore", path)(&err)
+
+	if err := b.checkProtected(path); err != nil {
+		return err
+	}
+
+	backend, path, err := b.getBackend(path)
+	if err != nil {
+		return err
+	}
+
+	if err := validatePath(path); err != nil {
+		return err
+	}
+
+	return backend.Restore(ctx, path)
+}
+
+func (b service) PurgeTrash(ctx context.Context, path string) (err error) {
+	defer b.instrument("purge_trash", path)(&err)
+
+	if err := b.checkProtected(path); err != nil {
+		return err
+	}
+
+	backend, path, err := b.getBackend(path)
+	if err != nil {
+		return err
+	}
+
+	if err := validatePath(path); err != nil {
+		return err
+	}
+
+	return backend.PurgeTrash(ctx, path)
+}
+
+func (b service) SignedURL(ctx context.Context, path string, ttl time.Duration) (_ string, err error) {
+	defer b.instrument("signed_url", path)(&err)
+
+	backend, path, err := b.getBackend(path)
+	if err != nil {
+		return "", err
+	}
+
+	if err := validatePath(path); err != nil {
+		return "", err
+	}
+
+	return backend.SignedURL(ctx, path, ttl)
+}
+
+func (b service) SignedUploadURL(ctx context.Context, path string, ttl time.Duration, contentType string) (_ string, err error) {
+	defer b.instrument("signed_upload_url", path)(&err)
+
+	if err := b.checkProtected(path); err != nil {
+		return "", err
+	}
+
+	backend, path, err := b.getBackend(path)
+	if err != nil {
+		return "", err
+	}
+
+	if err := validatePath(path); err != nil {
+		return "", err
+	}
+
+	return backend.SignedUploadURL(ctx, path, ttl, contentType)
+}
+
+// normalizePath collapses runs of delimiters, so that "/backend//folder" is the same path as "/backend/folder".
+func normalizePath(path string) string {
+	for strings.Contains(path, Delimiter+Delimiter) {
+		path = strings.ReplaceAll(path, Delimiter+Delimiter, Delimiter)
+	}
+	return path
+}
+
+// removeStoragePrefix strips the first segment, the name of the backend, and returns the rest of the path with
+// a single leading delimiter. Empty segments are dropped, so "/backend//folder/" becomes "/folder".
 func removeStoragePrefix(path string) string {
-	path = strings.TrimPrefix(path, Delimiter)
-	if path == Delimiter || path == "" {
-		return Delimiter
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(path, Delimiter) {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
 	}
 
-	if !strings.Contains(path, Delimiter) {
+	if len(segments) <= 1 {
 		return Delimiter
 	}
+	return Delimiter + strings.Join(segments[1:], Delimiter)
+}
 
-	split := strings.Split(path, Delimiter)
+func (b service) Exists(ctx context.Context, path string) (_ bool, err error) {
+	defer b.instrument("exists", path)(&err)
 
-	// root of storage
-	if len(split) == 2 && split[1] == "" {
-		return Delimiter
+	backend, path, err := b.getBackend(path)
+	if err != nil {
+		return false, err
 	}
 
-	// replace storage
-	split[0] = ""
-	return strings.Join(split, Delimiter)
+	if err := validatePath(path); err != nil {
+		return false, err
+	}
+
+	return backend.Exists(ctx, path)
 }
 
-func (b service) Delete(ctx context.Context, path string) error {
-	return errors.New("not implemented")
+func (b service) Delete(ctx context.Context, path string) (err error) {
+	defer b.instrument("delete", path)(&err)
+
+	if err := b.checkProtected(path); err != nil {
+		return err
+	}
+
+	backend, backendPath, err := b.getBackend(path)
+	if err != nil {
+		return err
+	}
+
+	if err := validatePath(backendPath); err != nil {
+		return err
+	}
+
+	if err := backend.Delete(ctx, backendPath); err != nil {
+		return err
+	}
+
+	b.listeners.notify(fileEventDelete, normalizePath(path))
+	return nil
 }
 
-func (b service) Upsert(ctx context.Context, file *UpsertFileCommand) error {
-	return errors.New("not implemented")
+// DeleteMany groups the paths by their backend, so that each backend deletes its files in a single call.
+// The deleted paths and the errors are reported by the paths passed in.
+func (b service) DeleteMany(ctx context.Context, paths []string) (deleted []string, errs map[string]error) {
+	var err error
+	defer b.instrument("delete_many", "")(&err)
+
+	errs = make(map[string]error)
+	// the original paths of the files by backend and by their path within the backend
+	pathsByBackend := make(map[string]map[string]string)
+	for _, path := range paths {
+		if err := b.checkProtected(path); err != nil {
+			errs[path] = err
+			continue
+		}
+
+		name, ok := b.resolver.resolve(normalizePath(path))
+		if !ok && b.strictBackendResolution {
+			errs[path] = fmt.Errorf("%w: %s", ErrBackendNotFound, path)
+			continue
+		}
+
+		if !ok {
+			b.log.Warn("Backend not found", "path", path)
+			continue
+		}
+
+		backendPath := removeStoragePrefix(normalizePath(path))
+		if err := validatePath(backendPath); err != nil {
+			errs[path] = err
+			continue
+		}
+
+		if pathsByBackend[name] == nil {
+			pathsByBackend[name] = make(map[string]string)
+		}
+		pathsByBackend[name][backendPath] = path
+	}
+
+	names := make([]string, 0, len(pathsByBackend))
+	for name := range pathsByBackend {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// the backends delete their files concurrently, within the limit of the service
+	type backendResult struct {
+		deleted []string
+		errs    map[string]error
+	}
+	results := make([]backendResult, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		originalPaths := pathsByBackend[name]
+		backendPaths := make([]string, 0, len(originalPaths))
+		for backendPath := range originalPaths {
+			backendPaths = append(backendPaths, backendPath)
+		}
+		sort.Strings(backendPaths)
+
+		wg.Add(1)
+		go func(i int, backend FileStorage, backendPaths []string) {
+			defer wg.Done()
+			if err := b.limiter.acquire(ctx); err != nil {
+				errs := make(map[string]error, len(backendPaths))
+				for _, backendPath := range backendPaths {
+					errs[backendPath] = err
+				}
+				results[i] = backendResult{errs: errs}
+				return
+			}
+			defer b.limiter.release()
+
+			backendDeleted, backendErrs := backend.DeleteMany(ctx, backendPaths)
+			results[i] = backendResult{deleted: backendDeleted, errs: backendErrs}
+		}(i, b.backendByName[name], backendPaths)
+	}
+	wg.Wait()
+
+	deleted = make([]string, 0, len(paths))
+	for i, name := range names {
+		originalPaths := pathsByBackend[name]
+		backendDeleted, backendErrs := results[i].deleted, results[i].errs
+		for _, backendPath := range backendDeleted {
+			deleted = append(deleted, originalPaths[backendPath])
+			b.listeners.notify(fileEventDelete, normalizePath(originalPaths[backendPath]))
+		}
+		for backendPath, backendErr := range backendErrs {
+			errs[originalPaths[backendPath]] = backendErr
+		}
+	}
+
+	if len(errs) > 0 {
+		err = fmt.Errorf("failed to delete %d of %d files", len(errs), len(paths))
+	}
+	return deleted, errs
 }
 
-func (b service) ListFiles(ctx context.Context, path string, cursor *Paging, options *ListOptions) (*ListFilesResponse, error) {
-	var filestorage FileStorage
-	if belongsToStorage(path, StorageNamePublic) {
-		filestorage = b.grafanaDsStorage
-		path = removeStoragePrefix(path)
-	} else {
-		return nil, errors.New("not implemented")
+func (b service) Upsert(ctx context.Context, file *UpsertFileCommand) (err error) {
+	defer b.instrument("upsert", file.Path)(&err)
+
+	if err := b.checkProtected(file.Path); err != nil {
+		return err
+	}
+
+	backend, path, err := b.getBackend(file.Path)
+	if err != nil {
+		return err
 	}
 
 	if err := validatePath(path); err != nil {
+		return err
+	}
+
+	backendCommand := *file
+	backendCommand.Path = path
+	if err := backend.Upsert(ctx, &backendCommand); err != nil {
+		return err
+	}
+
+	b.listeners.notify(fileEventUpsert, normalizePath(file.Path))
+	return nil
+}
+
+func (b service) UpsertStream(ctx context.Context, path string, r io.Reader, command *UpsertFileCommand) (err error) {
+	defer b.instrument("upsert_stream", path)(&err)
+
+	if err := b.checkProtected(path); err != nil {
+		return err
+	}
+
+	backend, backendPath, err := b.getBackend(path)
+	if err != nil {
+		return err
+	}
+
+	if err := validatePath(backendPath); err != nil {
+		return err
+	}
+
+	backendCommand := UpsertFileCommand{}
+	if command != nil {
+		backendCommand = *command
+	}
+	backendCommand.Path = backendPath
+	backendCommand.Contents = nil
+	if err := backend.UpsertStream(ctx, backendPath, r, &backendCommand); err != nil {
+		return err
+	}
+
+	b.listeners.notify(fileEventUpsert, normalizePath(path))
+	return nil
+}
+
+func (b service) Append(ctx context.Context, path string, data []byte) (err error) {
+	defer b.instrument("append", path)(&err)
+
+	if err := b.checkProtected(path); err != nil {
+		return err
+	}
+
+	backend, backendPath, err := b.getBackend(path)
+	if err != nil {
+		return err
+	}
+
+	if err := validatePath(backendPath); err != nil {
+		return err
+	}
+
+	if err := backend.Append(ctx, backendPath, data); err != nil {
+		return err
+	}
+
+	b.listeners.notify(fileEventUpsert, normalizePath(path))
+	return nil
+}
+
+func (b service) Touch(ctx context.Context, path string) (err error) {
+	defer b.instrument("touch", path)(&err)
+
+	if err := b.checkProtected(path); err != nil {
+		return err
+	}
+
+	backend, backendPath, err := b.getBackend(path)
+	if err != nil {
+		return err
+	}
+
+	if err := validatePath(backendPath); err != nil {
+		return err
+	}
+
+	if err := backend.Touch(ctx, backendPath); err != nil {
+		return err
+	}
+
+	b.listeners.notify(fileEventUpsert, normalizePath(path))
+	return nil
+}
+
+func (b service) Copy(ctx context.Context, srcPath string, dstPath string) (err error) {
+	defer b.instrument("copy", srcPath)(&err)
+
+	if err := b.checkProtected(dstPath); err != nil {
+		return err
+	}
+
+	srcBackend, srcPath, err := b.getBackend(srcPath)
+	if err != nil {
+		return err
+	}
+
+	dstBackend, dstPath, err := b.getBackend(dstPath)
+	if err != nil {
+		return err
+	}
+
+	if srcBackend != dstBackend {
+		return ErrCrossBackendOperation
+	}
+
+	if err := validatePath(srcPath); err != nil {
+		return err
+	}
+
+	if err := validatePath(dstPath); err != nil {
+		return err
+	}
+
+	return srcBackend.Copy(ctx, srcPath, dstPath)
+}
+
+func (b service) Move(ctx context.Context, srcPath string, dstPath string) (err error) {
+	defer b.instrument("move", srcPath)(&err)
+
+	if err := b.checkProtected(srcPath); err != nil {
+		return err
+	}
+
+	if err := b.checkProtected(dstPath); err != nil {
+		return err
+	}
+
+	srcBackend, srcPath, err := b.getBackend(srcPath)
+	if err != nil {
+		return err
+	}
+
+	dstBackend, dstPath, err := b.getBackend(dstPath)
+	if err != nil {
+		return err
+	}
+
+	if srcBackend != dstBackend {
+		return ErrCrossBackendOperation
+	}
+
+	if err := validatePath(srcPath); err != nil {
+		return err
+	}
+
+	if err := validatePath(dstPath); err != nil {
+		return err
+	}
+
+	return srcBackend.Move(ctx, srcPath, dstPath)
+}
+
+func (b service) ListFiles(ctx context.Context, path string, cursor *Paging, options *ListOptions) (_ *ListFilesResponse, err error) {
+	defer b.instrument("list_files", path)(&err)
+
+	backend, path, err := b.getBackend(path)
+	if err != nil {
 		return nil, err
 	}
 
-	return filestorage.ListFiles(ctx, path, cursor, options)
+	if err := validatePath(path); err != nil {
+		return nil, err
+	}
+
+	return backend.ListFiles(ctx, path, cursor, options)
 }
 
-func (b service) ListFolders(ctx context.Context, path string, options *ListOptions) ([]FileMetadata, error) {
-	var filestorage FileStorage
-	if belongsToStorage(path, StorageNamePublic) {
-		filestorage = b.grafanaDsStorage
-		path = removeStoragePrefix(path)
-	} else {
-		return nil, errors.New("not implemented")
+func (b service) WalkFiles(ctx context.Context, path string, options *ListOptions, fn func(FileMetadata) error) (err error) {
+	defer b.instrument("walk_files", path)(&err)
+
+	backend, path, err := b.getBackend(path)
+	if err != nil {
+		return err
 	}
 
 	if err := validatePath(path); err != nil {
+		return err
+	}
+
+	return backend.WalkFiles(ctx, path, options, fn)
+}
+
+func (b service) ListFolders(ctx context.Context, path string, options *ListOptions) (_ []FileMetadata, err error) {
+	defer b.instrument("list_folders", path)(&err)
+
+	if path == "" || path == Delimiter {
+		return b.listBackendFolders(), nil
+	}
+
+	backend, path, err := b.getBackend(path)
+	if err != nil {
 		return nil, err
 	}
 
-	return filestorage.ListFolders(ctx, path, options)
+	if err := validatePath(path); err != nil {
+		return nil, err
+	}
+
+	return backend.ListFolders(ctx, path, options)
+}
+
+// listBackendFolders lists the registered backends as the folders of the virtual root.
+func (b service) listBackendFolders() []FileMetadata {
+	names := make([]string, 0, len(b.backendByName))
+	for name := range b.backendByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	folders := make([]FileMetadata, 0, len(names))
+	for _, name := range names {
+		folders = append(folders, FileMetadata{
+			Name:     name,
+			FullPath: Join(name),
+		})
+	}
+	return folders
 }
 
-func (b service) CreateFolder(ctx context.Context, path string) error {
-	return errors.New("not implemented")
+func (b service) CreateFolder(ctx context.Context, path string, options *CreateFolderOptions) (err error) {
+	defer b.instrument("create_folder", path)(&err)
+
+	if err := b.checkProtected(path); err != nil {
+		return err
+	}
+
+	backend, path, err := b.getBackend(path)
+	if err != nil {
+		return err
+	}
+
+	if err := validatePath(path); err != nil {
+		return err
+	}
+
+	return backend.CreateFolder(ctx, path, options)
+}
+
+func (b service) DeleteFolder(ctx context.Context, path string, options *DeleteFolderOptions) (err error) {
+	defer b.instrument("delete_folder", path)(&err)
+
+	if err := b.checkProtected(path); err != nil {
+		return err
+	}
+
+	backend, backendPath, err := b.getBackend(path)
+	if err != nil {
+		return err
+	}
+
+	if err := validatePath(backendPath); err != nil {
+		return err
+	}
+
+	if err := backend.DeleteFolder(ctx, backendPath, options); err != nil {
+		return err
+	}
+
+	b.listeners.notify(fileEventDelete, normalizePath(path))
+	return nil
 }
 
-func (b service) DeleteFolder(ctx context.Context, path string) error {
-	return errors.New("not implemented")
+// PlanDeleteFolder returns the paths of the files DeleteFolder removes when forced, without deleting anything.
+// The folder is listed recursively page by page, so the plan only covers the files visible to ListFiles, and
+// files written after the planning are not part of it.
+func (b service) PlanDeleteFolder(ctx context.Context, path string) ([]string, error) {
+	folderPath := strings.TrimSuffix(normalizePath(path), Delimiter)
+	if err := b.checkProtected(folderPath); err != nil {
+		return nil, err
+	}
+
+	_, backendFolderPath, err := b.getBackend(folderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0)
+	paging := &Paging{First: deletePlanPageSize}
+	for {
+		resp, err := b.ListFiles(ctx, folderPath, paging, &ListOptions{Recursive: true})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range resp.Files {
+			name, ok := relativePath(backendFolderPath, file.FullPath)
+			if !ok {
+				return nil, fmt.Errorf("listed file %s is not in folder %s", file.FullPath, backendFolderPath)
+			}
+			paths = append(paths, folderPath+Delimiter+name)
+		}
+
+		if !resp.HasMore || resp.LastPath == "" {
+			break
+		}
+		paging = &Paging{First: deletePlanPageSize, After: resp.LastPath}
+	}
+
+	return paths, nil
 }
 
 func (b service) IsFolderEmpty(ctx context.Context, path string) (bool, error) {
 	return true, errors.New("not implemented")
 }
 
+// HealthCheck returns an error listing the backends which are not reachable.
+func (b service) HealthCheck(ctx context.Context) error {
+	errByBackendName := b.CheckBackendsHealth(ctx)
+	if len(errByBackendName) == 0 {
+		return nil
+	}
+
+	unhealthy := make([]string, 0, len(errByBackendName))
+	for name, err := range errByBackendName {
+		unhealthy = append(unhealthy, fmt.Sprintf("%s: %s", name, err))
+	}
+	sort.Strings(unhealthy)
+	return fmt.Errorf("unhealthy file storage backends: %s", strings.Join(unhealthy, ", "))
+}
+
+// CheckBackendsHealth checks all backends concurrently and returns the errors of the unhealthy ones by backend name.
+// Backends which do not respond within the timeout are reported as unhealthy so that a hung backend does not block the caller.
+func (b service) CheckBackendsHealth(ctx context.Context) map[string]error {
+	type healthCheckResult struct {
+		name string
+		err  error
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	// buffered so that late responses do not leak the goroutines
+	results := make(chan healthCheckResult, len(b.backendByName))
+	pending := make(map[string]bool, len(b.backendByName))
+	for name, backend := range b.backendByName {
+		pending[name] = true
+		go func(name string, backend FileStorage) {
+			results <- healthCheckResult{name: name, err: backend.HealthCheck(checkCtx)}
+		}(name, backend)
+	}
+
+	errByBackendName := make(map[string]error)
+	for len(pending) > 0 {
+		select {
+		case result := <-results:
+			delete(pending, result.name)
+			if result.err != nil {
+				b.log.Warn("File storage backend is unhealthy", "backend", result.name, "err", result.err)
+				errByBackendName[result.name] = result.err
+			}
+		case <-checkCtx.Done():
+			for name := range pending {
+				b.log.Warn("File storage backend health check timed out", "backend", name)
+				errByBackendName[name] = fmt.Errorf("health check timed out: %w", checkCtx.Err())
+			}
+			return errByBackendName
+		}
+	}
+
+	return errByBackendName
+}
+
 func (b service) close() error {
-	return b.grafanaDsStorage.close()
+	ctx, cancel := context.WithTimeout(context.Background(), closeGracePeriod)
+	defer cancel()
+
+	return b.closeWithContext(ctx)
+}
+
+// closeWithContext waits for the operations in flight to finish before closing the backends, so that writes are not
+// interrupted. The backends are closed anyway once the context is done. All errors are returned.
+func (b service) closeWithContext(ctx context.Context) error {
+	errs := make([]error, 0)
+	if err := b.operations.wait(ctx); err != nil {
+		b.log.Warn("Closing file storage backends with operations in flight", "operations", b.operations.inFlight(), "err", err)
+		errs = append(errs, fmt.Errorf("failed to wait for %d operations in flight: %w", b.operations.inFlight(), err))
+	}
+
+	b.listeners.close()
+
+	names := make([]string, 0, len(b.backendByName))
+	for name := range b.backendByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := b.backendByName[name].close(); err != nil {
+			b.log.Error("Failed to close file storage backend", "name", name, "err", err)
+			errs = append(errs, fmt.Errorf("failed to close file storage backend %s: %w", name, err))
+		}
+	}
+
+	return joinErrors(errs...)
+}
+
+// joinedErrors combines several errors, errors.Is and errors.As match any of them. It stands in for errors.Join,
+// which is not available in the Go version the module targets.
+type joinedErrors struct {
+	errs []error
+}
+
+// joinErrors returns nil if all errors are nil, otherwise an error wrapping the non-nil ones. Its message lists
+// the message of every error on a separate line.
+func joinErrors(errs ...error) error {
+	nonNil := make([]error, 0, len(errs))
+	for _, err := range errs {
+		if err != nil {
+			nonNil = append(nonNil, err)
+		}
+	}
+
+	if len(nonNil) == 0 {
+		return nil
+	}
+	return &joinedErrors{errs: nonNil}
+}
+
+func (e *joinedErrors) Error() string {
+	messages := make([]string, 0, len(e.errs))
+	for _, err := range e.errs {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (e *joinedErrors) Is(target error) bool {
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *joinedErrors) As(target interface{}) bool {
+	for _, err := range e.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
 }
@@ -1,12 +1,67 @@
 package filestorage
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/featuremgmt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/blob/s3blob"
+	"gocloud.dev/gcerrors"
+	"google.golang.org/api/googleapi"
 )
 
+func newTestMemBackend(t *testing.T, supportedOperations []Operation, options *CdkBlobStorageOptions) FileStorage {
+	t.Helper()
+
+	bucket, err := blob.OpenBucket(context.Background(), "mem://")
+	require.NoError(t, err)
+
+	backend := NewCdkBlobStorage(log.New("testStorageLogger"), bucket, Delimiter, nil, supportedOperations, options)
+	t.Cleanup(func() {
+		_ = backend.close()
+	})
+	return backend
+}
+
+func newTestService(backendByName map[string]FileStorage) *service {
+	return &service{
+		log:           log.New("testFileStorageService"),
+		dummyBackend:  &dummyFileStorage{},
+		backendByName: backendByName,
+		resolver:      newBackendResolver(backendByName),
+		listeners:     newListenerDispatcher(log.New("testFileStorageService")),
+		operations:    newOperationTracker(),
+	}
+}
+
 func TestFilestorage_removeStoragePrefix(t *testing.T) {
 	var tests = []struct {
 		name     string
@@ -33,6 +88,36 @@ func TestFilestorage_removeStoragePrefix(t *testing.T) {
 			path:     "public/",
 			expected: Delimiter,
 		},
+		{
+			name:     "should return root path if path is the storage name followed by several delimiters",
+			path:     "public//",
+			expected: Delimiter,
+		},
+		{
+			name:     "should return root path if path is only delimiters",
+			path:     "//",
+			expected: Delimiter,
+		},
+		{
+			name:     "should drop the trailing delimiter",
+			path:     "public/abc/",
+			expected: "/abc",
+		},
+		{
+			name:     "should collapse a double delimiter after the storage name",
+			path:     "public//abc",
+			expected: "/abc",
+		},
+		{
+			name:     "should collapse double delimiters inside the path",
+			path:     "public/abc//d///e",
+			expected: "/abc/d/e",
+		},
+		{
+			name:     "should keep all parts of a deep path",
+			path:     "public/a/b/c/d/e/file.txt",
+			expected: "/a/b/c/d/e/file.txt",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(fmt.Sprintf("%s%s", "absolute: ", tt.name), func(t *testing.T) {
@@ -44,3 +129,3769 @@ func TestFilestorage_removeStoragePrefix(t *testing.T) {
 		})
 	}
 }
+
+func TestFilestorage_validatePath(t *testing.T) {
+	var tests = []struct {
+		name        string
+		path        string
+		expectedErr error
+		segment     string
+	}{
+		{
+			name: "should accept the root path",
+			path: Delimiter,
+		},
+		{
+			name: "should accept a nested path",
+			path: "/folder/sub-folder/file_1.txt",
+		},
+		{
+			name:        "should reject relative paths",
+			path:        "folder/file.txt",
+			expectedErr: ErrRelativePath,
+		},
+		{
+			name:        "should reject traversal to the parent folder",
+			path:        "/folder/../file.txt",
+			expectedErr: ErrNonCanonicalPath,
+			segment:     `".."`,
+		},
+		{
+			name:        "should reject traversal at the end of the path",
+			path:        "/folder/..",
+			expectedErr: ErrNonCanonicalPath,
+			segment:     `".."`,
+		},
+		{
+			name:        "should reject the current folder segment",
+			path:        "/folder/./file.txt",
+			expectedErr: ErrNonCanonicalPath,
+			segment:     `"."`,
+		},
+		{
+			name:        "should reject double slashes",
+			path:        "/folder//file.txt",
+			expectedErr: ErrNonCanonicalPath,
+			segment:     "empty segment",
+		},
+		{
+			name:        "should reject windows separators",
+			path:        `/folder\sub\file.txt`,
+			expectedErr: ErrPathInvalid,
+			segment:     `"folder\\sub\\file.txt"`,
+		},
+		{
+			name:        "should reject windows traversal",
+			path:        `/folder/..\file.txt`,
+			expectedErr: ErrPathInvalid,
+			segment:     "backslash",
+		},
+		{
+			name:        "should reject leading whitespace",
+			path:        "/folder/ file.txt",
+			expectedErr: ErrPathInvalid,
+			segment:     `" file.txt"`,
+		},
+		{
+			name:        "should reject trailing whitespace",
+			path:        "/folder /file.txt",
+			expectedErr: ErrPathInvalid,
+			segment:     `"folder "`,
+		},
+		{
+			name:        "should reject null bytes",
+			path:        "/folder/file\x00.txt",
+			expectedErr: ErrPathInvalid,
+			segment:     "null byte",
+		},
+		{
+			name:        "should reject a trailing delimiter",
+			path:        "/folder/",
+			expectedErr: ErrPathEndsWithDelimiter,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePath(tt.path)
+			if tt.expectedErr == nil {
+				require.NoError(t, err)
+				return
+			}
+
+			require.ErrorIs(t, err, tt.expectedErr)
+			require.Contains(t, err.Error(), tt.segment)
+		})
+	}
+}
+
+func TestFilestorage_normalizePath(t *testing.T) {
+	require.Equal(t, "/backend/folder/file.txt", normalizePath("//backend///folder//file.txt"))
+	require.Equal(t, Delimiter, normalizePath("//"))
+
+	ctx := context.Background()
+	contents := []byte("contents")
+	s := newTestService(map[string]FileStorage{
+		"first": newTestMemBackend(t, nil, nil),
+	})
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/first//folder///file.txt", Contents: &contents}))
+
+	file, err := s.Get(ctx, "/first/folder/file.txt")
+	require.NoError(t, err)
+	require.NotNil(t, file)
+	require.Equal(t, contents, file.Contents)
+}
+
+func TestFilestorage_getBackend(t *testing.T) {
+	data := newTestMemBackend(t, nil, nil)
+	database := newTestMemBackend(t, nil, nil)
+	s := newTestService(map[string]FileStorage{
+		"data":     data,
+		"database": database,
+	})
+
+	t.Run("should match the first segment of the path exactly", func(t *testing.T) {
+		// repeated to make sure the result does not depend on the map iteration order
+		for i := 0; i < 10; i++ {
+			backend, path, err := s.getBackend("/database/folder/file.txt")
+			require.NoError(t, err)
+			require.Same(t, database, backend)
+			require.Equal(t, "/folder/file.txt", path)
+
+			backend, path, err = s.getBackend("/data/folder/file.txt")
+			require.NoError(t, err)
+			require.Same(t, data, backend)
+			require.Equal(t, "/folder/file.txt", path)
+
+			backend, path, err = s.getBackend("/data")
+			require.NoError(t, err)
+			require.Same(t, data, backend)
+			require.Equal(t, Delimiter, path)
+		}
+	})
+
+	t.Run("should return the dummy backend for unknown paths", func(t *testing.T) {
+		for _, p := range []string{"/unknown/file.txt", "/datab/file.txt", "/databases/file.txt", "data/file.txt"} {
+			backend, path, err := s.getBackend(p)
+			require.NoError(t, err)
+			require.Same(t, s.dummyBackend, backend)
+			require.Equal(t, p, path)
+		}
+	})
+
+	t.Run("should fail for unknown paths in strict mode", func(t *testing.T) {
+		strict := *s
+		strict.strictBackendResolution = true
+
+		backend, _, err := strict.getBackend("/data/folder/file.txt")
+		require.NoError(t, err)
+		require.Same(t, data, backend)
+
+		for _, p := range []string{"/unknown/file.txt", "/datab/file.txt", "data/file.txt"} {
+			_, _, err := strict.getBackend(p)
+			require.ErrorIs(t, err, ErrBackendNotFound)
+		}
+	})
+}
+
+func TestFilestorage_StrictBackendResolution(t *testing.T) {
+	ctx := context.Background()
+	contents := []byte("contents")
+
+	t.Run("should silently ignore unknown paths in lenient mode", func(t *testing.T) {
+		s := newTestService(map[string]FileStorage{"mem": newTestMemBackend(t, nil, nil)})
+
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/unknown/file.txt", Contents: &contents}))
+		file, err := s.Get(ctx, "/unknown/file.txt")
+		require.ErrorIs(t, err, ErrFileNotFound)
+		require.Nil(t, file)
+		require.ErrorIs(t, s.Delete(ctx, "/unknown/file.txt"), ErrFileNotFound)
+	})
+
+	t.Run("should fail on unknown paths in strict mode", func(t *testing.T) {
+		fsConfig, err := newConfig(newTestCfg(t, "[file_storage]\nstrict_backend_resolution = true"))
+		require.NoError(t, err)
+		require.True(t, fsConfig.StrictBackendResolution)
+
+		s := newTestService(map[string]FileStorage{"mem": newTestMemBackend(t, nil, nil)})
+		s.strictBackendResolution = fsConfig.StrictBackendResolution
+
+		err = s.Upsert(ctx, &UpsertFileCommand{Path: "/unknown/file.txt", Contents: &contents})
+		require.ErrorIs(t, err, ErrBackendNotFound)
+
+		_, err = s.Get(ctx, "/unknown/file.txt")
+		require.ErrorIs(t, err, ErrBackendNotFound)
+
+		_, err = s.ListFiles(ctx, "/unknown", nil, nil)
+		require.ErrorIs(t, err, ErrBackendNotFound)
+
+		err = s.Copy(ctx, "/mem/file.txt", "/unknown/file.txt")
+		require.ErrorIs(t, err, ErrBackendNotFound)
+
+		_, errs := s.DeleteMany(ctx, []string{"/unknown/file.txt"})
+		require.ErrorIs(t, errs["/unknown/file.txt"], ErrBackendNotFound)
+
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/file.txt", Contents: &contents}))
+		file, err := s.Get(ctx, "/mem/file.txt")
+		require.NoError(t, err)
+		require.Equal(t, contents, file.Contents)
+	})
+}
+
+func TestFilestorage_ProtectedPaths(t *testing.T) {
+	ctx := context.Background()
+	contents := []byte("contents")
+
+	fsConfig, err := newConfig(newTestCfg(t, "[file_storage]\nprotected_paths = /mem/system/,/other"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"/mem/system/", "/other"}, fsConfig.ProtectedPaths)
+
+	s := newTestService(map[string]FileStorage{"mem": newTestMemBackend(t, nil, nil)})
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/system/config.json", Contents: &contents}))
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/file.txt", Contents: &contents}))
+	s.protectedPaths = newProtectedPaths(fsConfig.ProtectedPaths)
+
+	t.Run("should allow reads", func(t *testing.T) {
+		file, err := s.Get(ctx, "/mem/system/config.json")
+		require.NoError(t, err)
+		require.Equal(t, contents, file.Contents)
+
+		exists, err := s.Exists(ctx, "/mem/System/config.json")
+		require.NoError(t, err)
+		require.True(t, exists)
+
+		resp, err := s.ListFiles(ctx, "/mem/system", nil, nil)
+		require.NoError(t, err)
+		require.Len(t, resp.Files, 1)
+	})
+
+	t.Run("should reject writes", func(t *testing.T) {
+		for _, path := range []string{"/mem/system", "/mem/system/config.json", "/mem/SYSTEM/nested/file.txt", "/mem//system/file.txt"} {
+			require.ErrorIs(t, s.Upsert(ctx, &UpsertFileCommand{Path: path, Contents: &contents}), ErrPathProtected, path)
+			require.ErrorIs(t, s.Delete(ctx, path), ErrPathProtected, path)
+			require.ErrorIs(t, s.CreateFolder(ctx, path, nil), ErrPathProtected, path)
+			require.ErrorIs(t, s.DeleteFolder(ctx, path, nil), ErrPathProtected, path)
+		}
+
+		require.ErrorIs(t, s.Append(ctx, "/mem/system/config.json", contents), ErrPathProtected)
+		require.ErrorIs(t, s.Copy(ctx, "/mem/file.txt", "/mem/system/file.txt"), ErrPathProtected)
+		require.ErrorIs(t, s.Move(ctx, "/mem/system/config.json", "/mem/config.json"), ErrPathProtected)
+
+		_, errs := s.DeleteMany(ctx, []string{"/mem/system/config.json"})
+		require.ErrorIs(t, errs["/mem/system/config.json"], ErrPathProtected)
+
+		file, err := s.Get(ctx, "/mem/system/config.json")
+		require.NoError(t, err)
+		require.Equal(t, contents, file.Contents)
+	})
+
+	t.Run("should allow writes next to the protected paths", func(t *testing.T) {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/systems/file.txt", Contents: &contents}))
+		require.NoError(t, s.Copy(ctx, "/mem/system/config.json", "/mem/config.json"))
+		require.NoError(t, s.Delete(ctx, "/mem/file.txt"))
+	})
+
+	t.Run("should reject relative protected paths", func(t *testing.T) {
+		_, err := newConfig(newTestCfg(t, "[file_storage]\nprotected_paths = system"))
+		require.Error(t, err)
+	})
+}
+
+func BenchmarkFilestorage_getBackend(b *testing.B) {
+	backendByName := make(map[string]FileStorage)
+	for i := 0; i < 50; i++ {
+		backendByName[fmt.Sprintf("backend%d", i)] = &dummyFileStorage{}
+	}
+	s := newTestService(backendByName)
+	paths := []string{"/backend7/folder/file.txt", "/backend42/file.txt", "/backend0/a/b/c"}
+
+	b.Run("map scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			path := paths[i%len(paths)]
+			for backendName := range s.backendByName {
+				if belongsToStorage(path, StorageName(backendName)) {
+					break
+				}
+			}
+		}
+	})
+
+	b.Run("resolver", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			s.getBackend(paths[i%len(paths)])
+		}
+	})
+}
+
+func TestFilestorage_Copy(t *testing.T) {
+	ctx := context.Background()
+	contents := []byte("contents")
+
+	s := newTestService(map[string]FileStorage{
+		"first":  newTestMemBackend(t, nil, nil),
+		"second": newTestMemBackend(t, nil, nil),
+	})
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/first/folder/file.txt", Contents: &contents}))
+
+	t.Run("should copy a file within a backend", func(t *testing.T) {
+		require.NoError(t, s.Copy(ctx, "/first/folder/file.txt", "/first/other/file.txt"))
+
+		file, err := s.Get(ctx, "/first/other/file.txt")
+		require.NoError(t, err)
+		require.NotNil(t, file)
+		require.Equal(t, contents, file.Contents)
+	})
+
+	t.Run("should not copy a file across backends", func(t *testing.T) {
+		err := s.Copy(ctx, "/first/folder/file.txt", "/second/folder/file.txt")
+		require.ErrorIs(t, err, ErrCrossBackendOperation)
+
+		file, err := s.Get(ctx, "/second/folder/file.txt")
+		require.ErrorIs(t, err, ErrFileNotFound)
+		require.Nil(t, file)
+	})
+
+	t.Run("should not copy a file if the backend does not support it", func(t *testing.T) {
+		readOnly := newTestService(map[string]FileStorage{
+			"first": newTestMemBackend(t, []Operation{OperationGet}, nil),
+		})
+		require.Error(t, readOnly.Copy(ctx, "/first/folder/file.txt", "/first/other/file.txt"))
+	})
+}
+
+func TestFilestorage_Move(t *testing.T) {
+	ctx := context.Background()
+	contents := []byte("contents")
+
+	s := newTestService(map[string]FileStorage{
+		"first":  newTestMemBackend(t, nil, nil),
+		"second": newTestMemBackend(t, nil, nil),
+	})
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/first/folder/file.txt", Contents: &contents}))
+
+	err := s.Move(ctx, "/first/folder/file.txt", "/second/folder/file.txt")
+	require.ErrorIs(t, err, ErrCrossBackendOperation)
+
+	file, err := s.Get(ctx, "/first/folder/file.txt")
+	require.NoError(t, err)
+	require.NotNil(t, file)
+
+	require.NoError(t, s.Move(ctx, "/first/folder/file.txt", "/first/renamed/file.txt"))
+
+	file, err = s.Get(ctx, "/first/folder/file.txt")
+	require.ErrorIs(t, err, ErrFileNotFound)
+	require.Nil(t, file)
+
+	file, err = s.Get(ctx, "/first/renamed/file.txt")
+	require.NoError(t, err)
+	require.NotNil(t, file)
+	require.Equal(t, contents, file.Contents)
+}
+
+func TestFilestorage_MaxFileSize(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"limited":   newTestMemBackend(t, nil, &CdkBlobStorageOptions{MaxFileSize: 4}),
+		"unlimited": newTestMemBackend(t, nil, &CdkBlobStorageOptions{}),
+	})
+
+	tooLarge := []byte("12345")
+	err := s.Upsert(ctx, &UpsertFileCommand{Path: "/limited/folder/file.txt", Contents: &tooLarge})
+	require.ErrorIs(t, err, ErrFileTooLarge)
+
+	folders, err := s.ListFolders(ctx, "/limited", nil)
+	require.NoError(t, err)
+	require.Empty(t, folders)
+
+	withinLimit := []byte("1234")
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/limited/folder/file.txt", Contents: &withinLimit}))
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/unlimited/folder/file.txt", Contents: &tooLarge}))
+}
+
+func TestFilestorage_Timestamps(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"first": newTestMemBackend(t, nil, nil),
+	})
+
+	before := time.Now().Add(-time.Second)
+	contents := []byte("contents")
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/first/folder/file.txt", Contents: &contents}))
+
+	file, err := s.Get(ctx, "/first/folder/file.txt")
+	require.NoError(t, err)
+	require.True(t, file.Modified.After(before))
+
+	resp, err := s.ListFiles(ctx, "/first/folder", nil, nil)
+	require.NoError(t, err)
+	require.Len(t, resp.Files, 1)
+	require.Equal(t, file.Modified, resp.Files[0].Modified)
+	require.Equal(t, file.Created, resp.Files[0].Created)
+}
+
+func TestFilestorage_Touch(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"first": newTestMemBackend(t, nil, nil),
+	})
+
+	contents := []byte("contents")
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{
+		Path:       "/first/folder/file.txt",
+		Contents:   &contents,
+		Properties: map[string]string{"key": "value"},
+	}))
+
+	before, err := s.Get(ctx, "/first/folder/file.txt")
+	require.NoError(t, err)
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, s.Touch(ctx, "/first/folder/file.txt"))
+
+	after, err := s.Get(ctx, "/first/folder/file.txt")
+	require.NoError(t, err)
+	require.True(t, after.Modified.After(before.Modified))
+	require.Equal(t, before.Contents, after.Contents)
+	require.Equal(t, before.MimeType, after.MimeType)
+	require.Equal(t, before.Properties, after.Properties)
+	require.Equal(t, before.FullPath, after.FullPath)
+
+	require.ErrorIs(t, s.Touch(ctx, "/first/folder/missing.txt"), ErrFileNotFound)
+}
+
+func TestFilestorage_Open(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"plain":      newTestMemBackend(t, nil, nil),
+		"compressed": newTestMemBackend(t, nil, &CdkBlobStorageOptions{CompressContentTypes: []string{"application/json"}}),
+		"content":    newTestMemBackend(t, nil, &CdkBlobStorageOptions{ContentAddressable: true}),
+	})
+
+	contents := []byte(`{"title": "0123456789"}`)
+	for _, backend := range []string{"plain", "compressed", "content"} {
+		t.Run(backend, func(t *testing.T) {
+			path := "/" + backend + "/folder/file.json"
+			require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: path, Contents: &contents}))
+
+			metadata, reader, err := s.Open(ctx, path)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, reader.Close()) }()
+			require.Equal(t, "/folder/file.json", metadata.FullPath)
+
+			readRange := func(t *testing.T, offset int64, whence int, length int) string {
+				t.Helper()
+
+				_, err := reader.Seek(offset, whence)
+				require.NoError(t, err)
+
+				part := make([]byte, length)
+				_, err = io.ReadFull(reader, part)
+				require.NoError(t, err)
+				return string(part)
+			}
+
+			require.Equal(t, "0123", readRange(t, 11, io.SeekStart, 4))
+			require.Equal(t, "456", readRange(t, 0, io.SeekCurrent, 3))
+			require.Equal(t, "89", readRange(t, 1, io.SeekCurrent, 2))
+			require.Equal(t, `"}`, readRange(t, -2, io.SeekEnd, 2))
+			require.Equal(t, `{"title"`, readRange(t, 0, io.SeekStart, 8))
+
+			_, err = reader.Read(make([]byte, 1))
+			require.NoError(t, err)
+			rest, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			require.Equal(t, contents[9:], rest)
+
+			_, err = reader.Seek(-1, io.SeekStart)
+			require.Error(t, err)
+		})
+	}
+
+	_, _, err := s.Open(ctx, "/plain/folder/missing.json")
+	require.ErrorIs(t, err, ErrFileNotFound)
+}
+
+func TestFilestorage_ListFilesWithMaxResults(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+
+	contents := []byte("contents")
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"} {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/folder/" + name, Contents: &contents}))
+	}
+
+	options := &ListOptions{MaxResults: 2}
+	paging := &Paging{First: 10}
+	pages := make([][]string, 0)
+	for {
+		resp, err := s.ListFiles(ctx, "/mem/folder", paging, options)
+		require.NoError(t, err)
+
+		names := make([]string, 0, len(resp.Files))
+		for _, file := range resp.Files {
+			names = append(names, file.Name)
+		}
+		pages = append(pages, names)
+
+		if !resp.HasMore {
+			break
+		}
+		paging = &Paging{First: 10, After: resp.LastPath}
+	}
+
+	require.Equal(t, [][]string{{"a.txt", "b.txt"}, {"c.txt", "d.txt"}, {"e.txt"}}, pages)
+
+	t.Run("should use the paging size if it is smaller than the cap", func(t *testing.T) {
+		resp, err := s.ListFiles(ctx, "/mem/folder", &Paging{First: 1}, &ListOptions{MaxResults: 3})
+		require.NoError(t, err)
+		require.Len(t, resp.Files, 1)
+		require.True(t, resp.HasMore)
+	})
+
+	t.Run("should use the paging size if the cap is not set", func(t *testing.T) {
+		resp, err := s.ListFiles(ctx, "/mem/folder", &Paging{First: 10}, &ListOptions{})
+		require.NoError(t, err)
+		require.Len(t, resp.Files, 5)
+		require.False(t, resp.HasMore)
+	})
+}
+
+func TestFilestorage_ListFilesRecursively(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+
+	contents := []byte("contents")
+	for _, filePath := range []string{
+		"/mem/root/a.txt",
+		"/mem/root/Level1/B.txt",
+		"/mem/root/Level1/level2/c.txt",
+		"/mem/root/Level1/level2/level3/d.txt",
+		"/mem/root/Level1/level2/level3/e.txt",
+		"/mem/root/z.txt",
+		"/mem/other/file.txt",
+	} {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: filePath, Contents: &contents}))
+	}
+
+	listAll := func(t *testing.T, pageSize int, options *ListOptions) ([]string, int) {
+		t.Helper()
+
+		paths := make([]string, 0)
+		pages := 0
+		paging := &Paging{First: pageSize}
+		for {
+			resp, err := s.ListFiles(ctx, "/mem/root", paging, options)
+			require.NoError(t, err)
+			pages++
+
+			for _, file := range resp.Files {
+				paths = append(paths, file.FullPath)
+			}
+
+			if !resp.HasMore {
+				return paths, pages
+			}
+			paging = &Paging{First: pageSize, After: resp.LastPath}
+		}
+	}
+
+	t.Run("should list files of all nested folders", func(t *testing.T) {
+		paths, pages := listAll(t, 100, &ListOptions{Recursive: true})
+		require.Equal(t, []string{
+			"/root/a.txt",
+			"/root/Level1/B.txt",
+			"/root/Level1/level2/c.txt",
+			"/root/Level1/level2/level3/d.txt",
+			"/root/Level1/level2/level3/e.txt",
+			"/root/z.txt",
+		}, paths)
+		require.Equal(t, 1, pages)
+	})
+
+	t.Run("should resume the walk from the cursor", func(t *testing.T) {
+		for _, pageSize := range []int{1, 2, 3, 4} {
+			paths, pages := listAll(t, pageSize, &ListOptions{Recursive: true})
+			require.Equal(t, []string{
+				"/root/a.txt",
+				"/root/Level1/B.txt",
+				"/root/Level1/level2/c.txt",
+				"/root/Level1/level2/level3/d.txt",
+				"/root/Level1/level2/level3/e.txt",
+				"/root/z.txt",
+			}, paths, "page size %d", pageSize)
+			require.LessOrEqual(t, pages, 6/pageSize+1, "page size %d", pageSize)
+		}
+	})
+
+	t.Run("should honor path filters", func(t *testing.T) {
+		paths, _ := listAll(t, 2, &ListOptions{
+			Recursive:   true,
+			PathFilters: *NewPathFilters(nil, []string{"/root/level1/level2/"}),
+		})
+		require.Equal(t, []string{"/root/a.txt", "/root/Level1/B.txt", "/root/z.txt"}, paths)
+	})
+
+	t.Run("should only list the folder itself if not recursive", func(t *testing.T) {
+		paths, _ := listAll(t, 100, &ListOptions{Recursive: false})
+		require.Equal(t, []string{"/root/a.txt", "/root/z.txt"}, paths)
+	})
+}
+
+func TestFilestorage_Trash(t *testing.T) {
+	ctx := context.Background()
+	newService := func(t *testing.T) *service {
+		return newTestService(map[string]FileStorage{
+			"mem": newTestMemBackend(t, nil, &CdkBlobStorageOptions{TrashPrefix: ".trash"}),
+		})
+	}
+
+	t.Run("should restore a deleted file", func(t *testing.T) {
+		s := newService(t)
+		contents := []byte("first")
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/folder/File.txt", Contents: &contents}))
+		require.NoError(t, s.Delete(ctx, "/mem/folder/File.txt"))
+
+		file, err := s.Get(ctx, "/mem/folder/File.txt")
+		require.ErrorIs(t, err, ErrFileNotFound)
+		require.Nil(t, file)
+
+		resp, err := s.ListFiles(ctx, "/mem", nil, &ListOptions{Recursive: true})
+		require.NoError(t, err)
+		require.Empty(t, resp.Files)
+
+		folders, err := s.ListFolders(ctx, "/mem", nil)
+		require.NoError(t, err)
+		require.Len(t, folders, 1)
+		require.Equal(t, "/folder", folders[0].FullPath)
+
+		require.NoError(t, s.Restore(ctx, "/mem/folder/file.txt"))
+
+		file, err = s.Get(ctx, "/mem/folder/File.txt")
+		require.NoError(t, err)
+		require.Equal(t, contents, file.Contents)
+		require.Equal(t, "/folder/File.txt", file.FullPath)
+
+		err = s.Restore(ctx, "/mem/folder/file.txt")
+		require.ErrorIs(t, err, ErrFileNotFound)
+	})
+
+	t.Run("should restore the most recently deleted version", func(t *testing.T) {
+		s := newService(t)
+		for _, version := range []string{"first", "second"} {
+			contents := []byte(version)
+			require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/file.txt", Contents: &contents}))
+			require.NoError(t, s.Delete(ctx, "/mem/file.txt"))
+		}
+
+		require.NoError(t, s.Restore(ctx, "/mem/file.txt"))
+		file, err := s.Get(ctx, "/mem/file.txt")
+		require.NoError(t, err)
+		require.Equal(t, []byte("second"), file.Contents)
+	})
+
+	t.Run("should restore a deleted folder", func(t *testing.T) {
+		s := newService(t)
+		contents := []byte("contents")
+		require.NoError(t, s.CreateFolder(ctx, "/mem/folder/nested", nil))
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/folder/a.txt", Contents: &contents}))
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/folder/nested/b.txt", Contents: &contents}))
+
+		require.NoError(t, s.DeleteFolder(ctx, "/mem/folder", &DeleteFolderOptions{Force: true}))
+		resp, err := s.ListFiles(ctx, "/mem", nil, &ListOptions{Recursive: true})
+		require.NoError(t, err)
+		require.Empty(t, resp.Files)
+
+		require.NoError(t, s.Restore(ctx, "/mem/folder"))
+		resp, err = s.ListFiles(ctx, "/mem", nil, &ListOptions{Recursive: true})
+		require.NoError(t, err)
+		require.Len(t, resp.Files, 2)
+		require.Equal(t, "/folder/a.txt", resp.Files[0].FullPath)
+		require.Equal(t, "/folder/nested/b.txt", resp.Files[1].FullPath)
+	})
+
+	t.Run("should permanently delete trashed files", func(t *testing.T) {
+		s := newService(t)
+		contents := []byte("contents")
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/a.txt", Contents: &contents}))
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/b.txt", Contents: &contents}))
+		require.NoError(t, s.Delete(ctx, "/mem/a.txt"))
+		require.NoError(t, s.Delete(ctx, "/mem/b.txt"))
+
+		require.NoError(t, s.PurgeTrash(ctx, "/mem/a.txt"))
+		require.ErrorIs(t, s.Restore(ctx, "/mem/a.txt"), ErrFileNotFound)
+
+		require.NoError(t, s.PurgeTrash(ctx, "/mem"))
+		require.ErrorIs(t, s.Restore(ctx, "/mem/b.txt"), ErrFileNotFound)
+	})
+
+	t.Run("should not be able to access the trash directly", func(t *testing.T) {
+		s := newService(t)
+		contents := []byte("contents")
+		err := s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/.trash/file.txt", Contents: &contents})
+		require.ErrorIs(t, err, ErrPathNotAllowed)
+
+		file, err := s.Get(ctx, "/mem/.trash/file.txt")
+		require.ErrorIs(t, err, ErrFileNotFound)
+		require.Nil(t, file)
+	})
+
+	t.Run("should delete files permanently if the trash is not enabled", func(t *testing.T) {
+		s := newTestService(map[string]FileStorage{
+			"mem": newTestMemBackend(t, nil, nil),
+		})
+		contents := []byte("contents")
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/file.txt", Contents: &contents}))
+		require.NoError(t, s.Delete(ctx, "/mem/file.txt"))
+
+		require.ErrorIs(t, s.Restore(ctx, "/mem/file.txt"), ErrTrashNotEnabled)
+	})
+}
+
+func TestFilestorage_Versions(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"versioned": newTestMemBackend(t, nil, &CdkBlobStorageOptions{Versioned: true}),
+		"plain":     newTestMemBackend(t, nil, nil),
+	})
+
+	for _, version := range []string{"first", "second", "third"} {
+		contents := []byte(version)
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/versioned/folder/Dashboard.json", Contents: &contents}))
+	}
+
+	file, err := s.Get(ctx, "/versioned/folder/Dashboard.json")
+	require.NoError(t, err)
+	require.Equal(t, []byte("third"), file.Contents)
+
+	versions, err := s.ListVersions(ctx, "/versioned/folder/dashboard.json")
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	for _, version := range versions {
+		require.Equal(t, "/folder/Dashboard.json", version.FullPath)
+		require.Equal(t, "Dashboard.json", version.Name)
+		require.NotEmpty(t, version.Version)
+	}
+
+	latest, err := s.GetVersion(ctx, "/versioned/folder/Dashboard.json", versions[0].Version)
+	require.NoError(t, err)
+	require.Equal(t, []byte("third"), latest.Contents)
+
+	oldest, err := s.GetVersion(ctx, "/versioned/folder/Dashboard.json", versions[2].Version)
+	require.NoError(t, err)
+	require.Equal(t, []byte("first"), oldest.Contents)
+	require.Equal(t, "/folder/Dashboard.json", oldest.FullPath)
+	require.Equal(t, versions[2].Version, oldest.Version)
+
+	_, err = s.GetVersion(ctx, "/versioned/folder/Dashboard.json", "unknown")
+	require.ErrorIs(t, err, ErrFileNotFound)
+
+	t.Run("should not list stored versions as files", func(t *testing.T) {
+		resp, err := s.ListFiles(ctx, "/versioned", nil, &ListOptions{Recursive: true})
+		require.NoError(t, err)
+		require.Len(t, resp.Files, 1)
+		require.Equal(t, "/folder/Dashboard.json", resp.Files[0].FullPath)
+	})
+
+	t.Run("should fail for backends without versioning", func(t *testing.T) {
+		contents := []byte("contents")
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/plain/file.txt", Contents: &contents}))
+
+		_, err := s.ListVersions(ctx, "/plain/file.txt")
+		require.ErrorIs(t, err, ErrVersioningNotEnabled)
+
+		_, err = s.GetVersion(ctx, "/plain/file.txt", "20220301100000.000000000")
+		require.ErrorIs(t, err, ErrVersioningNotEnabled)
+	})
+}
+
+func TestFilestorage_MaxTotalSize(t *testing.T) {
+	ctx := context.Background()
+	bucket, err := blob.OpenBucket(ctx, "mem://")
+	require.NoError(t, err)
+
+	existing := []byte("1234")
+	unlimited := NewCdkBlobStorage(log.New("testStorageLogger"), bucket, Delimiter, nil, nil, nil)
+	require.NoError(t, unlimited.Upsert(ctx, &UpsertFileCommand{Path: "/existing.txt", Contents: &existing}))
+
+	// shares the bucket, so the existing file counts towards the quota
+	s := newTestService(map[string]FileStorage{
+		"limited": NewCdkBlobStorage(log.New("testStorageLogger"), bucket, Delimiter, nil, nil, &CdkBlobStorageOptions{MaxTotalSize: 10}),
+	})
+	t.Cleanup(func() {
+		_ = bucket.Close()
+	})
+
+	contents := []byte("12345")
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/limited/folder/first.txt", Contents: &contents}))
+
+	err = s.Upsert(ctx, &UpsertFileCommand{Path: "/limited/folder/second.txt", Contents: &contents})
+	require.ErrorIs(t, err, ErrQuotaExceeded)
+
+	file, err := s.Get(ctx, "/limited/folder/second.txt")
+	require.ErrorIs(t, err, ErrFileNotFound)
+	require.Nil(t, file)
+
+	t.Run("should allow replacing a file with one of the same size", func(t *testing.T) {
+		replacement := []byte("54321")
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/limited/folder/first.txt", Contents: &replacement}))
+	})
+
+	t.Run("should free the quota of deleted files", func(t *testing.T) {
+		require.NoError(t, s.Delete(ctx, "/limited/folder/first.txt"))
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/limited/folder/second.txt", Contents: &contents}))
+	})
+
+	t.Run("should compute the total size again after deleting folders", func(t *testing.T) {
+		require.NoError(t, s.DeleteFolder(ctx, "/limited/folder", &DeleteFolderOptions{Force: true}))
+
+		large := []byte("123456")
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/limited/other/large.txt", Contents: &large}))
+	})
+}
+
+func TestFilestorage_MaxTotalSizeCopy(t *testing.T) {
+	ctx := context.Background()
+	contents := []byte("123456")
+
+	t.Run("should fail to copy a file over the quota", func(t *testing.T) {
+		s := newTestMemBackend(t, nil, &CdkBlobStorageOptions{MaxTotalSize: 10})
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/file.txt", Contents: &contents}))
+
+		require.ErrorIs(t, s.Copy(ctx, "/file.txt", "/copy.txt"), ErrQuotaExceeded)
+
+		_, err := s.Get(ctx, "/copy.txt")
+		require.ErrorIs(t, err, ErrFileNotFound)
+	})
+
+	t.Run("should allow moving a file within the quota", func(t *testing.T) {
+		s := newTestMemBackend(t, nil, &CdkBlobStorageOptions{MaxTotalSize: 10})
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/file.txt", Contents: &contents}))
+
+		require.NoError(t, s.Move(ctx, "/file.txt", "/moved.txt"))
+
+		small := []byte("1234")
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/small.txt", Contents: &small}))
+	})
+
+	t.Run("should fail to restore a file over the quota", func(t *testing.T) {
+		s := newTestMemBackend(t, nil, &CdkBlobStorageOptions{MaxTotalSize: 10, TrashPrefix: ".trash"})
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/file.txt", Contents: &contents}))
+		require.NoError(t, s.Delete(ctx, "/file.txt"))
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/other.txt", Contents: &contents}))
+
+		require.ErrorIs(t, s.Restore(ctx, "/file.txt"), ErrQuotaExceeded)
+
+		_, err := s.Get(ctx, "/file.txt")
+		require.ErrorIs(t, err, ErrFileNotFound)
+	})
+}
+
+func TestFilestorage_SignedURL(t *testing.T) {
+	ctx := context.Background()
+
+	// presigning S3 URLs happens locally, no requests are sent
+	sess, err := session.NewSession(aws.NewConfig().
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials("access-key", "secret-key", "")))
+	require.NoError(t, err)
+	s3Bucket, err := s3blob.OpenBucket(ctx, sess, "grafana-resources", nil)
+	require.NoError(t, err)
+	fsBucket, err := blob.OpenBucket(ctx, "file://"+t.TempDir())
+	require.NoError(t, err)
+
+	s := newTestService(map[string]FileStorage{
+		"s3":  NewCdkBlobStorage(log.New("testStorageLogger"), s3Bucket, "", nil, nil, nil),
+		"fs":  NewCdkBlobStorage(log.New("testStorageLogger"), fsBucket, "", nil, nil, nil),
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+	t.Cleanup(func() {
+		_ = s3Bucket.Close()
+		_ = fsBucket.Close()
+	})
+
+	t.Run("should sign urls of s3 backends", func(t *testing.T) {
+		url, err := s.SignedURL(ctx, "/s3/images/Logo.png", time.Minute)
+		require.NoError(t, err)
+		require.Contains(t, url, "grafana-resources")
+		require.Contains(t, url, "images/logo.png")
+		require.Contains(t, url, "X-Amz-Expires=60")
+	})
+
+	t.Run("should fail for backends which can not sign urls", func(t *testing.T) {
+		_, err := s.SignedURL(ctx, "/mem/images/logo.png", time.Minute)
+		require.ErrorIs(t, err, ErrSignedURLUnsupported)
+
+		_, err = s.SignedURL(ctx, "/fs/images/logo.png", time.Minute)
+		require.ErrorIs(t, err, ErrSignedURLUnsupported)
+	})
+
+	t.Run("should fail for invalid ttls", func(t *testing.T) {
+		_, err := s.SignedURL(ctx, "/s3/images/logo.png", 0)
+		require.Error(t, err)
+	})
+}
+
+func TestFilestorage_SignedUploadURL(t *testing.T) {
+	ctx := context.Background()
+
+	// presigning S3 URLs happens locally, no requests are sent
+	sess, err := session.NewSession(aws.NewConfig().
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials("access-key", "secret-key", "")))
+	require.NoError(t, err)
+	s3Bucket, err := s3blob.OpenBucket(ctx, sess, "grafana-resources", nil)
+	require.NoError(t, err)
+	fsBucket, err := blob.OpenBucket(ctx, "file://"+t.TempDir())
+	require.NoError(t, err)
+
+	s := newTestService(map[string]FileStorage{
+		"s3":       NewCdkBlobStorage(log.New("testStorageLogger"), s3Bucket, "", NewPathFilters([]string{"/uploads/"}, nil), nil, nil),
+		"limited":  NewCdkBlobStorage(log.New("testStorageLogger"), s3Bucket, "", nil, nil, &CdkBlobStorageOptions{MaxTotalSize: 1024}),
+		"readonly": NewCdkBlobStorage(log.New("testStorageLogger"), s3Bucket, "", nil, readOperations, nil),
+		"fs":       NewCdkBlobStorage(log.New("testStorageLogger"), fsBucket, "", nil, nil, nil),
+		"mem":      newTestMemBackend(t, nil, nil),
+	})
+	t.Cleanup(func() {
+		_ = s3Bucket.Close()
+		_ = fsBucket.Close()
+	})
+
+	t.Run("should sign upload urls of s3 backends", func(t *testing.T) {
+		url, err := s.SignedUploadURL(ctx, "/s3/uploads/Logo.png", time.Minute, "image/png")
+		require.NoError(t, err)
+		require.Contains(t, url, "grafana-resources")
+		require.Contains(t, url, "uploads/logo.png")
+		require.Contains(t, url, "X-Amz-Expires=60")
+		require.Contains(t, url, "content-type")
+	})
+
+	t.Run("should enforce the path filters", func(t *testing.T) {
+		_, err := s.SignedUploadURL(ctx, "/s3/images/logo.png", time.Minute, "image/png")
+		require.ErrorIs(t, err, ErrPathNotAllowed)
+	})
+
+	t.Run("should fail for backends which can not sign upload urls", func(t *testing.T) {
+		_, err := s.SignedUploadURL(ctx, "/mem/uploads/logo.png", time.Minute, "")
+		require.ErrorIs(t, err, ErrSignedURLUnsupported)
+
+		_, err = s.SignedUploadURL(ctx, "/fs/uploads/logo.png", time.Minute, "")
+		require.ErrorIs(t, err, ErrSignedURLUnsupported)
+
+		_, err = s.SignedUploadURL(ctx, "/limited/uploads/logo.png", time.Minute, "")
+		require.ErrorIs(t, err, ErrSignedURLUnsupported)
+	})
+
+	t.Run("should fail for backends which can not be written to", func(t *testing.T) {
+		_, err := s.SignedUploadURL(ctx, "/readonly/uploads/logo.png", time.Minute, "")
+		require.ErrorIs(t, err, ErrOperationNotSupported)
+	})
+
+	t.Run("should fail for invalid ttls", func(t *testing.T) {
+		_, err := s.SignedUploadURL(ctx, "/s3/uploads/logo.png", 0, "")
+		require.Error(t, err)
+	})
+}
+
+func TestFilestorage_UpsertStream(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem":       newTestMemBackend(t, nil, nil),
+		"limited":   newTestMemBackend(t, nil, &CdkBlobStorageOptions{MaxFileSize: 1024}),
+		"quota":     newTestMemBackend(t, nil, &CdkBlobStorageOptions{MaxTotalSize: 1024}),
+		"versioned": newTestMemBackend(t, nil, &CdkBlobStorageOptions{Versioned: true}),
+	})
+
+	// 5MB which do not repeat with a short period
+	payload := make([]byte, 5*1024*1024)
+	for i := range payload {
+		payload[i] = byte(i * 31 / 7)
+	}
+
+	t.Run("should stream a large file and read it back", func(t *testing.T) {
+		err := s.UpsertStream(ctx, "/mem/folder/large.bin", bytes.NewReader(payload), &UpsertFileCommand{
+			MimeType:   "application/octet-stream",
+			Properties: map[string]string{"owner": "admin"},
+		})
+		require.NoError(t, err)
+
+		file, err := s.Get(ctx, "/mem/folder/large.bin")
+		require.NoError(t, err)
+		require.True(t, bytes.Equal(payload, file.Contents))
+		require.Equal(t, int64(len(payload)), file.Size)
+		require.Equal(t, "application/octet-stream", file.MimeType)
+		require.Equal(t, map[string]string{"owner": "admin"}, file.Properties)
+
+		folders, err := s.ListFolders(ctx, "/mem", nil)
+		require.NoError(t, err)
+		require.Len(t, folders, 1)
+		require.Equal(t, "/folder", folders[0].FullPath)
+	})
+
+	t.Run("should keep the content type and properties of an overwritten file", func(t *testing.T) {
+		err := s.UpsertStream(ctx, "/mem/folder/large.bin", strings.NewReader("smaller"), nil)
+		require.NoError(t, err)
+
+		file, err := s.Get(ctx, "/mem/folder/large.bin")
+		require.NoError(t, err)
+		require.Equal(t, []byte("smaller"), file.Contents)
+		require.Equal(t, "application/octet-stream", file.MimeType)
+		require.Equal(t, map[string]string{"owner": "admin"}, file.Properties)
+	})
+
+	t.Run("should detect the content type of a new file", func(t *testing.T) {
+		require.NoError(t, s.UpsertStream(ctx, "/mem/dashboard.json", strings.NewReader("{}"), nil))
+		metadata, err := s.GetMetadata(ctx, "/mem/dashboard.json")
+		require.NoError(t, err)
+		require.Equal(t, "application/json", metadata.MimeType)
+
+		require.NoError(t, s.UpsertStream(ctx, "/mem/page", strings.NewReader("<html><body></body></html>"), nil))
+		metadata, err = s.GetMetadata(ctx, "/mem/page")
+		require.NoError(t, err)
+		require.Equal(t, "text/html; charset=utf-8", metadata.MimeType)
+	})
+
+	t.Run("should check the preconditions", func(t *testing.T) {
+		err := s.UpsertStream(ctx, "/mem/dashboard.json", strings.NewReader("{}"), &UpsertFileCommand{CreateOnly: true})
+		require.ErrorIs(t, err, ErrAlreadyExists)
+
+		err = s.UpsertStream(ctx, "/mem/dashboard.json", strings.NewReader("{}"), &UpsertFileCommand{IfMatchETag: "outdated"})
+		require.ErrorIs(t, err, ErrPreconditionFailed)
+
+		metadata, err := s.GetMetadata(ctx, "/mem/dashboard.json")
+		require.NoError(t, err)
+		err = s.UpsertStream(ctx, "/mem/dashboard.json", strings.NewReader(`{"a":1}`), &UpsertFileCommand{IfMatchETag: metadata.ETag})
+		require.NoError(t, err)
+	})
+
+	t.Run("should not write files above the size limits", func(t *testing.T) {
+		err := s.UpsertStream(ctx, "/limited/large.bin", bytes.NewReader(payload), nil)
+		require.ErrorIs(t, err, ErrFileTooLarge)
+		exists, err := s.Exists(ctx, "/limited/large.bin")
+		require.NoError(t, err)
+		require.False(t, exists)
+
+		err = s.UpsertStream(ctx, "/quota/large.bin", bytes.NewReader(payload), nil)
+		require.ErrorIs(t, err, ErrQuotaExceeded)
+		exists, err = s.Exists(ctx, "/quota/large.bin")
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+
+	t.Run("should store a version of a streamed file", func(t *testing.T) {
+		require.NoError(t, s.UpsertStream(ctx, "/versioned/large.bin", bytes.NewReader(payload), nil))
+
+		versions, err := s.ListVersions(ctx, "/versioned/large.bin")
+		require.NoError(t, err)
+		require.Len(t, versions, 1)
+
+		version, err := s.GetVersion(ctx, "/versioned/large.bin", versions[0].Version)
+		require.NoError(t, err)
+		require.True(t, bytes.Equal(payload, version.Contents))
+	})
+}
+
+func TestFilestorage_UpsertIfMatchETag(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+
+	first := []byte("first")
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/dashboard.json", Contents: &first}))
+	file, err := s.Get(ctx, "/mem/dashboard.json")
+	require.NoError(t, err)
+	staleETag := file.ETag
+
+	second := []byte("second")
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/dashboard.json", Contents: &second, IfMatchETag: staleETag}))
+
+	t.Run("should reject a stale ETag", func(t *testing.T) {
+		third := []byte("third")
+		err := s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/dashboard.json", Contents: &third, IfMatchETag: staleETag})
+		require.ErrorIs(t, err, ErrPreconditionFailed)
+
+		file, err := s.Get(ctx, "/mem/dashboard.json")
+		require.NoError(t, err)
+		require.Equal(t, second, file.Contents)
+	})
+
+	t.Run("should reject an ETag for a missing file", func(t *testing.T) {
+		err := s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/missing.json", Contents: &second, IfMatchETag: staleETag})
+		require.ErrorIs(t, err, ErrPreconditionFailed)
+	})
+
+	t.Run("should let only one of concurrent writers with the same ETag succeed", func(t *testing.T) {
+		file, err := s.Get(ctx, "/mem/dashboard.json")
+		require.NoError(t, err)
+
+		errs := make(chan error, 5)
+		for i := 0; i < 5; i++ {
+			contents := []byte(fmt.Sprintf("writer %d", i))
+			go func() {
+				errs <- s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/dashboard.json", Contents: &contents, IfMatchETag: file.ETag})
+			}()
+		}
+
+		succeeded := 0
+		for i := 0; i < 5; i++ {
+			if err := <-errs; err == nil {
+				succeeded++
+			} else {
+				require.ErrorIs(t, err, ErrPreconditionFailed)
+			}
+		}
+		require.Equal(t, 1, succeeded)
+	})
+}
+
+func TestFilestorage_UpsertCreateOnly(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+
+	first := []byte("first")
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/provisioned.json", Contents: &first, CreateOnly: true}))
+
+	t.Run("should not overwrite an existing file", func(t *testing.T) {
+		second := []byte("second")
+		err := s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/Provisioned.json", Contents: &second, CreateOnly: true})
+		require.ErrorIs(t, err, ErrAlreadyExists)
+
+		file, err := s.Get(ctx, "/mem/provisioned.json")
+		require.NoError(t, err)
+		require.Equal(t, first, file.Contents)
+	})
+
+	t.Run("should let only one of concurrent writers create the file", func(t *testing.T) {
+		errs := make(chan error, 5)
+		for i := 0; i < 5; i++ {
+			contents := []byte(fmt.Sprintf("writer %d", i))
+			go func() {
+				errs <- s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/concurrent.json", Contents: &contents, CreateOnly: true})
+			}()
+		}
+
+		succeeded := 0
+		for i := 0; i < 5; i++ {
+			if err := <-errs; err == nil {
+				succeeded++
+			} else {
+				require.ErrorIs(t, err, ErrAlreadyExists)
+			}
+		}
+		require.Equal(t, 1, succeeded)
+	})
+}
+
+func TestFilestorage_Properties(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+
+	contents := []byte("{}")
+	properties := map[string]string{"owner": "team-a", "source-dashboard-uid": "UDdpyzz7z"}
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/folder/panel.json", Contents: &contents, Properties: properties}))
+
+	t.Run("should return the properties of the file", func(t *testing.T) {
+		file, err := s.Get(ctx, "/mem/folder/panel.json")
+		require.NoError(t, err)
+		require.Equal(t, properties, file.Properties)
+
+		metadata, err := s.GetMetadata(ctx, "/mem/folder/panel.json")
+		require.NoError(t, err)
+		require.Equal(t, properties, metadata.Properties)
+
+		resp, err := s.ListFiles(ctx, "/mem/folder", nil, nil)
+		require.NoError(t, err)
+		require.Len(t, resp.Files, 1)
+		require.Equal(t, properties, resp.Files[0].Properties)
+	})
+
+	t.Run("should reject invalid properties", func(t *testing.T) {
+		for _, invalid := range []map[string]string{
+			{"Owner": "team-a"},
+			{"source dashboard": "UDdpyzz7z"},
+			{"__gf_original_path__": "/elsewhere.json"},
+			{"owner": strings.Repeat("a", maxPropertiesSize)},
+		} {
+			err := s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/folder/panel.json", Contents: &contents, Properties: invalid})
+			require.ErrorIs(t, err, ErrInvalidProperties)
+		}
+
+		file, err := s.Get(ctx, "/mem/folder/panel.json")
+		require.NoError(t, err)
+		require.Equal(t, properties, file.Properties)
+	})
+}
+
+func TestFilestorage_GetReader(t *testing.T) {
+	ctx := context.Background()
+	contents := []byte(`{"title": "dashboard"}`)
+
+	s := newTestService(map[string]FileStorage{
+		"first": newTestMemBackend(t, nil, nil),
+	})
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/first/folder/Dashboard.json", Contents: &contents}))
+
+	reader, meta, err := s.GetReader(ctx, "/first/folder/Dashboard.json")
+	require.NoError(t, err)
+	require.NotNil(t, reader)
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	require.Equal(t, "/folder/Dashboard.json", meta.FullPath)
+	require.Equal(t, int64(len(contents)), meta.Size)
+
+	read, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, contents, read)
+
+	missing, meta, err := s.GetReader(ctx, "/first/folder/missing.json")
+	require.ErrorIs(t, err, ErrFileNotFound)
+	require.Nil(t, missing)
+	require.Nil(t, meta)
+}
+
+// attributesOnlyBucket serves attributes of fixed keys and fails any attempt to read contents.
+type attributesOnlyBucket struct {
+	driver.Bucket
+	attributes map[string]*driver.Attributes
+	reads      int
+}
+
+var errAttributesOnlyBucketNotFound = errors.New("not found")
+
+func (b *attributesOnlyBucket) ErrorCode(err error) gcerrors.ErrorCode {
+	if errors.Is(err, errAttributesOnlyBucketNotFound) {
+		return gcerrors.NotFound
+	}
+	return gcerrors.Unknown
+}
+
+func (b *attributesOnlyBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	attributes, ok := b.attributes[key]
+	if !ok {
+		return nil, errAttributesOnlyBucketNotFound
+	}
+	return attributes, nil
+}
+
+func (b *attributesOnlyBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	b.reads++
+	return nil, errors.New("contents should not be read")
+}
+
+func (b *attributesOnlyBucket) Close() error {
+	return nil
+}
+
+func TestFilestorage_GetMetadata(t *testing.T) {
+	ctx := context.Background()
+	modified := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	bucket := &attributesOnlyBucket{
+		attributes: map[string]*driver.Attributes{
+			"/folder/dashboard.json": {
+				ContentType: "application/json",
+				Metadata:    map[string]string{originalPathAttributeKey: "/folder/Dashboard.json", "author": "admin"},
+				ModTime:     modified,
+				Size:        128,
+				MD5:         []byte{0xca, 0xfe},
+			},
+		},
+	}
+
+	s := newTestService(map[string]FileStorage{
+		"first": NewCdkBlobStorage(log.New("testStorageLogger"), blob.NewBucket(bucket), Delimiter, nil, nil, nil),
+	})
+
+	meta, err := s.GetMetadata(ctx, "/first/folder/Dashboard.json")
+	require.NoError(t, err)
+	require.Equal(t, "Dashboard.json", meta.Name)
+	require.Equal(t, "/folder/Dashboard.json", meta.FullPath)
+	require.Equal(t, "application/json", meta.MimeType)
+	require.Equal(t, int64(128), meta.Size)
+	require.Equal(t, modified, meta.Modified)
+	require.Equal(t, "cafe", meta.ETag)
+	require.Equal(t, map[string]string{"author": "admin"}, meta.Properties)
+	require.Equal(t, 0, bucket.reads)
+
+	_, err = s.GetMetadata(ctx, "/first/folder/missing.json")
+	require.ErrorIs(t, err, ErrFileNotFound)
+	require.Equal(t, 0, bucket.reads)
+
+	_, err = s.GetMetadata(ctx, "/unknown/folder/file.json")
+	require.ErrorIs(t, err, ErrFileNotFound)
+}
+
+// copyingBucket copies objects like an Azure container, replacing their metadata with the one set before the copy.
+type copyingBucket struct {
+	attributesOnlyBucket
+	copies int
+}
+
+func (b *copyingBucket) As(i interface{}) bool {
+	_, ok := i.(**azblob.ContainerURL)
+	return ok
+}
+
+func (b *copyingBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	src, ok := b.attributes[srcKey]
+	if !ok {
+		return errAttributesOnlyBucketNotFound
+	}
+
+	metadata := azblob.Metadata{}
+	if opts.BeforeCopy != nil {
+		err := opts.BeforeCopy(func(i interface{}) bool {
+			p, ok := i.(*azblob.Metadata)
+			if ok {
+				*p = metadata
+			}
+			return ok
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	dst := *src
+	dst.Metadata = make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		value, err := url.PathUnescape(v)
+		if err != nil {
+			return err
+		}
+		dst.Metadata[k] = value
+	}
+	b.attributes[dstKey] = &dst
+	b.copies++
+	return nil
+}
+
+// NewTypedWriter stores the attributes of written objects, e.g. of the folder markers, and discards their contents.
+func (b *copyingBucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	return &attributesWriter{bucket: b, key: key, attributes: &driver.Attributes{ContentType: contentType, Metadata: opts.Metadata}}, nil
+}
+
+type attributesWriter struct {
+	bucket     *copyingBucket
+	key        string
+	attributes *driver.Attributes
+}
+
+func (w *attributesWriter) Write(p []byte) (int, error) {
+	w.attributes.Size += int64(len(p))
+	return len(p), nil
+}
+
+func (w *attributesWriter) Close() error {
+	w.bucket.attributes[w.key] = w.attributes
+	return nil
+}
+
+func TestFilestorage_CopyInBucket(t *testing.T) {
+	ctx := context.Background()
+	bucket := &copyingBucket{
+		attributesOnlyBucket: attributesOnlyBucket{
+			attributes: map[string]*driver.Attributes{
+				"/dashboard.json": {
+					ContentType: "application/json",
+					Metadata:    map[string]string{originalPathAttributeKey: "/Dashboard.json", "author": "admin"},
+					Size:        128,
+				},
+			},
+		},
+	}
+
+	s := newTestService(map[string]FileStorage{
+		"first": NewCdkBlobStorage(log.New("testStorageLogger"), blob.NewBucket(bucket), Delimiter, nil, nil, nil),
+	})
+
+	require.NoError(t, s.Copy(ctx, "/first/Dashboard.json", "/first/Dashboard-Copy.json"))
+	require.Equal(t, 1, bucket.copies)
+	require.Equal(t, 0, bucket.reads)
+
+	meta, err := s.GetMetadata(ctx, "/first/Dashboard-Copy.json")
+	require.NoError(t, err)
+	require.Equal(t, "/Dashboard-Copy.json", meta.FullPath)
+	require.Equal(t, "application/json", meta.MimeType)
+	require.Equal(t, map[string]string{"author": "admin"}, meta.Properties)
+}
+
+func TestFilestorage_DeleteFolder(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+
+	require.NoError(t, s.CreateFolder(ctx, "/mem/empty", nil))
+	require.NoError(t, s.DeleteFolder(ctx, "/mem/empty", nil))
+
+	contents := []byte("contents")
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/folder/nested/file.txt", Contents: &contents}))
+
+	err := s.DeleteFolder(ctx, "/mem/folder", nil)
+	require.ErrorIs(t, err, ErrFolderNotEmpty)
+
+	err = s.DeleteFolder(ctx, "/mem/folder", &DeleteFolderOptions{Force: false})
+	require.ErrorIs(t, err, ErrFolderNotEmpty)
+
+	require.NoError(t, s.DeleteFolder(ctx, "/mem/folder", &DeleteFolderOptions{Force: true}))
+
+	file, err := s.Get(ctx, "/mem/folder/nested/file.txt")
+	require.ErrorIs(t, err, ErrFileNotFound)
+	require.Nil(t, file)
+
+	folders, err := s.ListFolders(ctx, "/mem", &ListOptions{Recursive: true})
+	require.NoError(t, err)
+	require.Empty(t, folders)
+}
+
+func TestFilestorage_PlanDeleteFolder(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+
+	contents := []byte("contents")
+	expected := []string{
+		"/mem/folder/a.txt",
+		"/mem/folder/nested/B.txt",
+		"/mem/folder/nested/deeper/c.json",
+	}
+	// more files than fit in a page of the listing
+	for i := 0; i < deletePlanPageSize; i++ {
+		expected = append(expected, fmt.Sprintf("/mem/folder/paged/file-%03d.txt", i))
+	}
+	for _, path := range append([]string{"/mem/folder-sibling/d.txt", "/mem/root.txt"}, expected...) {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: path, Contents: &contents}))
+	}
+	require.NoError(t, s.CreateFolder(ctx, "/mem/folder/empty", nil))
+
+	plan, err := s.PlanDeleteFolder(ctx, "/mem/folder/")
+	require.NoError(t, err)
+	require.ElementsMatch(t, expected, plan)
+
+	// planning does not delete anything
+	for _, path := range expected {
+		exists, err := s.Exists(ctx, path)
+		require.NoError(t, err)
+		require.True(t, exists, path)
+	}
+
+	require.NoError(t, s.DeleteFolder(ctx, "/mem/folder", &DeleteFolderOptions{Force: true}))
+	for _, path := range plan {
+		exists, err := s.Exists(ctx, path)
+		require.NoError(t, err)
+		require.False(t, exists, path)
+	}
+
+	exists, err := s.Exists(ctx, "/mem/folder-sibling/d.txt")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	plan, err = s.PlanDeleteFolder(ctx, "/mem/folder")
+	require.NoError(t, err)
+	require.Empty(t, plan)
+}
+
+func TestFilestorage_ETag(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+
+	contents := []byte("contents")
+	otherContents := []byte("other contents")
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/folder/a.txt", Contents: &contents}))
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/folder/b.txt", Contents: &contents}))
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/folder/c.txt", Contents: &otherContents}))
+
+	a, err := s.Get(ctx, "/mem/folder/a.txt")
+	require.NoError(t, err)
+	b, err := s.Get(ctx, "/mem/folder/b.txt")
+	require.NoError(t, err)
+	c, err := s.Get(ctx, "/mem/folder/c.txt")
+	require.NoError(t, err)
+
+	require.NotEmpty(t, a.ETag)
+	require.Equal(t, a.ETag, b.ETag)
+	require.NotEqual(t, a.ETag, c.ETag)
+
+	resp, err := s.ListFiles(ctx, "/mem/folder", nil, nil)
+	require.NoError(t, err)
+	require.Len(t, resp.Files, 3)
+	require.Equal(t, a.ETag, resp.Files[0].ETag)
+	require.Equal(t, b.ETag, resp.Files[1].ETag)
+	require.Equal(t, c.ETag, resp.Files[2].ETag)
+}
+
+func TestFilestorage_ListFilesWithFilter(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+
+	contents := []byte("contents")
+	for _, filePath := range []string{
+		"/mem/dashboards/dash-1.json",
+		"/mem/dashboards/dash-2.JSON",
+		"/mem/dashboards/nested/dash-3.json",
+		"/mem/dashboards/readme.md",
+		"/mem/images/dash-4.json",
+	} {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: filePath, Contents: &contents}))
+	}
+
+	resp, err := s.ListFiles(ctx, "/mem", nil, &ListOptions{Recursive: true, Filter: "*.json"})
+	require.NoError(t, err)
+	require.Len(t, resp.Files, 4)
+
+	resp, err = s.ListFiles(ctx, "/mem", nil, &ListOptions{
+		Recursive:   true,
+		Filter:      "dash-*",
+		PathFilters: PathFilters{allowedPrefixes: []string{"/dashboards/nested"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Files, 1)
+	require.Equal(t, "/dashboards/nested/dash-3.json", resp.Files[0].FullPath)
+
+	_, err = s.ListFiles(ctx, "/mem", nil, &ListOptions{Filter: "["})
+	require.ErrorIs(t, err, path.ErrBadPattern)
+}
+
+func TestFilestorage_ReadOnlyBackend(t *testing.T) {
+	ctx := context.Background()
+	cfg := backendConfig{Name: "archive", ReadOnly: true}
+	s := newTestService(map[string]FileStorage{
+		"archive": newTestMemBackend(t, cfg.operations(), nil),
+	})
+
+	contents := []byte("contents")
+	err := s.Upsert(ctx, &UpsertFileCommand{Path: "/archive/file.txt", Contents: &contents})
+	require.ErrorIs(t, err, ErrOperationNotSupported)
+
+	require.ErrorIs(t, s.Delete(ctx, "/archive/file.txt"), ErrOperationNotSupported)
+	require.ErrorIs(t, s.CreateFolder(ctx, "/archive/folder", nil), ErrOperationNotSupported)
+	require.ErrorIs(t, s.DeleteFolder(ctx, "/archive/folder", nil), ErrOperationNotSupported)
+
+	file, err := s.Get(ctx, "/archive/file.txt")
+	require.ErrorIs(t, err, ErrFileNotFound)
+	require.Nil(t, file)
+
+	_, err = s.ListFiles(ctx, "/archive", nil, nil)
+	require.NoError(t, err)
+}
+
+func TestFilestorage_PathNotAllowed(t *testing.T) {
+	ctx := context.Background()
+	bucket, err := blob.OpenBucket(ctx, "mem://")
+	require.NoError(t, err)
+	s := newTestService(map[string]FileStorage{
+		"mem": NewCdkBlobStorage(log.New("testStorageLogger"), bucket, "", NewPathFilters([]string{"/public/"}, nil), nil, nil),
+	})
+
+	contents := []byte("contents")
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/public/file.txt", Contents: &contents}))
+
+	t.Run("should fail the reads like for a missing file", func(t *testing.T) {
+		_, err := s.Get(ctx, "/mem/private/file.txt")
+		require.ErrorIs(t, err, ErrFileNotFound)
+
+		_, _, err = s.GetReader(ctx, "/mem/private/file.txt")
+		require.ErrorIs(t, err, ErrFileNotFound)
+
+		_, _, err = s.Open(ctx, "/mem/private/file.txt")
+		require.ErrorIs(t, err, ErrFileNotFound)
+
+		_, err = s.GetMetadata(ctx, "/mem/private/file.txt")
+		require.ErrorIs(t, err, ErrFileNotFound)
+
+		exists, err := s.Exists(ctx, "/mem/private/file.txt")
+		require.NoError(t, err)
+		require.False(t, exists)
+
+		require.ErrorIs(t, s.Copy(ctx, "/mem/private/file.txt", "/mem/public/copy.txt"), ErrFileNotFound)
+	})
+
+	t.Run("should fail the writes", func(t *testing.T) {
+		require.ErrorIs(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/private/file.txt", Contents: &contents}), ErrPathNotAllowed)
+		require.ErrorIs(t, s.UpsertStream(ctx, "/mem/private/file.txt", bytes.NewReader(contents), nil), ErrPathNotAllowed)
+		require.ErrorIs(t, s.Append(ctx, "/mem/private/file.txt", contents), ErrPathNotAllowed)
+		require.ErrorIs(t, s.Touch(ctx, "/mem/private/file.txt"), ErrPathNotAllowed)
+		require.ErrorIs(t, s.Delete(ctx, "/mem/private/file.txt"), ErrPathNotAllowed)
+		require.ErrorIs(t, s.Copy(ctx, "/mem/public/file.txt", "/mem/private/copy.txt"), ErrPathNotAllowed)
+		require.ErrorIs(t, s.Move(ctx, "/mem/public/file.txt", "/mem/private/moved.txt"), ErrPathNotAllowed)
+		require.ErrorIs(t, s.CreateFolder(ctx, "/mem/private/folder", nil), ErrPathNotAllowed)
+		require.ErrorIs(t, s.DeleteFolder(ctx, "/mem/private", nil), ErrPathNotAllowed)
+		require.ErrorIs(t, s.Restore(ctx, "/mem/private/file.txt"), ErrPathNotAllowed)
+		require.ErrorIs(t, s.PurgeTrash(ctx, "/mem/private/file.txt"), ErrPathNotAllowed)
+
+		_, errs := s.DeleteMany(ctx, []string{"/mem/private/file.txt"})
+		require.ErrorIs(t, errs["/mem/private/file.txt"], ErrPathNotAllowed)
+
+		file, err := s.Get(ctx, "/mem/public/file.txt")
+		require.NoError(t, err)
+		require.Equal(t, contents, file.Contents)
+	})
+}
+
+func TestFilestorage_OperationNotSupported(t *testing.T) {
+	ctx := context.Background()
+	contents := []byte("contents")
+
+	var tests = []struct {
+		operation Operation
+		call      func(s *service) error
+	}{
+		{
+			operation: OperationGet,
+			call: func(s *service) error {
+				_, err := s.Get(ctx, "/mem/file.txt")
+				return err
+			},
+		},
+		{
+			operation: OperationDelete,
+			call: func(s *service) error {
+				return s.Delete(ctx, "/mem/file.txt")
+			},
+		},
+		{
+			operation: OperationUpsert,
+			call: func(s *service) error {
+				return s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/file.txt", Contents: &contents})
+			},
+		},
+		{
+			operation: OperationListFiles,
+			call: func(s *service) error {
+				_, err := s.ListFiles(ctx, "/mem", nil, nil)
+				return err
+			},
+		},
+		{
+			operation: OperationListFolders,
+			call: func(s *service) error {
+				_, err := s.ListFolders(ctx, "/mem", nil)
+				return err
+			},
+		},
+		{
+			operation: OperationCreateFolder,
+			call: func(s *service) error {
+				return s.CreateFolder(ctx, "/mem/folder", nil)
+			},
+		},
+		{
+			operation: OperationDeleteFolder,
+			call: func(s *service) error {
+				return s.DeleteFolder(ctx, "/mem/folder", nil)
+			},
+		},
+		{
+			operation: OperationCopy,
+			call: func(s *service) error {
+				return s.Copy(ctx, "/mem/file.txt", "/mem/copy.txt")
+			},
+		},
+		{
+			operation: OperationMove,
+			call: func(s *service) error {
+				return s.Move(ctx, "/mem/file.txt", "/mem/moved.txt")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.operation), func(t *testing.T) {
+			supportedOperations := make([]Operation, 0)
+			for _, op := range allOperations {
+				if op != tt.operation {
+					supportedOperations = append(supportedOperations, op)
+				}
+			}
+
+			s := newTestService(map[string]FileStorage{
+				"mem": newTestMemBackend(t, supportedOperations, nil),
+			})
+
+			err := tt.call(s)
+			require.ErrorIs(t, err, ErrOperationNotSupported)
+			require.Contains(t, err.Error(), string(tt.operation))
+		})
+	}
+}
+
+func TestFilestorage_MimeType(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+
+	t.Run("should store the explicit mime type", func(t *testing.T) {
+		contents := []byte("{}")
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/folder/data", Contents: &contents, MimeType: "application/json"}))
+
+		file, err := s.Get(ctx, "/mem/folder/data")
+		require.NoError(t, err)
+		require.Equal(t, "application/json", file.MimeType)
+
+		resp, err := s.ListFiles(ctx, "/mem/folder", nil, nil)
+		require.NoError(t, err)
+		require.Len(t, resp.Files, 1)
+		require.Equal(t, "application/json", resp.Files[0].MimeType)
+	})
+
+	t.Run("should keep the stored mime type when only updating properties", func(t *testing.T) {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/folder/data", Properties: map[string]string{"key": "value"}}))
+
+		file, err := s.Get(ctx, "/mem/folder/data")
+		require.NoError(t, err)
+		require.Equal(t, "application/json", file.MimeType)
+	})
+
+	t.Run("should sniff the mime type of files without a known extension", func(t *testing.T) {
+		png := []byte("\x89PNG\x0D\x0A\x1A\x0A\x00\x00\x00\x0DIHDR")
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/images/logo", Contents: &png}))
+
+		file, err := s.Get(ctx, "/mem/images/logo")
+		require.NoError(t, err)
+		require.Equal(t, "image/png", file.MimeType)
+	})
+}
+
+type healthCheckBackend struct {
+	dummyFileStorage
+	healthCheck func(ctx context.Context) error
+}
+
+func (b healthCheckBackend) HealthCheck(ctx context.Context) error {
+	return b.healthCheck(ctx)
+}
+
+func TestFilestorage_HealthCheck(t *testing.T) {
+	errUnreachable := errors.New("unreachable")
+	unblock := make(chan struct{})
+	t.Cleanup(func() {
+		close(unblock)
+	})
+
+	s := newTestService(map[string]FileStorage{
+		"mem":   newTestMemBackend(t, nil, nil),
+		"dummy": &dummyFileStorage{},
+		"broken": healthCheckBackend{healthCheck: func(ctx context.Context) error {
+			return errUnreachable
+		}},
+		"hung": healthCheckBackend{healthCheck: func(ctx context.Context) error {
+			<-unblock
+			return nil
+		}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	errByBackendName := s.CheckBackendsHealth(ctx)
+	require.Len(t, errByBackendName, 2)
+	require.ErrorIs(t, errByBackendName["broken"], errUnreachable)
+	require.ErrorIs(t, errByBackendName["hung"], context.DeadlineExceeded)
+
+	healthy := newTestService(map[string]FileStorage{
+		"mem":   newTestMemBackend(t, nil, nil),
+		"dummy": &dummyFileStorage{},
+	})
+	require.NoError(t, healthy.HealthCheck(context.Background()))
+}
+
+func TestFilestorage_ListFoldersAtVirtualRoot(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"second":                  newTestMemBackend(t, nil, nil),
+		"first":                   newTestMemBackend(t, nil, nil),
+		string(StorageNamePublic): &dummyFileStorage{},
+	})
+
+	for _, root := range []string{"", Delimiter} {
+		folders, err := s.ListFolders(ctx, root, nil)
+		require.NoError(t, err)
+		require.Equal(t, []FileMetadata{
+			{Name: "first", FullPath: "/first"},
+			{Name: "public", FullPath: "/public"},
+			{Name: "second", FullPath: "/second"},
+		}, folders)
+	}
+}
+
+func TestFilestorage_ListFilesWithMimeTypeFilter(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+
+	contents := []byte("contents")
+	for _, filePath := range []string{
+		"/mem/media/a.png",
+		"/mem/media/b.json",
+		"/mem/media/c.txt",
+		"/mem/media/d.png",
+		"/mem/media/e.json",
+	} {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: filePath, Contents: &contents}))
+	}
+
+	t.Run("should list files of the given types", func(t *testing.T) {
+		resp, err := s.ListFiles(ctx, "/mem/media", nil, &ListOptions{MimeTypeFilter: []string{"application/json", "text/plain"}})
+		require.NoError(t, err)
+		require.Len(t, resp.Files, 3)
+		require.Equal(t, "/media/b.json", resp.Files[0].FullPath)
+		require.Equal(t, "/media/c.txt", resp.Files[1].FullPath)
+		require.Equal(t, "/media/e.json", resp.Files[2].FullPath)
+	})
+
+	t.Run("should paginate through files matching a wildcard type", func(t *testing.T) {
+		paths := make([]string, 0)
+		paging := &Paging{First: 1}
+		for {
+			resp, err := s.ListFiles(ctx, "/mem/media", paging, &ListOptions{MimeTypeFilter: []string{"image/*"}})
+			require.NoError(t, err)
+			for _, file := range resp.Files {
+				paths = append(paths, file.FullPath)
+			}
+
+			if !resp.HasMore || resp.LastPath == "" {
+				break
+			}
+			paging = &Paging{First: 1, After: resp.LastPath}
+		}
+
+		require.Equal(t, []string{"/media/a.png", "/media/d.png"}, paths)
+	})
+}
+
+type recordingListener struct {
+	events chan string
+}
+
+func (l *recordingListener) OnUpsert(path string) {
+	l.events <- "upsert " + path
+}
+
+func (l *recordingListener) OnDelete(path string) {
+	l.events <- "delete " + path
+}
+
+func (l *recordingListener) next(t *testing.T) string {
+	t.Helper()
+
+	select {
+	case event := <-l.events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the listener to be called")
+		return ""
+	}
+}
+
+func TestFilestorage_Listeners(t *testing.T) {
+	ctx := context.Background()
+	contents := []byte("contents")
+
+	s := newTestService(map[string]FileStorage{
+		"first": newTestMemBackend(t, nil, nil),
+	})
+	t.Cleanup(func() {
+		_ = s.close()
+	})
+
+	listener := &recordingListener{events: make(chan string, 10)}
+	s.AddListener(listener)
+
+	t.Run("should notify the listeners with the full path after successful writes", func(t *testing.T) {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/first/folder/file.txt", Contents: &contents}))
+		require.Equal(t, "upsert /first/folder/file.txt", listener.next(t))
+
+		require.NoError(t, s.Delete(ctx, "/first/folder/file.txt"))
+		require.Equal(t, "delete /first/folder/file.txt", listener.next(t))
+
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/first/other/file.txt", Contents: &contents}))
+		require.Equal(t, "upsert /first/other/file.txt", listener.next(t))
+
+		require.NoError(t, s.DeleteFolder(ctx, "/first/other", &DeleteFolderOptions{Force: true}))
+		require.Equal(t, "delete /first/other", listener.next(t))
+	})
+
+	t.Run("should not notify the listeners after failed writes", func(t *testing.T) {
+		require.Error(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/first/folder/../file.txt", Contents: &contents}))
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/first/last.txt", Contents: &contents}))
+		require.Equal(t, "upsert /first/last.txt", listener.next(t))
+	})
+
+	t.Run("should not block writes on slow listeners", func(t *testing.T) {
+		blocked := newTestService(map[string]FileStorage{
+			"first": newTestMemBackend(t, nil, nil),
+		})
+		unblock := make(chan struct{})
+		blocked.AddListener(&blockingListener{unblock: unblock})
+
+		for i := 0; i < listenerQueueSize+10; i++ {
+			require.NoError(t, blocked.Upsert(ctx, &UpsertFileCommand{Path: "/first/file.txt", Contents: &contents}))
+		}
+
+		close(unblock)
+		require.NoError(t, blocked.close())
+	})
+}
+
+type blockingListener struct {
+	unblock chan struct{}
+}
+
+func (l *blockingListener) OnUpsert(path string) {
+	<-l.unblock
+}
+
+func (l *blockingListener) OnDelete(path string) {
+	<-l.unblock
+}
+
+func TestFilestorage_Compression(t *testing.T) {
+	ctx := context.Background()
+	bucket, err := blob.OpenBucket(ctx, "mem://")
+	require.NoError(t, err)
+
+	backend := NewCdkBlobStorage(log.New("testStorageLogger"), bucket, Delimiter, nil, nil, &CdkBlobStorageOptions{
+		CompressContentTypes: []string{"Application/JSON"},
+	})
+	t.Cleanup(func() {
+		_ = backend.close()
+	})
+
+	dashboard := []byte(`{"title": "` + strings.Repeat("dashboard ", 100) + `"}`)
+	binary := []byte{0x89, 0x50, 0x4e, 0x47, 0x00, 0x01, 0x02, 0x03}
+	require.NoError(t, backend.Upsert(ctx, &UpsertFileCommand{Path: "/folder/dashboard.json", Contents: &dashboard, Properties: map[string]string{"a": "b"}}))
+	require.NoError(t, backend.Upsert(ctx, &UpsertFileCommand{Path: "/folder/image.png", Contents: &binary}))
+
+	t.Run("should store compressed JSON files", func(t *testing.T) {
+		stored, err := bucket.ReadAll(ctx, "/folder/dashboard.json")
+		require.NoError(t, err)
+		require.Less(t, len(stored), len(dashboard))
+
+		decompressed, err := decompress(stored)
+		require.NoError(t, err)
+		require.Equal(t, dashboard, decompressed)
+	})
+
+	t.Run("should leave binary files untouched", func(t *testing.T) {
+		stored, err := bucket.ReadAll(ctx, "/folder/image.png")
+		require.NoError(t, err)
+		require.Equal(t, binary, stored)
+
+		file, err := backend.Get(ctx, "/folder/image.png")
+		require.NoError(t, err)
+		require.Equal(t, binary, file.Contents)
+		require.Equal(t, int64(len(binary)), file.Size)
+	})
+
+	t.Run("should decompress files on read", func(t *testing.T) {
+		file, err := backend.Get(ctx, "/folder/dashboard.json")
+		require.NoError(t, err)
+		require.Equal(t, dashboard, file.Contents)
+		require.Equal(t, int64(len(dashboard)), file.Size)
+		require.Equal(t, map[string]string{"a": "b"}, file.Properties)
+
+		reader, metadata, err := backend.GetReader(ctx, "/folder/dashboard.json")
+		require.NoError(t, err)
+		contents, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		require.Equal(t, dashboard, contents)
+		require.Equal(t, int64(len(dashboard)), metadata.Size)
+		require.Equal(t, file.ETag, metadata.ETag)
+	})
+
+	t.Run("should report the uncompressed size", func(t *testing.T) {
+		metadata, err := backend.GetMetadata(ctx, "/folder/dashboard.json")
+		require.NoError(t, err)
+		require.Equal(t, int64(len(dashboard)), metadata.Size)
+		require.Equal(t, map[string]string{"a": "b"}, metadata.Properties)
+
+		resp, err := backend.ListFiles(ctx, "/folder", &Paging{First: 10}, nil)
+		require.NoError(t, err)
+		require.Len(t, resp.Files, 2)
+		require.Equal(t, "/folder/dashboard.json", resp.Files[0].FullPath)
+		require.Equal(t, int64(len(dashboard)), resp.Files[0].Size)
+		require.Equal(t, map[string]string{"a": "b"}, resp.Files[0].Properties)
+	})
+
+	t.Run("should keep the file compressed when it is updated or copied", func(t *testing.T) {
+		updated := []byte(`{"title": "updated"}`)
+		require.NoError(t, backend.Upsert(ctx, &UpsertFileCommand{Path: "/folder/dashboard.json", Contents: &updated}))
+		require.NoError(t, backend.Copy(ctx, "/folder/dashboard.json", "/folder/copy.json"))
+
+		for _, path := range []string{"/folder/dashboard.json", "/folder/copy.json"} {
+			file, err := backend.Get(ctx, path)
+			require.NoError(t, err)
+			require.Equal(t, updated, file.Contents)
+			require.Equal(t, int64(len(updated)), file.Size)
+			require.Equal(t, map[string]string{"a": "b"}, file.Properties)
+		}
+	})
+}
+
+// uploadRecordingBucket captures the S3 upload inputs prepared by the writer options, as the s3blob driver would.
+type uploadRecordingBucket struct {
+	driver.Bucket
+	uploads map[string]*s3manager.UploadInput
+}
+
+var errUploadRecordingBucketNotFound = errors.New("not found")
+
+func (b *uploadRecordingBucket) ErrorCode(err error) gcerrors.ErrorCode {
+	if errors.Is(err, errUploadRecordingBucketNotFound) {
+		return gcerrors.NotFound
+	}
+	return gcerrors.Unknown
+}
+
+func (b *uploadRecordingBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	return nil, errUploadRecordingBucketNotFound
+}
+
+func (b *uploadRecordingBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	return nil, errUploadRecordingBucketNotFound
+}
+
+func (b *uploadRecordingBucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	input := &s3manager.UploadInput{Key: aws.String(key)}
+	if opts.BeforeWrite != nil {
+		if err := opts.BeforeWrite(func(i interface{}) bool {
+			p, ok := i.(**s3manager.UploadInput)
+			if !ok {
+				return false
+			}
+			*p = input
+			return true
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	b.uploads[key] = input
+	return discardWriter{}, nil
+}
+
+func (b *uploadRecordingBucket) Close() error {
+	return nil
+}
+
+type discardWriter struct{}
+
+func (discardWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (discardWriter) Close() error {
+	return nil
+}
+
+func TestFilestorage_ServerSideEncryption(t *testing.T) {
+	ctx := context.Background()
+	contents := []byte("contents")
+	keyID := "arn:aws:kms:us-east-1:111122223333:key/test"
+
+	t.Run("should request SSE-KMS encryption with the configured key", func(t *testing.T) {
+		bucket := &uploadRecordingBucket{uploads: make(map[string]*s3manager.UploadInput)}
+		backend := NewCdkBlobStorage(log.New("testStorageLogger"), blob.NewBucket(bucket), "", nil, nil, &CdkBlobStorageOptions{
+			SSEKMSKeyID: keyID,
+		})
+		t.Cleanup(func() {
+			_ = backend.close()
+		})
+
+		require.NoError(t, backend.Upsert(ctx, &UpsertFileCommand{Path: "/folder/file.txt", Contents: &contents}))
+
+		upload, ok := bucket.uploads["/folder/file.txt"]
+		require.True(t, ok)
+		require.Equal(t, s3.ServerSideEncryptionAwsKms, aws.StringValue(upload.ServerSideEncryption))
+		require.Equal(t, keyID, aws.StringValue(upload.SSEKMSKeyId))
+	})
+
+	t.Run("should not request encryption without a key", func(t *testing.T) {
+		bucket := &uploadRecordingBucket{uploads: make(map[string]*s3manager.UploadInput)}
+		backend := NewCdkBlobStorage(log.New("testStorageLogger"), blob.NewBucket(bucket), "", nil, nil, nil)
+		t.Cleanup(func() {
+			_ = backend.close()
+		})
+
+		require.NoError(t, backend.Upsert(ctx, &UpsertFileCommand{Path: "/folder/file.txt", Contents: &contents}))
+
+		upload, ok := bucket.uploads["/folder/file.txt"]
+		require.True(t, ok)
+		require.Nil(t, upload.ServerSideEncryption)
+		require.Nil(t, upload.SSEKMSKeyId)
+	})
+
+	t.Run("should fail to write if the bucket does not support encryption", func(t *testing.T) {
+		backend := newTestMemBackend(t, nil, &CdkBlobStorageOptions{SSEKMSKeyID: keyID})
+
+		err := backend.Upsert(ctx, &UpsertFileCommand{Path: "/folder/file.txt", Contents: &contents})
+		require.ErrorIs(t, err, ErrEncryptionUnsupported)
+	})
+}
+
+func TestFilestorage_ExportImport(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"first":  newTestMemBackend(t, nil, nil),
+		"second": newTestMemBackend(t, nil, nil),
+	})
+
+	files := map[string]string{
+		"/first/folder/dashboard.json":     `{"title": "dashboard"}`,
+		"/first/folder/nested/image.png":   "image",
+		"/first/folder/nested/deep/a.txt":  "a",
+		"/first/other/not-exported.txt":    "other",
+		"/first/folder/nested/deep/b.yaml": "b: c",
+	}
+	for path, contents := range files {
+		contents := []byte(contents)
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{
+			Path:       path,
+			Contents:   &contents,
+			Properties: map[string]string{"source": getName(path)},
+		}))
+	}
+
+	var archive bytes.Buffer
+	require.NoError(t, s.ExportTo(ctx, "/first/folder", &archive))
+	require.NoError(t, s.ImportFrom(ctx, "/second/restored", &archive))
+
+	resp, err := s.ListFiles(ctx, "/second", &Paging{First: 100}, &ListOptions{Recursive: true})
+	require.NoError(t, err)
+	require.Len(t, resp.Files, 4)
+
+	for path, contents := range files {
+		if !strings.HasPrefix(path, "/first/folder/") {
+			continue
+		}
+
+		original, err := s.Get(ctx, path)
+		require.NoError(t, err)
+
+		restored, err := s.Get(ctx, "/second/restored/"+strings.TrimPrefix(path, "/first/folder/"))
+		require.NoError(t, err)
+		require.NotNil(t, restored)
+		require.Equal(t, contents, string(restored.Contents))
+		require.Equal(t, original.MimeType, restored.MimeType)
+		require.Equal(t, map[string]string{"source": getName(path)}, restored.Properties)
+	}
+
+	t.Run("should reject archive entries outside of the folder", func(t *testing.T) {
+		var archive bytes.Buffer
+		writer := tar.NewWriter(&archive)
+		require.NoError(t, writer.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "../escaped.txt", Size: 1, Mode: 0644}))
+		_, err := writer.Write([]byte("x"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		err = s.ImportFrom(ctx, "/second/restored", &archive)
+		require.ErrorIs(t, err, ErrNonCanonicalPath)
+
+		file, err := s.Get(ctx, "/second/escaped.txt")
+		require.ErrorIs(t, err, ErrFileNotFound)
+		require.Nil(t, file)
+	})
+}
+
+// slowBackend waits for the delay or the cancellation of the context before answering.
+type slowBackend struct {
+	dummyFileStorage
+	delay time.Duration
+}
+
+func (b slowBackend) wait(ctx context.Context) error {
+	select {
+	case <-time.After(b.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b slowBackend) Get(ctx context.Context, path string) (*File, error) {
+	if err := b.wait(ctx); err != nil {
+		return nil, err
+	}
+	return &File{FileMetadata: FileMetadata{FullPath: path}}, nil
+}
+
+func (b slowBackend) ListFiles(ctx context.Context, folderPath string, paging *Paging, options *ListOptions) (*ListFilesResponse, error) {
+	if err := b.wait(ctx); err != nil {
+		return nil, err
+	}
+	return &ListFilesResponse{}, nil
+}
+
+func TestFilestorage_OperationTimeout(t *testing.T) {
+	s := newTestService(map[string]FileStorage{
+		"hung": withOperationTimeout(slowBackend{delay: time.Minute}, 50*time.Millisecond),
+		"fast": withOperationTimeout(slowBackend{delay: 0}, time.Minute),
+		"none": withOperationTimeout(slowBackend{delay: 10 * time.Millisecond}, 0),
+	})
+
+	t.Run("should fail with a timeout error when the backend does not answer in time", func(t *testing.T) {
+		start := time.Now()
+		_, err := s.Get(context.Background(), "/hung/file.txt")
+		require.ErrorIs(t, err, ErrBackendTimeout)
+		require.Less(t, time.Since(start), 10*time.Second)
+
+		_, err = s.ListFiles(context.Background(), "/hung/folder", &Paging{First: 10}, nil)
+		require.ErrorIs(t, err, ErrBackendTimeout)
+	})
+
+	t.Run("should not report a cancellation by the caller as a timeout", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := s.Get(ctx, "/hung/file.txt")
+		require.ErrorIs(t, err, context.Canceled)
+		require.NotErrorIs(t, err, ErrBackendTimeout)
+	})
+
+	t.Run("should answer within the timeout", func(t *testing.T) {
+		file, err := s.Get(context.Background(), "/fast/file.txt")
+		require.NoError(t, err)
+		require.Equal(t, "/file.txt", file.FullPath)
+
+		file, err = s.Get(context.Background(), "/none/file.txt")
+		require.NoError(t, err)
+		require.Equal(t, "/file.txt", file.FullPath)
+	})
+}
+
+// flakyBackend fails the first calls of Get, Upsert, Delete and ListFiles with the error.
+type flakyBackend struct {
+	FileStorage
+	err      error
+	failures int
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (b *flakyBackend) fail() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls++
+	if b.calls <= b.failures {
+		return b.err
+	}
+	return nil
+}
+
+func (b *flakyBackend) Get(ctx context.Context, path string) (*File, error) {
+	if err := b.fail(); err != nil {
+		return nil, err
+	}
+	return b.FileStorage.Get(ctx, path)
+}
+
+func (b *flakyBackend) Upsert(ctx context.Context, command *UpsertFileCommand) error {
+	if err := b.fail(); err != nil {
+		return err
+	}
+	return b.FileStorage.Upsert(ctx, command)
+}
+
+func (b *flakyBackend) Delete(ctx context.Context, path string) error {
+	if err := b.fail(); err != nil {
+		return err
+	}
+	return b.FileStorage.Delete(ctx, path)
+}
+
+func (b *flakyBackend) ListFiles(ctx context.Context, folderPath string, paging *Paging, options *ListOptions) (*ListFilesResponse, error) {
+	if err := b.fail(); err != nil {
+		return nil, err
+	}
+	return b.FileStorage.ListFiles(ctx, folderPath, paging, options)
+}
+
+func TestFilestorage_Retries(t *testing.T) {
+	ctx := context.Background()
+	transientErr := fmt.Errorf("%w: operation did not complete within 1s", ErrBackendTimeout)
+
+	newFlakyService := func(t *testing.T, err error, failures int, maxRetries int) (*flakyBackend, FileStorage) {
+		backend := &flakyBackend{FileStorage: newTestMemBackend(t, nil, nil), err: err, failures: failures}
+		return backend, newTestService(map[string]FileStorage{
+			"flaky": withRetries(backend, maxRetries, time.Millisecond),
+		})
+	}
+
+	t.Run("should retry transient errors until the operation succeeds", func(t *testing.T) {
+		backend, s := newFlakyService(t, transientErr, 2, 3)
+
+		contents := []byte("data")
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/flaky/file.txt", Contents: &contents}))
+		require.Equal(t, 3, backend.calls)
+
+		backend.calls = 0
+		file, err := s.Get(ctx, "/flaky/file.txt")
+		require.NoError(t, err)
+		require.Equal(t, []byte("data"), file.Contents)
+		require.Equal(t, 3, backend.calls)
+
+		backend.calls = 0
+		resp, err := s.ListFiles(ctx, "/flaky", &Paging{First: 10}, nil)
+		require.NoError(t, err)
+		require.Len(t, resp.Files, 1)
+		require.Equal(t, 3, backend.calls)
+
+		backend.calls = 0
+		require.NoError(t, s.Delete(ctx, "/flaky/file.txt"))
+		require.Equal(t, 3, backend.calls)
+	})
+
+	t.Run("should give up after the max retries", func(t *testing.T) {
+		backend, s := newFlakyService(t, transientErr, 5, 2)
+
+		_, err := s.Get(ctx, "/flaky/file.txt")
+		require.ErrorIs(t, err, ErrBackendTimeout)
+		require.Equal(t, 3, backend.calls)
+	})
+
+	t.Run("should retry errors of unavailable object stores", func(t *testing.T) {
+		unavailableErrs := []error{
+			&googleapi.Error{Code: http.StatusServiceUnavailable},
+			awserr.NewRequestFailure(awserr.New("SlowDown", "please reduce your request rate", nil), http.StatusServiceUnavailable, "request-id"),
+			fmt.Errorf("read: %w", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}),
+			&net.DNSError{Err: "i/o timeout", Name: "storage.example.com", IsTimeout: true},
+		}
+		for _, unavailableErr := range unavailableErrs {
+			backend, s := newFlakyService(t, unavailableErr, 1, 3)
+
+			contents := []byte("data")
+			require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/flaky/file.txt", Contents: &contents}))
+			require.Equal(t, 2, backend.calls)
+		}
+	})
+
+	t.Run("should not retry errors which are not transient", func(t *testing.T) {
+		for _, nonTransientErr := range []error{ErrFileNotFound, ErrPreconditionFailed, &googleapi.Error{Code: http.StatusForbidden}} {
+			backend, s := newFlakyService(t, nonTransientErr, 2, 3)
+
+			err := s.Delete(ctx, "/flaky/file.txt")
+			require.ErrorIs(t, err, nonTransientErr)
+			require.Equal(t, 1, backend.calls)
+		}
+	})
+
+	t.Run("should stop retrying once the context is done", func(t *testing.T) {
+		backend, s := newFlakyService(t, transientErr, 2, 3)
+
+		cancelledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := s.Get(cancelledCtx, "/flaky/file.txt")
+		require.ErrorIs(t, err, ErrBackendTimeout)
+		require.Equal(t, 1, backend.calls)
+	})
+}
+
+// countingBackend counts the reads reaching the backend.
+type countingBackend struct {
+	FileStorage
+	mu       sync.Mutex
+	gets     int
+	metadata int
+}
+
+func (b *countingBackend) Get(ctx context.Context, path string) (*File, error) {
+	b.mu.Lock()
+	b.gets++
+	b.mu.Unlock()
+	return b.FileStorage.Get(ctx, path)
+}
+
+func (b *countingBackend) GetMetadata(ctx context.Context, path string) (*FileMetadata, error) {
+	b.mu.Lock()
+	b.metadata++
+	b.mu.Unlock()
+	return b.FileStorage.GetMetadata(ctx, path)
+}
+
+func TestFilestorage_Cache(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingBackend{FileStorage: newTestMemBackend(t, nil, nil)}
+	cached, err := withCache(backend, time.Minute, 10)
+	require.NoError(t, err)
+
+	now := time.Now()
+	cached.(*cachingFileStorage).now = func() time.Time {
+		return now
+	}
+	s := newTestService(map[string]FileStorage{"cached": cached})
+
+	contents := []byte("logo")
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/cached/logo.svg", Contents: &contents}))
+
+	t.Run("should serve a second read from the cache", func(t *testing.T) {
+		file, err := s.Get(ctx, "/cached/logo.svg")
+		require.NoError(t, err)
+		require.Equal(t, []byte("logo"), file.Contents)
+
+		// the cached file is not affected by changes of the returned one
+		file.Contents[0] = 'L'
+
+		file, err = s.Get(ctx, "/cached/Logo.svg")
+		require.NoError(t, err)
+		require.Equal(t, []byte("logo"), file.Contents)
+		require.Equal(t, 1, backend.gets)
+
+		_, err = s.GetMetadata(ctx, "/cached/logo.svg")
+		require.NoError(t, err)
+		metadata, err := s.GetMetadata(ctx, "/cached/logo.svg")
+		require.NoError(t, err)
+		require.Equal(t, int64(4), metadata.Size)
+		require.Equal(t, 1, backend.metadata)
+	})
+
+	t.Run("should invalidate the entries on write", func(t *testing.T) {
+		contents := []byte("new logo")
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/cached/logo.svg", Contents: &contents}))
+
+		file, err := s.Get(ctx, "/cached/logo.svg")
+		require.NoError(t, err)
+		require.Equal(t, []byte("new logo"), file.Contents)
+		require.Equal(t, 2, backend.gets)
+
+		metadata, err := s.GetMetadata(ctx, "/cached/logo.svg")
+		require.NoError(t, err)
+		require.Equal(t, int64(8), metadata.Size)
+		require.Equal(t, 2, backend.metadata)
+
+		require.NoError(t, s.Delete(ctx, "/cached/logo.svg"))
+		file, err = s.Get(ctx, "/cached/logo.svg")
+		require.ErrorIs(t, err, ErrFileNotFound)
+		require.Nil(t, file)
+		require.Equal(t, 3, backend.gets)
+	})
+
+	t.Run("should expire the entries after the TTL", func(t *testing.T) {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/cached/logo.svg", Contents: &contents}))
+		gets := backend.gets
+
+		_, err := s.Get(ctx, "/cached/logo.svg")
+		require.NoError(t, err)
+		_, err = s.Get(ctx, "/cached/logo.svg")
+		require.NoError(t, err)
+		require.Equal(t, gets+1, backend.gets)
+
+		now = now.Add(time.Minute)
+		_, err = s.Get(ctx, "/cached/logo.svg")
+		require.NoError(t, err)
+		require.Equal(t, gets+2, backend.gets)
+	})
+}
+
+func TestFilestorage_Metrics(t *testing.T) {
+	ctx := context.Background()
+	contents := []byte("contents")
+	s := newTestService(map[string]FileStorage{
+		"metrics": newTestMemBackend(t, nil, nil),
+	})
+
+	count := func(operation string, backend string) float64 {
+		return testutil.ToFloat64(operationsCounter.WithLabelValues(operation, backend))
+	}
+	errorCount := func(operation string, backend string) float64 {
+		return testutil.ToFloat64(operationErrorsCounter.WithLabelValues(operation, backend))
+	}
+
+	upserts, gets, deletes := count("upsert", "metrics"), count("get", "metrics"), count("delete", "metrics")
+	getErrors, notFound := errorCount("get", "metrics"), count("get", backendLabelNotFound)
+
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/metrics/file.txt", Contents: &contents}))
+	_, err := s.Get(ctx, "/metrics/file.txt")
+	require.NoError(t, err)
+	_, err = s.Get(ctx, "/metrics/../file.txt")
+	require.Error(t, err)
+	require.NoError(t, s.Delete(ctx, "/metrics/file.txt"))
+	_, err = s.Get(ctx, "/unknown/file.txt")
+	require.ErrorIs(t, err, ErrFileNotFound)
+
+	require.Equal(t, upserts+1, count("upsert", "metrics"))
+	require.Equal(t, gets+2, count("get", "metrics"))
+	require.Equal(t, getErrors+1, errorCount("get", "metrics"))
+	require.Equal(t, deletes+1, count("delete", "metrics"))
+	require.Equal(t, notFound+1, count("get", backendLabelNotFound))
+}
+
+// recordingLogger records the messages logged at warn level.
+type recordingLogger struct {
+	log.Logger
+	mu       sync.Mutex
+	warnings []recordedLogLine
+}
+
+type recordedLogLine struct {
+	msg string
+	ctx []interface{}
+}
+
+func (l *recordingLogger) Warn(msg string, ctx ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warnings = append(l.warnings, recordedLogLine{msg: msg, ctx: ctx})
+}
+
+func (l *recordingLogger) recorded() []recordedLogLine {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]recordedLogLine(nil), l.warnings...)
+}
+
+func TestFilestorage_SlowLog(t *testing.T) {
+	logger := &recordingLogger{Logger: log.New("testFileStorageService")}
+	s := newTestService(map[string]FileStorage{
+		"slow": slowBackend{delay: 50 * time.Millisecond},
+		"fast": slowBackend{delay: 0},
+	})
+	s.log = logger
+
+	t.Run("should not log slow operations by default", func(t *testing.T) {
+		_, err := s.Get(context.Background(), "/slow/file.txt")
+		require.NoError(t, err)
+		require.Empty(t, logger.recorded())
+	})
+
+	s.slowLogThreshold = 20 * time.Millisecond
+
+	t.Run("should not log operations faster than the threshold", func(t *testing.T) {
+		_, err := s.Get(context.Background(), "/fast/file.txt")
+		require.NoError(t, err)
+		require.Empty(t, logger.recorded())
+	})
+
+	t.Run("should log operations slower than the threshold", func(t *testing.T) {
+		_, err := s.Get(context.Background(), "/slow/file.txt")
+		require.NoError(t, err)
+
+		lines := logger.recorded()
+		require.Len(t, lines, 1)
+		require.Equal(t, "Slow file storage operation", lines[0].msg)
+		require.Equal(t, []interface{}{"operation", "get", "backend", "slow", "path", "/slow/file.txt", "elapsed"}, lines[0].ctx[:7])
+		require.GreaterOrEqual(t, lines[0].ctx[7], 50*time.Millisecond)
+	})
+}
+
+func TestFilestorage_MemBackends(t *testing.T) {
+	ctx := context.Background()
+	fsConfig, err := newConfig(newTestCfg(t, `
+[file_storage.backend.first]
+type = mem
+
+[file_storage.backend.second]
+type = mem
+allowed_prefixes = public/
+`))
+	require.NoError(t, err)
+
+	s := newTestService(map[string]FileStorage{})
+	require.NoError(t, s.registerBackends(ctx, fsConfig, nil, featuremgmt.WithFeatures()))
+	s.resolver = newBackendResolver(s.backendByName)
+	t.Cleanup(func() {
+		_ = s.close()
+	})
+
+	contents := []byte("first")
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/first/public/file.txt", Contents: &contents}))
+
+	file, err := s.Get(ctx, "/first/public/file.txt")
+	require.NoError(t, err)
+	require.NotNil(t, file)
+	require.Equal(t, contents, file.Contents)
+
+	file, err = s.Get(ctx, "/second/public/file.txt")
+	require.ErrorIs(t, err, ErrFileNotFound)
+	require.Nil(t, file)
+
+	contents = []byte("second")
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/second/public/file.txt", Contents: &contents}))
+
+	file, err = s.Get(ctx, "/first/public/file.txt")
+	require.NoError(t, err)
+	require.Equal(t, []byte("first"), file.Contents)
+
+	t.Run("should honor the allowed prefixes", func(t *testing.T) {
+		contents := []byte("private")
+		require.ErrorIs(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/second/private/file.txt", Contents: &contents}), ErrPathNotAllowed)
+
+		file, err := s.Get(ctx, "/second/private/file.txt")
+		require.ErrorIs(t, err, ErrFileNotFound)
+		require.Nil(t, file)
+	})
+}
+
+func TestFilestorage_RegexPathFilter(t *testing.T) {
+	ctx := context.Background()
+	bucket, err := blob.OpenBucket(ctx, "mem://")
+	require.NoError(t, err)
+
+	regexFilter, err := NewRegexPathFilter([]string{`^/objects/[0-9a-f]{8}$`}, nil)
+	require.NoError(t, err)
+
+	backends := map[string]FileStorage{
+		"prefix": NewCdkBlobStorage(log.New("testStorageLogger"), bucket, Delimiter, NewPathFilters([]string{"/objects/"}, nil), nil, nil),
+		"regex":  NewCdkBlobStorage(log.New("testStorageLogger"), bucket, Delimiter, regexFilter, nil, &CdkBlobStorageOptions{TrashPrefix: ".trash"}),
+	}
+	t.Cleanup(func() {
+		_ = backends["prefix"].close()
+	})
+
+	for _, path := range []string{"/objects/4a5b6c7d", "/objects/9e8f7a6b", "/objects/readme.txt"} {
+		contents := []byte(path)
+		require.NoError(t, backends["prefix"].Upsert(ctx, &UpsertFileCommand{Path: path, Contents: &contents}))
+	}
+
+	t.Run("should list the files matching the filter", func(t *testing.T) {
+		prefixResp, err := backends["prefix"].ListFiles(ctx, "/objects", nil, nil)
+		require.NoError(t, err)
+		require.Len(t, prefixResp.Files, 3)
+
+		regexResp, err := backends["regex"].ListFiles(ctx, "/objects", nil, &ListOptions{Recursive: true})
+		require.NoError(t, err)
+		require.Len(t, regexResp.Files, 2)
+		require.Equal(t, "/objects/4a5b6c7d", regexResp.Files[0].FullPath)
+		require.Equal(t, "/objects/9e8f7a6b", regexResp.Files[1].FullPath)
+	})
+
+	t.Run("should not get the files not matching the filter", func(t *testing.T) {
+		file, err := backends["prefix"].Get(ctx, "/objects/readme.txt")
+		require.NoError(t, err)
+		require.NotNil(t, file)
+
+		file, err = backends["regex"].Get(ctx, "/objects/readme.txt")
+		require.ErrorIs(t, err, ErrFileNotFound)
+		require.Nil(t, file)
+
+		file, err = backends["regex"].Get(ctx, "/objects/4a5b6c7d")
+		require.NoError(t, err)
+		require.NotNil(t, file)
+	})
+}
+
+// racingBackend modifies the file right after it is read, as a concurrent writer would.
+type racingBackend struct {
+	FileStorage
+}
+
+func (b racingBackend) Get(ctx context.Context, path string) (*File, error) {
+	file, err := b.FileStorage.Get(ctx, path)
+	if err != nil || file == nil {
+		return file, err
+	}
+
+	contents := []byte("overwritten")
+	if err := b.FileStorage.Upsert(ctx, &UpsertFileCommand{Path: path, Contents: &contents}); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func TestFilestorage_Append(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"logs":    newTestMemBackend(t, nil, nil),
+		"limited": newTestMemBackend(t, nil, &CdkBlobStorageOptions{MaxFileSize: 10}),
+	})
+
+	t.Run("should create the file and append to it sequentially", func(t *testing.T) {
+		require.NoError(t, s.Append(ctx, "/logs/audit/audit.log", []byte("first\n")))
+		require.NoError(t, s.Append(ctx, "/logs/audit/audit.log", []byte("second\n")))
+		require.NoError(t, s.Append(ctx, "/logs/audit/audit.log", []byte("third\n")))
+
+		file, err := s.Get(ctx, "/logs/audit/audit.log")
+		require.NoError(t, err)
+		require.NotNil(t, file)
+		require.Equal(t, "first\nsecond\nthird\n", string(file.Contents))
+
+		folders, err := s.ListFolders(ctx, "/logs", nil)
+		require.NoError(t, err)
+		require.Len(t, folders, 1)
+		require.Equal(t, "/audit", folders[0].FullPath)
+	})
+
+	t.Run("should keep the properties of the file", func(t *testing.T) {
+		contents := []byte("a")
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/logs/props.log", Contents: &contents, Properties: map[string]string{"source": "test"}}))
+		require.NoError(t, s.Append(ctx, "/logs/props.log", []byte("b")))
+
+		file, err := s.Get(ctx, "/logs/props.log")
+		require.NoError(t, err)
+		require.Equal(t, "ab", string(file.Contents))
+		require.Equal(t, "test", file.Properties["source"])
+	})
+
+	t.Run("should fail if the file exceeds the max file size", func(t *testing.T) {
+		require.NoError(t, s.Append(ctx, "/limited/file.log", []byte("12345")))
+		require.ErrorIs(t, s.Append(ctx, "/limited/file.log", []byte("123456")), ErrFileTooLarge)
+	})
+
+	t.Run("should fail if the file is modified concurrently", func(t *testing.T) {
+		backend := newTestMemBackend(t, nil, nil)
+		contents := []byte("original")
+		require.NoError(t, backend.Upsert(ctx, &UpsertFileCommand{Path: "/file.log", Contents: &contents}))
+
+		err := appendByRewriting(ctx, racingBackend{FileStorage: backend}, "/file.log", []byte(" appended"))
+		require.ErrorIs(t, err, ErrConcurrentAppend)
+
+		file, err := backend.Get(ctx, "/file.log")
+		require.NoError(t, err)
+		require.Equal(t, "overwritten", string(file.Contents))
+	})
+}
+
+// failingBucket fails every read of the attributes of a file.
+type failingBucket struct {
+	driver.Bucket
+	err error
+}
+
+func (b *failingBucket) ErrorCode(err error) gcerrors.ErrorCode {
+	return gcerrors.Unknown
+}
+
+func (b *failingBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	return nil, b.err
+}
+
+func (b *failingBucket) Close() error {
+	return nil
+}
+
+func TestFilestorage_Exists(t *testing.T) {
+	ctx := context.Background()
+	errUnavailable := errors.New("unavailable")
+	s := newTestService(map[string]FileStorage{
+		"mem":    newTestMemBackend(t, nil, nil),
+		"broken": NewCdkBlobStorage(log.New("testStorageLogger"), blob.NewBucket(&failingBucket{err: errUnavailable}), Delimiter, nil, nil, nil),
+	})
+
+	contents := []byte("contents")
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/folder/File.txt", Contents: &contents}))
+
+	t.Run("should report an existing file", func(t *testing.T) {
+		exists, err := s.Exists(ctx, "/mem/folder/File.txt")
+		require.NoError(t, err)
+		require.True(t, exists)
+
+		exists, err = s.Exists(ctx, "/mem/folder/file.txt")
+		require.NoError(t, err)
+		require.True(t, exists)
+	})
+
+	t.Run("should report a missing file without an error", func(t *testing.T) {
+		exists, err := s.Exists(ctx, "/mem/folder/missing.txt")
+		require.NoError(t, err)
+		require.False(t, exists)
+
+		exists, err = s.Exists(ctx, "/unknown/file.txt")
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+
+	t.Run("should return the errors of the backend", func(t *testing.T) {
+		exists, err := s.Exists(ctx, "/broken/file.txt")
+		require.ErrorIs(t, err, errUnavailable)
+		require.False(t, exists)
+	})
+}
+
+func TestFilestorage_DeleteMany(t *testing.T) {
+	ctx := context.Background()
+	errUnavailable := errors.New("unavailable")
+	s := newTestService(map[string]FileStorage{
+		"first":     newTestMemBackend(t, nil, nil),
+		"second":    newTestMemBackend(t, nil, nil),
+		"read-only": newTestMemBackend(t, []Operation{OperationGet, OperationUpsert}, nil),
+		"broken":    NewCdkBlobStorage(log.New("testStorageLogger"), blob.NewBucket(&failingBucket{err: errUnavailable}), Delimiter, nil, nil, nil),
+	})
+
+	contents := []byte("contents")
+	for _, path := range []string{"/first/a.txt", "/first/folder/b.txt", "/second/c.txt", "/read-only/d.txt"} {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: path, Contents: &contents}))
+	}
+
+	deleted, errs := s.DeleteMany(ctx, []string{
+		"/first/a.txt",
+		"/first/missing.txt",
+		"/first/folder/b.txt",
+		"/second/c.txt",
+		"/second/missing.txt",
+		"/unknown/e.txt",
+		"/first/../second/c.txt",
+		"/read-only/d.txt",
+		"/broken/f.txt",
+	})
+
+	require.ElementsMatch(t, []string{"/first/a.txt", "/first/folder/b.txt", "/second/c.txt"}, deleted)
+	require.Len(t, errs, 3)
+	require.Error(t, errs["/first/../second/c.txt"])
+	require.ErrorIs(t, errs["/read-only/d.txt"], ErrOperationNotSupported)
+	require.ErrorIs(t, errs["/broken/f.txt"], errUnavailable)
+
+	for _, path := range []string{"/first/a.txt", "/first/folder/b.txt", "/second/c.txt"} {
+		exists, err := s.Exists(ctx, path)
+		require.NoError(t, err)
+		require.False(t, exists, path)
+	}
+
+	exists, err := s.Exists(ctx, "/read-only/d.txt")
+	require.NoError(t, err)
+	require.True(t, exists)
+}
+
+func TestFilestorage_ListFoldersWithFileCount(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+
+	contents := []byte("contents")
+	require.NoError(t, s.CreateFolder(ctx, "/mem/empty", nil))
+	for _, path := range []string{"/mem/populated/a.txt", "/mem/populated/B.txt", "/mem/populated/c.txt", "/mem/populated/nested/d.txt"} {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: path, Contents: &contents}))
+	}
+
+	countByPath := func(t *testing.T, options *ListOptions) map[string]int {
+		t.Helper()
+
+		folders, err := s.ListFolders(ctx, "/mem", options)
+		require.NoError(t, err)
+
+		counts := make(map[string]int)
+		for _, folder := range folders {
+			counts[folder.FullPath] = folder.FileCount
+		}
+		return counts
+	}
+
+	t.Run("should count the files directly in each folder", func(t *testing.T) {
+		require.Equal(t, map[string]int{
+			"/empty":            0,
+			"/populated":        3,
+			"/populated/nested": 1,
+		}, countByPath(t, &ListOptions{Recursive: true, IncludeFileCount: true}))
+	})
+
+	t.Run("should not count the files unless requested", func(t *testing.T) {
+		require.Equal(t, map[string]int{
+			"/empty":            0,
+			"/populated":        0,
+			"/populated/nested": 0,
+		}, countByPath(t, &ListOptions{Recursive: true}))
+	})
+}
+
+func TestFilestorage_AllowedPaths(t *testing.T) {
+	ctx := context.Background()
+	bucket, err := blob.OpenBucket(ctx, "mem://")
+	require.NoError(t, err)
+	s := newTestService(map[string]FileStorage{
+		"all":      NewCdkBlobStorage(log.New("testStorageLogger"), bucket, Delimiter, nil, nil, nil),
+		"exact":    NewCdkBlobStorage(log.New("testStorageLogger"), bucket, Delimiter, NewPathFiltersWithAllowedPaths(nil, []string{"/logo.png"}, nil), nil, nil),
+		"prefix":   NewCdkBlobStorage(log.New("testStorageLogger"), bucket, Delimiter, NewPathFilters([]string{"/public/"}, nil), nil, nil),
+		"combined": NewCdkBlobStorage(log.New("testStorageLogger"), bucket, Delimiter, NewPathFiltersWithAllowedPaths([]string{"/public/"}, []string{"/logo.png"}, nil), nil, nil),
+	})
+
+	contents := []byte("contents")
+	for _, path := range []string{
+		"/all/logo.png",
+		"/all/logo.png.bak",
+		"/all/favicon.ico",
+		"/all/public/image.png",
+		"/all/private/image.png",
+	} {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: path, Contents: &contents}))
+	}
+
+	listPaths := func(t *testing.T, backend string) []string {
+		t.Helper()
+
+		resp, err := s.ListFiles(ctx, "/"+backend, nil, &ListOptions{Recursive: true})
+		require.NoError(t, err)
+
+		paths := make([]string, 0, len(resp.Files))
+		for _, file := range resp.Files {
+			paths = append(paths, file.FullPath)
+		}
+		return paths
+	}
+
+	t.Run("should expose the exact allowed path only", func(t *testing.T) {
+		require.Equal(t, []string{"/logo.png"}, listPaths(t, "exact"))
+
+		file, err := s.Get(ctx, "/exact/logo.png")
+		require.NoError(t, err)
+		require.Equal(t, contents, file.Contents)
+
+		_, err = s.Get(ctx, "/exact/logo.png.bak")
+		require.ErrorIs(t, err, ErrFileNotFound)
+	})
+
+	t.Run("should expose the allowed prefix only", func(t *testing.T) {
+		require.Equal(t, []string{"/public/image.png"}, listPaths(t, "prefix"))
+
+		_, err := s.Get(ctx, "/prefix/logo.png")
+		require.ErrorIs(t, err, ErrFileNotFound)
+	})
+
+	t.Run("should expose the allowed prefix and the exact allowed path", func(t *testing.T) {
+		require.Equal(t, []string{"/logo.png", "/public/image.png"}, listPaths(t, "combined"))
+
+		_, err := s.Get(ctx, "/combined/logo.png")
+		require.NoError(t, err)
+		_, err = s.Get(ctx, "/combined/favicon.ico")
+		require.ErrorIs(t, err, ErrFileNotFound)
+	})
+}
+
+func TestFilestorage_ListFoldersTree(t *testing.T) {
+	ctx := context.Background()
+	bucket, err := blob.OpenBucket(ctx, "mem://")
+	require.NoError(t, err)
+	s := newTestService(map[string]FileStorage{
+		"all":      NewCdkBlobStorage(log.New("testStorageLogger"), bucket, Delimiter, nil, nil, nil),
+		"filtered": NewCdkBlobStorage(log.New("testStorageLogger"), bucket, Delimiter, NewPathFilters(nil, []string{"/private/"}), nil, nil),
+	})
+
+	contents := []byte("contents")
+	for _, path := range []string{
+		"/all/root.txt",
+		"/all/alpha/beta/gamma/a.txt",
+		"/all/alpha/delta/b.txt",
+		"/all/Zeta/c.txt",
+		"/all/private/secret/d.txt",
+	} {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: path, Contents: &contents}))
+	}
+
+	listPaths := func(t *testing.T, folderPath string, options *ListOptions) []string {
+		t.Helper()
+
+		folders, err := s.ListFolders(ctx, folderPath, options)
+		require.NoError(t, err)
+
+		paths := make([]string, 0, len(folders))
+		for _, folder := range folders {
+			paths = append(paths, folder.FullPath)
+		}
+		return paths
+	}
+
+	t.Run("should list the whole tree sorted by path", func(t *testing.T) {
+		require.Equal(t, []string{
+			"/Zeta",
+			"/alpha",
+			"/alpha/beta",
+			"/alpha/beta/gamma",
+			"/alpha/delta",
+			"/private",
+			"/private/secret",
+		}, listPaths(t, "/all", &ListOptions{Recursive: true}))
+	})
+
+	t.Run("should not list the folders denied by the path filters", func(t *testing.T) {
+		require.Equal(t, []string{
+			"/Zeta",
+			"/alpha",
+			"/alpha/beta",
+			"/alpha/beta/gamma",
+			"/alpha/delta",
+		}, listPaths(t, "/filtered", &ListOptions{Recursive: true}))
+	})
+
+	t.Run("should stop at the max depth", func(t *testing.T) {
+		require.Equal(t, []string{
+			"/Zeta",
+			"/alpha",
+			"/alpha/beta",
+			"/alpha/delta",
+		}, listPaths(t, "/filtered", &ListOptions{Recursive: true, MaxDepth: 2}))
+
+		require.Equal(t, []string{
+			"/alpha/beta",
+			"/alpha/delta",
+		}, listPaths(t, "/filtered/alpha", &ListOptions{Recursive: true, MaxDepth: 1}))
+	})
+}
+
+func TestFilestorage_ListFilesDirectChildrenOnly(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+
+	contents := []byte("contents")
+	for _, path := range []string{"/mem/root.txt", "/mem/folder/a.txt", "/mem/folder/nested/b.txt", "/mem/folder/nested/deeper/c.txt"} {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: path, Contents: &contents}))
+	}
+
+	listPaths := func(t *testing.T, options *ListOptions) []string {
+		t.Helper()
+
+		resp, err := s.ListFiles(ctx, "/mem/folder", nil, options)
+		require.NoError(t, err)
+
+		paths := make([]string, 0, len(resp.Files))
+		for _, file := range resp.Files {
+			paths = append(paths, file.FullPath)
+		}
+		return paths
+	}
+
+	t.Run("should list the direct children only", func(t *testing.T) {
+		require.Equal(t, []string{"/folder/a.txt"}, listPaths(t, &ListOptions{DirectChildrenOnly: true}))
+	})
+
+	t.Run("should list the direct children only by default", func(t *testing.T) {
+		require.Equal(t, []string{"/folder/a.txt"}, listPaths(t, nil))
+		require.Equal(t, []string{"/folder/a.txt"}, listPaths(t, &ListOptions{}))
+	})
+
+	t.Run("should list the nested files when recursive", func(t *testing.T) {
+		require.Equal(t, []string{"/folder/a.txt", "/folder/nested/b.txt", "/folder/nested/deeper/c.txt"}, listPaths(t, &ListOptions{Recursive: true}))
+	})
+
+	t.Run("should reject both options together", func(t *testing.T) {
+		_, err := s.ListFiles(ctx, "/mem/folder", nil, &ListOptions{Recursive: true, DirectChildrenOnly: true})
+		require.Error(t, err)
+
+		_, err = s.ListFolders(ctx, "/mem/folder", &ListOptions{Recursive: true, DirectChildrenOnly: true})
+		require.Error(t, err)
+	})
+}
+
+func TestFilestorage_ListFilesTotalCount(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+
+	contents := []byte("contents")
+	for _, path := range []string{
+		"/mem/root.txt",
+		"/mem/folder/a.txt",
+		"/mem/folder/b.json",
+		"/mem/folder/c.png",
+		"/mem/folder/nested/d.txt",
+		"/mem/folder/nested/deeper/e.json",
+		"/mem/folder/denied/f.txt",
+	} {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: path, Contents: &contents}))
+	}
+	require.NoError(t, s.CreateFolder(ctx, "/mem/folder/empty", nil))
+
+	var tests = []struct {
+		name     string
+		paging   *Paging
+		options  *ListOptions
+		expected int
+	}{
+		{
+			name:     "should count the direct children",
+			options:  &ListOptions{IncludeTotalCount: true},
+			expected: 3,
+		},
+		{
+			name:     "should count the nested files when recursive",
+			options:  &ListOptions{Recursive: true, IncludeTotalCount: true},
+			expected: 6,
+		},
+		{
+			name:     "should count the files of all pages",
+			paging:   &Paging{First: 2},
+			options:  &ListOptions{Recursive: true, IncludeTotalCount: true},
+			expected: 6,
+		},
+		{
+			name:     "should count the files matching the filter",
+			options:  &ListOptions{Recursive: true, Filter: "*.json", IncludeTotalCount: true},
+			expected: 2,
+		},
+		{
+			name:     "should count the files matching the MIME types",
+			options:  &ListOptions{Recursive: true, MimeTypeFilter: []string{"image/*"}, IncludeTotalCount: true},
+			expected: 1,
+		},
+		{
+			name:     "should count the allowed files only",
+			options:  &ListOptions{Recursive: true, PathFilters: *NewPathFilters(nil, []string{"/folder/denied/"}), IncludeTotalCount: true},
+			expected: 5,
+		},
+		{
+			name:     "should not count unless requested",
+			options:  &ListOptions{Recursive: true},
+			expected: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.ListFiles(ctx, "/mem/folder", tt.paging, tt.options)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, resp.TotalCount)
+		})
+	}
+}
+
+func TestFilestorage_ListFilesMaxDepth(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+
+	contents := []byte("contents")
+	for _, path := range []string{
+		"/mem/root.txt",
+		"/mem/a/1.txt",
+		"/mem/a/b/2.txt",
+		"/mem/a/b/c/3.txt",
+		"/mem/a/b/c/d/4.txt",
+		"/mem/z.txt",
+	} {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: path, Contents: &contents}))
+	}
+
+	listAll := func(t *testing.T, folderPath string, first int, options *ListOptions) ([]string, int) {
+		paths := make([]string, 0)
+		pages := 0
+		paging := &Paging{First: first}
+		for {
+			resp, err := s.ListFiles(ctx, folderPath, paging, options)
+			require.NoError(t, err)
+			pages++
+			require.Less(t, pages, 20, "paging should end")
+
+			for _, file := range resp.Files {
+				paths = append(paths, file.FullPath)
+			}
+
+			if !resp.HasMore {
+				return paths, pages
+			}
+			paging = &Paging{First: first, After: resp.LastPath}
+		}
+	}
+
+	t.Run("should not descend below the max depth", func(t *testing.T) {
+		resp, err := s.ListFiles(ctx, "/mem", &Paging{First: 100}, &ListOptions{Recursive: true, MaxDepth: 2})
+		require.NoError(t, err)
+		require.Len(t, resp.Files, 3)
+		require.Equal(t, "/a/1.txt", resp.Files[0].FullPath)
+		require.Equal(t, "/root.txt", resp.Files[1].FullPath)
+		require.Equal(t, "/z.txt", resp.Files[2].FullPath)
+		require.True(t, resp.HasMore)
+
+		paths, _ := listAll(t, "/mem", 100, &ListOptions{Recursive: true, MaxDepth: 3})
+		require.Equal(t, []string{"/a/1.txt", "/a/b/2.txt", "/root.txt", "/z.txt"}, paths)
+	})
+
+	t.Run("should end the paging when the page ends with a folder which is too deep", func(t *testing.T) {
+		resp, err := s.ListFiles(ctx, "/mem/a", &Paging{First: 100}, &ListOptions{Recursive: true, MaxDepth: 1})
+		require.NoError(t, err)
+		require.Len(t, resp.Files, 1)
+		require.True(t, resp.HasMore)
+		require.Equal(t, "/a/b", resp.LastPath)
+
+		paths, pages := listAll(t, "/mem/a", 100, &ListOptions{Recursive: true, MaxDepth: 1})
+		require.Equal(t, []string{"/a/1.txt"}, paths)
+		require.Equal(t, 2, pages)
+	})
+
+	t.Run("should list every file within the max depth across pages", func(t *testing.T) {
+		paths, _ := listAll(t, "/mem", 1, &ListOptions{Recursive: true, MaxDepth: 3})
+		require.Equal(t, []string{"/a/1.txt", "/a/b/2.txt", "/root.txt", "/z.txt"}, paths)
+	})
+
+	t.Run("should list all levels without a max depth", func(t *testing.T) {
+		paths, pages := listAll(t, "/mem", 100, &ListOptions{Recursive: true})
+		require.Len(t, paths, 6)
+		require.Equal(t, 1, pages)
+	})
+
+	t.Run("should count the files within the max depth", func(t *testing.T) {
+		resp, err := s.ListFiles(ctx, "/mem", &Paging{First: 1}, &ListOptions{Recursive: true, MaxDepth: 2, IncludeTotalCount: true})
+		require.NoError(t, err)
+		require.Equal(t, 3, resp.TotalCount)
+	})
+
+	t.Run("should reject a negative max depth", func(t *testing.T) {
+		_, err := s.ListFiles(ctx, "/mem", &Paging{First: 100}, &ListOptions{Recursive: true, MaxDepth: -1})
+		require.Error(t, err)
+	})
+}
+
+func TestFilestorage_ListFilesSortBy(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+
+	// written in a different order than their names and sizes sort in
+	for _, file := range []struct {
+		path     string
+		contents string
+	}{
+		{path: "/mem/folder/b.txt", contents: "bbb"},
+		{path: "/mem/folder/C.txt", contents: "c"},
+		{path: "/mem/folder/a.txt", contents: "aa"},
+	} {
+		contents := []byte(file.contents)
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: file.path, Contents: &contents}))
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	listNames := func(t *testing.T, paging *Paging, options *ListOptions) ([]string, *ListFilesResponse) {
+		t.Helper()
+
+		resp, err := s.ListFiles(ctx, "/mem/folder", paging, options)
+		require.NoError(t, err)
+
+		names := make([]string, 0, len(resp.Files))
+		for _, file := range resp.Files {
+			names = append(names, file.Name)
+		}
+		return names, resp
+	}
+
+	var tests = []struct {
+		name     string
+		options  *ListOptions
+		expected []string
+	}{
+		{name: "should keep the order of the paths by default", options: &ListOptions{}, expected: []string{"a.txt", "b.txt", "C.txt"}},
+		{name: "should sort by name", options: &ListOptions{SortBy: SortByName}, expected: []string{"a.txt", "b.txt", "C.txt"}},
+		{name: "should sort by name descending", options: &ListOptions{SortBy: SortByName, SortDesc: true}, expected: []string{"C.txt", "b.txt", "a.txt"}},
+		{name: "should sort by size", options: &ListOptions{SortBy: SortBySize}, expected: []string{"C.txt", "a.txt", "b.txt"}},
+		{name: "should sort by size descending", options: &ListOptions{SortBy: SortBySize, SortDesc: true}, expected: []string{"b.txt", "a.txt", "C.txt"}},
+		{name: "should sort by modified time", options: &ListOptions{SortBy: SortByModified}, expected: []string{"b.txt", "C.txt", "a.txt"}},
+		{name: "should sort by modified time descending", options: &ListOptions{SortBy: SortByModified, SortDesc: true}, expected: []string{"a.txt", "C.txt", "b.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, _ := listNames(t, nil, tt.options)
+			require.Equal(t, tt.expected, names)
+		})
+	}
+
+	t.Run("should sort within the page and keep the cursor", func(t *testing.T) {
+		names, resp := listNames(t, &Paging{First: 2}, &ListOptions{SortBy: SortBySize, SortDesc: true})
+		require.Equal(t, []string{"b.txt", "a.txt"}, names)
+		require.True(t, resp.HasMore)
+		require.Equal(t, "/folder/b.txt", resp.LastPath)
+
+		names, _ = listNames(t, &Paging{First: 2, After: resp.LastPath}, &ListOptions{SortBy: SortBySize, SortDesc: true})
+		require.Equal(t, []string{"C.txt"}, names)
+	})
+
+	t.Run("should reject unknown sort fields", func(t *testing.T) {
+		_, err := s.ListFiles(ctx, "/mem/folder", nil, &ListOptions{SortBy: "owner"})
+		require.Error(t, err)
+	})
+}
+
+func TestFilestorage_BackendNames(t *testing.T) {
+	var tests = []struct {
+		name        string
+		backendName string
+		errContains string
+	}{
+		{name: "should accept a plain name", backendName: "team-images_v2.0"},
+		{name: "should reject an empty name", backendName: "", errContains: "can not be empty"},
+		{name: "should reject a name with a delimiter", backendName: "team/images", errContains: `can not contain "/"`},
+		{name: "should reject a name with leading whitespace", backendName: " images", errContains: "whitespace"},
+		{name: "should reject a name with unsafe characters", backendName: "images?", errContains: "contain only"},
+		{name: "should reject a name starting with a dot", backendName: "..", errContains: "must start with"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(map[string]FileStorage{})
+			err := s.registerBackend(backendConfig{Name: tt.backendName}, &dummyFileStorage{})
+			if tt.errContains == "" {
+				require.NoError(t, err)
+				require.Contains(t, s.backendByName, tt.backendName)
+				return
+			}
+
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.errContains)
+			require.Empty(t, s.backendByName)
+		})
+	}
+
+	t.Run("should reject configured backends with invalid names", func(t *testing.T) {
+		fsConfig, err := newConfig(newTestCfg(t, "[file_storage.backend.team/images]\ntype = mem"))
+		require.NoError(t, err)
+
+		s := newTestService(map[string]FileStorage{})
+		err = s.registerBackends(context.Background(), fsConfig, nil, featuremgmt.WithFeatures())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `invalid file storage backend name "team/images"`)
+	})
+}
+
+func TestFilestorage_DefaultListOptions(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"images": newTestMemBackend(t, nil, &CdkBlobStorageOptions{
+			DefaultListOptions: &ListOptions{
+				MimeTypeFilter:   []string{"image/*"},
+				IncludeFileCount: true,
+			},
+		}),
+	})
+
+	contents := []byte("contents")
+	for _, path := range []string{"/images/icons/a.png", "/images/icons/b.svg", "/images/icons/readme.txt"} {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: path, Contents: &contents}))
+	}
+
+	t.Run("should apply the backend defaults when the caller passes nil options", func(t *testing.T) {
+		resp, err := s.ListFiles(ctx, "/images/icons", nil, nil)
+		require.NoError(t, err)
+		require.Len(t, resp.Files, 2)
+		require.Equal(t, "/icons/a.png", resp.Files[0].FullPath)
+		require.Equal(t, "/icons/b.svg", resp.Files[1].FullPath)
+
+		folders, err := s.ListFolders(ctx, "/images", nil)
+		require.NoError(t, err)
+		require.Len(t, folders, 1)
+		require.Equal(t, 3, folders[0].FileCount)
+	})
+
+	t.Run("should prefer the options of the caller", func(t *testing.T) {
+		resp, err := s.ListFiles(ctx, "/images/icons", nil, &ListOptions{MimeTypeFilter: []string{"text/plain"}})
+		require.NoError(t, err)
+		require.Len(t, resp.Files, 1)
+		require.Equal(t, "/icons/readme.txt", resp.Files[0].FullPath)
+	})
+}
+
+func TestFilestorage_CloseWithContext(t *testing.T) {
+	newService := func() *service {
+		return newTestService(map[string]FileStorage{
+			"slow": slowBackend{delay: 200 * time.Millisecond},
+		})
+	}
+
+	startGet := func(t *testing.T, s *service) chan error {
+		t.Helper()
+
+		result := make(chan error, 1)
+		go func() {
+			_, err := s.Get(context.Background(), "/slow/file.txt")
+			result <- err
+		}()
+
+		require.Eventually(t, func() bool {
+			return s.operations.inFlight() == 1
+		}, time.Second, time.Millisecond)
+		return result
+	}
+
+	t.Run("should wait for the operations in flight", func(t *testing.T) {
+		s := newService()
+		result := startGet(t, s)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		require.NoError(t, s.closeWithContext(ctx))
+		require.Equal(t, 0, s.operations.inFlight())
+		require.NoError(t, <-result)
+	})
+
+	t.Run("should stop waiting once the grace deadline expires", func(t *testing.T) {
+		s := newService()
+		result := startGet(t, s)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		err := s.closeWithContext(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Less(t, time.Since(start), 150*time.Millisecond)
+		require.NoError(t, <-result)
+	})
+
+	t.Run("should close right away without operations in flight", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		require.NoError(t, newService().closeWithContext(ctx))
+	})
+}
+
+// failingCloseBackend fails to close with the given error.
+type failingCloseBackend struct {
+	dummyFileStorage
+	err error
+}
+
+func (b failingCloseBackend) close() error {
+	return b.err
+}
+
+func TestFilestorage_CloseErrors(t *testing.T) {
+	errFirst := errors.New("first backend is gone")
+	errSecond := errors.New("second backend is gone")
+	s := newTestService(map[string]FileStorage{
+		"first":  failingCloseBackend{err: errFirst},
+		"second": failingCloseBackend{err: errSecond},
+		"ok":     &dummyFileStorage{},
+	})
+
+	err := s.close()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to close file storage backend first: first backend is gone")
+	require.Contains(t, err.Error(), "failed to close file storage backend second: second backend is gone")
+	require.ErrorIs(t, err, errFirst)
+	require.ErrorIs(t, err, errSecond)
+
+	require.NoError(t, joinErrors(nil, nil))
+}
+
+func TestFilestorage_FileNotFound(t *testing.T) {
+	ctx := context.Background()
+	contents := []byte("contents")
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/file.txt", Contents: &contents}))
+
+	for _, path := range []string{"/mem/missing.txt", "/mem/file.txt/missing.txt", "/mem/folder/file.txt"} {
+		file, err := s.Get(ctx, path)
+		require.True(t, errors.Is(err, ErrFileNotFound), path)
+		require.Nil(t, file, path)
+
+		metadata, err := s.GetMetadata(ctx, path)
+		require.True(t, errors.Is(err, ErrFileNotFound), path)
+		require.Nil(t, metadata, path)
+
+		require.True(t, errors.Is(s.Delete(ctx, path), ErrFileNotFound), path)
+	}
+
+	t.Run("should not notify the listeners when the file is not found", func(t *testing.T) {
+		listener := &recordingListener{events: make(chan string, 10)}
+		s.AddListener(listener)
+
+		require.ErrorIs(t, s.Delete(ctx, "/mem/missing.txt"), ErrFileNotFound)
+		require.Empty(t, listener.events)
+	})
+
+	t.Run("should report missing files once they are deleted", func(t *testing.T) {
+		require.NoError(t, s.Delete(ctx, "/mem/file.txt"))
+		require.ErrorIs(t, s.Delete(ctx, "/mem/file.txt"), ErrFileNotFound)
+
+		_, err := s.Get(ctx, "/mem/file.txt")
+		require.ErrorIs(t, err, ErrFileNotFound)
+	})
+}
+
+func TestFilestorage_CreateExistingFolder(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+	require.NoError(t, s.CreateFolder(ctx, "/mem/folder/nested", nil))
+
+	t.Run("should do nothing by default", func(t *testing.T) {
+		require.NoError(t, s.CreateFolder(ctx, "/mem/folder/nested", nil))
+		require.NoError(t, s.CreateFolder(ctx, "/mem/folder", &CreateFolderOptions{}))
+
+		folders, err := s.ListFolders(ctx, "/mem", &ListOptions{Recursive: true})
+		require.NoError(t, err)
+		require.Len(t, folders, 2)
+	})
+
+	t.Run("should fail if asked to", func(t *testing.T) {
+		err := s.CreateFolder(ctx, "/mem/folder/nested", &CreateFolderOptions{FailIfExists: true})
+		require.ErrorIs(t, err, ErrFolderAlreadyExists)
+
+		err = s.CreateFolder(ctx, "/mem/Folder", &CreateFolderOptions{FailIfExists: true})
+		require.ErrorIs(t, err, ErrFolderAlreadyExists)
+
+		require.NoError(t, s.CreateFolder(ctx, "/mem/folder/other", &CreateFolderOptions{FailIfExists: true}))
+	})
+}
+
+func TestFilestorage_CreateNestedFolders(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should create all missing parent folders", func(t *testing.T) {
+		s := newTestService(map[string]FileStorage{
+			"mem": newTestMemBackend(t, nil, nil),
+		})
+		require.NoError(t, s.CreateFolder(ctx, "/mem/a/B/c/d", nil))
+
+		folders, err := s.ListFolders(ctx, "/mem", &ListOptions{Recursive: true})
+		require.NoError(t, err)
+		paths := make([]string, 0, len(folders))
+		for _, folder := range folders {
+			paths = append(paths, folder.FullPath)
+		}
+		require.Equal(t, []string{"/a", "/a/B", "/a/B/c", "/a/B/c/d"}, paths)
+
+		require.NoError(t, s.CreateFolder(ctx, "/mem/a/b/e", nil))
+		folders, err = s.ListFolders(ctx, "/mem/a/B", nil)
+		require.NoError(t, err)
+		require.Len(t, folders, 3)
+	})
+
+	t.Run("should not mark the parent folders which are not allowed", func(t *testing.T) {
+		bucket, err := blob.OpenBucket(ctx, "mem://")
+		require.NoError(t, err)
+		filter, err := NewRegexPathFilter(nil, []string{"^/deep/denied$"})
+		require.NoError(t, err)
+		s := newTestService(map[string]FileStorage{
+			"mem": NewCdkBlobStorage(log.New("testStorageLogger"), bucket, Delimiter, filter, nil, nil),
+		})
+
+		require.NoError(t, s.CreateFolder(ctx, "/mem/deep/denied/nested/leaf", nil))
+		for key, expected := range map[string]bool{
+			"/deep/":                    true,
+			"/deep/denied/":             false,
+			"/deep/denied/nested/":      true,
+			"/deep/denied/nested/leaf/": true,
+		} {
+			exists, err := bucket.Exists(ctx, key+directoryMarker)
+			require.NoError(t, err)
+			require.Equal(t, expected, exists, key)
+		}
+
+		require.ErrorIs(t, s.CreateFolder(ctx, "/mem/deep/denied", nil), ErrPathNotAllowed)
+		exists, err := bucket.Exists(ctx, "/deep/denied/"+directoryMarker)
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+}
+
+func TestFilestorage_ContentAddressable(t *testing.T) {
+	ctx := context.Background()
+	bucket, err := blob.OpenBucket(ctx, "mem://")
+	require.NoError(t, err)
+	s := newTestService(map[string]FileStorage{
+		"mem": NewCdkBlobStorage(log.New("testStorageLogger"), bucket, Delimiter, nil, nil, &CdkBlobStorageOptions{ContentAddressable: true}),
+	})
+
+	storedContents := func(t *testing.T) int {
+		t.Helper()
+		iterator := bucket.List(&blob.ListOptions{Prefix: contentKey("")})
+		count := 0
+		for {
+			_, err := iterator.Next(ctx)
+			if errors.Is(err, io.EOF) {
+				return count
+			}
+			require.NoError(t, err)
+			count++
+		}
+	}
+
+	shared := []byte("shared contents")
+	other := []byte("other contents")
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/a.txt", Contents: &shared}))
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/folder/B.txt", Contents: &shared}))
+	require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/c.txt", Contents: &other}))
+
+	t.Run("should store identical contents once", func(t *testing.T) {
+		require.Equal(t, 2, storedContents(t))
+
+		a, err := s.Get(ctx, "/mem/a.txt")
+		require.NoError(t, err)
+		b, err := s.Get(ctx, "/mem/folder/B.txt")
+		require.NoError(t, err)
+		require.Equal(t, shared, a.Contents)
+		require.Equal(t, shared, b.Contents)
+		require.Equal(t, a.ETag, b.ETag)
+		require.Equal(t, int64(len(shared)), b.Size)
+		require.Equal(t, "/folder/B.txt", b.FullPath)
+		require.Empty(t, b.Properties)
+	})
+
+	t.Run("should not list the stored contents", func(t *testing.T) {
+		resp, err := s.ListFiles(ctx, "/mem", nil, &ListOptions{Recursive: true})
+		require.NoError(t, err)
+		require.Len(t, resp.Files, 3)
+		for _, file := range resp.Files {
+			require.NotZero(t, file.Size, file.FullPath)
+		}
+
+		folders, err := s.ListFolders(ctx, "/mem", &ListOptions{Recursive: true})
+		require.NoError(t, err)
+		require.Len(t, folders, 1)
+		require.Equal(t, "/folder", folders[0].FullPath)
+	})
+
+	t.Run("should keep the contents until no file references them", func(t *testing.T) {
+		require.NoError(t, s.Delete(ctx, "/mem/a.txt"))
+		require.Equal(t, 2, storedContents(t))
+
+		b, err := s.Get(ctx, "/mem/folder/B.txt")
+		require.NoError(t, err)
+		require.Equal(t, shared, b.Contents)
+
+		require.NoError(t, s.Delete(ctx, "/mem/folder/B.txt"))
+		require.Equal(t, 1, storedContents(t))
+	})
+
+	t.Run("should release the contents of replaced and moved files", func(t *testing.T) {
+		require.NoError(t, s.Copy(ctx, "/mem/c.txt", "/mem/d.txt"))
+		require.NoError(t, s.Move(ctx, "/mem/c.txt", "/mem/e.txt"))
+		require.Equal(t, 1, storedContents(t))
+
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/d.txt", Contents: &shared}))
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: "/mem/e.txt", Contents: &shared}))
+		require.Equal(t, 1, storedContents(t))
+
+		e, err := s.Get(ctx, "/mem/e.txt")
+		require.NoError(t, err)
+		require.Equal(t, shared, e.Contents)
+	})
+}
+
+func TestFilestorage_WalkFiles(t *testing.T) {
+	ctx := context.Background()
+	contents := []byte("contents")
+	s := newTestService(map[string]FileStorage{
+		"mem": newTestMemBackend(t, nil, nil),
+	})
+	for i := 0; i < 150; i++ {
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: fmt.Sprintf("/mem/folder/%d/file.txt", i%3), Contents: &contents}))
+		require.NoError(t, s.Upsert(ctx, &UpsertFileCommand{Path: fmt.Sprintf("/mem/folder/file-%d.txt", i), Contents: &contents}))
+	}
+
+	count := func(path string, options *ListOptions) int {
+		t.Helper()
+		count := 0
+		require.NoError(t, s.WalkFiles(ctx, path, options, func(file FileMetadata) error {
+			count++
+			return nil
+		}))
+		return count
+	}
+
+	t.Run("should call the function for every file", func(t *testing.T) {
+		require.Equal(t, 153, count("/mem/folder", &ListOptions{Recursive: true}))
+		require.Equal(t, 150, count("/mem/folder", nil))
+		require.Equal(t, 1, count("/mem/folder/1", nil))
+		require.Equal(t, 0, count("/mem/missing", &ListOptions{Recursive: true}))
+	})
+
+	t.Run("should apply the list options", func(t *testing.T) {
+		require.Equal(t, 3, count("/mem/folder", &ListOptions{Recursive: true, Filter: "file.txt"}))
+		require.Equal(t, 150, count("/mem", &ListOptions{Recursive: true, MaxDepth: 2}))
+	})
+
+	t.Run("should stop at the first error of the function", func(t *testing.T) {
+		errStop := errors.New("stop")
+		calls := 0
+		err := s.WalkFiles(ctx, "/mem/folder", &ListOptions{Recursive: true}, func(file FileMetadata) error {
+			calls++
+			if calls == 10 {
+				return errStop
+			}
+			return nil
+		})
+		require.ErrorIs(t, err, errStop)
+		require.Equal(t, 10, calls)
+	})
+}
+
+// concurrencyCountingBackend records the highest number of DeleteMany calls running at once.
+type concurrencyCountingBackend struct {
+	dummyFileStorage
+	mu         *sync.Mutex
+	running    *int
+	maxRunning *int
+}
+
+func (b concurrencyCountingBackend) DeleteMany(ctx context.Context, paths []string) ([]string, map[string]error) {
+	b.mu.Lock()
+	*b.running++
+	if *b.running > *b.maxRunning {
+		*b.maxRunning = *b.running
+	}
+	b.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	b.mu.Lock()
+	*b.running--
+	b.mu.Unlock()
+	return paths, nil
+}
+
+func TestFilestorage_MaxConcurrency(t *testing.T) {
+	ctx := context.Background()
+	mu := &sync.Mutex{}
+	running, maxRunning := 0, 0
+
+	backends := make(map[string]FileStorage)
+	paths := make([]string, 0)
+	for i := 0; i < 6; i++ {
+		name := fmt.Sprintf("backend%d", i)
+		backends[name] = concurrencyCountingBackend{mu: mu, running: &running, maxRunning: &maxRunning}
+		paths = append(paths, fmt.Sprintf("/%s/file.txt", name))
+	}
+
+	t.Run("should run the backend operations concurrently", func(t *testing.T) {
+		s := newTestService(backends)
+		deleted, errs := s.DeleteMany(ctx, paths)
+		require.Empty(t, errs)
+		require.ElementsMatch(t, paths, deleted)
+		require.Greater(t, maxRunning, 1)
+	})
+
+	t.Run("should run at most max concurrency backend operations at once", func(t *testing.T) {
+		maxRunning = 0
+		s := newTestService(backends)
+		s.limiter = newConcurrencyLimiter(2)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				deleted, errs := s.DeleteMany(ctx, paths)
+				assert.Empty(t, errs)
+				assert.Len(t, deleted, len(paths))
+			}()
+		}
+		wg.Wait()
+
+		require.LessOrEqual(t, maxRunning, 2)
+		require.Zero(t, running)
+	})
+
+	t.Run("should fail the operations waiting for a slot once the context is done", func(t *testing.T) {
+		s := newTestService(backends)
+		s.limiter = newConcurrencyLimiter(1)
+		require.NoError(t, s.limiter.acquire(ctx))
+		defer s.limiter.release()
+
+		cancelledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		deleted, errs := s.DeleteMany(cancelledCtx, paths)
+		require.Empty(t, deleted)
+		require.Len(t, errs, len(paths))
+		require.ErrorIs(t, errs[paths[0]], context.Canceled)
+	})
+}
@@ -61,13 +61,13 @@ func runTests(createCases func() []fsTestCase, t *testing.T) {
 	setupInMemFS := func() {
 		commonSetup()
 		bucket, _ := blob.OpenBucket(context.Background(), "mem://")
-		filestorage = NewCdkBlobStorage(testLogger, bucket, Delimiter, nil)
+		filestorage = NewCdkBlobStorage(testLogger, bucket, Delimiter, nil, nil, nil)
 	}
 
 	//setupSqlFS := func() {
 	//	commonSetup()
 	//	sqlStore = sqlstore.InitTestDB(t)
-	//	filestorage = NewDbStorage(testLogger, sqlStore, nil)
+	//	filestorage = NewDbStorage(testLogger, sqlStore, nil, nil)
 	//}
 
 	setupLocalFs := func() {
@@ -82,7 +82,7 @@ func runTests(createCases func() []fsTestCase, t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		filestorage = NewCdkBlobStorage(testLogger, bucket, "", nil)
+		filestorage = NewCdkBlobStorage(testLogger, bucket, "", nil, nil, nil)
 	}
 
 	backends := []struct {
@@ -526,6 +526,171 @@ func TestFsStorage(t *testing.T) {
 					},
 				},
 			},
+			{
+				name: "copying a file",
+				steps: []interface{}{
+					cmdUpsert{
+						cmd: UpsertFileCommand{
+							Path:       "/folder1/File.png",
+							Contents:   &pngImage,
+							Properties: map[string]string{"prop1": "val1"},
+						},
+					},
+					cmdCopy{
+						srcPath: "/folder1/File.png",
+						dstPath: "/folder2/nested/Copy.png",
+					},
+					queryGet{
+						input: queryGetInput{
+							path: "/folder1/File.png",
+						},
+						checks: checks(
+							fName("File.png"),
+							fContents(pngImage),
+						),
+					},
+					queryGet{
+						input: queryGetInput{
+							path: "/folder2/nested/Copy.png",
+						},
+						checks: checks(
+							fName("Copy.png"),
+							fPath("/folder2/nested/Copy.png"),
+							fProperties(map[string]string{"prop1": "val1"}),
+							fMimeType("image/png"),
+							fContents(pngImage),
+						),
+					},
+					queryListFolders{
+						input: queryListFoldersInput{path: "/", options: &ListOptions{Recursive: true}},
+						checks: [][]interface{}{
+							checks(fPath("/folder1")),
+							checks(fPath("/folder2")),
+							checks(fPath("/folder2/nested")),
+						},
+					},
+				},
+			},
+			{
+				name: "copying a file onto an existing file overwrites it",
+				steps: []interface{}{
+					cmdUpsert{
+						cmd: UpsertFileCommand{
+							Path:     "/folder1/a.png",
+							Contents: &pngImage,
+						},
+					},
+					cmdUpsert{
+						cmd: UpsertFileCommand{
+							Path:     "/folder1/b.png",
+							Contents: &emptyFileBytes,
+						},
+					},
+					cmdCopy{
+						srcPath: "/folder1/a.png",
+						dstPath: "/folder1/b.png",
+					},
+					queryGet{
+						input: queryGetInput{
+							path: "/folder1/b.png",
+						},
+						checks: checks(
+							fSize(pngImageSize),
+							fContents(pngImage),
+						),
+					},
+				},
+			},
+			{
+				name: "copying a non-existent file",
+				steps: []interface{}{
+					cmdCopy{
+						srcPath: "/folder1/missing.png",
+						dstPath: "/folder1/copy.png",
+						error:   &cmdErrorOutput{},
+					},
+					queryGet{
+						input: queryGetInput{
+							path: "/folder1/copy.png",
+						},
+					},
+				},
+			},
+			{
+				name: "moving a file into a non-existent folder",
+				steps: []interface{}{
+					cmdUpsert{
+						cmd: UpsertFileCommand{
+							Path:       "/folder1/File.png",
+							Contents:   &pngImage,
+							Properties: map[string]string{"prop1": "val1"},
+						},
+					},
+					cmdMove{
+						srcPath: "/folder1/File.png",
+						dstPath: "/archive/2022/Moved.png",
+					},
+					queryGet{
+						input: queryGetInput{
+							path: "/folder1/File.png",
+						},
+					},
+					queryGet{
+						input: queryGetInput{
+							path: "/archive/2022/Moved.png",
+						},
+						checks: checks(
+							fName("Moved.png"),
+							fPath("/archive/2022/Moved.png"),
+							fProperties(map[string]string{"prop1": "val1"}),
+							fContents(pngImage),
+						),
+					},
+					queryListFolders{
+						input: queryListFoldersInput{path: "/", options: &ListOptions{Recursive: true}},
+						checks: [][]interface{}{
+							checks(fPath("/archive")),
+							checks(fPath("/archive/2022")),
+							checks(fPath("/folder1")),
+						},
+					},
+				},
+			},
+			{
+				name: "moving a file onto an existing file replaces it",
+				steps: []interface{}{
+					cmdUpsert{
+						cmd: UpsertFileCommand{
+							Path:     "/folder1/a.png",
+							Contents: &pngImage,
+						},
+					},
+					cmdUpsert{
+						cmd: UpsertFileCommand{
+							Path:     "/folder1/b.png",
+							Contents: &emptyFileBytes,
+						},
+					},
+					cmdMove{
+						srcPath: "/folder1/a.png",
+						dstPath: "/folder1/b.png",
+					},
+					queryGet{
+						input: queryGetInput{
+							path: "/folder1/a.png",
+						},
+					},
+					queryGet{
+						input: queryGetInput{
+							path: "/folder1/b.png",
+						},
+						checks: checks(
+							fSize(pngImageSize),
+							fContents(pngImage),
+						),
+					},
+				},
+			},
 			{
 				name: "deleting a file",
 				steps: []interface{}{
@@ -570,10 +735,13 @@ func TestFsStorage(t *testing.T) {
 				},
 			},
 			{
-				name: "deleting a non-existent file should be no-op",
+				name: "deleting a non-existent file should fail with not found",
 				steps: []interface{}{
 					cmdDelete{
 						path: "/file.png",
+						error: &cmdErrorOutput{
+							instance: ErrFileNotFound,
+						},
 					},
 				},
 			},
@@ -630,6 +798,43 @@ func TestFsStorage(t *testing.T) {
 					},
 				},
 			},
+			{
+				name: "creating an existing folder fails only when asked to",
+				steps: []interface{}{
+					cmdCreateFolder{
+						path: "/folder/nested",
+					},
+					cmdCreateFolder{
+						path: "/folder/nested",
+					},
+					cmdCreateFolder{
+						path:    "/FOLDER/nested",
+						options: &CreateFolderOptions{FailIfExists: true},
+						error: &cmdErrorOutput{
+							instance: ErrFolderAlreadyExists,
+						},
+					},
+					cmdCreateFolder{
+						path:    "/folder",
+						options: &CreateFolderOptions{FailIfExists: true},
+						error: &cmdErrorOutput{
+							instance: ErrFolderAlreadyExists,
+						},
+					},
+					cmdCreateFolder{
+						path:    "/folder/nested/new",
+						options: &CreateFolderOptions{FailIfExists: true},
+					},
+					queryListFolders{
+						input: queryListFoldersInput{path: "/", options: &ListOptions{Recursive: true}},
+						checks: [][]interface{}{
+							checks(fPath("/folder")),
+							checks(fPath("/folder/nested")),
+							checks(fPath("/folder/nested/new")),
+						},
+					},
+				},
+			},
 			{
 				name: "creating a folder with the same name or same name but different casing is a no-op",
 				steps: []interface{}{
@@ -786,6 +991,9 @@ func TestFsStorage(t *testing.T) {
 					},
 					cmdDelete{
 						path: "/folder/dashboards/myNewFolder",
+						error: &cmdErrorOutput{
+							instance: ErrFileNotFound,
+						},
 					},
 					queryListFolders{
 						input: queryListFoldersInput{path: "/", options: &ListOptions{Recursive: true}},
@@ -825,8 +1033,9 @@ func TestFsStorage(t *testing.T) {
 					cmdDeleteFolder{
 						path: "/folder/dashboards/myNewFolder",
 						error: &cmdErrorOutput{
-							message: "folder %s is not empty - cant remove it",
-							args:    []interface{}{"/folder/dashboards/myNewFolder"},
+							message:  "folder %s is not empty - cant remove it",
+							args:     []interface{}{"/folder/dashboards/myNewFolder"},
+							instance: ErrFolderNotEmpty,
 						},
 					},
 					queryListFolders{
@@ -847,6 +1056,36 @@ func TestFsStorage(t *testing.T) {
 					},
 				},
 			},
+			{
+				name: "should be able to force delete folders with files",
+				steps: []interface{}{
+					cmdCreateFolder{
+						path: "/folder/dashboards/myNewFolder/nested",
+					},
+					cmdUpsert{
+						cmd: UpsertFileCommand{
+							Path:     "/folder/dashboards/myNewFolder/nested/file.jpg",
+							Contents: &[]byte{},
+						},
+					},
+					cmdDeleteFolder{
+						path:    "/folder/dashboards/myNewFolder",
+						options: &DeleteFolderOptions{Force: true},
+					},
+					queryListFolders{
+						input: queryListFoldersInput{path: "/", options: &ListOptions{Recursive: true}},
+						checks: [][]interface{}{
+							checks(fPath("/folder")),
+							checks(fPath("/folder/dashboards")),
+						},
+					},
+					queryGet{
+						input: queryGetInput{
+							path: "/folder/dashboards/myNewFolder/nested/file.jpg",
+						},
+					},
+				},
+			},
 		}
 	}
 
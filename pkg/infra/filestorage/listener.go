@@ -0,0 +1,138 @@
+package filestorage
+
+import (
+	"sync"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+)
+
+const listenerQueueSize = 1000
+
+// FileStorageListener is notified after files are changed through the file storage service. The paths include
+// the name of the backend, as in "/backend/folder/file.txt".
+type FileStorageListener interface {
+	// OnUpsert is called after a file was written.
+	OnUpsert(path string)
+	// OnDelete is called after a file or a folder was deleted.
+	OnDelete(path string)
+}
+
+type fileEventType int
+
+const (
+	fileEventUpsert fileEventType = iota
+	fileEventDelete
+)
+
+type fileEvent struct {
+	eventType fileEventType
+	path      string
+}
+
+// listenerDispatcher calls the listeners from a single worker goroutine, so that a slow listener does not block
+// the writes. The events are queued up to listenerQueueSize and dropped once the queue is full. The worker is started
+// when the first listener is added. A nil dispatcher does not notify anyone.
+type listenerDispatcher struct {
+	log       log.Logger
+	mu        sync.RWMutex
+	listeners []FileStorageListener
+	events    chan fileEvent
+	done      chan struct{}
+	closed    bool
+}
+
+func newListenerDispatcher(logger log.Logger) *listenerDispatcher {
+	return &listenerDispatcher{
+		log: logger,
+	}
+}
+
+func (d *listenerDispatcher) addListener(listener FileStorageListener) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return
+	}
+
+	if d.events == nil {
+		d.events = make(chan fileEvent, listenerQueueSize)
+		d.done = make(chan struct{})
+		go d.run(d.events)
+	}
+	d.listeners = append(d.listeners, listener)
+}
+
+func (d *listenerDispatcher) notify(eventType fileEventType, path string) {
+	if d == nil {
+		return
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.events == nil || d.closed {
+		return
+	}
+
+	select {
+	case d.events <- fileEvent{eventType: eventType, path: path}:
+	default:
+		d.log.Warn("File storage listeners are too slow, dropping event", "path", path)
+	}
+}
+
+func (d *listenerDispatcher) run(events <-chan fileEvent) {
+	defer close(d.done)
+
+	for event := range events {
+		d.mu.RLock()
+		listeners := d.listeners
+		d.mu.RUnlock()
+
+		for _, listener := range listeners {
+			d.dispatch(listener, event)
+		}
+	}
+}
+
+func (d *listenerDispatcher) dispatch(listener FileStorageListener, event fileEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			d.log.Error("File storage listener panicked", "path", event.path, "error", r)
+		}
+	}()
+
+	switch event.eventType {
+	case fileEventUpsert:
+		listener.OnUpsert(event.path)
+	case fileEventDelete:
+		listener.OnDelete(event.path)
+	}
+}
+
+// close stops accepting events and waits for the queued ones to be dispatched.
+func (d *listenerDispatcher) close() {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	if d.events != nil {
+		close(d.events)
+	}
+	d.mu.Unlock()
+
+	if d.done != nil {
+		<-d.done
+	}
+}
@@ -0,0 +1,72 @@
+package filestorage
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// backendLabelNotFound labels the operations on paths which do not belong to any backend
+	backendLabelNotFound = "not_found"
+	// backendLabelRoot labels the operations on the virtual root listing the backends
+	backendLabelRoot = "root"
+)
+
+var (
+	operationsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grafana",
+		Name:      "filestorage_operations_total",
+		Help:      "A counter for file storage operations",
+	}, []string{"operation", "backend"})
+
+	operationErrorsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grafana",
+		Name:      "filestorage_operation_errors_total",
+		Help:      "A counter for failed file storage operations",
+	}, []string{"operation", "backend"})
+
+	operationDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "grafana",
+		Name:      "filestorage_operation_duration_seconds",
+		Help:      "Histogram of the duration of file storage operations",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	}, []string{"operation"})
+)
+
+func init() {
+	prometheus.MustRegister(operationsCounter, operationErrorsCounter, operationDurationHistogram)
+}
+
+// instrument starts measuring and tracking an operation on the path. The returned function is deferred by
+// the operation with a pointer to its returned error. Operations slower than the slow log threshold are logged.
+func (b service) instrument(operation string, path string) func(err *error) {
+	backend := b.backendLabel(path)
+	start := time.Now()
+	b.operations.start()
+	return func(err *error) {
+		b.operations.done()
+		elapsed := time.Since(start)
+		if b.slowLogThreshold > 0 && elapsed > b.slowLogThreshold {
+			b.log.Warn("Slow file storage operation", "operation", operation, "backend", backend, "path", path, "elapsed", elapsed)
+		}
+
+		operationDurationHistogram.WithLabelValues(operation).Observe(elapsed.Seconds())
+		operationsCounter.WithLabelValues(operation, backend).Inc()
+		if *err != nil {
+			operationErrorsCounter.WithLabelValues(operation, backend).Inc()
+		}
+	}
+}
+
+func (b service) backendLabel(path string) string {
+	path = normalizePath(path)
+	if path == "" || path == Delimiter {
+		return backendLabelRoot
+	}
+
+	if name, ok := b.resolver.resolve(path); ok {
+		return name
+	}
+	return backendLabelNotFound
+}
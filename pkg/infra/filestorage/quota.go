@@ -0,0 +1,81 @@
+package filestorage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// quotaTracker caches the total size of the files stored in a backend. The total is computed on first use and kept
+// up to date by the operations changing the size of a single file, while the operations touching many files reset it.
+// A nil tracker does not limit the total size.
+type quotaTracker struct {
+	mu               sync.Mutex
+	maxTotalSize     int64
+	totalSize        int64
+	computed         bool
+	computeTotalSize func(ctx context.Context) (int64, error)
+}
+
+func newQuotaTracker(maxTotalSize int64, computeTotalSize func(ctx context.Context) (int64, error)) *quotaTracker {
+	if maxTotalSize <= 0 {
+		return nil
+	}
+
+	return &quotaTracker{
+		maxTotalSize:     maxTotalSize,
+		computeTotalSize: computeTotalSize,
+	}
+}
+
+// reserve accounts for a file of existingSize bytes being replaced with newSize bytes. Writes which do not grow
+// the total size are always allowed, even if the quota is already exceeded.
+func (q *quotaTracker) reserve(ctx context.Context, path string, existingSize int64, newSize int64) error {
+	if q == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.computed {
+		totalSize, err := q.computeTotalSize(ctx)
+		if err != nil {
+			return err
+		}
+		q.totalSize = totalSize
+		q.computed = true
+	}
+
+	totalSize := q.totalSize - existingSize + newSize
+	if newSize > existingSize && totalSize > q.maxTotalSize {
+		return fmt.Errorf("%w: writing %s would store %d bytes, the limit is %d bytes", ErrQuotaExceeded, path, totalSize, q.maxTotalSize)
+	}
+
+	q.totalSize = totalSize
+	return nil
+}
+
+func (q *quotaTracker) release(size int64) {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.computed {
+		q.totalSize -= size
+	}
+}
+
+func (q *quotaTracker) reset() {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.computed = false
+}
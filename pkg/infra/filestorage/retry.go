@@ -0,0 +1,235 @@
+package filestorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"gocloud.dev/gcerrors"
+	"google.golang.org/api/googleapi"
+)
+
+// defaultRetryBaseDelay is the delay before the first retry when the base delay is not configured
+const defaultRetryBaseDelay = 100 * time.Millisecond
+
+var (
+	_ FileStorage = (*retryingFileStorage)(nil) // retryingFileStorage implements FileStorage
+)
+
+// retryingFileStorage retries Get, Upsert, Delete and ListFiles when the wrapped backend fails with a transient error,
+// doubling the delay before every retry. The other operations and errors pass through. A retried upsert can fail
+// with ErrPreconditionFailed or ErrAlreadyExists if the failed attempt was written anyway.
+type retryingFileStorage struct {
+	wrapped    FileStorage
+	maxRetries int
+	baseDelay  time.Duration
+}
+
+func withRetries(backend FileStorage, maxRetries int, baseDelay time.Duration) FileStorage {
+	if maxRetries <= 0 {
+		return backend
+	}
+
+	if baseDelay <= 0 {
+		baseDelay = defaultRetryBaseDelay
+	}
+
+	return &retryingFileStorage{
+		wrapped:    backend,
+		maxRetries: maxRetries,
+		baseDelay:  baseDelay,
+	}
+}
+
+// isTransientError reports whether the error is likely to go away on its own, e.g. a timeout or an unavailable
+// object store. Errors such as a missing file or a failed precondition are not.
+func isTransientError(err error) bool {
+	if errors.Is(err, ErrBackendTimeout) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	switch gcerrors.Code(err) {
+	case gcerrors.Internal, gcerrors.ResourceExhausted, gcerrors.DeadlineExceeded:
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	switch httpStatusCode(err) {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// httpStatusCode returns the status of the object store response the error was created from, or 0 if there is none.
+// go-cloud has no error code for an unavailable service, so the status is taken from the error of the SDK it wraps.
+func httpStatusCode(err error) int {
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return googleErr.Code
+	}
+
+	var awsErr awserr.RequestFailure
+	if errors.As(err, &awsErr) {
+		return awsErr.StatusCode()
+	}
+
+	var azureErr azblob.StorageError
+	if errors.As(err, &azureErr) && azureErr.Response() != nil {
+		return azureErr.Response().StatusCode
+	}
+	return 0
+}
+
+// retry calls the operation until it succeeds, fails with an error which is not transient, runs out of retries
+// or the context is done. It returns the error of the last attempt.
+func (r retryingFileStorage) retry(ctx context.Context, operation func() error) error {
+	delay := r.baseDelay
+	for attempt := 0; ; attempt++ {
+		err := operation()
+		if err == nil || attempt >= r.maxRetries || ctx.Err() != nil || !isTransientError(err) {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+func (r retryingFileStorage) Get(ctx context.Context, path string) (*File, error) {
+	var file *File
+	err := r.retry(ctx, func() error {
+		var err error
+		file, err = r.wrapped.Get(ctx, path)
+		return err
+	})
+	return file, err
+}
+
+func (r retryingFileStorage) GetReader(ctx context.Context, path string) (io.ReadCloser, *FileMetadata, error) {
+	return r.wrapped.GetReader(ctx, path)
+}
+
+func (r retryingFileStorage) Open(ctx context.Context, path string) (*FileMetadata, io.ReadSeekCloser, error) {
+	return r.wrapped.Open(ctx, path)
+}
+
+func (r retryingFileStorage) GetMetadata(ctx context.Context, path string) (*FileMetadata, error) {
+	return r.wrapped.GetMetadata(ctx, path)
+}
+
+func (r retryingFileStorage) SignedURL(ctx context.Context, path string, ttl time.Duration) (string, error) {
+	return r.wrapped.SignedURL(ctx, path, ttl)
+}
+
+func (r retryingFileStorage) SignedUploadURL(ctx context.Context, path string, ttl time.Duration, contentType string) (string, error) {
+	return r.wrapped.SignedUploadURL(ctx, path, ttl, contentType)
+}
+
+func (r retryingFileStorage) Exists(ctx context.Context, path string) (bool, error) {
+	return r.wrapped.Exists(ctx, path)
+}
+
+func (r retryingFileStorage) Delete(ctx context.Context, path string) error {
+	return r.retry(ctx, func() error {
+		return r.wrapped.Delete(ctx, path)
+	})
+}
+
+func (r retryingFileStorage) DeleteMany(ctx context.Context, paths []string) ([]string, map[string]error) {
+	return r.wrapped.DeleteMany(ctx, paths)
+}
+
+func (r retryingFileStorage) Upsert(ctx context.Context, command *UpsertFileCommand) error {
+	return r.retry(ctx, func() error {
+		return r.wrapped.Upsert(ctx, command)
+	})
+}
+
+// UpsertStream is not retried, the stream can not be read again.
+func (r retryingFileStorage) UpsertStream(ctx context.Context, path string, reader io.Reader, command *UpsertFileCommand) error {
+	return r.wrapped.UpsertStream(ctx, path, reader, command)
+}
+
+func (r retryingFileStorage) Append(ctx context.Context, path string, data []byte) error {
+	return r.wrapped.Append(ctx, path, data)
+}
+
+func (r retryingFileStorage) Touch(ctx context.Context, path string) error {
+	return r.wrapped.Touch(ctx, path)
+}
+
+func (r retryingFileStorage) Copy(ctx context.Context, srcPath string, dstPath string) error {
+	return r.wrapped.Copy(ctx, srcPath, dstPath)
+}
+
+func (r retryingFileStorage) Move(ctx context.Context, srcPath string, dstPath string) error {
+	return r.wrapped.Move(ctx, srcPath, dstPath)
+}
+
+func (r retryingFileStorage) ListFiles(ctx context.Context, folderPath string, paging *Paging, options *ListOptions) (*ListFilesResponse, error) {
+	var resp *ListFilesResponse
+	err := r.retry(ctx, func() error {
+		var err error
+		resp, err = r.wrapped.ListFiles(ctx, folderPath, paging, options)
+		return err
+	})
+	return resp, err
+}
+
+// WalkFiles is not retried, the function may have been called for some of the files already.
+func (r retryingFileStorage) WalkFiles(ctx context.Context, folderPath string, options *ListOptions, fn func(FileMetadata) error) error {
+	return r.wrapped.WalkFiles(ctx, folderPath, options, fn)
+}
+
+func (r retryingFileStorage) ListFolders(ctx context.Context, folderPath string, options *ListOptions) ([]FileMetadata, error) {
+	return r.wrapped.ListFolders(ctx, folderPath, options)
+}
+
+func (r retryingFileStorage) CreateFolder(ctx context.Context, path string, options *CreateFolderOptions) error {
+	return r.wrapped.CreateFolder(ctx, path, options)
+}
+
+func (r retryingFileStorage) DeleteFolder(ctx context.Context, path string, options *DeleteFolderOptions) error {
+	return r.wrapped.DeleteFolder(ctx, path, options)
+}
+
+func (r retryingFileStorage) Restore(ctx context.Context, path string) error {
+	return r.wrapped.Restore(ctx, path)
+}
+
+func (r retryingFileStorage) ListVersions(ctx context.Context, path string) ([]FileMetadata, error) {
+	return r.wrapped.ListVersions(ctx, path)
+}
+
+func (r retryingFileStorage) GetVersion(ctx context.Context, path string, version string) (*File, error) {
+	return r.wrapped.GetVersion(ctx, path, version)
+}
+
+func (r retryingFileStorage) PurgeTrash(ctx context.Context, path string) error {
+	return r.wrapped.PurgeTrash(ctx, path)
+}
+
+func (r retryingFileStorage) HealthCheck(ctx context.Context) error {
+	return r.wrapped.HealthCheck(ctx)
+}
+
+func (r retryingFileStorage) close() error {
+	return r.wrapped.close()
+}
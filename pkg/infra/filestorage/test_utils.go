@@ -29,14 +29,28 @@ type cmdUpsert struct {
 	error *cmdErrorOutput
 }
 
+type cmdCopy struct {
+	srcPath string
+	dstPath string
+	error   *cmdErrorOutput
+}
+
+type cmdMove struct {
+	srcPath string
+	dstPath string
+	error   *cmdErrorOutput
+}
+
 type cmdCreateFolder struct {
-	path  string
-	error *cmdErrorOutput
+	path    string
+	options *CreateFolderOptions
+	error   *cmdErrorOutput
 }
 
 type cmdDeleteFolder struct {
-	path  string
-	error *cmdErrorOutput
+	path    string
+	options *DeleteFolderOptions
+	error   *cmdErrorOutput
 }
 
 type queryGetInput struct {
@@ -171,14 +185,26 @@ func handleCommand(t *testing.T, ctx context.Context, cmd interface{}, cmdName s
 			require.NoError(t, err, "%s: should be able to upsert file %s", cmdName, c.cmd.Path)
 		}
 		expectedErr = c.error
+	case cmdCopy:
+		err = fs.Copy(ctx, c.srcPath, c.dstPath)
+		if c.error == nil {
+			require.NoError(t, err, "%s: should be able to copy %s to %s", cmdName, c.srcPath, c.dstPath)
+		}
+		expectedErr = c.error
+	case cmdMove:
+		err = fs.Move(ctx, c.srcPath, c.dstPath)
+		if c.error == nil {
+			require.NoError(t, err, "%s: should be able to move %s to %s", cmdName, c.srcPath, c.dstPath)
+		}
+		expectedErr = c.error
 	case cmdCreateFolder:
-		err = fs.CreateFolder(ctx, c.path)
+		err = fs.CreateFolder(ctx, c.path, c.options)
 		if c.error == nil {
 			require.NoError(t, err, "%s: should be able to create folder %s", cmdName, c.path)
 		}
 		expectedErr = c.error
 	case cmdDeleteFolder:
-		err = fs.DeleteFolder(ctx, c.path)
+		err = fs.DeleteFolder(ctx, c.path, c.options)
 		if c.error == nil {
 			require.NoError(t, err, "%s: should be able to delete %s", cmdName, c.path)
 		}
@@ -273,13 +299,14 @@ func handleQuery(t *testing.T, ctx context.Context, query interface{}, queryName
 	case queryGet:
 		inputPath := q.input.path
 		file, err := fs.Get(ctx, inputPath)
-		require.NoError(t, err, "%s: should be able to get file %s", queryName, inputPath)
 
 		if q.checks != nil && len(q.checks) > 0 {
+			require.NoError(t, err, "%s: should be able to get file %s", queryName, inputPath)
 			require.NotNil(t, file, "%s %s", queryName, inputPath)
 			require.Equal(t, strings.ToLower(inputPath), strings.ToLower(file.FullPath), "%s %s", queryName, inputPath)
 			runChecks(t, queryName, inputPath, *file, q.checks)
 		} else {
+			require.ErrorIs(t, err, ErrFileNotFound, "%s %s", queryName, inputPath)
 			require.Nil(t, file, "%s %s", queryName, inputPath)
 		}
 	case queryListFiles:
@@ -335,6 +362,10 @@ func executeTestStep(t *testing.T, ctx context.Context, step interface{}, stepNu
 		handleCommand(t, ctx, s, name, fs)
 	case cmdDelete:
 		handleCommand(t, ctx, s, name, fs)
+	case cmdCopy:
+		handleCommand(t, ctx, s, name, fs)
+	case cmdMove:
+		handleCommand(t, ctx, s, name, fs)
 	case cmdCreateFolder:
 		handleCommand(t, ctx, s, name, fs)
 	case cmdDeleteFolder:
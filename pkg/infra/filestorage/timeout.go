@@ -0,0 +1,269 @@
+package filestorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	_ FileStorage = (*timeoutFileStorage)(nil) // timeoutFileStorage implements FileStorage
+)
+
+// timeoutFileStorage bounds every call to the wrapped backend with the operation timeout of the backend, so that
+// a hung backend does not block the callers of the service. The errors caused by the timeout are reported as
+// ErrBackendTimeout. The wrapped backend has to honor the cancellation of the context.
+type timeoutFileStorage struct {
+	wrapped FileStorage
+	timeout time.Duration
+}
+
+func withOperationTimeout(backend FileStorage, timeout time.Duration) FileStorage {
+	if timeout <= 0 {
+		return backend
+	}
+
+	return &timeoutFileStorage{
+		wrapped: backend,
+		timeout: timeout,
+	}
+}
+
+func (t timeoutFileStorage) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, t.timeout)
+}
+
+// timeoutError reports the error as ErrBackendTimeout if it was caused by the operation timeout rather than
+// by the caller's context.
+func (t timeoutFileStorage) timeoutError(ctx context.Context, parent context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && parent.Err() == nil {
+		return fmt.Errorf("%w: operation did not complete within %s: %s", ErrBackendTimeout, t.timeout, err)
+	}
+	return err
+}
+
+func (t timeoutFileStorage) Get(ctx context.Context, path string) (*File, error) {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	file, err := t.wrapped.Get(opCtx, path)
+	return file, t.timeoutError(opCtx, ctx, err)
+}
+
+// GetReader only cancels the context of the operation once the reader is closed, the timeout also covers reading.
+func (t timeoutFileStorage) GetReader(ctx context.Context, path string) (io.ReadCloser, *FileMetadata, error) {
+	opCtx, cancel := t.withTimeout(ctx)
+
+	reader, metadata, err := t.wrapped.GetReader(opCtx, path)
+	if err != nil {
+		cancel()
+		return nil, nil, t.timeoutError(opCtx, ctx, err)
+	}
+
+	return &cancelOnCloseReader{ReadCloser: reader, cancel: cancel}, metadata, nil
+}
+
+type cancelOnCloseReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelOnCloseReader) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+// Open only cancels the context of the operation once the reader is closed, like GetReader.
+func (t timeoutFileStorage) Open(ctx context.Context, path string) (*FileMetadata, io.ReadSeekCloser, error) {
+	opCtx, cancel := t.withTimeout(ctx)
+
+	metadata, reader, err := t.wrapped.Open(opCtx, path)
+	if err != nil {
+		cancel()
+		return nil, nil, t.timeoutError(opCtx, ctx, err)
+	}
+
+	return metadata, &cancelOnCloseReadSeeker{ReadSeekCloser: reader, cancel: cancel}, nil
+}
+
+type cancelOnCloseReadSeeker struct {
+	io.ReadSeekCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelOnCloseReadSeeker) Close() error {
+	defer r.cancel()
+	return r.ReadSeekCloser.Close()
+}
+
+func (t timeoutFileStorage) GetMetadata(ctx context.Context, path string) (*FileMetadata, error) {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	metadata, err := t.wrapped.GetMetadata(opCtx, path)
+	return metadata, t.timeoutError(opCtx, ctx, err)
+}
+
+func (t timeoutFileStorage) SignedURL(ctx context.Context, path string, ttl time.Duration) (string, error) {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	url, err := t.wrapped.SignedURL(opCtx, path, ttl)
+	return url, t.timeoutError(opCtx, ctx, err)
+}
+
+func (t timeoutFileStorage) SignedUploadURL(ctx context.Context, path string, ttl time.Duration, contentType string) (string, error) {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	url, err := t.wrapped.SignedUploadURL(opCtx, path, ttl, contentType)
+	return url, t.timeoutError(opCtx, ctx, err)
+}
+
+func (t timeoutFileStorage) Exists(ctx context.Context, path string) (bool, error) {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	exists, err := t.wrapped.Exists(opCtx, path)
+	return exists, t.timeoutError(opCtx, ctx, err)
+}
+
+func (t timeoutFileStorage) Delete(ctx context.Context, path string) error {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	return t.timeoutError(opCtx, ctx, t.wrapped.Delete(opCtx, path))
+}
+
+func (t timeoutFileStorage) DeleteMany(ctx context.Context, paths []string) ([]string, map[string]error) {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	deleted, errs := t.wrapped.DeleteMany(opCtx, paths)
+	for path, err := range errs {
+		errs[path] = t.timeoutError(opCtx, ctx, err)
+	}
+	return deleted, errs
+}
+
+func (t timeoutFileStorage) Upsert(ctx context.Context, command *UpsertFileCommand) error {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	return t.timeoutError(opCtx, ctx, t.wrapped.Upsert(opCtx, command))
+}
+
+// UpsertStream is bounded by the timeout as a whole, including reading the stream.
+func (t timeoutFileStorage) UpsertStream(ctx context.Context, path string, r io.Reader, command *UpsertFileCommand) error {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	return t.timeoutError(opCtx, ctx, t.wrapped.UpsertStream(opCtx, path, r, command))
+}
+
+func (t timeoutFileStorage) Append(ctx context.Context, path string, data []byte) error {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	return t.timeoutError(opCtx, ctx, t.wrapped.Append(opCtx, path, data))
+}
+
+func (t timeoutFileStorage) Touch(ctx context.Context, path string) error {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	return t.timeoutError(opCtx, ctx, t.wrapped.Touch(opCtx, path))
+}
+
+func (t timeoutFileStorage) Copy(ctx context.Context, srcPath string, dstPath string) error {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	return t.timeoutError(opCtx, ctx, t.wrapped.Copy(opCtx, srcPath, dstPath))
+}
+
+func (t timeoutFileStorage) Move(ctx context.Context, srcPath string, dstPath string) error {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	return t.timeoutError(opCtx, ctx, t.wrapped.Move(opCtx, srcPath, dstPath))
+}
+
+func (t timeoutFileStorage) ListFiles(ctx context.Context, folderPath string, paging *Paging, options *ListOptions) (*ListFilesResponse, error) {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	resp, err := t.wrapped.ListFiles(opCtx, folderPath, paging, options)
+	return resp, t.timeoutError(opCtx, ctx, err)
+}
+
+// WalkFiles is bounded by the timeout as a whole, including the calls to the function.
+func (t timeoutFileStorage) WalkFiles(ctx context.Context, folderPath string, options *ListOptions, fn func(FileMetadata) error) error {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	return t.timeoutError(opCtx, ctx, t.wrapped.WalkFiles(opCtx, folderPath, options, fn))
+}
+
+func (t timeoutFileStorage) ListFolders(ctx context.Context, folderPath string, options *ListOptions) ([]FileMetadata, error) {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	folders, err := t.wrapped.ListFolders(opCtx, folderPath, options)
+	return folders, t.timeoutError(opCtx, ctx, err)
+}
+
+func (t timeoutFileStorage) CreateFolder(ctx context.Context, path string, options *CreateFolderOptions) error {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	return t.timeoutError(opCtx, ctx, t.wrapped.CreateFolder(opCtx, path, options))
+}
+
+func (t timeoutFileStorage) DeleteFolder(ctx context.Context, path string, options *DeleteFolderOptions) error {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	return t.timeoutError(opCtx, ctx, t.wrapped.DeleteFolder(opCtx, path, options))
+}
+
+func (t timeoutFileStorage) Restore(ctx context.Context, path string) error {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	return t.timeoutError(opCtx, ctx, t.wrapped.Restore(opCtx, path))
+}
+
+func (t timeoutFileStorage) ListVersions(ctx context.Context, path string) ([]FileMetadata, error) {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	versions, err := t.wrapped.ListVersions(opCtx, path)
+	return versions, t.timeoutError(opCtx, ctx, err)
+}
+
+func (t timeoutFileStorage) GetVersion(ctx context.Context, path string, version string) (*File, error) {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	file, err := t.wrapped.GetVersion(opCtx, path, version)
+	return file, t.timeoutError(opCtx, ctx, err)
+}
+
+func (t timeoutFileStorage) PurgeTrash(ctx context.Context, path string) error {
+	opCtx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	return t.timeoutError(opCtx, ctx, t.wrapped.PurgeTrash(opCtx, path))
+}
+
+// HealthCheck is bounded by the health check timeout of the service instead.
+func (t timeoutFileStorage) HealthCheck(ctx context.Context) error {
+	return t.wrapped.HealthCheck(ctx)
+}
+
+func (t timeoutFileStorage) close() error {
+	return t.wrapped.close()
+}
@@ -0,0 +1,119 @@
+package filestorage
+
+import (
+	"context"
+	"sync"
+)
+
+// operationTracker counts the operations in flight, so that the service can let them finish before closing
+// the backends. A nil tracker does not track anything.
+type operationTracker struct {
+	mu    sync.Mutex
+	count int
+	// idle is closed once no operation is in flight anymore, it is only created while someone waits
+	idle chan struct{}
+}
+
+func newOperationTracker() *operationTracker {
+	return &operationTracker{}
+}
+
+func (t *operationTracker) start() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.count++
+}
+
+func (t *operationTracker) done() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.count--
+	if t.count == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+func (t *operationTracker) inFlight() int {
+	if t == nil {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.count
+}
+
+// wait blocks until no operation is in flight or the context is done.
+func (t *operationTracker) wait(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	if t.count == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// concurrencyLimiter bounds the backend operations the bulk methods of the service run at once, across all
+// callers. A nil limiter does not limit anything.
+type concurrencyLimiter struct {
+	slots chan struct{}
+}
+
+func newConcurrencyLimiter(maxConcurrency int) *concurrencyLimiter {
+	return &concurrencyLimiter{slots: make(chan struct{}, maxConcurrency)}
+}
+
+// acquire blocks until a slot is free or the context is done. Every successful acquire has to be released.
+func (l *concurrencyLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *concurrencyLimiter) release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// run runs the operation once a slot is free.
+func (l *concurrencyLimiter) run(ctx context.Context, operation func() error) error {
+	if err := l.acquire(ctx); err != nil {
+		return err
+	}
+	defer l.release()
+
+	return operation()
+}
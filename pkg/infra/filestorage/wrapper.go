@@ -1,27 +1,40 @@
 package filestorage
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"mime"
+	"net/http"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/grafana/grafana/pkg/infra/log"
 	_ "gocloud.dev/blob/fileblob"
 	_ "gocloud.dev/blob/memblob"
 )
 
+// sniffLen is the number of bytes http.DetectContentType looks at
+const sniffLen = 512
+
 var (
 	directoryMarker = ".___gf_dir_marker___"
-	pathRegex       = regexp.MustCompile(`(^/$)|(^(/[A-Za-z0-9!\-_.*'()]+)+$)`)
+	segmentRegex    = regexp.MustCompile(`^[A-Za-z0-9!\-_.*'()]+$`)
 )
 
 type wrapper struct {
-	log         log.Logger
-	wrapped     FileStorage
-	pathFilters *PathFilters
+	log                 log.Logger
+	wrapped             FileStorage
+	pathFilters         PathFilter
+	supportedOperations []Operation
+	maxFileSize         int64
 }
 
 var (
@@ -54,30 +67,59 @@ func getName(path string) string {
 	return split[len(split)-1]
 }
 
+// contentETag is used as the ETag of files whose backend does not expose a hash of the contents.
+func contentETag(contents []byte) string {
+	hash := sha256.Sum256(contents)
+	return hex.EncodeToString(hash[:])
+}
+
+// bufferedReadSeekCloser seeks over contents read into memory, for the backends which can not read a range of a file.
+type bufferedReadSeekCloser struct {
+	*bytes.Reader
+}
+
+func newBufferedReadSeekCloser(contents []byte) io.ReadSeekCloser {
+	return bufferedReadSeekCloser{Reader: bytes.NewReader(contents)}
+}
+
+func (r bufferedReadSeekCloser) Close() error {
+	return nil
+}
+
+// validatePath checks that the path is absolute, canonical and made of allowed characters only.
+// The returned errors wrap the sentinel errors and name the offending segment.
 func validatePath(path string) error {
 	if !filepath.IsAbs(path) {
-		return ErrRelativePath
+		return fmt.Errorf("%w: %q", ErrRelativePath, path)
 	}
 
 	if path == Delimiter {
 		return nil
 	}
 
-	if filepath.Clean(path) != path {
-		return ErrNonCanonicalPath
-	}
-
 	if strings.HasSuffix(path, Delimiter) {
-		return ErrPathEndsWithDelimiter
+		return fmt.Errorf("%w: %q", ErrPathEndsWithDelimiter, path)
 	}
 
 	if len(path) > 1000 {
 		return ErrPathTooLong
 	}
 
-	matches := pathRegex.MatchString(path)
-	if !matches {
-		return ErrPathInvalid
+	for _, segment := range strings.Split(path, Delimiter)[1:] {
+		switch {
+		case segment == "":
+			return fmt.Errorf("%w: empty segment in %q", ErrNonCanonicalPath, path)
+		case segment == "." || segment == "..":
+			return fmt.Errorf("%w: segment %q", ErrNonCanonicalPath, segment)
+		case strings.TrimSpace(segment) != segment:
+			return fmt.Errorf("%w: segment %q has leading or trailing whitespace", ErrPathInvalid, segment)
+		case strings.ContainsRune(segment, 0):
+			return fmt.Errorf("%w: segment %q contains a null byte", ErrPathInvalid, segment)
+		case strings.Contains(segment, `\`):
+			return fmt.Errorf("%w: segment %q contains a backslash", ErrPathInvalid, segment)
+		case !segmentRegex.MatchString(segment):
+			return fmt.Errorf("%w: segment %q contains invalid characters", ErrPathInvalid, segment)
+		}
 	}
 
 	return nil
@@ -91,29 +133,197 @@ func (b wrapper) validatePath(path string) error {
 	return nil
 }
 
+func (b wrapper) isOperationSupported(operation Operation) bool {
+	if b.supportedOperations == nil {
+		return true
+	}
+
+	return containsOperation(b.supportedOperations, operation)
+}
+
+func (b wrapper) checkOperation(operation Operation) error {
+	if !b.isOperationSupported(operation) {
+		return fmt.Errorf("%w: %s", ErrOperationNotSupported, operation)
+	}
+	return nil
+}
+
 func (b wrapper) Get(ctx context.Context, path string) (*File, error) {
+	if err := b.checkOperation(OperationGet); err != nil {
+		return nil, err
+	}
+
 	if err := b.validatePath(path); err != nil {
 		return nil, err
 	}
 
-	if !b.pathFilters.isAllowed(path) {
-		return nil, nil
+	if !b.isAllowed(path) {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, path)
 	}
 
 	return b.wrapped.Get(ctx, path)
 }
+
+func (b wrapper) GetReader(ctx context.Context, path string) (io.ReadCloser, *FileMetadata, error) {
+	if err := b.checkOperation(OperationGet); err != nil {
+		return nil, nil, err
+	}
+
+	if err := b.validatePath(path); err != nil {
+		return nil, nil, err
+	}
+
+	if !b.isAllowed(path) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrFileNotFound, path)
+	}
+
+	return b.wrapped.GetReader(ctx, path)
+}
+
+func (b wrapper) Open(ctx context.Context, path string) (*FileMetadata, io.ReadSeekCloser, error) {
+	if err := b.checkOperation(OperationGet); err != nil {
+		return nil, nil, err
+	}
+
+	if err := b.validatePath(path); err != nil {
+		return nil, nil, err
+	}
+
+	if !b.isAllowed(path) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrFileNotFound, path)
+	}
+
+	return b.wrapped.Open(ctx, path)
+}
+
+func (b wrapper) SignedURL(ctx context.Context, path string, ttl time.Duration) (string, error) {
+	if err := b.checkOperation(OperationGet); err != nil {
+		return "", err
+	}
+
+	if err := b.validatePath(path); err != nil {
+		return "", err
+	}
+
+	if ttl <= 0 {
+		return "", fmt.Errorf("invalid signed url ttl %s: must be positive", ttl)
+	}
+
+	if !b.isAllowed(path) {
+		return "", fmt.Errorf("%w: %s", ErrFileNotFound, path)
+	}
+
+	return b.wrapped.SignedURL(ctx, path, ttl)
+}
+
+// SignedUploadURL fails for backends with a maximum file size, as the size of the uploads can not be checked.
+func (b wrapper) SignedUploadURL(ctx context.Context, path string, ttl time.Duration, contentType string) (string, error) {
+	if err := b.checkOperation(OperationUpsert); err != nil {
+		return "", err
+	}
+
+	if err := b.validatePath(path); err != nil {
+		return "", err
+	}
+
+	if ttl <= 0 {
+		return "", fmt.Errorf("invalid signed url ttl %s: must be positive", ttl)
+	}
+
+	if !b.isAllowed(path) {
+		return "", fmt.Errorf("%w: %s", ErrPathNotAllowed, path)
+	}
+
+	if b.maxFileSize > 0 {
+		return "", fmt.Errorf("%w: uploads would bypass the maximum file size", ErrSignedURLUnsupported)
+	}
+
+	return b.wrapped.SignedUploadURL(ctx, path, ttl, contentType)
+}
+
+func (b wrapper) GetMetadata(ctx context.Context, path string) (*FileMetadata, error) {
+	if err := b.checkOperation(OperationGet); err != nil {
+		return nil, err
+	}
+
+	if err := b.validatePath(path); err != nil {
+		return nil, err
+	}
+
+	if !b.isAllowed(path) {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, path)
+	}
+
+	return b.wrapped.GetMetadata(ctx, path)
+}
+
+func (b wrapper) Exists(ctx context.Context, path string) (bool, error) {
+	if err := b.checkOperation(OperationGet); err != nil {
+		return false, err
+	}
+
+	if err := b.validatePath(path); err != nil {
+		return false, err
+	}
+
+	if !b.isAllowed(path) {
+		return false, nil
+	}
+
+	return b.wrapped.Exists(ctx, path)
+}
+
 func (b wrapper) Delete(ctx context.Context, path string) error {
+	if err := b.checkOperation(OperationDelete); err != nil {
+		return err
+	}
+
 	if err := b.validatePath(path); err != nil {
 		return err
 	}
 
-	if !b.pathFilters.isAllowed(path) {
-		return nil
+	if !b.isAllowed(path) {
+		return fmt.Errorf("%w: %s", ErrPathNotAllowed, path)
 	}
 
 	return b.wrapped.Delete(ctx, path)
 }
 
+func (b wrapper) DeleteMany(ctx context.Context, paths []string) ([]string, map[string]error) {
+	errs := make(map[string]error)
+	if err := b.checkOperation(OperationDelete); err != nil {
+		for _, path := range paths {
+			errs[path] = err
+		}
+		return nil, errs
+	}
+
+	allowedPaths := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if err := b.validatePath(path); err != nil {
+			errs[path] = err
+			continue
+		}
+
+		if !b.isAllowed(path) {
+			errs[path] = fmt.Errorf("%w: %s", ErrPathNotAllowed, path)
+			continue
+		}
+
+		allowedPaths = append(allowedPaths, path)
+	}
+
+	if len(allowedPaths) == 0 {
+		return nil, errs
+	}
+
+	deleted, deleteErrs := b.wrapped.DeleteMany(ctx, allowedPaths)
+	for path, err := range deleteErrs {
+		errs[path] = err
+	}
+	return deleted, errs
+}
+
 func detectContentType(path string, originalGuess string) string {
 	if originalGuess == "application/octet-stream" || originalGuess == "" {
 		mimeTypeBasedOnExt := mime.TypeByExtension(filepath.Ext(path))
@@ -125,57 +335,275 @@ func detectContentType(path string, originalGuess string) string {
 	return originalGuess
 }
 
+// detectUpsertContentType prefers the type based on the file extension and only sniffs the first 512 bytes
+// of the contents if the extension is unknown.
+func detectUpsertContentType(path string, contents []byte) string {
+	if mimeTypeBasedOnExt := mime.TypeByExtension(filepath.Ext(path)); mimeTypeBasedOnExt != "" {
+		return mimeTypeBasedOnExt
+	}
+
+	if len(contents) == 0 {
+		return "application/octet-stream"
+	}
+	return http.DetectContentType(contents)
+}
+
 func (b wrapper) Upsert(ctx context.Context, file *UpsertFileCommand) error {
+	if err := b.checkOperation(OperationUpsert); err != nil {
+		return err
+	}
+
 	if err := b.validatePath(file.Path); err != nil {
 		return err
 	}
 
-	if !b.pathFilters.isAllowed(file.Path) {
-		return nil
+	if !b.isAllowed(file.Path) {
+		return fmt.Errorf("%w: %s", ErrPathNotAllowed, file.Path)
+	}
+
+	// checked before the parent folder gets created so that a rejected file leaves nothing behind
+	if b.maxFileSize > 0 && file.Contents != nil && int64(len(*file.Contents)) > b.maxFileSize {
+		return fmt.Errorf("%w: %s has %d bytes, the limit is %d bytes", ErrFileTooLarge, file.Path, len(*file.Contents), b.maxFileSize)
 	}
 
 	path := getParentFolderPath(file.Path)
 	b.log.Info("Creating folder before upserting file", "file", file.Path, "folder", path)
-	if err := b.CreateFolder(ctx, path); err != nil {
+	if err := b.createFolder(ctx, path, nil); err != nil {
 		return err
 	}
 
 	if file.Contents != nil && file.MimeType == "" {
-		file.MimeType = detectContentType(file.Path, "")
+		file.MimeType = detectUpsertContentType(file.Path, *file.Contents)
 	}
 
 	return b.wrapped.Upsert(ctx, file)
 }
 
+func (b wrapper) UpsertStream(ctx context.Context, path string, r io.Reader, command *UpsertFileCommand) error {
+	if err := b.checkOperation(OperationUpsert); err != nil {
+		return err
+	}
+
+	if err := b.validatePath(path); err != nil {
+		return err
+	}
+
+	if !b.isAllowed(path) {
+		return fmt.Errorf("%w: %s", ErrPathNotAllowed, path)
+	}
+
+	if command == nil {
+		command = &UpsertFileCommand{}
+	}
+
+	// the size is only known once the stream was read, the parent folder is created even if the file is too large
+	if b.maxFileSize > 0 {
+		r = &maxSizeReader{reader: r, path: path, maxSize: b.maxFileSize}
+	}
+
+	folderPath := getParentFolderPath(path)
+	b.log.Info("Creating folder before upserting file", "file", path, "folder", folderPath)
+	if err := b.createFolder(ctx, folderPath, nil); err != nil {
+		return err
+	}
+
+	if command.MimeType == "" {
+		buffered := bufio.NewReaderSize(r, sniffLen)
+		head, err := buffered.Peek(sniffLen)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		command.MimeType = detectUpsertContentType(path, head)
+		r = buffered
+	}
+
+	return b.wrapped.UpsertStream(ctx, path, r, command)
+}
+
+// maxSizeReader fails with ErrFileTooLarge once more than the max size was read.
+type maxSizeReader struct {
+	reader  io.Reader
+	path    string
+	maxSize int64
+	read    int64
+}
+
+func (r *maxSizeReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	if r.read > r.maxSize {
+		return n, fmt.Errorf("%w: %s has more than the limit of %d bytes", ErrFileTooLarge, r.path, r.maxSize)
+	}
+	return n, err
+}
+
+func (b wrapper) Append(ctx context.Context, path string, data []byte) error {
+	if err := b.checkOperation(OperationUpsert); err != nil {
+		return err
+	}
+
+	if err := b.validatePath(path); err != nil {
+		return err
+	}
+
+	if !b.isAllowed(path) {
+		return fmt.Errorf("%w: %s", ErrPathNotAllowed, path)
+	}
+
+	if b.maxFileSize > 0 {
+		var existingSize int64
+		metadata, err := b.wrapped.GetMetadata(ctx, path)
+		if err != nil && !errors.Is(err, ErrFileNotFound) {
+			return err
+		}
+		if metadata != nil {
+			existingSize = metadata.Size
+		}
+
+		if existingSize+int64(len(data)) > b.maxFileSize {
+			return fmt.Errorf("%w: %s would have %d bytes, the limit is %d bytes", ErrFileTooLarge, path, existingSize+int64(len(data)), b.maxFileSize)
+		}
+	}
+
+	folderPath := getParentFolderPath(path)
+	b.log.Info("Creating folder before appending to file", "file", path, "folder", folderPath)
+	if err := b.createFolder(ctx, folderPath, nil); err != nil {
+		return err
+	}
+
+	return b.wrapped.Append(ctx, path, data)
+}
+
+func (b wrapper) Touch(ctx context.Context, path string) error {
+	if err := b.checkOperation(OperationUpsert); err != nil {
+		return err
+	}
+
+	if err := b.validatePath(path); err != nil {
+		return err
+	}
+
+	if !b.isAllowed(path) {
+		return fmt.Errorf("%w: %s", ErrPathNotAllowed, path)
+	}
+
+	return b.wrapped.Touch(ctx, path)
+}
+
+func (b wrapper) Copy(ctx context.Context, srcPath string, dstPath string) error {
+	if err := b.checkOperation(OperationCopy); err != nil {
+		return err
+	}
+
+	if err := b.prepareDestination(ctx, srcPath, dstPath); err != nil {
+		return err
+	}
+
+	return b.wrapped.Copy(ctx, srcPath, dstPath)
+}
+
+func (b wrapper) Move(ctx context.Context, srcPath string, dstPath string) error {
+	if err := b.checkOperation(OperationMove); err != nil {
+		return err
+	}
+
+	if err := b.prepareDestination(ctx, srcPath, dstPath); err != nil {
+		return err
+	}
+
+	return b.wrapped.Move(ctx, srcPath, dstPath)
+}
+
+// prepareDestination validates both paths of a copy/move and creates the parent folder of the destination.
+func (b wrapper) prepareDestination(ctx context.Context, srcPath string, dstPath string) error {
+	if err := b.validatePath(srcPath); err != nil {
+		return err
+	}
+
+	if err := b.validatePath(dstPath); err != nil {
+		return err
+	}
+
+	if !b.isAllowed(srcPath) {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, srcPath)
+	}
+
+	if !b.isAllowed(dstPath) {
+		return fmt.Errorf("%w: %s", ErrPathNotAllowed, dstPath)
+	}
+
+	path := getParentFolderPath(dstPath)
+	b.log.Info("Creating destination folder", "file", dstPath, "folder", path)
+	return b.createFolder(ctx, path, nil)
+}
+
+// isAllowed checks the path against the path filters of the backend. Reads of a path which is not allowed fail with
+// ErrFileNotFound like reads of a missing file, writes fail with ErrPathNotAllowed.
+func (b wrapper) isAllowed(path string) bool {
+	return b.pathFilters == nil || b.pathFilters.IsAllowed(path)
+}
+
 func (b wrapper) withDefaults(options *ListOptions, folderQuery bool) *ListOptions {
+	prefixFilters, isPrefixFilters := b.pathFilters.(*PathFilters)
 	if options == nil {
 		options = &ListOptions{}
 		options.Recursive = folderQuery
-		if b.pathFilters != nil && b.pathFilters.allowedPrefixes != nil {
-			options.PathFilters = *b.pathFilters
+		if isPrefixFilters && prefixFilters != nil {
+			options.PathFilters = *prefixFilters
+		} else if !isPrefixFilters {
+			options.filter = b.pathFilters
 		}
 
 		return options
 	}
 
-	if b.pathFilters != nil && b.pathFilters.allowedPrefixes != nil {
+	if !isPrefixFilters {
+		options.filter = b.pathFilters
+		return options
+	}
+
+	if prefixFilters != nil && prefixFilters.allowedPrefixes != nil {
 		if options.allowedPrefixes != nil {
-			options.allowedPrefixes = append(options.allowedPrefixes, b.pathFilters.allowedPrefixes...)
+			options.allowedPrefixes = append(options.allowedPrefixes, prefixFilters.allowedPrefixes...)
 		} else {
-			copiedPrefixes := make([]string, len(b.pathFilters.allowedPrefixes))
-			copy(copiedPrefixes, b.pathFilters.allowedPrefixes)
+			copiedPrefixes := make([]string, len(prefixFilters.allowedPrefixes))
+			copy(copiedPrefixes, prefixFilters.allowedPrefixes)
 			options.allowedPrefixes = copiedPrefixes
 		}
 	}
 
+	if prefixFilters != nil && prefixFilters.allowedPaths != nil {
+		allowedPaths := make([]string, 0, len(options.allowedPaths)+len(prefixFilters.allowedPaths))
+		allowedPaths = append(allowedPaths, options.allowedPaths...)
+		options.allowedPaths = append(allowedPaths, prefixFilters.allowedPaths...)
+	}
+
+	if prefixFilters != nil && prefixFilters.deniedPrefixes != nil {
+		deniedPrefixes := make([]string, 0, len(options.deniedPrefixes)+len(prefixFilters.deniedPrefixes))
+		deniedPrefixes = append(deniedPrefixes, options.deniedPrefixes...)
+		options.deniedPrefixes = append(deniedPrefixes, prefixFilters.deniedPrefixes...)
+	}
+
 	return options
 }
 
 func (b wrapper) ListFiles(ctx context.Context, path string, paging *Paging, options *ListOptions) (*ListFilesResponse, error) {
+	if err := b.checkOperation(OperationListFiles); err != nil {
+		return nil, err
+	}
+
+	return b.listFiles(ctx, path, paging, options)
+}
+
+func (b wrapper) listFiles(ctx context.Context, path string, paging *Paging, options *ListOptions) (*ListFilesResponse, error) {
 	if err := b.validatePath(path); err != nil {
 		return nil, err
 	}
 
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+
 	if paging == nil {
 		paging = &Paging{
 			First: 100,
@@ -187,69 +615,163 @@ func (b wrapper) ListFiles(ctx context.Context, path string, paging *Paging, opt
 	return b.wrapped.ListFiles(ctx, path, paging, b.withDefaults(options, false))
 }
 
+func (b wrapper) WalkFiles(ctx context.Context, path string, options *ListOptions, fn func(FileMetadata) error) error {
+	if err := b.checkOperation(OperationListFiles); err != nil {
+		return err
+	}
+
+	if err := b.validatePath(path); err != nil {
+		return err
+	}
+
+	if err := options.validate(); err != nil {
+		return err
+	}
+
+	return b.wrapped.WalkFiles(ctx, path, b.withDefaults(options, false), fn)
+}
+
 func (b wrapper) ListFolders(ctx context.Context, path string, options *ListOptions) ([]FileMetadata, error) {
+	if err := b.checkOperation(OperationListFolders); err != nil {
+		return nil, err
+	}
+
+	return b.listFolders(ctx, path, options)
+}
+
+func (b wrapper) listFolders(ctx context.Context, path string, options *ListOptions) ([]FileMetadata, error) {
 	if err := b.validatePath(path); err != nil {
 		return nil, err
 	}
 
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+
 	return b.wrapped.ListFolders(ctx, path, b.withDefaults(options, true))
 }
 
-func (b wrapper) CreateFolder(ctx context.Context, path string) error {
+func (b wrapper) CreateFolder(ctx context.Context, path string, options *CreateFolderOptions) error {
+	if err := b.checkOperation(OperationCreateFolder); err != nil {
+		return err
+	}
+
 	if err := b.validatePath(path); err != nil {
 		return err
 	}
 
-	if !b.pathFilters.isAllowed(path) {
-		return nil
+	if !b.isAllowed(path) {
+		return fmt.Errorf("%w: %s", ErrPathNotAllowed, path)
 	}
 
-	return b.wrapped.CreateFolder(ctx, path)
+	return b.createFolder(ctx, path, options)
 }
 
-func (b wrapper) DeleteFolder(ctx context.Context, path string) error {
+// createFolder skips folders which are not allowed, the parent folder of an allowed file may not be allowed itself.
+func (b wrapper) createFolder(ctx context.Context, path string, options *CreateFolderOptions) error {
 	if err := b.validatePath(path); err != nil {
 		return err
 	}
 
-	if !b.pathFilters.isAllowed(path) {
+	if !b.isAllowed(path) {
 		return nil
 	}
 
-	isEmpty, err := b.isFolderEmpty(ctx, path)
-	if err != nil {
+	if b.pathFilters != nil {
+		withFilter := CreateFolderOptions{}
+		if options != nil {
+			withFilter = *options
+		}
+		withFilter.filter = b.pathFilters
+		options = &withFilter
+	}
+
+	return b.wrapped.CreateFolder(ctx, path, options)
+}
+
+func (b wrapper) DeleteFolder(ctx context.Context, path string, options *DeleteFolderOptions) error {
+	if err := b.checkOperation(OperationDeleteFolder); err != nil {
+		return err
+	}
+
+	if err := b.validatePath(path); err != nil {
 		return err
 	}
 
-	if !isEmpty {
-		return fmt.Errorf("folder %s is not empty - cant remove it", path)
+	if !b.isAllowed(path) {
+		return fmt.Errorf("%w: %s", ErrPathNotAllowed, path)
 	}
 
-	return b.wrapped.DeleteFolder(ctx, path)
+	return b.wrapped.DeleteFolder(ctx, path, options)
 }
 
-func (b wrapper) isFolderEmpty(ctx context.Context, path string) (bool, error) {
-	filesInFolder, err := b.ListFiles(ctx, path, &Paging{First: 1}, &ListOptions{Recursive: true})
-	if err != nil {
-		return false, err
+func (b wrapper) ListVersions(ctx context.Context, path string) ([]FileMetadata, error) {
+	if err := b.checkOperation(OperationGet); err != nil {
+		return nil, err
 	}
 
-	if len(filesInFolder.Files) > 0 {
-		return false, nil
+	if err := b.validatePath(path); err != nil {
+		return nil, err
 	}
 
-	folders, err := b.ListFolders(ctx, path, &ListOptions{
-		Recursive: true,
-	})
-	if err != nil {
-		return false, err
+	if !b.isAllowed(path) {
+		return []FileMetadata{}, nil
 	}
 
-	if len(folders) > 0 {
-		return false, nil
+	return b.wrapped.ListVersions(ctx, path)
+}
+
+func (b wrapper) GetVersion(ctx context.Context, path string, version string) (*File, error) {
+	if err := b.checkOperation(OperationGet); err != nil {
+		return nil, err
+	}
+
+	if err := b.validatePath(path); err != nil {
+		return nil, err
+	}
+
+	if !b.isAllowed(path) {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, path)
+	}
+
+	return b.wrapped.GetVersion(ctx, path, version)
+}
+
+// Restore writes the restored files, so it requires the upsert operation.
+func (b wrapper) Restore(ctx context.Context, path string) error {
+	if err := b.checkOperation(OperationUpsert); err != nil {
+		return err
+	}
+
+	if err := b.validatePath(path); err != nil {
+		return err
+	}
+
+	if !b.isAllowed(path) {
+		return fmt.Errorf("%w: %s", ErrPathNotAllowed, path)
+	}
+
+	return b.wrapped.Restore(ctx, path)
+}
+
+func (b wrapper) PurgeTrash(ctx context.Context, path string) error {
+	if err := b.checkOperation(OperationDelete); err != nil {
+		return err
+	}
+
+	if err := b.validatePath(path); err != nil {
+		return err
 	}
 
-	return true, nil
+	if !b.isAllowed(path) {
+		return fmt.Errorf("%w: %s", ErrPathNotAllowed, path)
+	}
+
+	return b.wrapped.PurgeTrash(ctx, path)
+}
+
+func (b wrapper) HealthCheck(ctx context.Context) error {
+	return b.wrapped.HealthCheck(ctx)
 }
 
 func (b wrapper) close() error {
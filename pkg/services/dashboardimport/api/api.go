@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/grafana/grafana/pkg/api/apierrors"
@@ -13,6 +14,7 @@ import (
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	acmiddleware "github.com/grafana/grafana/pkg/services/accesscontrol/middleware"
 	"github.com/grafana/grafana/pkg/services/dashboardimport"
+	"github.com/grafana/grafana/pkg/util"
 	"github.com/grafana/grafana/pkg/web"
 )
 
@@ -52,10 +54,6 @@ func (api *ImportDashboardAPI) ImportDashboard(c *models.ReqContext) response.Re
 		return response.Error(http.StatusBadRequest, "bad request data", err)
 	}
 
-	if req.PluginId == "" && req.Dashboard == nil {
-		return response.Error(http.StatusUnprocessableEntity, "Dashboard must be set", nil)
-	}
-
 	limitReached, err := api.quotaService.QuotaReached(c, "dashboard")
 	if err != nil {
 		return response.Error(500, "failed to get quota", err)
@@ -66,7 +64,7 @@ func (api *ImportDashboardAPI) ImportDashboard(c *models.ReqContext) response.Re
 	}
 
 	trimDefaults := c.QueryBoolWithDefault("trimdefaults", true)
-	if trimDefaults && !api.schemaLoaderService.IsDisabled() {
+	if trimDefaults && !api.schemaLoaderService.IsDisabled() && req.Dashboard != nil {
 		req.Dashboard, err = api.schemaLoaderService.DashboardApplyDefaults(req.Dashboard)
 		if err != nil {
 			return response.Error(http.StatusInternalServerError, "Error while applying default value to the dashboard json", err)
@@ -76,6 +74,30 @@ func (api *ImportDashboardAPI) ImportDashboard(c *models.ReqContext) response.Re
 	req.User = c.SignedInUser
 	resp, err := api.dashboardImportService.ImportDashboard(c.Req.Context(), &req)
 	if err != nil {
+		var missingInputsErr dashboardimport.MissingInputsError
+		if errors.As(err, &missingInputsErr) {
+			return response.JSON(http.StatusUnprocessableEntity, util.DynMap{
+				"message":       missingInputsErr.Error(),
+				"missingInputs": missingInputsErr.MissingInputs,
+			})
+		}
+		var missingDatasourcesErr dashboardimport.MissingDatasourcesError
+		if errors.As(err, &missingDatasourcesErr) {
+			return response.JSON(http.StatusUnprocessableEntity, util.DynMap{
+				"message":            missingDatasourcesErr.Error(),
+				"missingDatasources": missingDatasourcesErr.Datasources,
+			})
+		}
+		if errors.Is(err, dashboardimport.ErrNoDashboardSource) {
+			return response.Error(http.StatusUnprocessableEntity, err.Error(), nil)
+		}
+		if errors.Is(err, dashboardimport.ErrDashboardURLNotAllowed) || errors.Is(err, dashboardimport.ErrLibraryPanelCycle) {
+			return response.Error(http.StatusBadRequest, err.Error(), nil)
+		}
+		// an existing dashboard is only replaced when the request explicitly allows overwriting it
+		if errors.Is(err, models.ErrDashboardWithSameUIDExists) || errors.Is(err, models.ErrDashboardWithSameNameInFolderExists) {
+			return response.Error(http.StatusPreconditionFailed, err.Error(), nil)
+		}
 		return apierrors.ToDashboardErrorResponse(c.Req.Context(), api.pluginStore, err)
 	}
 
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/grafana/grafana/pkg/api/routing"
@@ -22,6 +23,12 @@ func TestImportDashboardAPI(t *testing.T) {
 		service := &serviceMock{
 			importDashboardFunc: func(ctx context.Context, req *dashboardimport.ImportDashboardRequest) (*dashboardimport.ImportDashboardResponse, error) {
 				importDashboardServiceCalled = true
+				if req.Dashboard == nil && req.URL == "" {
+					return nil, dashboardimport.ErrNoDashboardSource
+				}
+				if strings.HasPrefix(req.URL, "file://") {
+					return nil, dashboardimport.ErrDashboardURLNotAllowed
+				}
 				return nil, nil
 			},
 		}
@@ -69,6 +76,30 @@ func TestImportDashboardAPI(t *testing.T) {
 			require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
 		})
 
+		t.Run("Signed in, only url set should call import dashboard service", func(t *testing.T) {
+			for _, tc := range []struct {
+				url            string
+				expectedStatus int
+			}{
+				{url: "https://dashboards.example.com/dashboard.json", expectedStatus: http.StatusOK},
+				{url: "file:///etc/passwd", expectedStatus: http.StatusBadRequest},
+			} {
+				importDashboardServiceCalled = false
+				jsonBytes, err := json.Marshal(map[string]string{"url": tc.url})
+				require.NoError(t, err)
+				req := s.NewRequest(http.MethodPost, "/api/dashboards/import", bytes.NewReader(jsonBytes))
+				req.Header.Add("Content-Type", "application/json")
+				webtest.RequestWithSignedInUser(req, &models.SignedInUser{
+					UserId: 1,
+				})
+				resp, err := s.Send(req)
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+				require.Equal(t, tc.expectedStatus, resp.StatusCode)
+				require.True(t, importDashboardServiceCalled)
+			}
+		})
+
 		t.Run("Signed in, dashboard model set should call import dashboard service", func(t *testing.T) {
 			cmd := &dashboardimport.ImportDashboardRequest{
 				Dashboard: simplejson.New(),
@@ -150,6 +181,46 @@ func TestImportDashboardAPI(t *testing.T) {
 		})
 	})
 
+	t.Run("Quota not reached, dashboard already exists", func(t *testing.T) {
+		service := &serviceMock{
+			importDashboardFunc: func(ctx context.Context, req *dashboardimport.ImportDashboardRequest) (*dashboardimport.ImportDashboardResponse, error) {
+				if req.Overwrite {
+					return &dashboardimport.ImportDashboardResponse{}, nil
+				}
+				return nil, models.ErrDashboardWithSameUIDExists
+			},
+		}
+
+		importDashboardAPI := New(service, quotaServiceFunc(quotaNotReached), &schemaLoaderServiceMock{}, nil, acmock.New().WithDisabled())
+		routeRegister := routing.NewRouteRegister()
+		importDashboardAPI.RegisterAPIEndpoints(routeRegister)
+		s := webtest.NewServer(t, routeRegister)
+
+		for _, tc := range []struct {
+			overwrite      bool
+			expectedStatus int
+		}{
+			{overwrite: false, expectedStatus: http.StatusPreconditionFailed},
+			{overwrite: true, expectedStatus: http.StatusOK},
+		} {
+			cmd := &dashboardimport.ImportDashboardRequest{
+				Dashboard: simplejson.New(),
+				Overwrite: tc.overwrite,
+			}
+			jsonBytes, err := json.Marshal(cmd)
+			require.NoError(t, err)
+			req := s.NewRequest(http.MethodPost, "/api/dashboards/import", bytes.NewReader(jsonBytes))
+			req.Header.Add("Content-Type", "application/json")
+			webtest.RequestWithSignedInUser(req, &models.SignedInUser{
+				UserId: 1,
+			})
+			resp, err := s.Send(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			require.Equal(t, tc.expectedStatus, resp.StatusCode)
+		}
+	})
+
 	t.Run("Quota reached", func(t *testing.T) {
 		service := &serviceMock{}
 		schemaLoaderService := &schemaLoaderServiceMock{}
@@ -179,6 +250,7 @@ func TestImportDashboardAPI(t *testing.T) {
 }
 
 type serviceMock struct {
+	dashboardimport.Service
 	importDashboardFunc func(ctx context.Context, req *dashboardimport.ImportDashboardRequest) (*dashboardimport.ImportDashboardResponse, error)
 }
 
@@ -2,51 +2,280 @@ package dashboardimport
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/grafana/grafana/pkg/components/simplejson"
 	"github.com/grafana/grafana/pkg/models"
 )
 
+// ErrGnetDashboardNotFound returned when a dashboard does not exist on grafana.com.
+var ErrGnetDashboardNotFound = errors.New("dashboard not found on grafana.com")
+
+// ErrInvalidTitleConflictMode returned when the import request sets an unknown OnTitleConflict mode.
+var ErrInvalidTitleConflictMode = errors.New("invalid title conflict mode")
+
+// ErrNoDashboardSource returned when the import request sets none of the sources the dashboard can be loaded from:
+// a plugin, the dashboard JSON, the raw dashboard bytes, a grafana.com dashboard ID or a URL.
+var ErrNoDashboardSource = errors.New("no dashboard source: one of pluginId, dashboard, gnetId or url must be set")
+
+// ErrDashboardURLNotAllowed returned when the dashboard URL of an import request is not an HTTP(S) URL, or its host
+// is not allowed by the configuration.
+var ErrDashboardURLNotAllowed = errors.New("dashboard URL not allowed")
+
+// ErrDashboardTooLarge returned when the dashboard downloaded from a URL is larger than the size limit.
+var ErrDashboardTooLarge = errors.New("dashboard too large")
+
+// ErrLibraryPanelCycle returned when the model of a library panel exported with the dashboard uses the library
+// panel itself, directly or through the models of other exported library panels.
+var ErrLibraryPanelCycle = errors.New("library panel references itself")
+
+// ErrOrgIdsWithUser returned when an import request sets both OrgIds and the user importing into a single org.
+var ErrOrgIdsWithUser = errors.New("orgIds and user are mutually exclusive")
+
+// ErrOrgIdsNotSupported returned when an import request setting OrgIds is passed to ImportDashboard, which imports
+// into the org of the user only.
+var ErrOrgIdsNotSupported = errors.New("orgIds are only supported by ImportDashboardIntoOrgs")
+
+// GnetDashboardError returned when downloading a dashboard from grafana.com fails with an unexpected status code.
+type GnetDashboardError struct {
+	GnetId     int64
+	StatusCode int
+}
+
+func (e GnetDashboardError) Error() string {
+	return fmt.Sprintf("failed to download dashboard %d from grafana.com: status code %d", e.GnetId, e.StatusCode)
+}
+
+// DashboardURLError returned when downloading a dashboard from a URL fails with an unexpected status code.
+type DashboardURLError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e DashboardURLError) Error() string {
+	return fmt.Sprintf("failed to download dashboard from %s: status code %d", e.URL, e.StatusCode)
+}
+
 // ImportDashboardInput definition of input parameters when importing a dashboard.
 type ImportDashboardInput struct {
-	Type     string `json:"type"`
+	Type string `json:"type"`
+	// PluginId scopes a wildcard input, i.e. one named "*", to the inputs of the dashboard declared with the
+	// same plugin ID, e.g. the datasource type. Inputs of the dashboard declared without a plugin ID match any
+	// wildcard input.
 	PluginId string `json:"pluginId"`
 	Name     string `json:"name"`
 	Value    string `json:"value"`
+	Label    string `json:"label,omitempty"`
+}
+
+// MissingInputsError returned when the dashboard declares inputs that are not provided by the import request.
+type MissingInputsError struct {
+	MissingInputs []ImportDashboardInput
+}
+
+func (e MissingInputsError) Error() string {
+	names := make([]string, 0, len(e.MissingInputs))
+	for _, input := range e.MissingInputs {
+		names = append(names, input.Name)
+	}
+	return fmt.Sprintf("Dashboard input variables: %s missing from import command", strings.Join(names, ", "))
+}
+
+// MissingDatasourcesError returned in strict mode when datasource inputs point to datasources that do not exist.
+type MissingDatasourcesError struct {
+	Datasources []string
+}
+
+func (e MissingDatasourcesError) Error() string {
+	return fmt.Sprintf("Datasources: %s not found", strings.Join(e.Datasources, ", "))
+}
+
+// ImportDashboardsError returned by a batch import when some of the dashboards failed to import.
+type ImportDashboardsError struct {
+	// Errors holds the import errors by the index of the failed request.
+	Errors map[int]error
+}
+
+func (e ImportDashboardsError) Error() string {
+	indexes := make([]int, 0, len(e.Errors))
+	for i := range e.Errors {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	messages := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		messages = append(messages, fmt.Sprintf("dashboard %d: %s", i, e.Errors[i]))
+	}
+	return fmt.Sprintf("failed to import %d dashboards: %s", len(e.Errors), strings.Join(messages, "; "))
 }
 
+// DashboardParseError returned when the dashboard JSON bytes of an import request can not be parsed.
+type DashboardParseError struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e DashboardParseError) Error() string {
+	return fmt.Sprintf("failed to parse dashboard JSON at line %d, column %d: %s", e.Line, e.Column, e.Err)
+}
+
+func (e DashboardParseError) Unwrap() error {
+	return e.Err
+}
+
+// TitleConflictMode tells the import what to do when the folder already has a dashboard with the same title.
+type TitleConflictMode string
+
+const (
+	// TitleConflictFail fails the import.
+	TitleConflictFail TitleConflictMode = "fail"
+	// TitleConflictSuffix imports the dashboard as a new dashboard titled "<title> (Imported N)".
+	TitleConflictSuffix TitleConflictMode = "suffix"
+	// TitleConflictOverwrite overwrites the existing dashboard.
+	TitleConflictOverwrite TitleConflictMode = "overwrite"
+)
+
 // ImportDashboardRequest request object for importing a dashboard.
 type ImportDashboardRequest struct {
-	PluginId  string                 `json:"pluginId"`
-	Path      string                 `json:"path"`
-	Overwrite bool                   `json:"overwrite"`
-	Dashboard *simplejson.Json       `json:"dashboard"`
-	Inputs    []ImportDashboardInput `json:"inputs"`
-	FolderId  int64                  `json:"folderId"`
-	FolderUid string                 `json:"folderUid"`
+	PluginId                string                 `json:"pluginId"`
+	Path                    string                 `json:"path"`
+	Overwrite               bool                   `json:"overwrite"`
+	Dashboard               *simplejson.Json       `json:"dashboard"`
+	GnetId                  int64                  `json:"gnetId"`
+	Inputs                  []ImportDashboardInput `json:"inputs"`
+	FolderId                int64                  `json:"folderId"`
+	FolderUid               string                 `json:"folderUid"`
+	FolderTitle             string                 `json:"folderTitle"`
+	DryRun                  bool                   `json:"dryRun"`
+	RegenerateUID           bool                   `json:"regenerateUid"`
+	FailOnMissingDatasource bool                   `json:"failOnMissingDatasource"`
+	ContinueOnError         bool                   `json:"continueOnError"`
+
+	// Message is stored as the message of the saved dashboard version. It defaults to "Imported via API".
+	Message string `json:"message"`
+
+	// OnTitleConflict handles a dashboard with the same title in the target folder. When not set, the import
+	// only overwrites it if Overwrite is set.
+	OnTitleConflict TitleConflictMode `json:"onTitleConflict,omitempty"`
+
+	// PreservePanelIDs keeps the IDs of the panels of the overwritten dashboard with the same UID, so that the
+	// annotations and links referencing them stay valid. The incoming panels are matched to the existing ones by
+	// title, then by position. It only applies when the import overwrites the dashboard.
+	PreservePanelIDs bool `json:"preservePanelIds"`
+
+	// ImportLibraryPanelsOnly creates the library panels of the dashboard in the target folder without saving
+	// the dashboard itself. The library panels are not created in a dry run.
+	ImportLibraryPanelsOnly bool `json:"importLibraryPanelsOnly"`
+
+	// DashboardBytes is the raw dashboard JSON, parsed when Dashboard is not set.
+	DashboardBytes []byte `json:"-"`
+
+	// URL is an HTTP(S) URL the dashboard JSON is downloaded from when no other source is set. The hosts it can
+	// point at can be restricted in the configuration.
+	URL string `json:"url,omitempty"`
+
+	// Migrate upgrades the dashboard to the latest schema version supported by the backend before it is saved.
+	// It defaults to true when not set.
+	Migrate *bool `json:"migrate,omitempty"`
+
+	// Sanitize removes the fields which only make sense in the instance the dashboard was exported from before it
+	// is saved: the id, the version and the "__inputs" and "__requires" metadata. It defaults to true when not set.
+	Sanitize *bool `json:"sanitize,omitempty"`
+
+	// OrgIds are the orgs ImportDashboardIntoOrgs imports the dashboard into, as an org admin. It is mutually
+	// exclusive with User, which imports into the org of the user.
+	OrgIds []int64 `json:"-"`
 
 	User *models.SignedInUser `json:"-"`
 }
 
 // ImportDashboardResponse response object returned when importing a dashboard.
 type ImportDashboardResponse struct {
-	UID              string `json:"uid"`
-	PluginId         string `json:"pluginId"`
-	Title            string `json:"title"`
-	Imported         bool   `json:"imported"`
-	ImportedUri      string `json:"importedUri"`
-	ImportedUrl      string `json:"importedUrl"`
-	Slug             string `json:"slug"`
-	DashboardId      int64  `json:"dashboardId"`
-	FolderId         int64  `json:"folderId"`
-	ImportedRevision int64  `json:"importedRevision"`
-	Revision         int64  `json:"revision"`
-	Description      string `json:"description"`
-	Path             string `json:"path"`
-	Removed          bool   `json:"removed"`
+	UID              string   `json:"uid"`
+	PluginId         string   `json:"pluginId"`
+	Title            string   `json:"title"`
+	Imported         bool     `json:"imported"`
+	ImportedUri      string   `json:"importedUri"`
+	ImportedUrl      string   `json:"importedUrl"`
+	Slug             string   `json:"slug"`
+	DashboardId      int64    `json:"dashboardId"`
+	FolderId         int64    `json:"folderId"`
+	ImportedRevision int64    `json:"importedRevision"`
+	Revision         int64    `json:"revision"`
+	Description      string   `json:"description"`
+	Path             string   `json:"path"`
+	Removed          bool     `json:"removed"`
+	Warnings         []string `json:"warnings,omitempty"`
+
+	// OrgId is the org the dashboard was imported into, it is only set by ImportDashboardIntoOrgs.
+	OrgId int64 `json:"orgId,omitempty"`
+
+	// ImportedLibraryPanels are the UIDs of the library panels created by the import, ConnectedLibraryPanels
+	// the UIDs of the existing library panels reused by the dashboard.
+	ImportedLibraryPanels  []string `json:"importedLibraryPanels,omitempty"`
+	ConnectedLibraryPanels []string `json:"connectedLibraryPanels,omitempty"`
+
+	// DatasourceMappings maps the name of each datasource input of the dashboard to the UID of the datasource
+	// it was given, or to the input value itself if it matches no datasource.
+	DatasourceMappings map[string]string `json:"datasourceMappings,omitempty"`
+}
+
+// ImportPreview describes how importing a dashboard would change the existing dashboard with the same UID.
+type ImportPreview struct {
+	UID   string `json:"uid"`
+	Title string `json:"title"`
+	// Exists is false if no dashboard has the UID yet, the import would then create the dashboard.
+	Exists bool `json:"exists"`
+	// ChangedFields are the top-level fields of the dashboard whose values would change, sorted by name.
+	// The panels are only compared by their count, the id and version fields are not compared.
+	ChangedFields      []FieldChange `json:"changedFields"`
+	ExistingPanelCount int           `json:"existingPanelCount"`
+	IncomingPanelCount int           `json:"incomingPanelCount"`
+	PanelsAdded        int           `json:"panelsAdded"`
+	PanelsRemoved      int           `json:"panelsRemoved"`
+}
+
+// FieldChange is a top-level dashboard field changed by an import, a nil value means the field is not set.
+type FieldChange struct {
+	Field    string      `json:"field"`
+	Existing interface{} `json:"existing"`
+	Incoming interface{} `json:"incoming"`
 }
 
 // Service service interface for importing dashboards.
 type Service interface {
 	ImportDashboard(ctx context.Context, req *ImportDashboardRequest) (*ImportDashboardResponse, error)
+	// ImportDashboards imports the dashboards one by one. The responses are in the order of the requests, with nil
+	// for the dashboards which failed to import. A failure stops the import unless the failed request has
+	// ContinueOnError set, the errors are returned as ImportDashboardsError.
+	ImportDashboards(ctx context.Context, reqs []*ImportDashboardRequest) ([]*ImportDashboardResponse, error)
+	// ImportDashboardsFromDir imports the JSON files in the directory with the inputs, folder and options of the
+	// request. Files which can not be parsed are reported as ImportDashboardsError without stopping the import.
+	ImportDashboardsFromDir(ctx context.Context, dir string, req *ImportDashboardRequest) ([]*ImportDashboardResponse, error)
+	// ImportDashboardIntoOrgs imports the dashboard of the request into each of its OrgIds, along with its library
+	// panels. The responses are in the order of the orgs, with nil for the orgs the import failed in. A failure
+	// stops the import unless the request has ContinueOnError set, the errors are returned as
+	// ImportDashboardsError.
+	ImportDashboardIntoOrgs(ctx context.Context, req *ImportDashboardRequest) ([]*ImportDashboardResponse, error)
+	// ImportDashboardPreview compares the dashboard of the request, with the inputs substituted, to the existing
+	// dashboard with the same UID without saving anything.
+	ImportDashboardPreview(ctx context.Context, req *ImportDashboardRequest) (*ImportPreview, error)
+}
+
+// DatasourceRef is the datasource written into the object datasource references of an imported dashboard.
+type DatasourceRef struct {
+	UID  string
+	Type string
+}
+
+// DatasourceResolver resolves the datasource inputs of imported dashboards to the datasources of the org.
+type DatasourceResolver interface {
+	// ResolveDatasource returns the datasource of the org the input refers to, or nil if there is none. The plugin
+	// ID of the input is the datasource type the dashboard declares the input with.
+	ResolveDatasource(ctx context.Context, orgID int64, input ImportDashboardInput) (*DatasourceRef, error)
 }
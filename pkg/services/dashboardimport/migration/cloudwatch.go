@@ -0,0 +1,141 @@
+package migration
+
+const (
+	cloudWatchMetricQueryTypeSearch = 0
+	cloudWatchMetricQueryTypeQuery  = 1
+
+	cloudWatchMetricEditorModeBuilder = 0
+	cloudWatchMetricEditorModeCode    = 1
+
+	refIDLetters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+// upgradeCloudWatchQueries sets the query type and editor mode of the CloudWatch metric queries, and splits the
+// queries with several statistics into one query per statistic. The new queries are added after the others.
+func upgradeCloudWatchQueries(panel map[string]interface{}) {
+	targets, ok := panel["targets"].([]interface{})
+	if !ok {
+		return
+	}
+
+	// the new queries are upgraded too, like the frontend which iterates over the growing targets
+	for i := 0; i < len(targets); i++ {
+		target, ok := targets[i].(map[string]interface{})
+		if !ok || !hasKeys(target, "dimensions", "namespace", "region", "metricName") {
+			continue
+		}
+
+		if _, ok := target["metricQueryType"]; !ok {
+			target["metricQueryType"] = toNumber(cloudWatchMetricQueryTypeSearch)
+		}
+		if _, ok := target["metricEditorMode"]; !ok {
+			queryType, _ := toInt(target["metricQueryType"])
+			if queryType == cloudWatchMetricQueryTypeQuery || isTruthy(target["expression"]) {
+				target["metricEditorMode"] = toNumber(cloudWatchMetricEditorModeCode)
+			} else {
+				target["metricEditorMode"] = toNumber(cloudWatchMetricEditorModeBuilder)
+			}
+		}
+
+		statistics, ok := target["statistics"]
+		if !ok {
+			continue
+		}
+		delete(target, "statistics")
+
+		stats, _ := statistics.([]interface{})
+		if len(stats) == 0 {
+			continue
+		}
+
+		target["statistic"] = stats[0]
+		for _, stat := range stats[1:] {
+			newTarget := copyObject(target)
+			newTarget["statistic"] = stat
+			newTarget["refId"] = nextRefID(targets)
+			targets = append(targets, newTarget)
+		}
+	}
+
+	panel["targets"] = targets
+}
+
+// upgradeCloudWatchAnnotations splits the CloudWatch annotations with several statistics into one annotation per
+// statistic, whose names are suffixed with the statistic. The new annotations are added after the others.
+func upgradeCloudWatchAnnotations(data map[string]interface{}) {
+	annotations, ok := data["annotations"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	list, ok := annotations["list"].([]interface{})
+	if !ok {
+		return
+	}
+
+	for _, annotation := range objects(list) {
+		if !hasKeys(annotation, "dimensions", "namespace", "region", "prefixMatching", "statistics") {
+			continue
+		}
+
+		stats, _ := annotation["statistics"].([]interface{})
+		if len(stats) == 0 {
+			continue
+		}
+		delete(annotation, "statistics")
+
+		name := jsString(annotation["name"])
+		for _, stat := range stats[1:] {
+			newAnnotation := copyObject(annotation)
+			newAnnotation["statistic"] = stat
+			newAnnotation["name"] = name + " - " + jsString(stat)
+			list = append(list, newAnnotation)
+		}
+
+		annotation["statistic"] = stats[0]
+		// the original is only renamed if new annotations were added
+		if len(stats) > 1 {
+			annotation["name"] = name + " - " + jsString(stats[0])
+		}
+	}
+
+	annotations["list"] = list
+}
+
+// nextRefID returns the first reference ID not used by the queries, going from A to Z and then from AA onwards.
+func nextRefID(queries []interface{}) string {
+	used := make(map[interface{}]bool, len(queries))
+	for _, query := range objects(queries) {
+		used[query["refId"]] = true
+	}
+
+	for num := 0; ; num++ {
+		if refID := refIDForNumber(num); !used[refID] {
+			return refID
+		}
+	}
+}
+
+func refIDForNumber(num int) string {
+	if num < len(refIDLetters) {
+		return refIDLetters[num : num+1]
+	}
+	return refIDForNumber(num/len(refIDLetters)-1) + refIDLetters[num%len(refIDLetters):num%len(refIDLetters)+1]
+}
+
+func hasKeys(object map[string]interface{}, keys ...string) bool {
+	for _, key := range keys {
+		if _, ok := object[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// copyObject returns a shallow copy of the object, like the spread syntax in JavaScript.
+func copyObject(object map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(object))
+	for key, value := range object {
+		result[key] = value
+	}
+	return result
+}
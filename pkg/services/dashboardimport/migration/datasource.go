@@ -0,0 +1,67 @@
+package migration
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// datasourceRefs holds the references of the datasources of an org by name, including the built-in datasources.
+type datasourceRefs map[string]map[string]interface{}
+
+func (m *DashboardMigrator) datasourceRefs(ctx context.Context, orgID int64) (datasourceRefs, error) {
+	refs := datasourceRefs{
+		"-- Grafana --":   {"type": "datasource", "uid": "grafana"},
+		"-- Mixed --":     {"type": "datasource", "uid": "-- Mixed --"},
+		"-- Dashboard --": {"type": "datasource", "uid": "-- Dashboard --"},
+	}
+
+	if m.dataSourceService == nil {
+		return refs, nil
+	}
+
+	query := &models.GetDataSourcesQuery{OrgId: orgID}
+	if err := m.dataSourceService.GetDataSources(ctx, query); err != nil {
+		return nil, err
+	}
+
+	for _, ds := range query.Result {
+		refs[ds.Name] = map[string]interface{}{"type": ds.Type, "uid": ds.Uid}
+	}
+	return refs, nil
+}
+
+// upgradePanel replaces the datasource names of the panel and of its queries with references. The default
+// datasource of the panel becomes null, queries using the default datasource are left as they are.
+func (r datasourceRefs) upgradePanel(panel map[string]interface{}) {
+	if datasource, ok := panel["datasource"]; ok {
+		panel["datasource"] = r.ref(datasource)
+	}
+
+	for _, target := range objects(panel["targets"]) {
+		if ref := r.ref(target["datasource"]); ref != nil {
+			target["datasource"] = ref
+		}
+	}
+}
+
+// ref returns the reference of the datasource name, or the name as UID if the org has no datasource with the name.
+// Template variables and import inputs are thus kept, they are resolved when used.
+func (r datasourceRefs) ref(nameOrRef interface{}) interface{} {
+	switch v := nameOrRef.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		return v
+	case string:
+		if v == "" || v == "default" {
+			return nil
+		}
+
+		if ref, ok := r[v]; ok {
+			return map[string]interface{}{"type": ref["type"], "uid": ref["uid"]}
+		}
+		return map[string]interface{}{"uid": v}
+	}
+	return nameOrRef
+}
@@ -0,0 +1,625 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/plugins"
+	"github.com/grafana/grafana/pkg/services/datasources"
+)
+
+const (
+	// MinSchemaVersion is the oldest schema version the migrator upgrades. Older dashboards need layout
+	// migrations which are only implemented in the frontend, so they are left for it to migrate as a whole.
+	MinSchemaVersion = 16
+	// LatestSchemaVersion is the schema version dashboards are upgraded to. The frontend applies the
+	// migrations of any newer version when the dashboard is loaded.
+	LatestSchemaVersion = 35
+
+	gridColumnCount = 24
+)
+
+var (
+	legacyVariableNamesRegex = regexp.MustCompile(`(__series_name)|(\$__series_name)|(__value_time)|(__field_name)|(\$__field_name)`)
+	seriesLabelsRegex        = regexp.MustCompile(`__series.labels`)
+	nonSlugCharactersRegex   = regexp.MustCompile(`[^\w ]+`)
+	spacesRegex              = regexp.MustCompile(` +`)
+)
+
+// Service upgrades dashboard JSON models to a newer schema version.
+type Service interface {
+	// Migrate upgrades the dashboard of the org, the datasource names it uses are looked up in the org.
+	Migrate(ctx context.Context, orgID int64, dashboard *simplejson.Json) error
+}
+
+// DashboardMigrator ports the steps of the frontend DashboardMigrator from MinSchemaVersion up to LatestSchemaVersion.
+// Without a plugin store singlestat panels are always converted to stat or gauge panels, and without a datasource
+// service datasource names are referenced by UID, which the frontend also resolves by name.
+type DashboardMigrator struct {
+	pluginStore       plugins.Store
+	dataSourceService datasources.DataSourceService
+}
+
+func ProvideService(pluginStore plugins.Store, dataSourceService datasources.DataSourceService) *DashboardMigrator {
+	return &DashboardMigrator{
+		pluginStore:       pluginStore,
+		dataSourceService: dataSourceService,
+	}
+}
+
+type panelUpgrade func(panel map[string]interface{})
+
+// Migrate upgrades the dashboard in place. Dashboards outside of the supported range of schema versions are left untouched.
+func (m *DashboardMigrator) Migrate(ctx context.Context, orgID int64, dashboard *simplejson.Json) error {
+	data, err := dashboard.Map()
+	if err != nil {
+		return fmt.Errorf("invalid dashboard model: %w", err)
+	}
+
+	oldVersion, ok := toInt(data["schemaVersion"])
+	if !ok || oldVersion < MinSchemaVersion || oldVersion >= LatestSchemaVersion {
+		return nil
+	}
+
+	panelUpgrades := make([]panelUpgrade, 0)
+	if oldVersion < 17 {
+		panelUpgrades = append(panelUpgrades, upgradeMinSpan)
+	}
+	if oldVersion < 18 {
+		panelUpgrades = append(panelUpgrades, upgradeGaugeOptions)
+	}
+	if oldVersion < 19 {
+		panelUpgrades = append(panelUpgrades, upgradePanelLinks)
+	}
+	if oldVersion < 20 {
+		panelUpgrades = append(panelUpgrades, upgradeDataLinksVariablesSyntax)
+	}
+	if oldVersion < 21 {
+		panelUpgrades = append(panelUpgrades, upgradeDataLinksSeriesLabels)
+	}
+	if oldVersion < 22 {
+		panelUpgrades = append(panelUpgrades, upgradeTableStylesAlignment)
+	}
+	if oldVersion < 23 {
+		for _, variable := range templateVariables(data) {
+			alignCurrentWithMulti(variable)
+		}
+	}
+	if oldVersion < 24 {
+		panelUpgrades = append(panelUpgrades, upgradeAngularTable)
+	}
+	if oldVersion < 26 {
+		panelUpgrades = append(panelUpgrades, upgradeReactText)
+	}
+	if oldVersion < 27 {
+		for _, variable := range templateVariables(data) {
+			upgradeConstantVariable(variable)
+		}
+	}
+	if oldVersion < 28 {
+		panelUpgrades = append(panelUpgrades, m.singlestatUpgrade(ctx))
+		for _, variable := range templateVariables(data) {
+			removeVariableTags(variable)
+		}
+	}
+	if oldVersion < 29 {
+		for _, variable := range templateVariables(data) {
+			upgradeQueryVariableRefresh(variable)
+		}
+	}
+	if oldVersion < 30 {
+		panelUpgrades = append(panelUpgrades, upgradeValueMappingsForPanel, upgradeTooltipOptions)
+	}
+	if oldVersion < 31 {
+		panelUpgrades = append(panelUpgrades, upgradeLabelsToFieldsTransformation)
+	}
+	// version 32 has no migration, the CloudWatch migrations were moved to version 34
+	if oldVersion < 33 {
+		refs, err := m.datasourceRefs(ctx, orgID)
+		if err != nil {
+			return err
+		}
+		panelUpgrades = append(panelUpgrades, refs.upgradePanel)
+	}
+	if oldVersion < 34 {
+		panelUpgrades = append(panelUpgrades, upgradeCloudWatchQueries)
+		upgradeCloudWatchAnnotations(data)
+	}
+	if oldVersion < 35 {
+		panelUpgrades = append(panelUpgrades, ensureXAxisVisibility)
+	}
+
+	for _, panel := range objects(data["panels"]) {
+		for _, upgrade := range panelUpgrades {
+			upgrade(panel)
+		}
+
+		// collapsed rows hold their panels
+		for _, nestedPanel := range objects(panel["panels"]) {
+			for _, upgrade := range panelUpgrades {
+				upgrade(nestedPanel)
+			}
+		}
+	}
+
+	data["schemaVersion"] = toNumber(LatestSchemaVersion)
+	return nil
+}
+
+// upgradeMinSpan replaces the minimum span of repeated panels with the maximum number of panels per row.
+func upgradeMinSpan(panel map[string]interface{}) {
+	minSpan, ok := toFloat(panel["minSpan"])
+	if ok && minSpan != 0 {
+		max := gridColumnCount / minSpan
+		factors := getFactors(gridColumnCount)
+		for i, factor := range factors {
+			if float64(factor) > max {
+				if i > 0 {
+					panel["maxPerRow"] = toNumber(factors[i-1])
+				}
+				break
+			}
+		}
+	}
+	delete(panel, "minSpan")
+}
+
+func getFactors(n int) []int {
+	factors := make([]int, 0)
+	for i := 1; i <= n; i++ {
+		if n%i == 0 {
+			factors = append(factors, i)
+		}
+	}
+	return factors
+}
+
+func upgradeGaugeOptions(panel map[string]interface{}) {
+	options, ok := panel["options-gauge"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	valueOptions := make(map[string]interface{})
+	for _, key := range []string{"unit", "stat", "decimals", "prefix", "suffix"} {
+		if value, ok := options[key]; ok {
+			valueOptions[key] = value
+		}
+		delete(options, key)
+	}
+	options["valueOptions"] = valueOptions
+
+	// thresholds used to be stored in reverse order
+	if thresholds, ok := options["thresholds"].([]interface{}); ok {
+		for i, j := 0, len(thresholds)-1; i < j; i, j = i+1, j-1 {
+			thresholds[i], thresholds[j] = thresholds[j], thresholds[i]
+		}
+	}
+
+	// this options prop was due to a bug
+	delete(options, "options")
+
+	panel["options"] = options
+	delete(panel, "options-gauge")
+}
+
+func upgradePanelLinks(panel map[string]interface{}) {
+	links, ok := panel["links"].([]interface{})
+	if !ok {
+		return
+	}
+
+	upgraded := make([]interface{}, 0, len(links))
+	for _, link := range links {
+		if linkMap, ok := link.(map[string]interface{}); ok {
+			upgraded = append(upgraded, upgradePanelLink(linkMap))
+		}
+	}
+	panel["links"] = upgraded
+}
+
+func upgradePanelLink(link map[string]interface{}) map[string]interface{} {
+	url, _ := link["url"].(string)
+	if dashboard, _ := link["dashboard"].(string); url == "" && dashboard != "" {
+		url = "dashboard/db/" + slugifyForURL(dashboard)
+	}
+	if dashURI, _ := link["dashUri"].(string); url == "" && dashURI != "" {
+		url = "dashboard/" + dashURI
+	}
+	// some models are incomplete and have no dashboard or dashUri
+	if url == "" {
+		url = "/"
+	}
+
+	if keepTime, _ := link["keepTime"].(bool); keepTime {
+		url = appendQueryToURL(url, "$__url_time_range")
+	}
+	if includeVars, _ := link["includeVars"].(bool); includeVars {
+		url = appendQueryToURL(url, "$__all_variables")
+	}
+	if params, _ := link["params"].(string); params != "" {
+		url = appendQueryToURL(url, params)
+	}
+
+	upgraded := map[string]interface{}{
+		"url": url,
+	}
+	for _, key := range []string{"title", "targetBlank"} {
+		if value, ok := link[key]; ok {
+			upgraded[key] = value
+		}
+	}
+	return upgraded
+}
+
+func slugifyForURL(s string) string {
+	return spacesRegex.ReplaceAllString(nonSlugCharactersRegex.ReplaceAllString(strings.ToLower(s), ""), "-")
+}
+
+func appendQueryToURL(url string, query string) string {
+	if query == "" {
+		return url
+	}
+
+	if pos := strings.Index(url, "?"); pos != -1 {
+		if len(url)-pos > 1 {
+			url += "&"
+		}
+	} else {
+		url += "?"
+	}
+	return url + query
+}
+
+func upgradeDataLinksVariablesSyntax(panel map[string]interface{}) {
+	updateVariablesSyntax := func(text string) string {
+		return legacyVariableNamesRegex.ReplaceAllStringFunc(text, func(match string) string {
+			switch match {
+			case "__series_name":
+				return "__series.name"
+			case "$__series_name":
+				return "${__series.name}"
+			case "__value_time":
+				return "__value.time"
+			case "__field_name":
+				return "__field.name"
+			case "$__field_name":
+				return "${__field.name}"
+			}
+			return match
+		})
+	}
+
+	updateDataLinks(panel, updateVariablesSyntax)
+
+	if defaults := fieldOptionsDefaults(panel); defaults != nil {
+		if title, ok := defaults["title"].(string); ok && title != "" {
+			defaults["title"] = updateVariablesSyntax(title)
+		}
+	}
+}
+
+func upgradeDataLinksSeriesLabels(panel map[string]interface{}) {
+	updateDataLinks(panel, func(url string) string {
+		return seriesLabelsRegex.ReplaceAllString(url, "__field.labels")
+	})
+}
+
+// updateDataLinks rewrites the URLs of the graph panel data links and of the field options default links.
+func updateDataLinks(panel map[string]interface{}, updateURL func(url string) string) {
+	updateLinks := func(links []interface{}) {
+		for _, link := range links {
+			if linkMap, ok := link.(map[string]interface{}); ok {
+				if url, ok := linkMap["url"].(string); ok {
+					linkMap["url"] = updateURL(url)
+				}
+			}
+		}
+	}
+
+	if options, ok := panel["options"].(map[string]interface{}); ok {
+		if dataLinks, ok := options["dataLinks"].([]interface{}); ok {
+			updateLinks(dataLinks)
+		}
+	}
+
+	if defaults := fieldOptionsDefaults(panel); defaults != nil {
+		if links, ok := defaults["links"].([]interface{}); ok {
+			updateLinks(links)
+		}
+	}
+}
+
+func fieldOptionsDefaults(panel map[string]interface{}) map[string]interface{} {
+	options, ok := panel["options"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	fieldOptions, ok := options["fieldOptions"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	defaults, _ := fieldOptions["defaults"].(map[string]interface{})
+	return defaults
+}
+
+func upgradeTableStylesAlignment(panel map[string]interface{}) {
+	if panel["type"] != "table" {
+		return
+	}
+
+	for _, style := range objects(panel["styles"]) {
+		style["align"] = "auto"
+	}
+}
+
+// upgradeAngularTable keeps customized angular tables on the old table panel.
+func upgradeAngularTable(panel map[string]interface{}) {
+	if panel["type"] != "table" {
+		return
+	}
+
+	if _, ok := panel["styles"]; !ok {
+		// styles are missing so assumes default settings
+		return
+	}
+
+	if panel["table"] == "table2" {
+		return
+	}
+
+	panel["type"] = "table-old"
+}
+
+func upgradeReactText(panel map[string]interface{}) {
+	if panel["type"] != "text2" {
+		return
+	}
+
+	panel["type"] = "text"
+	if options, ok := panel["options"].(map[string]interface{}); ok {
+		delete(options, "angular")
+	}
+}
+
+func alignCurrentWithMulti(variable map[string]interface{}) {
+	multiValue, isMulti := variable["multi"]
+	if !isMulti {
+		return
+	}
+
+	current, ok := variable["current"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	multi, _ := multiValue.(bool)
+	_, isArray := current["value"].([]interface{})
+	if multi && !isArray {
+		current["value"] = convertToMulti(current["value"])
+		current["text"] = convertToMulti(current["text"])
+	} else if !multi && isArray {
+		current["value"] = convertToSingle(current["value"])
+		current["text"] = convertToSingle(current["text"])
+	}
+}
+
+func convertToMulti(value interface{}) interface{} {
+	if _, ok := value.([]interface{}); ok {
+		return value
+	}
+	return []interface{}{value}
+}
+
+func convertToSingle(value interface{}) interface{} {
+	values, ok := value.([]interface{})
+	if !ok {
+		return value
+	}
+
+	if len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// upgradeConstantVariable turns visible constants into text boxes and aligns their current value with the query.
+func upgradeConstantVariable(variable map[string]interface{}) {
+	if variable["type"] != "constant" {
+		return
+	}
+
+	if hide, ok := toInt(variable["hide"]); ok && (hide == 0 || hide == 1) {
+		variable["type"] = "textbox"
+	}
+
+	query, ok := variable["query"]
+	if !ok || query == nil {
+		query = ""
+	}
+
+	current := map[string]interface{}{
+		"selected": true,
+		"text":     query,
+		"value":    query,
+	}
+	variable["current"] = current
+	variable["options"] = []interface{}{current}
+}
+
+// removeVariableTags removes the tag options of the variables, the tags of query variables are not supported anymore.
+func removeVariableTags(variable map[string]interface{}) {
+	for _, key := range []string{"tags", "tagsQuery", "tagValuesQuery", "useTags"} {
+		if isTruthy(variable[key]) {
+			delete(variable, key)
+		}
+	}
+}
+
+// upgradeQueryVariableRefresh refreshes query variables at least on dashboard load, so that their options do not
+// have to be stored.
+func upgradeQueryVariableRefresh(variable map[string]interface{}) {
+	if variable["type"] != "query" {
+		return
+	}
+
+	if refresh, ok := toInt(variable["refresh"]); !ok || (refresh != 1 && refresh != 2) {
+		variable["refresh"] = toNumber(1)
+	}
+
+	if options, ok := variable["options"].([]interface{}); ok && len(options) > 0 {
+		variable["options"] = []interface{}{}
+	}
+}
+
+func upgradeTooltipOptions(panel map[string]interface{}) {
+	if panel["type"] != "timeseries" && panel["type"] != "xychart" {
+		return
+	}
+
+	options, ok := panel["options"].(map[string]interface{})
+	if !ok || !isTruthy(options["tooltipOptions"]) {
+		return
+	}
+
+	options["tooltip"] = options["tooltipOptions"]
+	delete(options, "tooltipOptions")
+}
+
+// upgradeLabelsToFieldsTransformation adds a merge transformation after the labels to fields transformations,
+// which do not merge the frames anymore.
+func upgradeLabelsToFieldsTransformation(panel map[string]interface{}) {
+	transformations, ok := panel["transformations"].([]interface{})
+	if !ok {
+		return
+	}
+
+	upgraded := make([]interface{}, 0, len(transformations))
+	found := false
+	for _, transformation := range transformations {
+		upgraded = append(upgraded, transformation)
+		if object, ok := transformation.(map[string]interface{}); ok && object["id"] == "labelsToFields" {
+			upgraded = append(upgraded, map[string]interface{}{
+				"id":      "merge",
+				"options": map[string]interface{}{},
+			})
+			found = true
+		}
+	}
+
+	if found {
+		panel["transformations"] = upgraded
+	}
+}
+
+// ensureXAxisVisibility keeps the time axis of time series panels visible when all their axes are hidden.
+func ensureXAxisVisibility(panel map[string]interface{}) {
+	if panel["type"] != "timeseries" {
+		return
+	}
+
+	fieldConfig, ok := panel["fieldConfig"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	defaults, _ := fieldConfig["defaults"].(map[string]interface{})
+	custom, _ := defaults["custom"].(map[string]interface{})
+	if custom["axisPlacement"] != "hidden" {
+		return
+	}
+
+	overrides, _ := fieldConfig["overrides"].([]interface{})
+	fieldConfig["overrides"] = append(overrides, map[string]interface{}{
+		"matcher": map[string]interface{}{
+			"id":      "byType",
+			"options": "time",
+		},
+		"properties": []interface{}{
+			map[string]interface{}{
+				"id":    "custom.axisPlacement",
+				"value": "auto",
+			},
+		},
+	})
+}
+
+func templateVariables(data map[string]interface{}) []map[string]interface{} {
+	templating, ok := data["templating"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return objects(templating["list"])
+}
+
+func objects(value interface{}) []map[string]interface{} {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	result := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		if object, ok := item.(map[string]interface{}); ok {
+			result = append(result, object)
+		}
+	}
+	return result
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// toNumber returns numbers the way they are decoded from dashboard JSON, since the template evaluator drops other numeric types.
+func toNumber(value int) json.Number {
+	return json.Number(strconv.Itoa(value))
+}
+
+// floatNumber converts the float to a JSON number, values which JSON can not represent become null like in the frontend.
+func floatNumber(value float64) interface{} {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil
+	}
+	return json.Number(strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// isTruthy reports whether the JSON value is truthy in JavaScript.
+func isTruthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case json.Number, float64, int, int64:
+		f, _ := toFloat(v)
+		return f != 0 && !math.IsNaN(f)
+	}
+	return true
+}
+
+func toInt(value interface{}) (int, bool) {
+	f, ok := toFloat(value)
+	return int(f), ok
+}
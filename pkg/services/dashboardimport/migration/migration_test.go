@@ -0,0 +1,390 @@
+package migration
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/plugins"
+	"github.com/grafana/grafana/pkg/services/datasources"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDashboardMigrator(t *testing.T) {
+	migrator := ProvideService(nil, nil)
+
+	t.Run("should migrate a schema version 16 dashboard to the latest version", func(t *testing.T) {
+		dashboard, err := simplejson.NewJson([]byte(`{
+			"schemaVersion": 16,
+			"title": "My Dashboard",
+			"panels": [
+				{
+					"id": 1,
+					"type": "graph",
+					"minSpan": 8,
+					"links": [
+						{"dashboard": "Other Dashboard", "keepTime": true, "includeVars": true, "params": "a=b", "title": "Other"},
+						{"url": "http://example.com?x=1", "targetBlank": true}
+					],
+					"options": {
+						"dataLinks": [{"url": "/d?series=$__series_name&time=__value_time&label=${__series.labels.job}"}]
+					}
+				},
+				{
+					"id": 2,
+					"type": "gauge",
+					"options-gauge": {
+						"unit": "ms",
+						"decimals": 2,
+						"thresholds": [{"value": 80}, {"value": 50}],
+						"options": {}
+					}
+				},
+				{
+					"id": 3,
+					"type": "table",
+					"styles": [{"pattern": "Time"}]
+				},
+				{
+					"id": 4,
+					"type": "row",
+					"collapsed": true,
+					"panels": [{"id": 5, "type": "text2", "options": {"angular": {}, "content": "hi"}}]
+				}
+			],
+			"templating": {
+				"list": [
+					{"name": "multi", "type": "query", "multi": true, "current": {"text": "a", "value": "a"}},
+					{"name": "single", "type": "query", "multi": false, "current": {"text": ["b", "c"], "value": ["b", "c"]}},
+					{"name": "visible", "type": "constant", "hide": 0, "query": "value"},
+					{"name": "hidden", "type": "constant", "hide": 2, "query": "secret"}
+				]
+			}
+		}`))
+		require.NoError(t, err)
+
+		err = migrator.Migrate(context.Background(), 1, dashboard)
+		require.NoError(t, err)
+
+		require.Equal(t, LatestSchemaVersion, dashboard.Get("schemaVersion").MustInt())
+
+		graph := dashboard.Get("panels").GetIndex(0)
+		_, hasMinSpan := graph.CheckGet("minSpan")
+		require.False(t, hasMinSpan)
+		require.Equal(t, 3, graph.Get("maxPerRow").MustInt())
+		require.Equal(t, "dashboard/db/other-dashboard?$__url_time_range&$__all_variables&a=b", graph.Get("links").GetIndex(0).Get("url").MustString())
+		require.Equal(t, "Other", graph.Get("links").GetIndex(0).Get("title").MustString())
+		require.Equal(t, "http://example.com?x=1", graph.Get("links").GetIndex(1).Get("url").MustString())
+		require.True(t, graph.Get("links").GetIndex(1).Get("targetBlank").MustBool())
+		require.Equal(t, "/d?series=${__series.name}&time=__value.time&label=${__field.labels.job}", graph.Get("options").Get("dataLinks").GetIndex(0).Get("url").MustString())
+
+		gauge := dashboard.Get("panels").GetIndex(1)
+		_, hasGaugeOptions := gauge.CheckGet("options-gauge")
+		require.False(t, hasGaugeOptions)
+		valueOptions := gauge.Get("options").Get("valueOptions")
+		require.Len(t, valueOptions.MustMap(), 2)
+		require.Equal(t, "ms", valueOptions.Get("unit").MustString())
+		require.Equal(t, 2, valueOptions.Get("decimals").MustInt())
+		require.Equal(t, 50, gauge.Get("options").Get("thresholds").GetIndex(0).Get("value").MustInt())
+		_, hasNestedOptions := gauge.Get("options").CheckGet("options")
+		require.False(t, hasNestedOptions)
+
+		table := dashboard.Get("panels").GetIndex(2)
+		require.Equal(t, "table-old", table.Get("type").MustString())
+		require.Equal(t, "auto", table.Get("styles").GetIndex(0).Get("align").MustString())
+
+		text := dashboard.Get("panels").GetIndex(3).Get("panels").GetIndex(0)
+		require.Equal(t, "text", text.Get("type").MustString())
+		_, hasAngular := text.Get("options").CheckGet("angular")
+		require.False(t, hasAngular)
+
+		variables := dashboard.Get("templating").Get("list")
+		require.Equal(t, []interface{}{"a"}, variables.GetIndex(0).Get("current").Get("value").MustArray())
+		require.Equal(t, "b", variables.GetIndex(1).Get("current").Get("value").MustString())
+		require.Equal(t, "textbox", variables.GetIndex(2).Get("type").MustString())
+		require.Equal(t, "value", variables.GetIndex(2).Get("current").Get("value").MustString())
+		require.Len(t, variables.GetIndex(2).Get("options").MustArray(), 1)
+		require.Equal(t, "constant", variables.GetIndex(3).Get("type").MustString())
+		require.Equal(t, "secret", variables.GetIndex(3).Get("current").Get("text").MustString())
+	})
+
+	t.Run("should leave dashboards outside of the supported versions untouched", func(t *testing.T) {
+		for _, body := range []string{
+			`{"title": "No version", "panels": [{"type": "text2"}]}`,
+			`{"schemaVersion": 14, "panels": [{"type": "text2"}]}`,
+			`{"schemaVersion": 35, "panels": [{"type": "text2"}]}`,
+		} {
+			dashboard, err := simplejson.NewJson([]byte(body))
+			require.NoError(t, err)
+			expected, err := dashboard.Encode()
+			require.NoError(t, err)
+
+			err = migrator.Migrate(context.Background(), 1, dashboard)
+			require.NoError(t, err)
+
+			actual, err := dashboard.Encode()
+			require.NoError(t, err)
+			require.JSONEq(t, string(expected), string(actual))
+		}
+	})
+}
+
+func TestDashboardMigrator_SchemaVersion28To35(t *testing.T) {
+	migrate := func(t *testing.T, migrator *DashboardMigrator, body string) *simplejson.Json {
+		t.Helper()
+		dashboard, err := simplejson.NewJson([]byte(body))
+		require.NoError(t, err)
+		require.NoError(t, migrator.Migrate(context.Background(), 1, dashboard))
+		require.Equal(t, LatestSchemaVersion, dashboard.Get("schemaVersion").MustInt())
+		return dashboard
+	}
+
+	t.Run("should convert singlestat panels to stat and gauge panels", func(t *testing.T) {
+		dashboard := migrate(t, ProvideService(nil, nil), `{
+			"schemaVersion": 27,
+			"panels": [
+				{
+					"id": 1,
+					"type": "singlestat",
+					"title": "Stat",
+					"legend": true,
+					"format": "ms",
+					"valueName": "current",
+					"thresholds": "10,20,30",
+					"colors": ["#FF0000", "green", "orange"],
+					"sparkline": {"show": true, "lineColor": "blue"},
+					"mappingType": 1,
+					"valueMaps": [{"op": "=", "text": "test", "value": "20"}, {"op": "=", "text": "50", "value": "40"}],
+					"fieldConfig": {"defaults": {"custom": {}}, "overrides": [{"matcher": {}, "properties": [{"id": "custom.width", "value": 1}]}]},
+					"targets": [{"refId": "A"}]
+				},
+				{
+					"id": 2,
+					"type": "singlestat",
+					"thresholds": "10,20,30",
+					"colors": ["#FF0000", "green", "orange"],
+					"gauge": {"show": true, "minValue": 0, "maxValue": 100, "thresholdMarkers": true, "thresholdLabels": false},
+					"mappingType": 2,
+					"rangeMaps": [{"from": "1", "to": "5", "text": "text"}, {"from": "5", "to": "10", "text": "50"}]
+				}
+			]
+		}`)
+
+		stat := dashboard.Get("panels").GetIndex(0)
+		require.Equal(t, "stat", stat.Get("type").MustString())
+		require.Equal(t, "Stat", stat.Get("title").MustString())
+		_, hasLegend := stat.CheckGet("legend")
+		require.False(t, hasLegend)
+		require.Equal(t, []interface{}{"lastNotNull"}, stat.Get("options").Get("reduceOptions").Get("calcs").MustArray())
+		require.Equal(t, "area", stat.Get("options").Get("graphMode").MustString())
+		require.Equal(t, "none", stat.Get("options").Get("colorMode").MustString())
+
+		defaults := stat.Get("fieldConfig").Get("defaults")
+		require.Equal(t, "ms", defaults.Get("unit").MustString())
+		require.Equal(t, "blue", defaults.Get("color").Get("fixedColor").MustString())
+		require.Empty(t, stat.Get("fieldConfig").Get("overrides").MustArray())
+
+		steps := defaults.Get("thresholds").Get("steps")
+		require.Len(t, steps.MustArray(), 3)
+		require.Nil(t, steps.GetIndex(0).Get("value").Interface())
+		require.Equal(t, "#FF0000", steps.GetIndex(0).Get("color").MustString())
+		require.Equal(t, 20, steps.GetIndex(2).Get("value").MustInt())
+
+		// the value maps of the singlestat panel are merged by the upgrade of the value mappings
+		mappings := defaults.Get("mappings")
+		require.Len(t, mappings.MustArray(), 1)
+		require.Equal(t, "value", mappings.GetIndex(0).Get("type").MustString())
+		require.Equal(t, map[string]interface{}{"text": "test"}, mappings.GetIndex(0).Get("options").Get("20").MustMap())
+		require.Equal(t, map[string]interface{}{"text": "50", "color": "orange"}, mappings.GetIndex(0).Get("options").Get("40").MustMap())
+
+		gauge := dashboard.Get("panels").GetIndex(1)
+		require.Equal(t, "gauge", gauge.Get("type").MustString())
+		require.True(t, gauge.Get("options").Get("showThresholdMarkers").MustBool())
+		require.False(t, gauge.Get("options").Get("showThresholdLabels").MustBool(true))
+		require.Equal(t, []interface{}{"mean"}, gauge.Get("options").Get("reduceOptions").Get("calcs").MustArray())
+		require.Equal(t, 100, gauge.Get("fieldConfig").Get("defaults").Get("max").MustInt())
+
+		ranges := gauge.Get("fieldConfig").Get("defaults").Get("mappings")
+		require.Len(t, ranges.MustArray(), 2)
+		require.Equal(t, "range", ranges.GetIndex(1).Get("type").MustString())
+		require.Equal(t, 5, ranges.GetIndex(1).Get("options").Get("from").MustInt())
+		require.Equal(t, 10, ranges.GetIndex(1).Get("options").Get("to").MustInt())
+		require.Equal(t, "orange", ranges.GetIndex(1).Get("options").Get("result").Get("color").MustString())
+	})
+
+	t.Run("should use the singlestat plugin if it is installed", func(t *testing.T) {
+		pluginStore := fakePluginStore{plugins: map[string]plugins.PluginDTO{singlestatPluginID: {}}}
+		dashboard := migrate(t, ProvideService(pluginStore, nil), `{
+			"schemaVersion": 27,
+			"panels": [{"type": "singlestat", "thresholds": "10", "colors": ["red", "green"]}]
+		}`)
+
+		panel := dashboard.Get("panels").GetIndex(0)
+		require.Equal(t, singlestatPluginID, panel.Get("type").MustString())
+		require.Equal(t, "10", panel.Get("thresholds").MustString())
+	})
+
+	t.Run("should upgrade the legacy value mappings", func(t *testing.T) {
+		dashboard := migrate(t, ProvideService(nil, nil), `{
+			"schemaVersion": 29,
+			"panels": [
+				{
+					"type": "timeseries",
+					"fieldConfig": {
+						"defaults": {
+							"thresholds": {"mode": "absolute", "steps": [{"color": "green", "value": null}, {"color": "red", "value": 80}]},
+							"mappings": [
+								{"id": 0, "text": "1", "type": 1, "value": "up"},
+								{"id": 1, "text": "BAD", "type": 1, "value": "down"},
+								{"id": 2, "text": "below", "type": 2, "from": "0", "to": "30"},
+								{"id": 3, "text": "100", "type": 1, "value": "null"}
+							]
+						},
+						"overrides": [{"matcher": {}, "properties": [{"id": "mappings", "value": [{"id": 0, "text": "test", "type": 1, "value": "1"}]}]}]
+					},
+					"options": {"tooltipOptions": {"mode": "multi"}}
+				}
+			]
+		}`)
+
+		panel := dashboard.Get("panels").GetIndex(0)
+		mappings, err := panel.Get("fieldConfig").Get("defaults").Get("mappings").Encode()
+		require.NoError(t, err)
+		require.JSONEq(t, `[
+			{"type": "value", "options": {"up": {"text": "1", "color": "green"}, "down": {"text": "BAD"}}},
+			{"type": "range", "options": {"from": 0, "to": 30, "result": {"text": "below"}}},
+			{"type": "special", "options": {"match": "null", "result": {"text": "100", "color": "red"}}}
+		]`, string(mappings))
+
+		overrideMappings, err := panel.Get("fieldConfig").Get("overrides").GetIndex(0).Get("properties").GetIndex(0).Get("value").Encode()
+		require.NoError(t, err)
+		require.JSONEq(t, `[{"type": "value", "options": {"1": {"text": "test"}}}]`, string(overrideMappings))
+
+		require.Equal(t, "multi", panel.Get("options").Get("tooltip").Get("mode").MustString())
+		_, hasTooltipOptions := panel.Get("options").CheckGet("tooltipOptions")
+		require.False(t, hasTooltipOptions)
+	})
+
+	t.Run("should reference the datasources by UID", func(t *testing.T) {
+		dataSourceService := &fakeDataSourceService{
+			dataSources: []*models.DataSource{{Name: "Prometheus", Type: "prometheus", Uid: "prom-uid"}},
+		}
+		dashboard := migrate(t, ProvideService(nil, dataSourceService), `{
+			"schemaVersion": 32,
+			"panels": [
+				{"datasource": "Prometheus", "targets": [{"refId": "A"}, {"refId": "B", "datasource": "-- Grafana --"}]},
+				{"datasource": "${DS_PROMETHEUS}"},
+				{"datasource": "default"},
+				{"type": "row", "panels": [{"datasource": {"type": "loki", "uid": "loki-uid"}}]}
+			]
+		}`)
+
+		panels := dashboard.Get("panels")
+		require.Equal(t, map[string]interface{}{"type": "prometheus", "uid": "prom-uid"}, panels.GetIndex(0).Get("datasource").MustMap())
+		_, hasDatasource := panels.GetIndex(0).Get("targets").GetIndex(0).CheckGet("datasource")
+		require.False(t, hasDatasource)
+		require.Equal(t, map[string]interface{}{"type": "datasource", "uid": "grafana"}, panels.GetIndex(0).Get("targets").GetIndex(1).Get("datasource").MustMap())
+		require.Equal(t, map[string]interface{}{"uid": "${DS_PROMETHEUS}"}, panels.GetIndex(1).Get("datasource").MustMap())
+		require.Nil(t, panels.GetIndex(2).Get("datasource").Interface())
+		require.Equal(t, map[string]interface{}{"type": "loki", "uid": "loki-uid"}, panels.GetIndex(3).Get("panels").GetIndex(0).Get("datasource").MustMap())
+	})
+
+	t.Run("should split the CloudWatch queries and annotations with several statistics", func(t *testing.T) {
+		dashboard := migrate(t, ProvideService(nil, nil), `{
+			"schemaVersion": 33,
+			"annotations": {
+				"list": [
+					{"name": "Annotation", "dimensions": {}, "namespace": "AWS/EC2", "region": "us-east-1", "prefixMatching": false, "statistics": ["Max", "Min"]}
+				]
+			},
+			"panels": [
+				{
+					"targets": [
+						{"refId": "A", "dimensions": {}, "namespace": "AWS/EC2", "region": "us-east-1", "metricName": "CPUUtilization", "statistics": ["Average", "Maximum", "Minimum"]},
+						{"refId": "B", "dimensions": {}, "namespace": "AWS/EC2", "region": "us-east-1", "metricName": "CPUUtilization", "expression": "SUM(x)", "statistics": ["Sum"]}
+					]
+				}
+			]
+		}`)
+
+		targets, err := dashboard.Get("panels").GetIndex(0).Get("targets").Encode()
+		require.NoError(t, err)
+		require.JSONEq(t, `[
+			{"refId": "A", "dimensions": {}, "namespace": "AWS/EC2", "region": "us-east-1", "metricName": "CPUUtilization", "metricQueryType": 0, "metricEditorMode": 0, "statistic": "Average"},
+			{"refId": "B", "dimensions": {}, "namespace": "AWS/EC2", "region": "us-east-1", "metricName": "CPUUtilization", "expression": "SUM(x)", "metricQueryType": 0, "metricEditorMode": 1, "statistic": "Sum"},
+			{"refId": "C", "dimensions": {}, "namespace": "AWS/EC2", "region": "us-east-1", "metricName": "CPUUtilization", "metricQueryType": 0, "metricEditorMode": 0, "statistic": "Maximum"},
+			{"refId": "D", "dimensions": {}, "namespace": "AWS/EC2", "region": "us-east-1", "metricName": "CPUUtilization", "metricQueryType": 0, "metricEditorMode": 0, "statistic": "Minimum"}
+		]`, string(targets))
+
+		annotations, err := dashboard.Get("annotations").Get("list").Encode()
+		require.NoError(t, err)
+		require.JSONEq(t, `[
+			{"name": "Annotation - Max", "dimensions": {}, "namespace": "AWS/EC2", "region": "us-east-1", "prefixMatching": false, "statistic": "Max"},
+			{"name": "Annotation - Min", "dimensions": {}, "namespace": "AWS/EC2", "region": "us-east-1", "prefixMatching": false, "statistic": "Min"}
+		]`, string(annotations))
+	})
+
+	t.Run("should upgrade variables, transformations and hidden axes", func(t *testing.T) {
+		dashboard := migrate(t, ProvideService(nil, nil), `{
+			"schemaVersion": 27,
+			"panels": [
+				{
+					"type": "timeseries",
+					"transformations": [{"id": "labelsToFields", "options": {}}, {"id": "organize", "options": {}}],
+					"fieldConfig": {"defaults": {"custom": {"axisPlacement": "hidden"}}, "overrides": []}
+				}
+			],
+			"templating": {
+				"list": [
+					{"name": "query", "type": "query", "refresh": 0, "tags": ["a"], "useTags": true, "options": [{"text": "a", "value": "a"}]},
+					{"name": "custom", "type": "custom", "refresh": 0, "options": [{"text": "b", "value": "b"}]}
+				]
+			}
+		}`)
+
+		panel := dashboard.Get("panels").GetIndex(0)
+		transformations := panel.Get("transformations")
+		require.Len(t, transformations.MustArray(), 3)
+		require.Equal(t, "merge", transformations.GetIndex(1).Get("id").MustString())
+
+		overrides, err := panel.Get("fieldConfig").Get("overrides").Encode()
+		require.NoError(t, err)
+		require.JSONEq(t, `[{"matcher": {"id": "byType", "options": "time"}, "properties": [{"id": "custom.axisPlacement", "value": "auto"}]}]`, string(overrides))
+
+		query := dashboard.Get("templating").Get("list").GetIndex(0)
+		require.Equal(t, 1, query.Get("refresh").MustInt())
+		require.Empty(t, query.Get("options").MustArray())
+		_, hasTags := query.CheckGet("tags")
+		require.False(t, hasTags)
+		_, hasUseTags := query.CheckGet("useTags")
+		require.False(t, hasUseTags)
+
+		custom := dashboard.Get("templating").Get("list").GetIndex(1)
+		require.Equal(t, 0, custom.Get("refresh").MustInt())
+		require.Len(t, custom.Get("options").MustArray(), 1)
+	})
+}
+
+type fakePluginStore struct {
+	plugins.Store
+
+	plugins map[string]plugins.PluginDTO
+}
+
+func (pr fakePluginStore) Plugin(_ context.Context, pluginID string) (plugins.PluginDTO, bool) {
+	p, exists := pr.plugins[pluginID]
+
+	return p, exists
+}
+
+type fakeDataSourceService struct {
+	datasources.DataSourceService
+
+	dataSources []*models.DataSource
+}
+
+func (s *fakeDataSourceService) GetDataSources(_ context.Context, query *models.GetDataSourcesQuery) error {
+	query.Result = s.dataSources
+	return nil
+}
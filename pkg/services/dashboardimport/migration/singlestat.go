@@ -0,0 +1,235 @@
+package migration
+
+import (
+	"context"
+	"strings"
+)
+
+const singlestatPluginID = "grafana-singlestat-panel"
+
+// panelProperties are the properties of panels which are kept when their type changes, the others belong to the
+// previous panel type.
+var panelProperties = map[string]bool{
+	"id":               true,
+	"gridPos":          true,
+	"type":             true,
+	"title":            true,
+	"scopedVars":       true,
+	"repeat":           true,
+	"repeatIteration":  true,
+	"repeatPanelId":    true,
+	"repeatDirection":  true,
+	"repeatedByRow":    true,
+	"minSpan":          true,
+	"collapsed":        true,
+	"panels":           true,
+	"targets":          true,
+	"datasource":       true,
+	"timeFrom":         true,
+	"timeShift":        true,
+	"hideTimeOverride": true,
+	"description":      true,
+	"links":            true,
+	"cacheTimeout":     true,
+	"transparent":      true,
+	"pluginVersion":    true,
+	"transformations":  true,
+	"fieldConfig":      true,
+	"maxDataPoints":    true,
+	"interval":         true,
+	"libraryPanel":     true,
+}
+
+var reducerAliases = map[string]string{
+	"avg":     "mean",
+	"current": "lastNotNull",
+	"total":   "sum",
+}
+
+var reducers = map[string]bool{
+	"sum": true, "max": true, "min": true, "logmin": true, "mean": true, "last": true, "first": true, "count": true,
+	"range": true, "diff": true, "diffperc": true, "delta": true, "step": true, "firstNotNull": true,
+	"lastNotNull": true, "changeCount": true, "distinctCount": true, "allIsZero": true, "allIsNull": true,
+	"allValues": true,
+}
+
+// singlestatUpgrade returns the upgrade of the removed singlestat panels. They become singlestat plugin panels if
+// the plugin is installed, and stat or gauge panels otherwise.
+func (m *DashboardMigrator) singlestatUpgrade(ctx context.Context) panelUpgrade {
+	pluginInstalled := false
+	if m.pluginStore != nil {
+		_, pluginInstalled = m.pluginStore.Plugin(ctx, singlestatPluginID)
+	}
+
+	return func(panel map[string]interface{}) {
+		if panel["type"] != "singlestat" {
+			return
+		}
+
+		if pluginInstalled {
+			panel["type"] = singlestatPluginID
+			return
+		}
+		migrateSinglestat(panel)
+	}
+}
+
+// migrateSinglestat converts the singlestat panel to a gauge panel if it shows a gauge, and to a stat panel otherwise.
+func migrateSinglestat(panel map[string]interface{}) {
+	angular := make(map[string]interface{})
+	for key, value := range panel {
+		if !panelProperties[key] {
+			angular[key] = value
+			delete(panel, key)
+		}
+	}
+	delete(panel, "pluginVersion")
+
+	defaults, options := migrateFromAngularSinglestat(angular)
+
+	gauge, _ := angular["gauge"].(map[string]interface{})
+	if isTruthy(gauge["show"]) {
+		panel["type"] = "gauge"
+		setIfPresent(options, "showThresholdMarkers", gauge, "thresholdMarkers")
+		setIfPresent(options, "showThresholdLabels", gauge, "thresholdLabels")
+	} else {
+		panel["type"] = "stat"
+		upgradeStatOptions(angular, defaults, options)
+	}
+
+	fieldConfig, _ := panel["fieldConfig"].(map[string]interface{})
+	panel["fieldConfig"] = map[string]interface{}{
+		"defaults":  defaults,
+		"overrides": standardOverrides(fieldConfig["overrides"]),
+	}
+	panel["options"] = options
+}
+
+// migrateFromAngularSinglestat returns the field defaults and the options shared by the stat and gauge panels.
+func migrateFromAngularSinglestat(angular map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	reduceOptions := map[string]interface{}{
+		"calcs": []interface{}{reducerID(angular["valueName"])},
+	}
+	if isTruthy(angular["tableColumn"]) {
+		reduceOptions["fields"] = "/^" + jsString(angular["tableColumn"]) + "$/"
+	}
+	options := map[string]interface{}{
+		"reduceOptions": reduceOptions,
+		"orientation":   "horizontal",
+	}
+
+	defaults := make(map[string]interface{})
+	if isTruthy(angular["format"]) {
+		defaults["unit"] = angular["format"]
+	}
+	if isTruthy(angular["nullPointMode"]) {
+		defaults["nullValueMode"] = angular["nullPointMode"]
+	}
+	if isTruthy(angular["nullText"]) {
+		defaults["noValue"] = angular["nullText"]
+	}
+	if decimals, ok := toFloat(angular["decimals"]); isTruthy(angular["decimals"]) || (ok && decimals == 0) {
+		defaults["decimals"] = angular["decimals"]
+	}
+
+	var thresholds map[string]interface{}
+	levels, isString := angular["thresholds"].(string)
+	if colors, ok := angular["colors"].([]interface{}); ok && isString && levels != "" {
+		thresholds = map[string]interface{}{
+			"mode":  "absolute",
+			"steps": thresholdSteps(strings.Split(levels, ","), colors),
+		}
+		defaults["thresholds"] = thresholds
+	}
+
+	if mappings := convertAngularValueMappings(angular, thresholds); len(mappings) > 0 {
+		defaults["mappings"] = mappings
+	}
+
+	if gauge, ok := angular["gauge"].(map[string]interface{}); ok && isTruthy(gauge["show"]) {
+		setIfPresent(defaults, "min", gauge, "minValue")
+		setIfPresent(defaults, "max", gauge, "maxValue")
+	}
+
+	return defaults, options
+}
+
+// thresholdSteps returns a step for each color, the levels are the values of the steps after the base step.
+func thresholdSteps(levels []string, colors []interface{}) []interface{} {
+	steps := make([]interface{}, 0, len(colors))
+	for idx, color := range colors {
+		step := map[string]interface{}{"color": color}
+		switch {
+		case idx == 0:
+			step["value"] = nil
+		case idx <= len(levels):
+			step["value"] = jsNumber(strings.TrimSpace(levels[idx-1]))
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+func upgradeStatOptions(angular map[string]interface{}, defaults map[string]interface{}, options map[string]interface{}) {
+	sparkline, _ := angular["sparkline"].(map[string]interface{})
+
+	options["graphMode"] = "none"
+	if isTruthy(sparkline["show"]) {
+		options["graphMode"] = "area"
+	}
+
+	switch {
+	case isTruthy(angular["colorBackground"]):
+		options["colorMode"] = "background"
+	case isTruthy(angular["colorValue"]):
+		options["colorMode"] = "value"
+	default:
+		options["colorMode"] = "none"
+		if isTruthy(sparkline["lineColor"]) && options["graphMode"] == "area" {
+			defaults["color"] = map[string]interface{}{
+				"mode":       "fixed",
+				"fixedColor": sparkline["lineColor"],
+			}
+		}
+	}
+
+	if angular["valueName"] == "name" {
+		options["textMode"] = "name"
+	}
+}
+
+// standardOverrides removes the custom properties of the panel type from the overrides, and the overrides left without properties.
+func standardOverrides(overrides interface{}) []interface{} {
+	result := make([]interface{}, 0)
+	for _, override := range objects(overrides) {
+		properties := make([]interface{}, 0)
+		for _, property := range objects(override["properties"]) {
+			if id, _ := property["id"].(string); !strings.HasPrefix(id, "custom.") {
+				properties = append(properties, property)
+			}
+		}
+
+		if len(properties) > 0 {
+			override["properties"] = properties
+			result = append(result, override)
+		}
+	}
+	return result
+}
+
+func reducerID(valueName interface{}) string {
+	name, _ := valueName.(string)
+	if alias, ok := reducerAliases[name]; ok {
+		return alias
+	}
+	if reducers[name] {
+		return name
+	}
+	return "mean"
+}
+
+func setIfPresent(target map[string]interface{}, key string, source map[string]interface{}, sourceKey string) {
+	if value, ok := source[sourceKey]; ok {
+		target[key] = value
+	}
+}
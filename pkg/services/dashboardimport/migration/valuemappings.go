@@ -0,0 +1,270 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	legacyValueToText = 1
+	legacyRangeToText = 2
+
+	// fallbackColor is the color of the values of fields whose thresholds have no steps
+	fallbackColor = "gray"
+)
+
+var floatPrefixRegex = regexp.MustCompile(`^[+-]?(Infinity|(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?)`)
+
+// upgradeValueMappingsForPanel converts the legacy value and range mappings of the field defaults and overrides.
+func upgradeValueMappingsForPanel(panel map[string]interface{}) {
+	fieldConfig, ok := panel["fieldConfig"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	if defaults, ok := fieldConfig["defaults"].(map[string]interface{}); ok && isTruthy(defaults["mappings"]) {
+		thresholds, _ := defaults["thresholds"].(map[string]interface{})
+		setOrDelete(defaults, "mappings", upgradeValueMappings(defaults["mappings"], thresholds))
+	}
+
+	for _, override := range objects(fieldConfig["overrides"]) {
+		for _, property := range objects(override["properties"]) {
+			if property["id"] == "mappings" {
+				setOrDelete(property, "value", upgradeValueMappings(property["value"], nil))
+			}
+		}
+	}
+}
+
+// upgradeValueMappings merges the value mappings into a single value map, and converts the legacy mappings. The text
+// of a legacy mapping is colored like the thresholds would color it. It returns nil if there are no mappings.
+func upgradeValueMappings(oldMappings interface{}, thresholds map[string]interface{}) interface{} {
+	mappings, ok := oldMappings.([]interface{})
+	if !ok {
+		if isTruthy(oldMappings) {
+			return oldMappings
+		}
+		return nil
+	}
+
+	valueMapOptions := make(map[string]interface{})
+	upgraded := make([]interface{}, 0, len(mappings))
+	for _, mapping := range mappings {
+		old, ok := mapping.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if isTruthy(old["type"]) && isTruthy(old["options"]) {
+			// value maps converted from singlestat panels are merged as well
+			if options, ok := old["options"].(map[string]interface{}); ok && old["type"] == "value" {
+				for value, result := range options {
+					valueMapOptions[value] = result
+				}
+			} else {
+				upgraded = append(upgraded, old)
+			}
+			continue
+		}
+
+		color := thresholdColor(old["text"], thresholds)
+		switch mappingType, _ := toInt(old["type"]); mappingType {
+		case legacyValueToText:
+			if old["value"] == nil {
+				continue
+			}
+			if old["value"] == "null" {
+				upgraded = append(upgraded, nullValueMapping(old["text"], color))
+			} else {
+				valueMapOptions[jsString(old["value"])] = mappingResult(old["text"], color)
+			}
+		case legacyRangeToText:
+			upgraded = append(upgraded, rangeMapping(old, color))
+		}
+	}
+
+	if len(valueMapOptions) > 0 {
+		upgraded = append([]interface{}{map[string]interface{}{
+			"type":    "value",
+			"options": valueMapOptions,
+		}}, upgraded...)
+	}
+	return upgraded
+}
+
+// convertAngularValueMappings converts the value or range maps of an angular singlestat panel to one mapping each.
+func convertAngularValueMappings(angular map[string]interface{}, thresholds map[string]interface{}) []interface{} {
+	valueMaps, _ := angular["valueMaps"].([]interface{})
+	rangeMaps, _ := angular["rangeMaps"].([]interface{})
+
+	mappingType, _ := toInt(angular["mappingType"])
+	if !isTruthy(angular["mappingType"]) {
+		if len(valueMaps) > 0 {
+			mappingType = legacyValueToText
+		} else if len(rangeMaps) > 0 {
+			mappingType = legacyRangeToText
+		}
+	}
+
+	mappings := make([]interface{}, 0)
+	switch mappingType {
+	case legacyValueToText:
+		for _, valueMap := range objects(valueMaps) {
+			color := thresholdColor(valueMap["text"], thresholds)
+			if valueMap["value"] == nil {
+				continue
+			}
+			if valueMap["value"] == "null" {
+				mappings = append(mappings, nullValueMapping(valueMap["text"], color))
+				continue
+			}
+			mappings = append(mappings, map[string]interface{}{
+				"type": "value",
+				"options": map[string]interface{}{
+					jsString(valueMap["value"]): mappingResult(valueMap["text"], color),
+				},
+			})
+		}
+	case legacyRangeToText:
+		for _, rangeMap := range objects(rangeMaps) {
+			mappings = append(mappings, rangeMapping(rangeMap, thresholdColor(rangeMap["text"], thresholds)))
+		}
+	}
+	return mappings
+}
+
+func rangeMapping(old map[string]interface{}, color string) map[string]interface{} {
+	if old["from"] == "null" || old["to"] == "null" {
+		return nullValueMapping(old["text"], color)
+	}
+
+	return map[string]interface{}{
+		"type": "range",
+		"options": map[string]interface{}{
+			"from":   jsNumber(old["from"]),
+			"to":     jsNumber(old["to"]),
+			"result": mappingResult(old["text"], color),
+		},
+	}
+}
+
+func nullValueMapping(text interface{}, color string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "special",
+		"options": map[string]interface{}{
+			"match":  "null",
+			"result": mappingResult(text, color),
+		},
+	}
+}
+
+func mappingResult(text interface{}, color string) map[string]interface{} {
+	result := map[string]interface{}{"text": text}
+	if color != "" {
+		result["color"] = color
+	}
+	return result
+}
+
+// thresholdColor returns the color of the threshold the text is in if it starts with a number, or an empty color.
+func thresholdColor(text interface{}, thresholds map[string]interface{}) string {
+	value, ok := parseFloat(text)
+	if thresholds == nil || !ok {
+		return ""
+	}
+
+	steps := objects(thresholds["steps"])
+	if len(steps) == 0 {
+		return fallbackColor
+	}
+
+	active := steps[0]
+	for _, step := range steps {
+		// the value of the base step is -Infinity, which JSON stores as null
+		stepValue, ok := toFloat(step["value"])
+		if step["value"] == nil {
+			stepValue, ok = math.Inf(-1), true
+		}
+
+		if !ok || value < stepValue {
+			break
+		}
+		active = step
+	}
+
+	color, _ := active["color"].(string)
+	return color
+}
+
+// parseFloat parses the number the value starts with like parseFloat in JavaScript.
+func parseFloat(value interface{}) (float64, bool) {
+	if f, ok := toFloat(value); ok {
+		return f, true
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return 0, false
+	}
+
+	prefix := floatPrefixRegex.FindString(strings.TrimSpace(s))
+	if prefix == "" {
+		return 0, false
+	}
+
+	f, err := strconv.ParseFloat(strings.Replace(prefix, "Infinity", "Inf", 1), 64)
+	return f, err == nil
+}
+
+// jsNumber converts the value to a number like the unary plus in JavaScript.
+func jsNumber(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return json.Number("0")
+	case bool:
+		if v {
+			return json.Number("1")
+		}
+		return json.Number("0")
+	case string:
+		trimmed := strings.TrimSpace(v)
+		if trimmed == "" {
+			return json.Number("0")
+		}
+		f, err := strconv.ParseFloat(trimmed, 64)
+		if err != nil {
+			return nil
+		}
+		return floatNumber(f)
+	}
+
+	if f, ok := toFloat(value); ok {
+		return floatNumber(f)
+	}
+	return nil
+}
+
+// jsString converts the value to a string like String in JavaScript.
+func jsString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "undefined"
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+func setOrDelete(object map[string]interface{}, key string, value interface{}) {
+	if value == nil {
+		delete(object, key)
+		return
+	}
+	object[key] = value
+}
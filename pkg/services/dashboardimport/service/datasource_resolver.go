@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/dashboardimport"
+	"github.com/grafana/grafana/pkg/services/datasources"
+)
+
+var _ dashboardimport.DatasourceResolver = (*DefaultDatasourceResolver)(nil)
+
+// DefaultDatasourceResolver resolves a datasource input to the datasource of the org with the input value as name,
+// then as UID. Inputs without a value fall back to the default datasource of the type the input is declared with,
+// values matching neither resolve to no datasource so that they are reported as missing.
+type DefaultDatasourceResolver struct {
+	dataSourceService datasources.DataSourceService
+}
+
+func ProvideDatasourceResolver(dataSourceService datasources.DataSourceService) *DefaultDatasourceResolver {
+	return &DefaultDatasourceResolver{dataSourceService: dataSourceService}
+}
+
+func (r *DefaultDatasourceResolver) ResolveDatasource(ctx context.Context, orgID int64, input dashboardimport.ImportDashboardInput) (*dashboardimport.DatasourceRef, error) {
+	if input.Value == "" {
+		return r.typeDefault(ctx, orgID, input.PluginId)
+	}
+
+	queries := []*models.GetDataSourceQuery{
+		{OrgId: orgID, Name: input.Value},
+		{OrgId: orgID, Uid: input.Value},
+	}
+
+	for _, query := range queries {
+		err := r.dataSourceService.GetDataSource(ctx, query)
+		if err == nil && query.Result != nil {
+			return &dashboardimport.DatasourceRef{UID: query.Result.Uid, Type: query.Result.Type}, nil
+		}
+
+		if err != nil && !errors.Is(err, models.ErrDataSourceNotFound) {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// typeDefault returns the default datasource of the org if it has the type, otherwise the first datasource of
+// the type by name. It returns nil if the org has no datasource of the type.
+func (r *DefaultDatasourceResolver) typeDefault(ctx context.Context, orgID int64, datasourceType string) (*dashboardimport.DatasourceRef, error) {
+	if datasourceType == "" {
+		return nil, nil
+	}
+
+	query := &models.GetDataSourcesQuery{OrgId: orgID}
+	if err := r.dataSourceService.GetDataSources(ctx, query); err != nil {
+		return nil, err
+	}
+
+	var found *models.DataSource
+	for _, ds := range query.Result {
+		if ds.Type != datasourceType {
+			continue
+		}
+
+		if ds.IsDefault {
+			found = ds
+			break
+		}
+
+		if found == nil {
+			found = ds
+		}
+	}
+
+	if found == nil {
+		return nil, nil
+	}
+	return &dashboardimport.DatasourceRef{UID: found.Uid, Type: found.Type}, nil
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/services/dashboardimport"
+)
+
+// ImportDashboardsFromDir imports every `*.json` file found in the directory and its subdirectories, in lexical
+// order of their paths. The files share the inputs, folder and options of the request. A file which is not valid
+// JSON does not stop the import, other failures stop it unless the request has ContinueOnError set. The responses
+// are in the order of the files, with nil for the failed ones, the errors are returned as ImportDashboardsError.
+func (s *ImportDashboardService) ImportDashboardsFromDir(ctx context.Context, dir string, req *dashboardimport.ImportDashboardRequest) ([]*dashboardimport.ImportDashboardResponse, error) {
+	files, err := findDashboardFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]*dashboardimport.ImportDashboardResponse, len(files))
+	errs := make(map[int]error)
+	for i, file := range files {
+		resp, err := s.importDashboardFile(ctx, dir, file, req)
+		if err != nil {
+			errs[i] = fmt.Errorf("%s: %w", file, err)
+
+			var parseErr dashboardimport.DashboardParseError
+			if !errors.As(err, &parseErr) && !req.ContinueOnError {
+				break
+			}
+			continue
+		}
+		responses[i] = resp
+	}
+
+	if len(errs) > 0 {
+		return responses, dashboardimport.ImportDashboardsError{Errors: errs}
+	}
+
+	return responses, nil
+}
+
+// findDashboardFiles returns the paths of the JSON files in the directory, relative to it.
+func findDashboardFiles(dir string) ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(path), ".json") {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, relativePath)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dashboards in %s: %w", dir, err)
+	}
+
+	return files, nil
+}
+
+func (s *ImportDashboardService) importDashboardFile(ctx context.Context, dir string, file string, req *dashboardimport.ImportDashboardRequest) (*dashboardimport.ImportDashboardResponse, error) {
+	// It's safe to ignore gosec warning G304 since the files are listed from the directory given by the caller.
+	// nolint:gosec
+	data, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return nil, err
+	}
+
+	dashboardJSON, err := parseDashboardBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	fileReq := *req
+	fileReq.PluginId = ""
+	fileReq.GnetId = 0
+	fileReq.DashboardBytes = nil
+	fileReq.Dashboard = dashboardJSON
+	fileReq.Path = file
+	return s.ImportDashboard(ctx, &fileReq)
+}
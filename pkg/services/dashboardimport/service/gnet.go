@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/infra/localcache"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/dashboardimport"
+)
+
+const gnetDashboardCacheTTL = 5 * time.Minute
+
+// gnetClient downloads dashboards from the grafana.com API.
+type gnetClient struct {
+	baseURL    string
+	httpClient *http.Client
+	cache      *localcache.CacheService
+	log        log.Logger
+}
+
+func newGnetClient(grafanaComURL string) *gnetClient {
+	return &gnetClient{
+		baseURL:    strings.TrimSuffix(grafanaComURL, "/"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		cache:      localcache.New(gnetDashboardCacheTTL, 2*gnetDashboardCacheTTL),
+		log:        log.New("dashboardimport.gnet"),
+	}
+}
+
+func (c *gnetClient) getDashboard(ctx context.Context, gnetID int64) (*simplejson.Json, error) {
+	cacheKey := fmt.Sprintf("gnet-dashboard-%d", gnetID)
+	if cached, found := c.cache.Get(cacheKey); found {
+		// the raw body is cached so every import gets its own copy of the dashboard JSON
+		return simplejson.NewJson(cached.([]byte))
+	}
+
+	url := fmt.Sprintf("%s/api/dashboards/%d/revisions/latest/download", c.baseURL, gnetID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			c.log.Warn("Failed to close response body", "err", err)
+		}
+	}()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %d", dashboardimport.ErrGnetDashboardNotFound, gnetID)
+	case resp.StatusCode != http.StatusOK:
+		return nil, dashboardimport.GnetDashboardError{GnetId: gnetID, StatusCode: resp.StatusCode}
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	dashboardJSON, err := simplejson.NewJson(body)
+	if err != nil {
+		return nil, err
+	}
+
+	c.cache.Set(cacheKey, body, gnetDashboardCacheTTL)
+	return dashboardJSON, nil
+}
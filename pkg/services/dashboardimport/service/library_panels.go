@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/dashboardimport"
+	"github.com/grafana/grafana/pkg/services/librarypanels"
+)
+
+// importLibraryPanelsOnly creates the library panels of the generated dashboard in the folder, without saving
+// the dashboard nor connecting the panels to it. Nothing is created in a dry run.
+func (s *ImportDashboardService) importLibraryPanelsOnly(ctx context.Context, req *dashboardimport.ImportDashboardRequest, generatedDash *simplejson.Json, folderID int64) (*librarypanels.ImportLibraryPanelsResult, error) {
+	if req.DryRun {
+		return &librarypanels.ImportLibraryPanelsResult{}, nil
+	}
+
+	dash := models.NewDashboardFromJson(generatedDash)
+	dash.OrgId = req.User.OrgId
+	dash.FolderId = folderID
+
+	libraryPanelDash, err := withLibraryPanelModels(dash, generatedDash)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.libraryPanelService.ImportLibraryPanelsForDashboard(ctx, req.User, libraryPanelDash, folderID)
+}
+
+// withLibraryPanelModels returns a copy of the dashboard whose library panels carry the models of the library
+// elements exported in "__elements", for the library panels to be created from. The elements are read from the
+// generated dashboard, so that the inputs used in their models are substituted like in the dashboard panels.
+// The fields of the panels themselves, like their position, take precedence over the fields of the models.
+func withLibraryPanelModels(dash *models.Dashboard, generatedDash *simplejson.Json) (*models.Dashboard, error) {
+	elements := libraryPanelModels(generatedDash)
+	if len(elements) == 0 {
+		return dash, nil
+	}
+
+	// the data is copied, the saved dashboard must not change
+	encoded, err := dash.Data.Encode()
+	if err != nil {
+		return nil, err
+	}
+	data, err := simplejson.NewJson(encoded)
+	if err != nil {
+		return nil, err
+	}
+	mergeLibraryPanelModels(data, elements)
+
+	copied := *dash
+	copied.Data = data
+	return &copied, nil
+}
+
+// mergeLibraryPanelModels merges the models into the library panels of the parent, including the panels nested
+// in collapsed rows.
+func mergeLibraryPanelModels(parent *simplejson.Json, elements map[string]map[string]interface{}) {
+	panels := parent.Get("panels").MustArray()
+	for i, panel := range panels {
+		panelJSON := simplejson.NewFromAny(panel)
+		if panelJSON.Get("type").MustString() == "row" {
+			mergeLibraryPanelModels(panelJSON, elements)
+			continue
+		}
+
+		model, ok := elements[panelJSON.GetPath("libraryPanel", "uid").MustString()]
+		if !ok {
+			continue
+		}
+
+		merged := make(map[string]interface{}, len(model))
+		for key, value := range model {
+			merged[key] = value
+		}
+		for key, value := range panelJSON.MustMap() {
+			merged[key] = value
+		}
+		panels[i] = merged
+	}
+
+	if len(panels) > 0 {
+		parent.Set("panels", panels)
+	}
+}
+
+// libraryPanelModels returns the models of the library panels exported in "__elements" by UID.
+func libraryPanelModels(generatedDash *simplejson.Json) map[string]map[string]interface{} {
+	elements := make(map[string]map[string]interface{})
+	for _, element := range generatedDash.Get("__elements").MustArray() {
+		elementJSON := simplejson.NewFromAny(element)
+		if elementJSON.Get("kind").MustInt64() != int64(models.PanelElement) {
+			continue
+		}
+
+		uid := elementJSON.Get("uid").MustString()
+		model, err := elementJSON.Get("model").Map()
+		if uid == "" || err != nil {
+			continue
+		}
+		elements[uid] = model
+	}
+	return elements
+}
+
+// checkLibraryPanelCycles fails with ErrLibraryPanelCycle if the model of an exported library panel nests a panel
+// using the library panel itself, directly or through the models of other exported library panels. The
+// "libraryPanel" header of a model naming its own library panel is not a reference.
+func checkLibraryPanelCycles(generatedDash *simplejson.Json) error {
+	elements := libraryPanelModels(generatedDash)
+	uids := make([]string, 0, len(elements))
+	for uid := range elements {
+		uids = append(uids, uid)
+	}
+	// the reported library panel does not depend on the map order
+	sort.Strings(uids)
+
+	// a library panel is in progress while the library panels its model uses are visited
+	const inProgress, done = 1, 2
+	state := make(map[string]int, len(elements))
+
+	var visit func(uid string) error
+	visit = func(uid string) error {
+		switch state[uid] {
+		case inProgress:
+			return fmt.Errorf("%w: %s", dashboardimport.ErrLibraryPanelCycle, uid)
+		case done:
+			return nil
+		}
+
+		state[uid] = inProgress
+		for _, ref := range nestedLibraryPanelUIDs(simplejson.NewFromAny(elements[uid])) {
+			if _, ok := elements[ref]; !ok {
+				continue
+			}
+			if err := visit(ref); err != nil {
+				return err
+			}
+		}
+		state[uid] = done
+		return nil
+	}
+
+	for _, uid := range uids {
+		if err := visit(uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nestedLibraryPanelUIDs returns the UIDs of the library panels used by the panels nested in the parent, at any
+// depth.
+func nestedLibraryPanelUIDs(parent *simplejson.Json) []string {
+	uids := make([]string, 0)
+	for _, panel := range parent.Get("panels").MustArray() {
+		panelJSON := simplejson.NewFromAny(panel)
+		if uid := panelJSON.GetPath("libraryPanel", "uid").MustString(); uid != "" {
+			uids = append(uids, uid)
+		}
+		uids = append(uids, nestedLibraryPanelUIDs(panelJSON)...)
+	}
+	return uids
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/dashboardimport"
+)
+
+// ImportDashboardIntoOrgs imports the dashboard once per org of the request, as an admin of the org like the
+// plugin dashboards are. Each import resolves the folder, the datasources and the library panels in its own org.
+func (s *ImportDashboardService) ImportDashboardIntoOrgs(ctx context.Context, req *dashboardimport.ImportDashboardRequest) ([]*dashboardimport.ImportDashboardResponse, error) {
+	if req.User != nil {
+		return nil, dashboardimport.ErrOrgIdsWithUser
+	}
+
+	responses := make([]*dashboardimport.ImportDashboardResponse, len(req.OrgIds))
+	errs := make(map[int]error)
+	for i, orgID := range req.OrgIds {
+		orgReq := *req
+		orgReq.OrgIds = nil
+		orgReq.User = &models.SignedInUser{UserId: 0, OrgRole: models.ROLE_ADMIN, OrgId: orgID}
+
+		resp, err := s.ImportDashboard(ctx, &orgReq)
+		if err != nil {
+			errs[i] = fmt.Errorf("org %d: %w", orgID, err)
+			if !req.ContinueOnError {
+				break
+			}
+			continue
+		}
+
+		resp.OrgId = orgID
+		responses[i] = resp
+	}
+
+	if len(errs) > 0 {
+		return responses, dashboardimport.ImportDashboardsError{Errors: errs}
+	}
+
+	return responses, nil
+}
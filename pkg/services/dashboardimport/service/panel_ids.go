@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+)
+
+// preservePanelIDs gives the panels of the generated dashboard the IDs of the matching panels of the existing
+// dashboard with the same UID. It does nothing if there is no such dashboard.
+func (s *ImportDashboardService) preservePanelIDs(ctx context.Context, orgID int64, dash *simplejson.Json) error {
+	existing, err := s.getExistingDashboard(ctx, orgID, dash.Get("uid").MustString())
+	if err != nil {
+		return err
+	}
+
+	if existing == nil {
+		return nil
+	}
+
+	assignPanelIDs(flattenPanels(existing), flattenPanels(dash))
+	return nil
+}
+
+// flattenPanels returns the panels of the dashboard, including the panels nested in collapsed rows.
+func flattenPanels(parent *simplejson.Json) []*simplejson.Json {
+	panels := make([]*simplejson.Json, 0)
+	for _, panel := range parent.Get("panels").MustArray() {
+		panelJSON := simplejson.NewFromAny(panel)
+		panels = append(panels, panelJSON)
+		panels = append(panels, flattenPanels(panelJSON)...)
+	}
+	return panels
+}
+
+// assignPanelIDs matches the incoming panels to the existing panels by title, then the panels left by position,
+// each existing panel matching at most one incoming panel. The matched panels take the ID of the existing panel,
+// the others keep their ID unless an existing or a matched panel has it, in which case they get an unused one, so
+// that the removed panels are not replaced by unrelated ones.
+func assignPanelIDs(existing []*simplejson.Json, incoming []*simplejson.Json) {
+	maxID := int64(0)
+	taken := make(map[int64]bool, len(existing))
+	candidates := make([]*simplejson.Json, 0, len(existing))
+	for _, panel := range existing {
+		id := panel.Get("id").MustInt64()
+		if id <= 0 {
+			continue
+		}
+
+		candidates = append(candidates, panel)
+		taken[id] = true
+		if id > maxID {
+			maxID = id
+		}
+	}
+
+	used := make([]bool, len(candidates))
+	matched := make(map[int]int64)
+	match := func(key func(panel *simplejson.Json) string) {
+		for i, panel := range incoming {
+			if _, ok := matched[i]; ok {
+				continue
+			}
+
+			k := key(panel)
+			if k == "" {
+				continue
+			}
+
+			for j, candidate := range candidates {
+				if !used[j] && key(candidate) == k {
+					used[j] = true
+					matched[i] = candidate.Get("id").MustInt64()
+					break
+				}
+			}
+		}
+	}
+	match(panelTitle)
+	match(panelPosition)
+
+	for i, panel := range incoming {
+		if id, ok := matched[i]; ok {
+			panel.Set("id", id)
+		} else if id := panel.Get("id").MustInt64(); id > maxID {
+			maxID = id
+		}
+	}
+
+	for i, panel := range incoming {
+		if _, ok := matched[i]; ok {
+			continue
+		}
+
+		id := panel.Get("id").MustInt64()
+		if id <= 0 || taken[id] {
+			maxID++
+			id = maxID
+		}
+		panel.Set("id", id)
+		taken[id] = true
+	}
+}
+
+func panelTitle(panel *simplejson.Json) string {
+	return panel.Get("title").MustString()
+}
+
+// panelPosition is the position of the top left corner of the panel in the grid, or empty if it has none.
+func panelPosition(panel *simplejson.Json) string {
+	gridPos, ok := panel.CheckGet("gridPos")
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%d,%d", gridPos.Get("x").MustInt64(), gridPos.Get("y").MustInt64())
+}
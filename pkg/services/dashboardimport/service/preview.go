@@ -0,0 +1,135 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/dashboardimport"
+)
+
+// previewIgnoredFields are not compared, they change with every save or are summarized by the panel counts.
+var previewIgnoredFields = map[string]bool{
+	"id":      true,
+	"version": true,
+	"panels":  true,
+}
+
+// ImportDashboardPreview generates the dashboard like ImportDashboard does and compares it with the dashboard
+// it would overwrite, without saving anything.
+func (s *ImportDashboardService) ImportDashboardPreview(ctx context.Context, req *dashboardimport.ImportDashboardRequest) (*dashboardimport.ImportPreview, error) {
+	if err := validateDashboardSource(req); err != nil {
+		return nil, err
+	}
+
+	_, incoming, _, err := s.generateDashboard(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &dashboardimport.ImportPreview{
+		UID:                incoming.Get("uid").MustString(),
+		Title:              incoming.Get("title").MustString(),
+		ChangedFields:      make([]dashboardimport.FieldChange, 0),
+		IncomingPanelCount: countPanels(incoming.Get("panels")),
+	}
+
+	existing, err := s.getExistingDashboard(ctx, req.User.OrgId, preview.UID)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing == nil {
+		preview.PanelsAdded = preview.IncomingPanelCount
+		return preview, nil
+	}
+
+	preview.Exists = true
+	preview.ExistingPanelCount = countPanels(existing.Get("panels"))
+	if preview.IncomingPanelCount > preview.ExistingPanelCount {
+		preview.PanelsAdded = preview.IncomingPanelCount - preview.ExistingPanelCount
+	} else {
+		preview.PanelsRemoved = preview.ExistingPanelCount - preview.IncomingPanelCount
+	}
+
+	preview.ChangedFields, err = diffFields(existing.MustMap(), incoming.MustMap())
+	if err != nil {
+		return nil, err
+	}
+
+	return preview, nil
+}
+
+// getExistingDashboard returns the data of the dashboard with the UID, or nil if there is none.
+func (s *ImportDashboardService) getExistingDashboard(ctx context.Context, orgID int64, uid string) (*simplejson.Json, error) {
+	if uid == "" {
+		return nil, nil
+	}
+
+	query := &models.GetDashboardQuery{OrgId: orgID, Uid: uid}
+	if err := s.dashboardStore.GetDashboard(ctx, query); err != nil {
+		if errors.Is(err, models.ErrDashboardNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return query.Result.Data, nil
+}
+
+// countPanels counts the panels, including the panels nested in collapsed rows.
+func countPanels(panels *simplejson.Json) int {
+	count := 0
+	for _, panel := range panels.MustArray() {
+		count++
+		count += countPanels(simplejson.NewFromAny(panel).Get("panels"))
+	}
+	return count
+}
+
+// diffFields compares the values of the top-level fields by their JSON encoding, so that numbers compare equal
+// however they were decoded.
+func diffFields(existing map[string]interface{}, incoming map[string]interface{}) ([]dashboardimport.FieldChange, error) {
+	fields := make(map[string]bool)
+	for field := range existing {
+		fields[field] = true
+	}
+	for field := range incoming {
+		fields[field] = true
+	}
+
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		if !previewIgnoredFields[field] {
+			names = append(names, field)
+		}
+	}
+	sort.Strings(names)
+
+	changes := make([]dashboardimport.FieldChange, 0)
+	for _, field := range names {
+		existingJSON, err := json.Marshal(existing[field])
+		if err != nil {
+			return nil, err
+		}
+
+		incomingJSON, err := json.Marshal(incoming[field])
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(existingJSON, incomingJSON) {
+			changes = append(changes, dashboardimport.FieldChange{
+				Field:    field,
+				Existing: existing[field],
+				Incoming: incoming[field],
+			})
+		}
+	}
+
+	return changes, nil
+}
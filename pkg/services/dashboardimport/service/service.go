@@ -1,35 +1,57 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"strconv"
 
 	"github.com/grafana/grafana/pkg/api/routing"
+	"github.com/grafana/grafana/pkg/components/simplejson"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/plugins"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/dashboardimport"
 	"github.com/grafana/grafana/pkg/services/dashboardimport/api"
+	"github.com/grafana/grafana/pkg/services/dashboardimport/migration"
 	"github.com/grafana/grafana/pkg/services/dashboardimport/utils"
 	"github.com/grafana/grafana/pkg/services/dashboards"
+	"github.com/grafana/grafana/pkg/services/datasources"
 	"github.com/grafana/grafana/pkg/services/featuremgmt"
 	"github.com/grafana/grafana/pkg/services/librarypanels"
 	"github.com/grafana/grafana/pkg/services/quota"
 	"github.com/grafana/grafana/pkg/services/schemaloader"
+	"github.com/grafana/grafana/pkg/services/search"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
 )
 
-func ProvideService(routeRegister routing.RouteRegister,
+// defaultImportMessage is the version message of the imported dashboards when the request does not set one.
+const defaultImportMessage = "Imported via API"
+
+func ProvideService(cfg *setting.Cfg, routeRegister routing.RouteRegister,
 	quotaService *quota.QuotaService, schemaLoaderService *schemaloader.SchemaLoaderService,
 	pluginDashboardManager plugins.PluginDashboardManager, pluginStore plugins.Store,
 	libraryPanelService librarypanels.Service, dashboardService dashboards.DashboardService,
-	ac accesscontrol.AccessControl, permissionsServices accesscontrol.PermissionsServices, features featuremgmt.FeatureToggles,
+	folderService dashboards.FolderService, dataSourceService datasources.DataSourceService,
+	schemaMigrator migration.Service, ac accesscontrol.AccessControl, permissionsServices accesscontrol.PermissionsServices, features featuremgmt.FeatureToggles,
+	sqlStore *sqlstore.SQLStore, datasourceResolver dashboardimport.DatasourceResolver,
 ) *ImportDashboardService {
 	s := &ImportDashboardService{
+		dashboardStore:              sqlStore,
 		features:                    features,
 		pluginDashboardManager:      pluginDashboardManager,
 		dashboardService:            dashboardService,
+		folderService:               folderService,
+		dataSourceService:           dataSourceService,
+		datasourceResolver:          datasourceResolver,
+		schemaMigrator:              schemaMigrator,
 		libraryPanelService:         libraryPanelService,
 		dashboardPermissionsService: permissionsServices.GetDashboardService(),
+		gnetClient:                  newGnetClient(cfg.GrafanaComURL),
+		urlClient:                   newURLClient(cfg.DashboardImportAllowedHosts),
 	}
 
 	dashboardImportAPI := api.New(s, quotaService, schemaLoaderService, pluginStore, ac)
@@ -38,38 +60,105 @@ func ProvideService(routeRegister routing.RouteRegister,
 	return s
 }
 
+// dashboardStore loads the existing dashboards compared by ImportDashboardPreview and probed for title conflicts.
+type dashboardStore interface {
+	GetDashboard(ctx context.Context, query *models.GetDashboardQuery) error
+	FindDashboards(ctx context.Context, query *search.FindPersistedDashboardsQuery) ([]sqlstore.DashboardSearchProjection, error)
+}
+
 type ImportDashboardService struct {
+	dashboardStore              dashboardStore
 	features                    featuremgmt.FeatureToggles
 	pluginDashboardManager      plugins.PluginDashboardManager
 	dashboardService            dashboards.DashboardService
+	folderService               dashboards.FolderService
+	dataSourceService           datasources.DataSourceService
+	datasourceResolver          dashboardimport.DatasourceResolver
+	schemaMigrator              migration.Service
 	libraryPanelService         librarypanels.Service
 	dashboardPermissionsService accesscontrol.PermissionsService
+	gnetClient                  *gnetClient
+	urlClient                   *urlClient
 }
 
 func (s *ImportDashboardService) ImportDashboard(ctx context.Context, req *dashboardimport.ImportDashboardRequest) (*dashboardimport.ImportDashboardResponse, error) {
-	var dashboard *models.Dashboard
-	if req.PluginId != "" {
-		var err error
-		if dashboard, err = s.pluginDashboardManager.LoadPluginDashboard(ctx, req.PluginId, req.Path); err != nil {
+	if len(req.OrgIds) > 0 {
+		return nil, dashboardimport.ErrOrgIdsNotSupported
+	}
+
+	if err := validateDashboardSource(req); err != nil {
+		return nil, err
+	}
+
+	dashboard, generatedDash, datasourceInputs, err := s.generateDashboard(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	datasourceMappings, err := s.datasourceMappings(ctx, req.User.OrgId, datasourceInputs)
+	if err != nil {
+		return nil, err
+	}
+
+	warnings, err := s.checkDatasourceInputs(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	folderID, createsFolder, err := s.resolveFolderID(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if createsFolder {
+		warnings = append(warnings, fmt.Sprintf("folder %s not found, it would be created", req.FolderTitle))
+	}
+
+	if req.ImportLibraryPanelsOnly {
+		libraryPanels, err := s.importLibraryPanelsOnly(ctx, req, generatedDash, folderID)
+		if err != nil {
 			return nil, err
 		}
-	} else {
-		dashboard = models.NewDashboardFromJson(req.Dashboard)
+
+		return &dashboardimport.ImportDashboardResponse{
+			UID:                    generatedDash.Get("uid").MustString(),
+			PluginId:               req.PluginId,
+			Title:                  generatedDash.Get("title").MustString(),
+			Path:                   req.Path,
+			FolderId:               folderID,
+			ImportedRevision:       dashboard.Data.Get("revision").MustInt64(1),
+			Imported:               false,
+			Warnings:               warnings,
+			ImportedLibraryPanels:  libraryPanels.Created,
+			ConnectedLibraryPanels: libraryPanels.Existing,
+			DatasourceMappings:     datasourceMappings,
+		}, nil
 	}
 
-	evaluator := utils.NewDashTemplateEvaluator(dashboard.Data, req.Inputs)
-	generatedDash, err := evaluator.Eval()
+	overwrite, err := s.resolveTitleConflict(ctx, req, folderID, generatedDash)
 	if err != nil {
 		return nil, err
 	}
 
+	if req.PreservePanelIDs && overwrite {
+		if err := s.preservePanelIDs(ctx, req.User.OrgId, generatedDash); err != nil {
+			return nil, err
+		}
+	}
+
+	message := req.Message
+	if message == "" {
+		message = defaultImportMessage
+	}
+
 	saveCmd := models.SaveDashboardCommand{
 		Dashboard: generatedDash,
 		OrgId:     req.User.OrgId,
 		UserId:    req.User.UserId,
-		Overwrite: req.Overwrite,
+		Overwrite: overwrite,
 		PluginId:  req.PluginId,
-		FolderId:  req.FolderId,
+		FolderId:  folderID,
+		Message:   message,
 	}
 
 	dto := &dashboards.SaveDashboardDTO{
@@ -77,6 +166,27 @@ func (s *ImportDashboardService) ImportDashboard(ctx context.Context, req *dashb
 		Dashboard: saveCmd.GetDashboardModel(),
 		Overwrite: saveCmd.Overwrite,
 		User:      req.User,
+		Message:   saveCmd.Message,
+	}
+
+	if req.DryRun {
+		if _, err := s.dashboardService.BuildSaveDashboardCommand(ctx, dto, false, true); err != nil {
+			return nil, err
+		}
+
+		return &dashboardimport.ImportDashboardResponse{
+			UID:                dto.Dashboard.Uid,
+			PluginId:           req.PluginId,
+			Title:              dto.Dashboard.Title,
+			Path:               req.Path,
+			Revision:           dto.Dashboard.Data.Get("revision").MustInt64(1),
+			FolderId:           dto.Dashboard.FolderId,
+			ImportedRevision:   dashboard.Data.Get("revision").MustInt64(1),
+			Imported:           false,
+			Slug:               dto.Dashboard.Slug,
+			Warnings:           warnings,
+			DatasourceMappings: datasourceMappings,
+		}, nil
 	}
 
 	savedDash, err := s.dashboardService.ImportDashboard(ctx, dto)
@@ -84,7 +194,12 @@ func (s *ImportDashboardService) ImportDashboard(ctx context.Context, req *dashb
 		return nil, err
 	}
 
-	err = s.libraryPanelService.ImportLibraryPanelsForDashboard(ctx, req.User, savedDash, req.FolderId)
+	libraryPanelDash, err := withLibraryPanelModels(savedDash, generatedDash)
+	if err != nil {
+		return nil, err
+	}
+
+	libraryPanels, err := s.libraryPanelService.ImportLibraryPanelsForDashboard(ctx, req.User, libraryPanelDash, folderID)
 	if err != nil {
 		return nil, err
 	}
@@ -101,21 +216,268 @@ func (s *ImportDashboardService) ImportDashboard(ctx context.Context, req *dashb
 	}
 
 	return &dashboardimport.ImportDashboardResponse{
-		UID:              savedDash.Uid,
-		PluginId:         req.PluginId,
-		Title:            savedDash.Title,
-		Path:             req.Path,
-		Revision:         savedDash.Data.Get("revision").MustInt64(1),
-		FolderId:         savedDash.FolderId,
-		ImportedUri:      "db/" + savedDash.Slug,
-		ImportedUrl:      savedDash.GetUrl(),
-		ImportedRevision: dashboard.Data.Get("revision").MustInt64(1),
-		Imported:         true,
-		DashboardId:      savedDash.Id,
-		Slug:             savedDash.Slug,
+		UID:                    savedDash.Uid,
+		PluginId:               req.PluginId,
+		Title:                  savedDash.Title,
+		Path:                   req.Path,
+		Revision:               savedDash.Data.Get("revision").MustInt64(1),
+		FolderId:               savedDash.FolderId,
+		ImportedUri:            "db/" + savedDash.Slug,
+		ImportedUrl:            savedDash.GetUrl(),
+		ImportedRevision:       dashboard.Data.Get("revision").MustInt64(1),
+		Imported:               true,
+		DashboardId:            savedDash.Id,
+		Slug:                   savedDash.Slug,
+		Warnings:               warnings,
+		ImportedLibraryPanels:  libraryPanels.Created,
+		ConnectedLibraryPanels: libraryPanels.Existing,
+		DatasourceMappings:     datasourceMappings,
 	}, nil
 }
 
+// validateDashboardSource checks that the request sets a source for generateDashboard to load the dashboard from.
+func validateDashboardSource(req *dashboardimport.ImportDashboardRequest) error {
+	if req.PluginId == "" && req.Dashboard == nil && len(req.DashboardBytes) == 0 && req.GnetId == 0 && req.URL == "" {
+		return dashboardimport.ErrNoDashboardSource
+	}
+	return nil
+}
+
+// generateDashboard loads the dashboard of the request and substitutes the inputs. It returns the loaded dashboard
+// along with the generated dashboard JSON and the applied datasource inputs by input name.
+func (s *ImportDashboardService) generateDashboard(ctx context.Context, req *dashboardimport.ImportDashboardRequest) (*models.Dashboard, *simplejson.Json, map[string]dashboardimport.ImportDashboardInput, error) {
+	var dashboard *models.Dashboard
+	if req.PluginId != "" {
+		var err error
+		if dashboard, err = s.pluginDashboardManager.LoadPluginDashboard(ctx, req.PluginId, req.Path); err != nil {
+			return nil, nil, nil, err
+		}
+	} else if req.Dashboard == nil && len(req.DashboardBytes) > 0 {
+		dashboardJSON, err := parseDashboardBytes(req.DashboardBytes)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		dashboard = models.NewDashboardFromJson(dashboardJSON)
+	} else if req.Dashboard == nil && req.GnetId != 0 {
+		dashboardJSON, err := s.gnetClient.getDashboard(ctx, req.GnetId)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		dashboard = models.NewDashboardFromJson(dashboardJSON)
+	} else if req.Dashboard == nil && req.URL != "" {
+		dashboardJSON, err := s.urlClient.getDashboard(ctx, req.URL)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		dashboard = models.NewDashboardFromJson(dashboardJSON)
+	} else {
+		dashboard = models.NewDashboardFromJson(req.Dashboard)
+	}
+
+	if req.Migrate == nil || *req.Migrate {
+		if err := s.schemaMigrator.Migrate(ctx, req.User.OrgId, dashboard.Data); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	evaluator := utils.NewDashTemplateEvaluator(dashboard.Data, req.Inputs, s.evaluatorDatasourceResolver(ctx, req.User.OrgId))
+	generatedDash, err := evaluator.Eval()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if req.RegenerateUID {
+		generatedDash.Del("uid")
+	}
+
+	if err := checkLibraryPanelCycles(generatedDash); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if req.Sanitize == nil || *req.Sanitize {
+		sanitizeDashboard(generatedDash)
+	}
+
+	return dashboard, generatedDash, evaluator.DatasourceInputs(), nil
+}
+
+// sanitizedFields are exported along with the dashboard but describe the instance it was exported from. Without
+// its id and version, the dashboard is matched to the existing dashboards by its UID only, and is saved with the
+// version 0 the dashboard model gives a new dashboard.
+var sanitizedFields = []string{"id", "version", "__inputs", "__requires"}
+
+func sanitizeDashboard(dash *simplejson.Json) {
+	for _, field := range sanitizedFields {
+		dash.Del(field)
+	}
+}
+
+// parseDashboardBytes returns a DashboardParseError pointing at the line and column where the parsing failed.
+func parseDashboardBytes(data []byte) (*simplejson.Json, error) {
+	dashboardJSON, err := simplejson.NewJson(data)
+	if err == nil {
+		return dashboardJSON, nil
+	}
+
+	offset := int64(len(data))
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) {
+		offset = syntaxErr.Offset
+	} else if errors.As(err, &typeErr) {
+		offset = typeErr.Offset
+	}
+
+	// the offset counts the bytes read, including the offending one
+	pos := int(offset) - 1
+	if pos < 0 {
+		pos = 0
+	}
+	if pos > len(data) {
+		pos = len(data)
+	}
+
+	line := bytes.Count(data[:pos], []byte("\n")) + 1
+	column := pos - bytes.LastIndexByte(data[:pos], '\n')
+	return nil, dashboardimport.DashboardParseError{Line: line, Column: column, Err: err}
+}
+
+func (s *ImportDashboardService) ImportDashboards(ctx context.Context, reqs []*dashboardimport.ImportDashboardRequest) ([]*dashboardimport.ImportDashboardResponse, error) {
+	responses := make([]*dashboardimport.ImportDashboardResponse, len(reqs))
+	errs := make(map[int]error)
+	for i, req := range reqs {
+		resp, err := s.ImportDashboard(ctx, req)
+		if err != nil {
+			errs[i] = err
+			if !req.ContinueOnError {
+				break
+			}
+			continue
+		}
+		responses[i] = resp
+	}
+
+	if len(errs) > 0 {
+		return responses, dashboardimport.ImportDashboardsError{Errors: errs}
+	}
+
+	return responses, nil
+}
+
+// checkDatasourceInputs returns a warning for every datasource input which resolves to no datasource of the org.
+// In strict mode such inputs fail the import instead.
+func (s *ImportDashboardService) checkDatasourceInputs(ctx context.Context, req *dashboardimport.ImportDashboardRequest) ([]string, error) {
+	missing := make([]string, 0)
+	checked := make(map[string]bool)
+	for _, input := range req.Inputs {
+		if input.Type != "datasource" || input.Value == "" || checked[input.Value] {
+			continue
+		}
+		checked[input.Value] = true
+
+		ref, err := s.getDatasourceResolver().ResolveDatasource(ctx, req.User.OrgId, input)
+		if err != nil {
+			return nil, err
+		}
+
+		if ref == nil {
+			missing = append(missing, input.Value)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil, nil
+	}
+
+	if req.FailOnMissingDatasource {
+		return nil, dashboardimport.MissingDatasourcesError{Datasources: missing}
+	}
+
+	warnings := make([]string, 0, len(missing))
+	for _, datasource := range missing {
+		warnings = append(warnings, fmt.Sprintf("datasource %s not found", datasource))
+	}
+	return warnings, nil
+}
+
+// getDatasourceResolver returns the injected resolver, or the default one for services built without it.
+func (s *ImportDashboardService) getDatasourceResolver() dashboardimport.DatasourceResolver {
+	if s.datasourceResolver != nil {
+		return s.datasourceResolver
+	}
+	return ProvideDatasourceResolver(s.dataSourceService)
+}
+
+// evaluatorDatasourceResolver resolves the datasource inputs used in object datasource references to the
+// datasources of the org. Values resolving to no datasource are kept as they are, they are reported by
+// checkDatasourceInputs.
+func (s *ImportDashboardService) evaluatorDatasourceResolver(ctx context.Context, orgID int64) utils.DatasourceResolver {
+	resolver := s.getDatasourceResolver()
+	return func(input dashboardimport.ImportDashboardInput) (*dashboardimport.DatasourceRef, error) {
+		return resolver.ResolveDatasource(ctx, orgID, input)
+	}
+}
+
+// datasourceMappings maps the datasource inputs to the UIDs of their datasources. Inputs resolving to no datasource
+// keep their value, they are reported by checkDatasourceInputs.
+func (s *ImportDashboardService) datasourceMappings(ctx context.Context, orgID int64, datasourceInputs map[string]dashboardimport.ImportDashboardInput) (map[string]string, error) {
+	if len(datasourceInputs) == 0 {
+		return nil, nil
+	}
+
+	resolver := s.getDatasourceResolver()
+	mappings := make(map[string]string, len(datasourceInputs))
+	for name, input := range datasourceInputs {
+		ref, err := resolver.ResolveDatasource(ctx, orgID, input)
+		if err != nil {
+			return nil, err
+		}
+
+		if ref == nil {
+			mappings[name] = input.Value
+			continue
+		}
+		mappings[name] = ref.UID
+	}
+	return mappings, nil
+}
+
+// resolveFolderID returns the ID of the folder to import the dashboard into. Unless the ID is given,
+// the folder is looked up by its UID or title and created if it does not exist yet. A dry run does not create
+// the folder, it returns the ID 0 of the General folder and true instead.
+func (s *ImportDashboardService) resolveFolderID(ctx context.Context, req *dashboardimport.ImportDashboardRequest) (int64, bool, error) {
+	if req.FolderId != 0 || (req.FolderUid == "" && req.FolderTitle == "") {
+		return req.FolderId, false, nil
+	}
+
+	var folder *models.Folder
+	var err error
+	if req.FolderUid != "" {
+		folder, err = s.folderService.GetFolderByUID(ctx, req.User, req.User.OrgId, req.FolderUid)
+	} else {
+		folder, err = s.folderService.GetFolderByTitle(ctx, req.User, req.User.OrgId, req.FolderTitle)
+	}
+
+	if err == nil {
+		return folder.Id, false, nil
+	}
+
+	if !errors.Is(err, models.ErrFolderNotFound) || req.FolderTitle == "" {
+		return 0, false, err
+	}
+
+	if req.DryRun {
+		return 0, true, nil
+	}
+
+	folder, err = s.folderService.CreateFolder(ctx, req.User, req.User.OrgId, req.FolderTitle, req.FolderUid)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return folder.Id, false, nil
+}
+
 func (s *ImportDashboardService) setDashboardPermissions(ctx context.Context, user *models.SignedInUser, dashboard *models.Dashboard) error {
 	resourceID := strconv.FormatInt(dashboard.Id, 10)
 
@@ -2,7 +2,10 @@ package service
 
 import (
 	"context"
+	"errors"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"path/filepath"
 	"testing"
 
@@ -10,9 +13,13 @@ import (
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/plugins"
 	"github.com/grafana/grafana/pkg/services/dashboardimport"
+	"github.com/grafana/grafana/pkg/services/dashboardimport/migration"
 	"github.com/grafana/grafana/pkg/services/dashboards"
+	"github.com/grafana/grafana/pkg/services/datasources"
 	"github.com/grafana/grafana/pkg/services/featuremgmt"
 	"github.com/grafana/grafana/pkg/services/librarypanels"
+	"github.com/grafana/grafana/pkg/services/search"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
 	"github.com/stretchr/testify/require"
 )
 
@@ -43,9 +50,9 @@ func TestImportDashboardService(t *testing.T) {
 		importLibraryPanelsForDashboard := false
 		connectLibraryPanelsForDashboardCalled := false
 		libraryPanelService := &libraryPanelServiceMock{
-			importLibraryPanelsForDashboardFunc: func(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard, folderID int64) error {
+			importLibraryPanelsForDashboardFunc: func(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard, folderID int64) (*librarypanels.ImportLibraryPanelsResult, error) {
 				importLibraryPanelsForDashboard = true
-				return nil
+				return &librarypanels.ImportLibraryPanelsResult{Created: []string{"created"}, Existing: []string{"existing"}}, nil
 			},
 			connectLibraryPanelsForDashboardFunc: func(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard) error {
 				connectLibraryPanelsForDashboardCalled = true
@@ -53,6 +60,8 @@ func TestImportDashboardService(t *testing.T) {
 			},
 		}
 		s := &ImportDashboardService{
+			schemaMigrator:         migration.ProvideService(nil, nil),
+			dataSourceService:      &dataSourceServiceMock{},
 			pluginDashboardManager: pluginDashboardManager,
 			dashboardService:       dashboardService,
 			libraryPanelService:    libraryPanelService,
@@ -78,12 +87,15 @@ func TestImportDashboardService(t *testing.T) {
 		require.Equal(t, int64(2), importDashboardArg.User.UserId)
 		require.Equal(t, "prometheus", importDashboardArg.Dashboard.PluginId)
 		require.Equal(t, int64(5), importDashboardArg.Dashboard.FolderId)
+		require.Equal(t, "Imported via API", importDashboardArg.Message)
 
 		panel := importDashboardArg.Dashboard.Data.Get("panels").GetIndex(0)
-		require.Equal(t, "prom", panel.Get("datasource").MustString())
+		require.Equal(t, "prom", panel.Get("datasource").Get("uid").MustString())
 
 		require.True(t, importLibraryPanelsForDashboard)
 		require.True(t, connectLibraryPanelsForDashboardCalled)
+		require.Equal(t, []string{"created"}, resp.ImportedLibraryPanels)
+		require.Equal(t, []string{"existing"}, resp.ConnectedLibraryPanels)
 	})
 
 	t.Run("When importing a non-plugin dashboard should save dashboard and sync library panels", func(t *testing.T) {
@@ -106,6 +118,8 @@ func TestImportDashboardService(t *testing.T) {
 		}
 		libraryPanelService := &libraryPanelServiceMock{}
 		s := &ImportDashboardService{
+			schemaMigrator:      migration.ProvideService(nil, nil),
+			dataSourceService:   &dataSourceServiceMock{},
 			features:            featuremgmt.WithFeatures(),
 			dashboardService:    dashboardService,
 			libraryPanelService: libraryPanelService,
@@ -122,6 +136,7 @@ func TestImportDashboardService(t *testing.T) {
 			},
 			User:     &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
 			FolderId: 5,
+			Message:  "Deployed by CI",
 		}
 		resp, err := s.ImportDashboard(context.Background(), req)
 		require.NoError(t, err)
@@ -133,72 +148,1819 @@ func TestImportDashboardService(t *testing.T) {
 		require.Equal(t, int64(2), importDashboardArg.User.UserId)
 		require.Equal(t, "", importDashboardArg.Dashboard.PluginId)
 		require.Equal(t, int64(5), importDashboardArg.Dashboard.FolderId)
+		require.Equal(t, "Deployed by CI", importDashboardArg.Message)
 
 		panel := importDashboardArg.Dashboard.Data.Get("panels").GetIndex(0)
-		require.Equal(t, "prom", panel.Get("datasource").MustString())
+		require.Equal(t, "prom", panel.Get("datasource").Get("uid").MustString())
 	})
 }
 
-func loadTestDashboard(ctx context.Context, pluginID, path string) (*models.Dashboard, error) {
-	// It's safe to ignore gosec warning G304 since this is a test and arguments comes from test configuration.
-	// nolint:gosec
-	bytes, err := ioutil.ReadFile(filepath.Join("testdata", path))
-	if err != nil {
-		return nil, err
+func TestImportDashboardDryRun(t *testing.T) {
+	importDashboardCalled := false
+	var validatedDTO *dashboards.SaveDashboardDTO
+	dashboardService := &dashboardServiceMock{
+		importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+			importDashboardCalled = true
+			return dto.Dashboard, nil
+		},
+		buildSaveDashboardCommandFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO, shouldValidateAlerts bool, validateProvisionedDashboard bool) (*models.SaveDashboardCommand, error) {
+			validatedDTO = dto
+			return &models.SaveDashboardCommand{}, nil
+		},
 	}
 
-	dashboardJSON, err := simplejson.NewJson(bytes)
-	if err != nil {
-		return nil, err
+	libraryPanelsCalled := false
+	libraryPanelService := &libraryPanelServiceMock{
+		importLibraryPanelsForDashboardFunc: func(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard, folderID int64) (*librarypanels.ImportLibraryPanelsResult, error) {
+			libraryPanelsCalled = true
+			return &librarypanels.ImportLibraryPanelsResult{}, nil
+		},
+		connectLibraryPanelsForDashboardFunc: func(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard) error {
+			libraryPanelsCalled = true
+			return nil
+		},
 	}
 
-	return models.NewDashboardFromJson(dashboardJSON), nil
+	s := &ImportDashboardService{
+		schemaMigrator:      migration.ProvideService(nil, nil),
+		dataSourceService:   &dataSourceServiceMock{},
+		features:            featuremgmt.WithFeatures(),
+		dashboardService:    dashboardService,
+		libraryPanelService: libraryPanelService,
+	}
+
+	dash, err := loadTestDashboard(context.Background(), "", "dashboard.json")
+	require.NoError(t, err)
+
+	req := &dashboardimport.ImportDashboardRequest{
+		Dashboard: dash.Data,
+		Inputs: []dashboardimport.ImportDashboardInput{
+			{Name: "*", Type: "datasource", Value: "prom"},
+		},
+		User:     &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+		FolderId: 5,
+		DryRun:   true,
+	}
+	resp, err := s.ImportDashboard(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, "UDdpyzz7z", resp.UID)
+	require.Equal(t, dash.Title, resp.Title)
+	require.False(t, resp.Imported)
+
+	require.False(t, importDashboardCalled)
+	require.False(t, libraryPanelsCalled)
+
+	require.NotNil(t, validatedDTO)
+	panel := validatedDTO.Dashboard.Data.Get("panels").GetIndex(0)
+	require.Equal(t, "prom", panel.Get("datasource").Get("uid").MustString())
 }
 
-type pluginDashboardManagerMock struct {
-	plugins.PluginDashboardManager
-	loadPluginDashboardFunc func(ctx context.Context, pluginID, path string) (*models.Dashboard, error)
+func TestImportDashboardRegenerateUID(t *testing.T) {
+	newService := func(importedDTO **dashboards.SaveDashboardDTO) *ImportDashboardService {
+		return &ImportDashboardService{
+			schemaMigrator:    migration.ProvideService(nil, nil),
+			dataSourceService: &dataSourceServiceMock{},
+			features:          featuremgmt.WithFeatures(),
+			dashboardService: &dashboardServiceMock{
+				importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+					*importedDTO = dto
+					uid := dto.Dashboard.Uid
+					if uid == "" {
+						uid = "generated"
+					}
+					return &models.Dashboard{
+						Id:    4,
+						Uid:   uid,
+						Title: dto.Dashboard.Title,
+						Data:  dto.Dashboard.Data,
+					}, nil
+				},
+			},
+			libraryPanelService: &libraryPanelServiceMock{},
+		}
+	}
+
+	newRequest := func(t *testing.T, regenerateUID bool) *dashboardimport.ImportDashboardRequest {
+		dash, err := loadTestDashboard(context.Background(), "", "dashboard.json")
+		require.NoError(t, err)
+
+		return &dashboardimport.ImportDashboardRequest{
+			Dashboard: dash.Data,
+			Inputs: []dashboardimport.ImportDashboardInput{
+				{Name: "*", Type: "datasource", Value: "prom"},
+			},
+			User:          &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+			RegenerateUID: regenerateUID,
+		}
+	}
+
+	t.Run("should keep the uid of the dashboard by default", func(t *testing.T) {
+		var importedDTO *dashboards.SaveDashboardDTO
+		resp, err := newService(&importedDTO).ImportDashboard(context.Background(), newRequest(t, false))
+		require.NoError(t, err)
+		require.Equal(t, "UDdpyzz7z", importedDTO.Dashboard.Uid)
+		require.Equal(t, "UDdpyzz7z", resp.UID)
+	})
+
+	t.Run("should clear the uid of the dashboard when regenerating it", func(t *testing.T) {
+		var importedDTO *dashboards.SaveDashboardDTO
+		resp, err := newService(&importedDTO).ImportDashboard(context.Background(), newRequest(t, true))
+		require.NoError(t, err)
+		require.Empty(t, importedDTO.Dashboard.Uid)
+		_, exists := importedDTO.Dashboard.Data.CheckGet("uid")
+		require.False(t, exists)
+		require.Equal(t, "generated", resp.UID)
+	})
 }
 
-func (m *pluginDashboardManagerMock) LoadPluginDashboard(ctx context.Context, pluginID, path string) (*models.Dashboard, error) {
-	if m.loadPluginDashboardFunc != nil {
-		return m.loadPluginDashboardFunc(ctx, pluginID, path)
+func TestImportDashboardOverwrite(t *testing.T) {
+	savedByUID := make(map[string]*models.Dashboard)
+	dashboardService := &dashboardServiceMock{
+		importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+			if _, exists := savedByUID[dto.Dashboard.Uid]; exists && !dto.Overwrite {
+				return nil, models.ErrDashboardWithSameUIDExists
+			}
+			savedByUID[dto.Dashboard.Uid] = dto.Dashboard
+			return dto.Dashboard, nil
+		},
 	}
 
-	return nil, nil
+	s := &ImportDashboardService{
+		schemaMigrator:      migration.ProvideService(nil, nil),
+		dataSourceService:   &dataSourceServiceMock{},
+		features:            featuremgmt.WithFeatures(),
+		dashboardService:    dashboardService,
+		libraryPanelService: &libraryPanelServiceMock{},
+	}
+
+	newRequest := func(t *testing.T, overwrite bool) *dashboardimport.ImportDashboardRequest {
+		dash, err := loadTestDashboard(context.Background(), "", "dashboard.json")
+		require.NoError(t, err)
+
+		return &dashboardimport.ImportDashboardRequest{
+			Dashboard: dash.Data,
+			Inputs: []dashboardimport.ImportDashboardInput{
+				{Name: "*", Type: "datasource", Value: "prom"},
+			},
+			User:      &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+			Overwrite: overwrite,
+		}
+	}
+
+	_, err := s.ImportDashboard(context.Background(), newRequest(t, false))
+	require.NoError(t, err)
+
+	_, err = s.ImportDashboard(context.Background(), newRequest(t, false))
+	require.ErrorIs(t, err, models.ErrDashboardWithSameUIDExists)
+
+	resp, err := s.ImportDashboard(context.Background(), newRequest(t, true))
+	require.NoError(t, err)
+	require.Equal(t, "UDdpyzz7z", resp.UID)
 }
 
-type dashboardServiceMock struct {
-	dashboards.DashboardService
-	importDashboardFunc func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error)
+func TestImportDashboardIntoFolder(t *testing.T) {
+	newService := func(importedDTO **dashboards.SaveDashboardDTO, folderService dashboards.FolderService) *ImportDashboardService {
+		return &ImportDashboardService{
+			schemaMigrator:    migration.ProvideService(nil, nil),
+			dataSourceService: &dataSourceServiceMock{},
+			features:          featuremgmt.WithFeatures(),
+			dashboardService: &dashboardServiceMock{
+				importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+					*importedDTO = dto
+					return dto.Dashboard, nil
+				},
+			},
+			folderService:       folderService,
+			libraryPanelService: &libraryPanelServiceMock{},
+		}
+	}
+
+	newRequest := func(t *testing.T) *dashboardimport.ImportDashboardRequest {
+		dash, err := loadTestDashboard(context.Background(), "", "dashboard.json")
+		require.NoError(t, err)
+
+		return &dashboardimport.ImportDashboardRequest{
+			Dashboard: dash.Data,
+			Inputs: []dashboardimport.ImportDashboardInput{
+				{Name: "*", Type: "datasource", Value: "prom"},
+			},
+			User: &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+		}
+	}
+
+	t.Run("should import into an existing folder by uid", func(t *testing.T) {
+		folderService := &folderServiceMock{
+			getFolderByUIDFunc: func(ctx context.Context, user *models.SignedInUser, orgID int64, uid string) (*models.Folder, error) {
+				require.Equal(t, "gitops", uid)
+				return &models.Folder{Id: 7, Uid: uid}, nil
+			},
+		}
+
+		var importedDTO *dashboards.SaveDashboardDTO
+		req := newRequest(t)
+		req.FolderUid = "gitops"
+		resp, err := newService(&importedDTO, folderService).ImportDashboard(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, int64(7), importedDTO.Dashboard.FolderId)
+		require.Equal(t, int64(7), resp.FolderId)
+		require.False(t, folderService.createFolderCalled)
+	})
+
+	t.Run("should create a folder by title if it does not exist", func(t *testing.T) {
+		folderService := &folderServiceMock{
+			getFolderByTitleFunc: func(ctx context.Context, user *models.SignedInUser, orgID int64, title string) (*models.Folder, error) {
+				return nil, models.ErrFolderNotFound
+			},
+			createFolderFunc: func(ctx context.Context, user *models.SignedInUser, orgID int64, title, uid string) (*models.Folder, error) {
+				require.Equal(t, int64(3), orgID)
+				require.Equal(t, "GitOps", title)
+				return &models.Folder{Id: 8, Title: title}, nil
+			},
+		}
+
+		var importedDTO *dashboards.SaveDashboardDTO
+		req := newRequest(t)
+		req.FolderTitle = "GitOps"
+		_, err := newService(&importedDTO, folderService).ImportDashboard(context.Background(), req)
+		require.NoError(t, err)
+		require.True(t, folderService.createFolderCalled)
+		require.Equal(t, int64(8), importedDTO.Dashboard.FolderId)
+	})
+
+	t.Run("should not create the folder in a dry run", func(t *testing.T) {
+		folderService := &folderServiceMock{}
+		s := newService(nil, folderService)
+		s.dashboardService = &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				require.Fail(t, "the dashboard must not be saved in a dry run")
+				return nil, nil
+			},
+			buildSaveDashboardCommandFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO, shouldValidateAlerts bool, validateProvisionedDashboard bool) (*models.SaveDashboardCommand, error) {
+				require.Equal(t, int64(0), dto.Dashboard.FolderId)
+				return &models.SaveDashboardCommand{}, nil
+			},
+		}
+
+		req := newRequest(t)
+		req.FolderTitle = "GitOps"
+		req.DryRun = true
+		resp, err := s.ImportDashboard(context.Background(), req)
+		require.NoError(t, err)
+		require.False(t, folderService.createFolderCalled)
+		require.False(t, resp.Imported)
+		require.Contains(t, resp.Warnings, "folder GitOps not found, it would be created")
+	})
+
+	t.Run("should prefer the folder id", func(t *testing.T) {
+		var importedDTO *dashboards.SaveDashboardDTO
+		req := newRequest(t)
+		req.FolderId = 5
+		req.FolderTitle = "GitOps"
+		_, err := newService(&importedDTO, &folderServiceMock{}).ImportDashboard(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, int64(5), importedDTO.Dashboard.FolderId)
+	})
 }
 
-func (s *dashboardServiceMock) ImportDashboard(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
-	if s.importDashboardFunc != nil {
-		return s.importDashboardFunc(ctx, dto)
+func TestImportDashboardMissingDatasource(t *testing.T) {
+	importDashboardCalled := false
+	s := &ImportDashboardService{
+		features: featuremgmt.WithFeatures(),
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				importDashboardCalled = true
+				return dto.Dashboard, nil
+			},
+		},
+		schemaMigrator: migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{
+			getDataSourceFunc: func(ctx context.Context, query *models.GetDataSourceQuery) error {
+				if query.Uid == "prom" || query.Name == "Prometheus" {
+					query.Result = &models.DataSource{Uid: "prom", Name: "Prometheus"}
+					return nil
+				}
+				return models.ErrDataSourceNotFound
+			},
+		},
+		libraryPanelService: &libraryPanelServiceMock{},
 	}
 
-	return nil, nil
+	newRequest := func(t *testing.T, datasource string, strict bool) *dashboardimport.ImportDashboardRequest {
+		dash, err := loadTestDashboard(context.Background(), "", "dashboard.json")
+		require.NoError(t, err)
+
+		return &dashboardimport.ImportDashboardRequest{
+			Dashboard: dash.Data,
+			Inputs: []dashboardimport.ImportDashboardInput{
+				{Name: "*", Type: "datasource", Value: datasource},
+			},
+			User:                    &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+			FailOnMissingDatasource: strict,
+		}
+	}
+
+	t.Run("should not warn about existing datasources", func(t *testing.T) {
+		for _, datasource := range []string{"prom", "Prometheus"} {
+			resp, err := s.ImportDashboard(context.Background(), newRequest(t, datasource, true))
+			require.NoError(t, err)
+			require.Empty(t, resp.Warnings)
+		}
+	})
+
+	t.Run("should warn about missing datasources", func(t *testing.T) {
+		importDashboardCalled = false
+		resp, err := s.ImportDashboard(context.Background(), newRequest(t, "missing", false))
+		require.NoError(t, err)
+		require.Equal(t, []string{"datasource missing not found"}, resp.Warnings)
+		require.True(t, importDashboardCalled)
+	})
+
+	t.Run("should fail on missing datasources in strict mode", func(t *testing.T) {
+		importDashboardCalled = false
+		_, err := s.ImportDashboard(context.Background(), newRequest(t, "missing", true))
+		var missingDatasourcesErr dashboardimport.MissingDatasourcesError
+		require.ErrorAs(t, err, &missingDatasourcesErr)
+		require.Equal(t, []string{"missing"}, missingDatasourcesErr.Datasources)
+		require.False(t, importDashboardCalled)
+	})
 }
 
-type libraryPanelServiceMock struct {
-	librarypanels.Service
-	connectLibraryPanelsForDashboardFunc func(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard) error
-	importLibraryPanelsForDashboardFunc  func(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard, folderID int64) error
+func TestImportDashboardMissingDatasourceWithTypeDefault(t *testing.T) {
+	var importDashboardArg *dashboards.SaveDashboardDTO
+	s := &ImportDashboardService{
+		features: featuremgmt.WithFeatures(),
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				importDashboardArg = dto
+				return dto.Dashboard, nil
+			},
+		},
+		schemaMigrator: migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{
+			getDataSourceFunc: func(ctx context.Context, query *models.GetDataSourceQuery) error {
+				return models.ErrDataSourceNotFound
+			},
+			getDataSourcesFunc: func(ctx context.Context, query *models.GetDataSourcesQuery) error {
+				query.Result = []*models.DataSource{{OrgId: 3, Uid: "prom-uid", Name: "Prometheus", Type: "prometheus", IsDefault: true}}
+				return nil
+			},
+		},
+		libraryPanelService: &libraryPanelServiceMock{},
+	}
+
+	newRequest := func(strict bool) *dashboardimport.ImportDashboardRequest {
+		return &dashboardimport.ImportDashboardRequest{
+			DashboardBytes: []byte(`{
+				"__inputs": [{"name": "DS_PROM", "type": "datasource", "pluginId": "prometheus"}],
+				"panels": [{"datasource": "${DS_PROM}"}]
+			}`),
+			Inputs: []dashboardimport.ImportDashboardInput{
+				{Name: "DS_PROM", Type: "datasource", PluginId: "prometheus", Value: "MyProm"},
+			},
+			User:                    &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+			FailOnMissingDatasource: strict,
+		}
+	}
+
+	t.Run("should warn about a missing datasource although the type has a default", func(t *testing.T) {
+		resp, err := s.ImportDashboard(context.Background(), newRequest(false))
+		require.NoError(t, err)
+		require.Equal(t, []string{"datasource MyProm not found"}, resp.Warnings)
+		require.Equal(t, "MyProm", importDashboardArg.Dashboard.Data.Get("panels").GetIndex(0).Get("datasource").MustString())
+	})
+
+	t.Run("should fail on a missing datasource in strict mode although the type has a default", func(t *testing.T) {
+		_, err := s.ImportDashboard(context.Background(), newRequest(true))
+		var missingDatasourcesErr dashboardimport.MissingDatasourcesError
+		require.ErrorAs(t, err, &missingDatasourcesErr)
+		require.Equal(t, []string{"MyProm"}, missingDatasourcesErr.Datasources)
+	})
 }
 
-func (s *libraryPanelServiceMock) ConnectLibraryPanelsForDashboard(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard) error {
-	if s.connectLibraryPanelsForDashboardFunc != nil {
-		return s.connectLibraryPanelsForDashboardFunc(ctx, signedInUser, dash)
+func TestImportDashboards(t *testing.T) {
+	importedCount := 0
+	connectedCount := 0
+	s := &ImportDashboardService{
+		features: featuremgmt.WithFeatures(),
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				importedCount++
+				return dto.Dashboard, nil
+			},
+		},
+		schemaMigrator:    migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{},
+		libraryPanelService: &libraryPanelServiceMock{
+			connectLibraryPanelsForDashboardFunc: func(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard) error {
+				connectedCount++
+				return nil
+			},
+		},
 	}
 
-	return nil
+	newRequest := func(t *testing.T, withInputs bool, continueOnError bool) *dashboardimport.ImportDashboardRequest {
+		dash, err := loadTestDashboard(context.Background(), "", "dashboard.json")
+		require.NoError(t, err)
+
+		req := &dashboardimport.ImportDashboardRequest{
+			Dashboard:       dash.Data,
+			User:            &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+			ContinueOnError: continueOnError,
+		}
+		if withInputs {
+			req.Inputs = []dashboardimport.ImportDashboardInput{
+				{Name: "*", Type: "datasource", Value: "prom"},
+			}
+		}
+		return req
+	}
+
+	t.Run("should continue past failed dashboards when allowed", func(t *testing.T) {
+		importedCount, connectedCount = 0, 0
+		responses, err := s.ImportDashboards(context.Background(), []*dashboardimport.ImportDashboardRequest{
+			newRequest(t, true, true),
+			newRequest(t, false, true),
+			newRequest(t, true, true),
+		})
+
+		var importErr dashboardimport.ImportDashboardsError
+		require.ErrorAs(t, err, &importErr)
+		require.Len(t, importErr.Errors, 1)
+		require.ErrorAs(t, importErr.Errors[1], &dashboardimport.MissingInputsError{})
+
+		require.Len(t, responses, 3)
+		require.NotNil(t, responses[0])
+		require.Nil(t, responses[1])
+		require.NotNil(t, responses[2])
+		require.Equal(t, 2, importedCount)
+		require.Equal(t, 2, connectedCount)
+	})
+
+	t.Run("should stop at the first failed dashboard by default", func(t *testing.T) {
+		importedCount, connectedCount = 0, 0
+		responses, err := s.ImportDashboards(context.Background(), []*dashboardimport.ImportDashboardRequest{
+			newRequest(t, true, false),
+			newRequest(t, false, false),
+			newRequest(t, true, false),
+		})
+
+		var importErr dashboardimport.ImportDashboardsError
+		require.ErrorAs(t, err, &importErr)
+		require.Contains(t, importErr.Errors, 1)
+
+		require.Len(t, responses, 3)
+		require.NotNil(t, responses[0])
+		require.Nil(t, responses[1])
+		require.Nil(t, responses[2])
+		require.Equal(t, 1, importedCount)
+		require.Equal(t, 1, connectedCount)
+	})
+
+	t.Run("should import all dashboards", func(t *testing.T) {
+		responses, err := s.ImportDashboards(context.Background(), []*dashboardimport.ImportDashboardRequest{
+			newRequest(t, true, false),
+			newRequest(t, true, false),
+		})
+		require.NoError(t, err)
+		require.Len(t, responses, 2)
+	})
 }
 
-func (s *libraryPanelServiceMock) ImportLibraryPanelsForDashboard(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard, folderID int64) error {
-	if s.importLibraryPanelsForDashboardFunc != nil {
-		return s.importLibraryPanelsForDashboardFunc(ctx, signedInUser, dash, folderID)
+func TestImportDashboardIntoOrgs(t *testing.T) {
+	savedOrgIDs := make([]int64, 0)
+	libraryPanelOrgIDs := make([]int64, 0)
+	connectedOrgIDs := make([]int64, 0)
+	s := &ImportDashboardService{
+		features: featuremgmt.WithFeatures(),
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				savedOrgIDs = append(savedOrgIDs, dto.OrgId)
+				dto.Dashboard.OrgId = dto.OrgId
+				return dto.Dashboard, nil
+			},
+		},
+		schemaMigrator:    migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{},
+		libraryPanelService: &libraryPanelServiceMock{
+			importLibraryPanelsForDashboardFunc: func(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard, folderID int64) (*librarypanels.ImportLibraryPanelsResult, error) {
+				require.Equal(t, signedInUser.OrgId, dash.OrgId)
+				libraryPanelOrgIDs = append(libraryPanelOrgIDs, signedInUser.OrgId)
+				return &librarypanels.ImportLibraryPanelsResult{Created: []string{"lib-uid"}}, nil
+			},
+			connectLibraryPanelsForDashboardFunc: func(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard) error {
+				connectedOrgIDs = append(connectedOrgIDs, signedInUser.OrgId)
+				return nil
+			},
+		},
 	}
 
-	return nil
+	newRequest := func() *dashboardimport.ImportDashboardRequest {
+		return &dashboardimport.ImportDashboardRequest{
+			DashboardBytes: []byte(`{"uid": "provisioned", "title": "Provisioned", "panels": [{"id": 1, "libraryPanel": {"uid": "lib-uid", "name": "Library"}}]}`),
+			OrgIds:         []int64{3, 4},
+		}
+	}
+
+	responses, err := s.ImportDashboardIntoOrgs(context.Background(), newRequest())
+	require.NoError(t, err)
+	require.Len(t, responses, 2)
+	for i, orgID := range []int64{3, 4} {
+		require.Equal(t, orgID, responses[i].OrgId)
+		require.Equal(t, "provisioned", responses[i].UID)
+		require.True(t, responses[i].Imported)
+		require.Equal(t, []string{"lib-uid"}, responses[i].ImportedLibraryPanels)
+	}
+	require.Equal(t, []int64{3, 4}, savedOrgIDs)
+	require.Equal(t, []int64{3, 4}, libraryPanelOrgIDs)
+	require.Equal(t, []int64{3, 4}, connectedOrgIDs)
+
+	t.Run("should reject a request with both orgs and a user", func(t *testing.T) {
+		req := newRequest()
+		req.User = &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3}
+		_, err := s.ImportDashboardIntoOrgs(context.Background(), req)
+		require.ErrorIs(t, err, dashboardimport.ErrOrgIdsWithUser)
+	})
+
+	t.Run("should reject orgs in a single org import", func(t *testing.T) {
+		_, err := s.ImportDashboard(context.Background(), newRequest())
+		require.ErrorIs(t, err, dashboardimport.ErrOrgIdsNotSupported)
+	})
+}
+
+func TestImportDashboardFromGnet(t *testing.T) {
+	dashboardBytes, err := ioutil.ReadFile(filepath.Join("testdata", "dashboard.json"))
+	require.NoError(t, err)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/api/dashboards/1860/revisions/latest/download":
+			_, _ = w.Write(dashboardBytes)
+		case "/api/dashboards/500/revisions/latest/download":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	var importDashboardArg *dashboards.SaveDashboardDTO
+	s := &ImportDashboardService{
+		schemaMigrator:    migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{},
+		features:          featuremgmt.WithFeatures(),
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				importDashboardArg = dto
+				return dto.Dashboard, nil
+			},
+		},
+		libraryPanelService: &libraryPanelServiceMock{},
+		gnetClient:          newGnetClient(server.URL),
+	}
+
+	newRequest := func(gnetID int64) *dashboardimport.ImportDashboardRequest {
+		return &dashboardimport.ImportDashboardRequest{
+			GnetId: gnetID,
+			Inputs: []dashboardimport.ImportDashboardInput{
+				{Name: "*", Type: "datasource", Value: "prom"},
+			},
+			User: &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+		}
+	}
+
+	t.Run("should download, substitute inputs and cache the dashboard", func(t *testing.T) {
+		resp, err := s.ImportDashboard(context.Background(), newRequest(1860))
+		require.NoError(t, err)
+		require.Equal(t, "UDdpyzz7z", resp.UID)
+
+		panel := importDashboardArg.Dashboard.Data.Get("panels").GetIndex(0)
+		require.Equal(t, "prom", panel.Get("datasource").Get("uid").MustString())
+
+		_, err = s.ImportDashboard(context.Background(), newRequest(1860))
+		require.NoError(t, err)
+		require.Equal(t, 1, requests)
+	})
+
+	t.Run("should return a not found error for unknown dashboards", func(t *testing.T) {
+		_, err := s.ImportDashboard(context.Background(), newRequest(404))
+		require.ErrorIs(t, err, dashboardimport.ErrGnetDashboardNotFound)
+	})
+
+	t.Run("should return the status code of failed downloads", func(t *testing.T) {
+		_, err := s.ImportDashboard(context.Background(), newRequest(500))
+
+		var gnetErr dashboardimport.GnetDashboardError
+		require.True(t, errors.As(err, &gnetErr))
+		require.Equal(t, http.StatusInternalServerError, gnetErr.StatusCode)
+	})
+}
+
+func TestImportDashboardFromURL(t *testing.T) {
+	dashboardBytes, err := ioutil.ReadFile(filepath.Join("testdata", "dashboard.json"))
+	require.NoError(t, err)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/dashboard.json":
+			_, _ = w.Write(dashboardBytes)
+		case "/redirect":
+			http.Redirect(w, r, "http://dashboards.example.com/dashboard.json", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	var importDashboardArg *dashboards.SaveDashboardDTO
+	newService := func(allowedHosts ...string) *ImportDashboardService {
+		return &ImportDashboardService{
+			schemaMigrator:    migration.ProvideService(nil, nil),
+			dataSourceService: &dataSourceServiceMock{},
+			features:          featuremgmt.WithFeatures(),
+			dashboardService: &dashboardServiceMock{
+				importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+					importDashboardArg = dto
+					return dto.Dashboard, nil
+				},
+			},
+			libraryPanelService: &libraryPanelServiceMock{},
+			urlClient:           newURLClient(allowedHosts),
+		}
+	}
+
+	newRequest := func(url string) *dashboardimport.ImportDashboardRequest {
+		return &dashboardimport.ImportDashboardRequest{
+			URL: url,
+			Inputs: []dashboardimport.ImportDashboardInput{
+				{Name: "*", Type: "datasource", Value: "prom"},
+			},
+			User: &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+		}
+	}
+
+	t.Run("should download the dashboard and substitute inputs", func(t *testing.T) {
+		s := newService("127.0.0.1")
+		resp, err := s.ImportDashboard(context.Background(), newRequest(server.URL+"/dashboard.json"))
+		require.NoError(t, err)
+		require.Equal(t, "UDdpyzz7z", resp.UID)
+
+		panel := importDashboardArg.Dashboard.Data.Get("panels").GetIndex(0)
+		require.Equal(t, "prom", panel.Get("datasource").Get("uid").MustString())
+	})
+
+	t.Run("should not request hosts which are not allowed", func(t *testing.T) {
+		requests = 0
+		s := newService("dashboards.example.com")
+		_, err := s.ImportDashboard(context.Background(), newRequest(server.URL+"/dashboard.json"))
+		require.ErrorIs(t, err, dashboardimport.ErrDashboardURLNotAllowed)
+		require.Equal(t, 0, requests)
+	})
+
+	t.Run("should not follow redirects to hosts which are not allowed", func(t *testing.T) {
+		s := newService("127.0.0.1")
+		_, err := s.ImportDashboard(context.Background(), newRequest(server.URL+"/redirect"))
+		require.ErrorIs(t, err, dashboardimport.ErrDashboardURLNotAllowed)
+	})
+
+	t.Run("should only allow http and https URLs", func(t *testing.T) {
+		s := newService()
+		for _, url := range []string{"file:///etc/passwd", "ftp://127.0.0.1/dashboard.json", "/dashboard.json"} {
+			_, err := s.ImportDashboard(context.Background(), newRequest(url))
+			require.ErrorIs(t, err, dashboardimport.ErrDashboardURLNotAllowed, url)
+		}
+	})
+
+	t.Run("should return the status code of failed downloads", func(t *testing.T) {
+		s := newService()
+		_, err := s.ImportDashboard(context.Background(), newRequest(server.URL+"/missing.json"))
+
+		var urlErr dashboardimport.DashboardURLError
+		require.True(t, errors.As(err, &urlErr))
+		require.Equal(t, http.StatusNotFound, urlErr.StatusCode)
+	})
+
+	t.Run("should reject dashboards larger than the size limit", func(t *testing.T) {
+		s := newService()
+		s.urlClient.maxSize = int64(len(dashboardBytes) - 1)
+		_, err := s.ImportDashboard(context.Background(), newRequest(server.URL+"/dashboard.json"))
+		require.ErrorIs(t, err, dashboardimport.ErrDashboardTooLarge)
+
+		s.urlClient.maxSize = int64(len(dashboardBytes))
+		_, err = s.ImportDashboard(context.Background(), newRequest(server.URL+"/dashboard.json"))
+		require.NoError(t, err)
+	})
+}
+
+func TestImportDashboardSchemaMigration(t *testing.T) {
+	var importDashboardArg *dashboards.SaveDashboardDTO
+	s := &ImportDashboardService{
+		schemaMigrator:    migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{},
+		features:          featuremgmt.WithFeatures(),
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				importDashboardArg = dto
+				return dto.Dashboard, nil
+			},
+		},
+		libraryPanelService: &libraryPanelServiceMock{},
+	}
+
+	newRequest := func(t *testing.T, migrate *bool) *dashboardimport.ImportDashboardRequest {
+		dash, err := simplejson.NewJson([]byte(`{
+			"title": "Old dashboard",
+			"schemaVersion": 16,
+			"panels": [{"id": 1, "type": "text2", "minSpan": 8}]
+		}`))
+		require.NoError(t, err)
+		return &dashboardimport.ImportDashboardRequest{
+			Dashboard: dash,
+			Migrate:   migrate,
+			User:      &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+		}
+	}
+
+	t.Run("should migrate the dashboard schema by default", func(t *testing.T) {
+		_, err := s.ImportDashboard(context.Background(), newRequest(t, nil))
+		require.NoError(t, err)
+
+		data := importDashboardArg.Dashboard.Data
+		require.Equal(t, migration.LatestSchemaVersion, data.Get("schemaVersion").MustInt())
+		panel := data.Get("panels").GetIndex(0)
+		require.Equal(t, "text", panel.Get("type").MustString())
+		require.Equal(t, 3, panel.Get("maxPerRow").MustInt())
+	})
+
+	t.Run("should keep the dashboard schema when migration is disabled", func(t *testing.T) {
+		migrate := false
+		_, err := s.ImportDashboard(context.Background(), newRequest(t, &migrate))
+		require.NoError(t, err)
+
+		data := importDashboardArg.Dashboard.Data
+		require.Equal(t, 16, data.Get("schemaVersion").MustInt())
+		require.Equal(t, "text2", data.Get("panels").GetIndex(0).Get("type").MustString())
+	})
+}
+
+func TestImportDashboardFromBytes(t *testing.T) {
+	var importDashboardArg *dashboards.SaveDashboardDTO
+	s := &ImportDashboardService{
+		schemaMigrator:    migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{},
+		features:          featuremgmt.WithFeatures(),
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				importDashboardArg = dto
+				return dto.Dashboard, nil
+			},
+		},
+		libraryPanelService: &libraryPanelServiceMock{},
+	}
+	user := &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3}
+
+	t.Run("should import a dashboard from JSON bytes", func(t *testing.T) {
+		resp, err := s.ImportDashboard(context.Background(), &dashboardimport.ImportDashboardRequest{
+			DashboardBytes: []byte(`{"title": "From bytes", "uid": "bytes"}`),
+			User:           user,
+		})
+		require.NoError(t, err)
+		require.Equal(t, "From bytes", resp.Title)
+		require.Equal(t, "bytes", importDashboardArg.Dashboard.Uid)
+	})
+
+	t.Run("should prefer the parsed dashboard over the bytes", func(t *testing.T) {
+		resp, err := s.ImportDashboard(context.Background(), &dashboardimport.ImportDashboardRequest{
+			Dashboard:      simplejson.NewFromAny(map[string]interface{}{"title": "Parsed"}),
+			DashboardBytes: []byte(`{"title": "From bytes"}`),
+			User:           user,
+		})
+		require.NoError(t, err)
+		require.Equal(t, "Parsed", resp.Title)
+	})
+
+	t.Run("should point at the line and column of malformed JSON", func(t *testing.T) {
+		_, err := s.ImportDashboard(context.Background(), &dashboardimport.ImportDashboardRequest{
+			DashboardBytes: []byte("{\n  \"title\": \"Broken\",\n  \"panels\": [}\n}"),
+			User:           user,
+		})
+
+		var parseErr dashboardimport.DashboardParseError
+		require.True(t, errors.As(err, &parseErr))
+		require.Equal(t, 3, parseErr.Line)
+		require.Equal(t, 14, parseErr.Column)
+		require.Contains(t, err.Error(), "line 3, column 14")
+	})
+}
+
+func TestImportDashboardSanitize(t *testing.T) {
+	var importDashboardArg *dashboards.SaveDashboardDTO
+	s := &ImportDashboardService{
+		schemaMigrator:    migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{},
+		features:          featuremgmt.WithFeatures(),
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				importDashboardArg = dto
+				return dto.Dashboard, nil
+			},
+		},
+		libraryPanelService: &libraryPanelServiceMock{},
+	}
+
+	newRequest := func(sanitize *bool) *dashboardimport.ImportDashboardRequest {
+		return &dashboardimport.ImportDashboardRequest{
+			DashboardBytes: []byte(`{
+				"__inputs": [{"name": "VAR_TITLE", "type": "constant"}],
+				"__requires": [{"type": "grafana", "id": "grafana", "version": "8.3.0"}],
+				"id": 12,
+				"uid": "exported",
+				"title": "${VAR_TITLE}",
+				"version": 7,
+				"schemaVersion": 35,
+				"panels": [{"id": 1}]
+			}`),
+			Inputs: []dashboardimport.ImportDashboardInput{
+				{Name: "VAR_TITLE", Type: "constant", Value: "Exported"},
+			},
+			Sanitize: sanitize,
+			User:     &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+		}
+	}
+
+	t.Run("should strip the fields of the exporting instance by default", func(t *testing.T) {
+		_, err := s.ImportDashboard(context.Background(), newRequest(nil))
+		require.NoError(t, err)
+
+		require.Equal(t, int64(0), importDashboardArg.Dashboard.Id)
+		require.Equal(t, 0, importDashboardArg.Dashboard.Version)
+		data := importDashboardArg.Dashboard.Data.MustMap()
+		for _, field := range []string{"id", "__inputs", "__requires"} {
+			require.NotContains(t, data, field)
+		}
+		require.EqualValues(t, 0, data["version"])
+		require.Equal(t, "exported", data["uid"])
+		require.Equal(t, "Exported", data["title"])
+		require.Len(t, importDashboardArg.Dashboard.Data.Get("panels").MustArray(), 1)
+	})
+
+	t.Run("should keep the fields when disabled", func(t *testing.T) {
+		sanitize := false
+		_, err := s.ImportDashboard(context.Background(), newRequest(&sanitize))
+		require.NoError(t, err)
+
+		require.Equal(t, int64(12), importDashboardArg.Dashboard.Id)
+		require.Equal(t, 7, importDashboardArg.Dashboard.Version)
+		data := importDashboardArg.Dashboard.Data.MustMap()
+		require.Contains(t, data, "__requires")
+	})
+}
+
+func TestImportDashboardWithoutSource(t *testing.T) {
+	// none of the dependencies are set, the request must be rejected before any of them is used
+	s := &ImportDashboardService{}
+
+	resp, err := s.ImportDashboard(context.Background(), &dashboardimport.ImportDashboardRequest{})
+	require.ErrorIs(t, err, dashboardimport.ErrNoDashboardSource)
+	require.Nil(t, resp)
+
+	preview, err := s.ImportDashboardPreview(context.Background(), &dashboardimport.ImportDashboardRequest{})
+	require.ErrorIs(t, err, dashboardimport.ErrNoDashboardSource)
+	require.Nil(t, preview)
+}
+
+func TestImportDashboardDatasourceReferences(t *testing.T) {
+	var importDashboardArg *dashboards.SaveDashboardDTO
+	s := &ImportDashboardService{
+		schemaMigrator: migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{
+			getDataSourceFunc: func(ctx context.Context, query *models.GetDataSourceQuery) error {
+				if query.OrgId == 3 && (query.Uid == "prom-uid" || query.Name == "Prometheus") {
+					query.Result = &models.DataSource{Uid: "prom-uid", Name: "Prometheus"}
+					return nil
+				}
+				return models.ErrDataSourceNotFound
+			},
+		},
+		features: featuremgmt.WithFeatures(),
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				importDashboardArg = dto
+				return dto.Dashboard, nil
+			},
+		},
+		libraryPanelService: &libraryPanelServiceMock{},
+	}
+
+	importDashboard := func(t *testing.T, dashboard string, datasource string) *simplejson.Json {
+		t.Helper()
+
+		_, err := s.ImportDashboard(context.Background(), &dashboardimport.ImportDashboardRequest{
+			DashboardBytes: []byte(dashboard),
+			Inputs: []dashboardimport.ImportDashboardInput{
+				{Name: "*", Type: "datasource", Value: datasource},
+			},
+			User: &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+		})
+		require.NoError(t, err)
+		return importDashboardArg.Dashboard.Data.Get("panels").GetIndex(0)
+	}
+
+	t.Run("should keep the datasource name in string references", func(t *testing.T) {
+		panel := importDashboard(t, `{
+			"__inputs": [{"name": "DS_PROM", "type": "datasource", "pluginId": "prometheus"}],
+			"panels": [{"datasource": "${DS_PROM}", "targets": [{"datasource": "${DS_PROM}"}]}]
+		}`, "Prometheus")
+
+		require.Equal(t, "Prometheus", panel.Get("datasource").MustString())
+		require.Equal(t, "Prometheus", panel.Get("targets").GetIndex(0).Get("datasource").MustString())
+	})
+
+	t.Run("should resolve the datasource name to its UID in object references", func(t *testing.T) {
+		panel := importDashboard(t, `{
+			"__inputs": [{"name": "DS_PROM", "type": "datasource", "pluginId": "prometheus"}],
+			"panels": [{
+				"datasource": {"type": "prometheus", "uid": "${DS_PROM}"},
+				"targets": [{"datasource": {"type": "prometheus", "uid": "${DS_PROM}"}}]
+			}]
+		}`, "Prometheus")
+
+		require.Equal(t, "prom-uid", panel.GetPath("datasource", "uid").MustString())
+		require.Equal(t, "prom-uid", panel.Get("targets").GetIndex(0).GetPath("datasource", "uid").MustString())
+	})
+
+	t.Run("should keep the datasource UID in object references", func(t *testing.T) {
+		panel := importDashboard(t, `{
+			"__inputs": [{"name": "DS_PROM", "type": "datasource", "pluginId": "prometheus"}],
+			"panels": [{"datasource": {"type": "prometheus", "uid": "${DS_PROM}"}}]
+		}`, "prom-uid")
+
+		require.Equal(t, "prom-uid", panel.GetPath("datasource", "uid").MustString())
+	})
+}
+
+func TestImportDashboardLibraryPanelsOnly(t *testing.T) {
+	var libraryPanelDash *models.Dashboard
+	var libraryPanelFolderID int64
+	s := &ImportDashboardService{
+		schemaMigrator:    migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{},
+		features:          featuremgmt.WithFeatures(),
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				t.Fatal("the dashboard must not be saved")
+				return nil, nil
+			},
+		},
+		libraryPanelService: &libraryPanelServiceMock{
+			importLibraryPanelsForDashboardFunc: func(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard, folderID int64) (*librarypanels.ImportLibraryPanelsResult, error) {
+				libraryPanelDash = dash
+				libraryPanelFolderID = folderID
+				return &librarypanels.ImportLibraryPanelsResult{Created: []string{"lib-uid"}, Existing: []string{"existing-uid"}}, nil
+			},
+			connectLibraryPanelsForDashboardFunc: func(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard) error {
+				t.Fatal("the library panels must not be connected")
+				return nil
+			},
+		},
+	}
+
+	req := &dashboardimport.ImportDashboardRequest{
+		DashboardBytes: []byte(`{
+			"uid": "dash-uid",
+			"title": "Shared panels",
+			"__inputs": [{"name": "DS_PROM", "type": "datasource", "pluginId": "prometheus"}],
+			"panels": [
+				{"id": 1, "datasource": "${DS_PROM}", "libraryPanel": {"uid": "lib-uid", "name": "Library panel"}},
+				{"id": 2, "libraryPanel": {"uid": "existing-uid", "name": "Existing panel"}}
+			]
+		}`),
+		Inputs: []dashboardimport.ImportDashboardInput{
+			{Name: "DS_PROM", Type: "datasource", Value: "prom"},
+		},
+		FolderId:                5,
+		ImportLibraryPanelsOnly: true,
+		User:                    &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+	}
+
+	resp, err := s.ImportDashboard(context.Background(), req)
+	require.NoError(t, err)
+	require.False(t, resp.Imported)
+	require.Equal(t, "dash-uid", resp.UID)
+	require.Equal(t, []string{"lib-uid"}, resp.ImportedLibraryPanels)
+	require.Equal(t, []string{"existing-uid"}, resp.ConnectedLibraryPanels)
+
+	require.NotNil(t, libraryPanelDash)
+	require.Equal(t, int64(5), libraryPanelFolderID)
+	require.Equal(t, int64(3), libraryPanelDash.OrgId)
+	require.Equal(t, "prom", libraryPanelDash.Data.Get("panels").GetIndex(0).Get("datasource").MustString())
+
+	t.Run("should not create the library panels in a dry run", func(t *testing.T) {
+		libraryPanelDash = nil
+		req.DryRun = true
+
+		resp, err := s.ImportDashboard(context.Background(), req)
+		require.NoError(t, err)
+		require.Empty(t, resp.ImportedLibraryPanels)
+		require.Nil(t, libraryPanelDash)
+	})
+}
+
+func TestImportDashboardDatasourceMappings(t *testing.T) {
+	s := &ImportDashboardService{
+		schemaMigrator: migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{
+			getDataSourceFunc: func(ctx context.Context, query *models.GetDataSourceQuery) error {
+				switch {
+				case query.Name == "Prometheus":
+					query.Result = &models.DataSource{Uid: "prom-uid", Name: "Prometheus"}
+					return nil
+				case query.Uid == "loki-uid":
+					query.Result = &models.DataSource{Uid: "loki-uid", Name: "Loki"}
+					return nil
+				}
+				return models.ErrDataSourceNotFound
+			},
+		},
+		features: featuremgmt.WithFeatures(),
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				return dto.Dashboard, nil
+			},
+		},
+		libraryPanelService: &libraryPanelServiceMock{},
+	}
+
+	req := &dashboardimport.ImportDashboardRequest{
+		DashboardBytes: []byte(`{
+			"__inputs": [
+				{"name": "DS_PROM", "type": "datasource", "pluginId": "prometheus"},
+				{"name": "DS_LOKI", "type": "datasource", "pluginId": "loki"},
+				{"name": "DS_TEMPO", "type": "datasource", "pluginId": "tempo"},
+				{"name": "DS_EXPR", "type": "datasource", "pluginId": "__expr__"},
+				{"name": "VAR_ENV", "type": "constant", "value": "prod"}
+			],
+			"panels": [{"datasource": "${DS_PROM}"}]
+		}`),
+		Inputs: []dashboardimport.ImportDashboardInput{
+			{Name: "DS_PROM", Type: "datasource", Value: "Prometheus"},
+			{Name: "DS_LOKI", Type: "datasource", Value: "loki-uid"},
+			{Name: "DS_TEMPO", Type: "datasource", Value: "Tempo"},
+		},
+		User: &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+	}
+
+	expected := map[string]string{
+		"DS_PROM":  "prom-uid",
+		"DS_LOKI":  "loki-uid",
+		"DS_TEMPO": "Tempo",
+	}
+
+	resp, err := s.ImportDashboard(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, expected, resp.DatasourceMappings)
+
+	req.DryRun = true
+	resp, err = s.ImportDashboard(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, expected, resp.DatasourceMappings)
+}
+
+func TestDefaultDatasourceResolver(t *testing.T) {
+	orgDatasources := []*models.DataSource{
+		{OrgId: 3, Uid: "loki-uid", Name: "Loki", Type: "loki"},
+		{OrgId: 3, Uid: "prom-uid", Name: "Prometheus", Type: "prometheus"},
+		{OrgId: 3, Uid: "mimir-uid", Name: "Mimir", Type: "prometheus", IsDefault: true},
+		{OrgId: 3, Uid: "Tempo", Name: "Tempo by UID", Type: "tempo"},
+		{OrgId: 3, Uid: "tempo-uid", Name: "Tempo", Type: "tempo"},
+	}
+
+	resolver := ProvideDatasourceResolver(&dataSourceServiceMock{
+		getDataSourceFunc: func(ctx context.Context, query *models.GetDataSourceQuery) error {
+			for _, ds := range orgDatasources {
+				if ds.OrgId == query.OrgId && ((query.Uid != "" && ds.Uid == query.Uid) || (query.Name != "" && ds.Name == query.Name)) {
+					query.Result = ds
+					return nil
+				}
+			}
+			return models.ErrDataSourceNotFound
+		},
+		getDataSourcesFunc: func(ctx context.Context, query *models.GetDataSourcesQuery) error {
+			query.Result = make([]*models.DataSource, 0)
+			for _, ds := range orgDatasources {
+				if ds.OrgId == query.OrgId {
+					query.Result = append(query.Result, ds)
+				}
+			}
+			return nil
+		},
+	})
+
+	resolve := func(t *testing.T, orgID int64, pluginID string, value string) *dashboardimport.DatasourceRef {
+		t.Helper()
+
+		ref, err := resolver.ResolveDatasource(context.Background(), orgID, dashboardimport.ImportDashboardInput{
+			Name:     "DS",
+			Type:     "datasource",
+			PluginId: pluginID,
+			Value:    value,
+		})
+		require.NoError(t, err)
+		return ref
+	}
+
+	t.Run("should match the datasource by name", func(t *testing.T) {
+		require.Equal(t, &dashboardimport.DatasourceRef{UID: "prom-uid", Type: "prometheus"}, resolve(t, 3, "prometheus", "Prometheus"))
+	})
+
+	t.Run("should match the name before the UID", func(t *testing.T) {
+		require.Equal(t, &dashboardimport.DatasourceRef{UID: "tempo-uid", Type: "tempo"}, resolve(t, 3, "tempo", "Tempo"))
+	})
+
+	t.Run("should match the datasource by UID", func(t *testing.T) {
+		require.Equal(t, &dashboardimport.DatasourceRef{UID: "loki-uid", Type: "loki"}, resolve(t, 3, "loki", "loki-uid"))
+	})
+
+	t.Run("should fall back to the default datasource of the type without a value", func(t *testing.T) {
+		require.Equal(t, &dashboardimport.DatasourceRef{UID: "mimir-uid", Type: "prometheus"}, resolve(t, 3, "prometheus", ""))
+	})
+
+	t.Run("should fall back to the first datasource of the type without a default", func(t *testing.T) {
+		require.Equal(t, &dashboardimport.DatasourceRef{UID: "Tempo", Type: "tempo"}, resolve(t, 3, "tempo", ""))
+	})
+
+	t.Run("should resolve no datasource", func(t *testing.T) {
+		require.Nil(t, resolve(t, 3, "prometheus", "missing"))
+		require.Nil(t, resolve(t, 3, "", "missing"))
+		require.Nil(t, resolve(t, 3, "graphite", ""))
+		require.Nil(t, resolve(t, 4, "prometheus", "Prometheus"))
+	})
+}
+
+func TestImportDashboardDatasourceResolver(t *testing.T) {
+	var importDashboardArg *dashboards.SaveDashboardDTO
+	resolved := make([]dashboardimport.ImportDashboardInput, 0)
+	s := &ImportDashboardService{
+		schemaMigrator:    migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{},
+		datasourceResolver: &datasourceResolverMock{
+			resolveDatasourceFunc: func(ctx context.Context, orgID int64, input dashboardimport.ImportDashboardInput) (*dashboardimport.DatasourceRef, error) {
+				require.Equal(t, int64(3), orgID)
+				resolved = append(resolved, input)
+				if input.Value == "missing" {
+					return nil, nil
+				}
+				return &dashboardimport.DatasourceRef{UID: "mimir-uid", Type: "mimir"}, nil
+			},
+		},
+		features: featuremgmt.WithFeatures(),
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				importDashboardArg = dto
+				return dto.Dashboard, nil
+			},
+		},
+		libraryPanelService: &libraryPanelServiceMock{},
+	}
+
+	req := &dashboardimport.ImportDashboardRequest{
+		DashboardBytes: []byte(`{
+			"__inputs": [
+				{"name": "DS_PROM", "type": "datasource", "pluginId": "prometheus"},
+				{"name": "DS_LOKI", "type": "datasource", "pluginId": "loki"}
+			],
+			"panels": [
+				{"datasource": {"type": "prometheus", "uid": "${DS_PROM}"}},
+				{"datasource": {"type": "loki", "uid": "${DS_LOKI}"}}
+			]
+		}`),
+		Inputs: []dashboardimport.ImportDashboardInput{
+			{Name: "DS_PROM", Type: "datasource", Value: "Prometheus"},
+			{Name: "DS_LOKI", Type: "datasource", Value: "missing"},
+		},
+		User: &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+	}
+
+	resp, err := s.ImportDashboard(context.Background(), req)
+	require.NoError(t, err)
+	require.Contains(t, resolved, dashboardimport.ImportDashboardInput{Name: "DS_PROM", Type: "datasource", PluginId: "prometheus", Value: "Prometheus"})
+	require.Equal(t, map[string]string{"DS_PROM": "mimir-uid", "DS_LOKI": "missing"}, resp.DatasourceMappings)
+	require.Equal(t, []string{"datasource missing not found"}, resp.Warnings)
+
+	panels := importDashboardArg.Dashboard.Data.Get("panels")
+	require.Equal(t, "mimir-uid", panels.GetIndex(0).GetPath("datasource", "uid").MustString())
+	require.Equal(t, "mimir", panels.GetIndex(0).GetPath("datasource", "type").MustString())
+	require.Equal(t, "missing", panels.GetIndex(1).GetPath("datasource", "uid").MustString())
+	require.Equal(t, "loki", panels.GetIndex(1).GetPath("datasource", "type").MustString())
+}
+
+func TestImportDashboardLibraryPanelDatasources(t *testing.T) {
+	var savedDash *models.Dashboard
+	var libraryPanelDash *models.Dashboard
+	s := &ImportDashboardService{
+		schemaMigrator: migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{
+			getDataSourceFunc: func(ctx context.Context, query *models.GetDataSourceQuery) error {
+				if query.Name == "Prometheus" {
+					query.Result = &models.DataSource{Uid: "prom-uid", Name: "Prometheus"}
+					return nil
+				}
+				return models.ErrDataSourceNotFound
+			},
+		},
+		features: featuremgmt.WithFeatures(),
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				savedDash = dto.Dashboard
+				return dto.Dashboard, nil
+			},
+		},
+		libraryPanelService: &libraryPanelServiceMock{
+			importLibraryPanelsForDashboardFunc: func(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard, folderID int64) (*librarypanels.ImportLibraryPanelsResult, error) {
+				libraryPanelDash = dash
+				return &librarypanels.ImportLibraryPanelsResult{Created: []string{"lib-uid"}}, nil
+			},
+		},
+	}
+
+	resp, err := s.ImportDashboard(context.Background(), &dashboardimport.ImportDashboardRequest{
+		DashboardBytes: []byte(`{
+			"__inputs": [{"name": "DS_PROM", "type": "datasource", "pluginId": "prometheus"}],
+			"__elements": [{
+				"uid": "lib-uid",
+				"name": "Library panel",
+				"kind": 1,
+				"model": {
+					"type": "graph",
+					"gridPos": {"x": 12, "y": 12, "w": 6, "h": 6},
+					"datasource": {"type": "prometheus", "uid": "${DS_PROM}"},
+					"targets": [{"expr": "up", "datasource": {"type": "prometheus", "uid": "${DS_PROM}"}}]
+				}
+			}],
+			"panels": [
+				{"id": 1, "gridPos": {"x": 0, "y": 0, "w": 12, "h": 8}, "libraryPanel": {"uid": "lib-uid", "name": "Library panel"}},
+				{"id": 2, "type": "row", "collapsed": true, "panels": [
+					{"id": 3, "gridPos": {"x": 0, "y": 9, "w": 12, "h": 8}, "libraryPanel": {"uid": "lib-uid", "name": "Library panel"}}
+				]}
+			]
+		}`),
+		Inputs: []dashboardimport.ImportDashboardInput{
+			{Name: "DS_PROM", Type: "datasource", Value: "Prometheus"},
+		},
+		User: &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"lib-uid"}, resp.ImportedLibraryPanels)
+	require.NotNil(t, libraryPanelDash)
+
+	for _, panel := range []*simplejson.Json{
+		libraryPanelDash.Data.Get("panels").GetIndex(0),
+		libraryPanelDash.Data.Get("panels").GetIndex(1).Get("panels").GetIndex(0),
+	} {
+		require.Equal(t, "graph", panel.Get("type").MustString())
+		require.Equal(t, "lib-uid", panel.GetPath("libraryPanel", "uid").MustString())
+		require.Equal(t, "prom-uid", panel.GetPath("datasource", "uid").MustString())
+		require.Equal(t, "prom-uid", panel.Get("targets").GetIndex(0).GetPath("datasource", "uid").MustString())
+		require.Equal(t, 12, panel.GetPath("gridPos", "w").MustInt())
+	}
+
+	// the saved dashboard keeps the library panel references only
+	savedPanel := savedDash.Data.Get("panels").GetIndex(0)
+	require.Equal(t, "lib-uid", savedPanel.GetPath("libraryPanel", "uid").MustString())
+	_, hasDatasource := savedPanel.CheckGet("datasource")
+	require.False(t, hasDatasource)
+}
+
+func TestImportDashboardLibraryPanelCycle(t *testing.T) {
+	importDashboardCalled := false
+	s := &ImportDashboardService{
+		schemaMigrator:    migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{},
+		features:          featuremgmt.WithFeatures(),
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				importDashboardCalled = true
+				return dto.Dashboard, nil
+			},
+		},
+		libraryPanelService: &libraryPanelServiceMock{},
+	}
+
+	importDashboard := func(t *testing.T, elements string) error {
+		t.Helper()
+
+		importDashboardCalled = false
+		_, err := s.ImportDashboard(context.Background(), &dashboardimport.ImportDashboardRequest{
+			DashboardBytes: []byte(`{
+				"__elements": ` + elements + `,
+				"panels": [{"id": 1, "libraryPanel": {"uid": "a", "name": "A"}}]
+			}`),
+			User: &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+		})
+		return err
+	}
+
+	t.Run("should reject a library panel using itself", func(t *testing.T) {
+		err := importDashboard(t, `[
+			{"uid": "a", "name": "A", "kind": 1, "model": {"type": "row", "panels": [{"libraryPanel": {"uid": "a", "name": "A"}}]}}
+		]`)
+		require.ErrorIs(t, err, dashboardimport.ErrLibraryPanelCycle)
+		require.False(t, importDashboardCalled)
+	})
+
+	t.Run("should reject library panels using each other", func(t *testing.T) {
+		err := importDashboard(t, `[
+			{"uid": "a", "name": "A", "kind": 1, "model": {"type": "row", "panels": [{"libraryPanel": {"uid": "b", "name": "B"}}]}},
+			{"uid": "b", "name": "B", "kind": 1, "model": {"type": "row", "panels": [
+				{"type": "row", "panels": [{"libraryPanel": {"uid": "a", "name": "A"}}]}
+			]}}
+		]`)
+		require.ErrorIs(t, err, dashboardimport.ErrLibraryPanelCycle)
+		require.False(t, importDashboardCalled)
+	})
+
+	t.Run("should import library panels naming themselves in their header", func(t *testing.T) {
+		err := importDashboard(t, `[
+			{"uid": "a", "name": "A", "kind": 1, "model": {"type": "row", "libraryPanel": {"uid": "a", "name": "A"}, "panels": [{"libraryPanel": {"uid": "b", "name": "B"}}]}},
+			{"uid": "b", "name": "B", "kind": 1, "model": {"type": "graph", "libraryPanel": {"uid": "b", "name": "B"}}}
+		]`)
+		require.NoError(t, err)
+		require.True(t, importDashboardCalled)
+	})
+}
+
+func TestImportDashboardsFromDir(t *testing.T) {
+	imported := make([]*dashboards.SaveDashboardDTO, 0)
+	s := &ImportDashboardService{
+		schemaMigrator:    migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{},
+		features:          featuremgmt.WithFeatures(),
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				imported = append(imported, dto)
+				return dto.Dashboard, nil
+			},
+		},
+		libraryPanelService: &libraryPanelServiceMock{},
+	}
+
+	resps, err := s.ImportDashboardsFromDir(context.Background(), filepath.Join("testdata", "dir"), &dashboardimport.ImportDashboardRequest{
+		Inputs: []dashboardimport.ImportDashboardInput{
+			{Name: "*", Type: "datasource", Value: "prom"},
+		},
+		FolderId: 5,
+		User:     &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+	})
+
+	var importErr dashboardimport.ImportDashboardsError
+	require.ErrorAs(t, err, &importErr)
+	require.Len(t, importErr.Errors, 1)
+	require.Contains(t, importErr.Errors[1].Error(), "invalid.json")
+
+	var parseErr dashboardimport.DashboardParseError
+	require.ErrorAs(t, importErr.Errors[1], &parseErr)
+	require.Equal(t, 4, parseErr.Line)
+
+	require.Len(t, resps, 3)
+	require.Equal(t, "First", resps[0].Title)
+	require.Equal(t, "first.json", resps[0].Path)
+	require.Nil(t, resps[1])
+	require.Equal(t, "Second", resps[2].Title)
+	require.Equal(t, filepath.Join("nested", "second.json"), resps[2].Path)
+
+	require.Len(t, imported, 2)
+	for _, dto := range imported {
+		require.Equal(t, int64(5), dto.Dashboard.FolderId)
+	}
+	require.Equal(t, "prom", imported[0].Dashboard.Data.Get("panels").GetIndex(0).Get("datasource").MustString())
+}
+
+func TestImportDashboardPreview(t *testing.T) {
+	existing, err := simplejson.NewJson([]byte(`{
+		"id": 12,
+		"uid": "existing",
+		"title": "Old title",
+		"version": 7,
+		"schemaVersion": 35,
+		"tags": ["team-a"],
+		"refresh": "1m",
+		"panels": [{"id": 1}, {"id": 2}]
+	}`))
+	require.NoError(t, err)
+
+	importDashboardCalled := false
+	s := &ImportDashboardService{
+		schemaMigrator:    migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{},
+		features:          featuremgmt.WithFeatures(),
+		dashboardStore: &dashboardStoreMock{
+			getDashboardFunc: func(ctx context.Context, query *models.GetDashboardQuery) error {
+				if query.OrgId != 3 || query.Uid != "existing" {
+					return models.ErrDashboardNotFound
+				}
+				query.Result = models.NewDashboardFromJson(existing)
+				return nil
+			},
+		},
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				importDashboardCalled = true
+				return dto.Dashboard, nil
+			},
+		},
+		libraryPanelService: &libraryPanelServiceMock{},
+	}
+	user := &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3}
+
+	t.Run("should compare the incoming dashboard with the existing one", func(t *testing.T) {
+		preview, err := s.ImportDashboardPreview(context.Background(), &dashboardimport.ImportDashboardRequest{
+			DashboardBytes: []byte(`{
+				"__inputs": [{"name": "VAR_TITLE", "type": "constant"}],
+				"id": 40,
+				"uid": "existing",
+				"title": "${VAR_TITLE}",
+				"version": 1,
+				"schemaVersion": 35,
+				"tags": ["team-a"],
+				"panels": [{"id": 1}, {"id": 2}, {"id": 3, "panels": [{"id": 4}]}]
+			}`),
+			Inputs: []dashboardimport.ImportDashboardInput{
+				{Name: "VAR_TITLE", Type: "constant", Value: "New title"},
+			},
+			User: user,
+		})
+		require.NoError(t, err)
+
+		require.True(t, preview.Exists)
+		require.Equal(t, "existing", preview.UID)
+		require.Equal(t, "New title", preview.Title)
+		require.Equal(t, 2, preview.ExistingPanelCount)
+		require.Equal(t, 4, preview.IncomingPanelCount)
+		require.Equal(t, 2, preview.PanelsAdded)
+		require.Equal(t, 0, preview.PanelsRemoved)
+		require.Equal(t, []dashboardimport.FieldChange{
+			{Field: "refresh", Existing: "1m", Incoming: nil},
+			{Field: "title", Existing: "Old title", Incoming: "New title"},
+		}, preview.ChangedFields)
+		require.False(t, importDashboardCalled)
+	})
+
+	t.Run("should report a new dashboard", func(t *testing.T) {
+		preview, err := s.ImportDashboardPreview(context.Background(), &dashboardimport.ImportDashboardRequest{
+			DashboardBytes: []byte(`{"uid": "new", "title": "New", "schemaVersion": 35, "panels": [{"id": 1}]}`),
+			User:           user,
+		})
+		require.NoError(t, err)
+
+		require.False(t, preview.Exists)
+		require.Equal(t, 1, preview.PanelsAdded)
+		require.Empty(t, preview.ChangedFields)
+		require.False(t, importDashboardCalled)
+	})
+}
+
+func TestImportDashboardOnTitleConflict(t *testing.T) {
+	var findQuery *search.FindPersistedDashboardsQuery
+	store := &dashboardStoreMock{
+		findDashboardsFunc: func(ctx context.Context, query *search.FindPersistedDashboardsQuery) ([]sqlstore.DashboardSearchProjection, error) {
+			findQuery = query
+			return []sqlstore.DashboardSearchProjection{
+				{Title: "Cluster"},
+				{Title: "Cluster (Imported 1)"},
+				{Title: "Cluster overview"},
+			}, nil
+		},
+	}
+
+	var importDashboardArg *dashboards.SaveDashboardDTO
+	s := &ImportDashboardService{
+		schemaMigrator:    migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{},
+		features:          featuremgmt.WithFeatures(),
+		dashboardStore:    store,
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				importDashboardArg = dto
+				return dto.Dashboard, nil
+			},
+		},
+		libraryPanelService: &libraryPanelServiceMock{},
+	}
+
+	newRequest := func(title string, mode dashboardimport.TitleConflictMode) *dashboardimport.ImportDashboardRequest {
+		findQuery = nil
+		importDashboardArg = nil
+		return &dashboardimport.ImportDashboardRequest{
+			DashboardBytes:  []byte(`{"id": 8, "uid": "cluster", "title": "` + title + `", "schemaVersion": 27}`),
+			FolderId:        5,
+			OnTitleConflict: mode,
+			User:            &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+		}
+	}
+
+	t.Run("should fail when the folder has the title", func(t *testing.T) {
+		_, err := s.ImportDashboard(context.Background(), newRequest("Cluster", dashboardimport.TitleConflictFail))
+		require.ErrorIs(t, err, models.ErrDashboardWithSameNameInFolderExists)
+		require.Nil(t, importDashboardArg)
+
+		require.NotNil(t, findQuery)
+		require.Equal(t, "Cluster", findQuery.Title)
+		require.Equal(t, []int64{5}, findQuery.FolderIds)
+	})
+
+	t.Run("should import when the folder does not have the title", func(t *testing.T) {
+		resp, err := s.ImportDashboard(context.Background(), newRequest("Nodes", dashboardimport.TitleConflictFail))
+		require.NoError(t, err)
+		require.Equal(t, "Nodes", resp.Title)
+		require.Equal(t, "cluster", resp.UID)
+		require.False(t, importDashboardArg.Overwrite)
+	})
+
+	t.Run("should suffix the title and regenerate the UID", func(t *testing.T) {
+		resp, err := s.ImportDashboard(context.Background(), newRequest("Cluster", dashboardimport.TitleConflictSuffix))
+		require.NoError(t, err)
+		require.Equal(t, "Cluster (Imported 2)", resp.Title)
+		require.Empty(t, resp.UID)
+
+		require.NotNil(t, importDashboardArg)
+		require.Equal(t, int64(0), importDashboardArg.Dashboard.Id)
+		require.False(t, importDashboardArg.Overwrite)
+	})
+
+	t.Run("should overwrite the existing dashboard", func(t *testing.T) {
+		resp, err := s.ImportDashboard(context.Background(), newRequest("Cluster", dashboardimport.TitleConflictOverwrite))
+		require.NoError(t, err)
+		require.Equal(t, "Cluster", resp.Title)
+		require.Equal(t, "cluster", resp.UID)
+		require.True(t, importDashboardArg.Overwrite)
+		require.Nil(t, findQuery)
+	})
+
+	t.Run("should reject an unknown mode", func(t *testing.T) {
+		_, err := s.ImportDashboard(context.Background(), newRequest("Cluster", "rename"))
+		require.ErrorIs(t, err, dashboardimport.ErrInvalidTitleConflictMode)
+	})
+}
+
+func TestImportDashboardPreservePanelIDs(t *testing.T) {
+	existing, err := simplejson.NewJson([]byte(`{
+		"id": 12,
+		"uid": "existing",
+		"title": "Cluster",
+		"schemaVersion": 35,
+		"panels": [
+			{"id": 1, "title": "CPU", "gridPos": {"x": 0, "y": 0, "w": 12, "h": 8}},
+			{"id": 2, "title": "Memory", "gridPos": {"x": 12, "y": 0, "w": 12, "h": 8}},
+			{"id": 5, "title": "Disk", "gridPos": {"x": 0, "y": 8, "w": 12, "h": 8}},
+			{"id": 9, "title": "Removed", "gridPos": {"x": 12, "y": 16, "w": 12, "h": 8}}
+		]
+	}`))
+	require.NoError(t, err)
+
+	var importDashboardArg *dashboards.SaveDashboardDTO
+	s := &ImportDashboardService{
+		schemaMigrator:    migration.ProvideService(nil, nil),
+		dataSourceService: &dataSourceServiceMock{},
+		features:          featuremgmt.WithFeatures(),
+		dashboardStore: &dashboardStoreMock{
+			getDashboardFunc: func(ctx context.Context, query *models.GetDashboardQuery) error {
+				if query.OrgId != 3 || query.Uid != "existing" {
+					return models.ErrDashboardNotFound
+				}
+				query.Result = models.NewDashboardFromJson(existing)
+				return nil
+			},
+		},
+		dashboardService: &dashboardServiceMock{
+			importDashboardFunc: func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+				importDashboardArg = dto
+				return dto.Dashboard, nil
+			},
+		},
+		libraryPanelService: &libraryPanelServiceMock{},
+	}
+
+	importPanels := func(t *testing.T, uid string, preserve bool, overwrite bool) map[string]int64 {
+		t.Helper()
+		importDashboardArg = nil
+		_, err := s.ImportDashboard(context.Background(), &dashboardimport.ImportDashboardRequest{
+			DashboardBytes: []byte(`{
+				"uid": "` + uid + `",
+				"title": "Cluster",
+				"schemaVersion": 35,
+				"panels": [
+					{"id": 1, "title": "Memory", "gridPos": {"x": 0, "y": 0, "w": 12, "h": 8}},
+					{"id": 2, "title": "CPU", "gridPos": {"x": 12, "y": 0, "w": 12, "h": 8}},
+					{"id": 3, "title": "Disk usage", "gridPos": {"x": 0, "y": 8, "w": 12, "h": 8}},
+					{"id": 4, "title": "Network", "type": "row", "gridPos": {"x": 0, "y": 16, "w": 24, "h": 1}, "collapsed": true,
+						"panels": [{"id": 9, "title": "Traffic", "gridPos": {"x": 0, "y": 17, "w": 12, "h": 8}}]}
+				]
+			}`),
+			Overwrite:        overwrite,
+			PreservePanelIDs: preserve,
+			User:             &models.SignedInUser{UserId: 2, OrgRole: models.ROLE_ADMIN, OrgId: 3},
+		})
+		require.NoError(t, err)
+		require.NotNil(t, importDashboardArg)
+
+		ids := make(map[string]int64)
+		for _, panel := range flattenPanels(importDashboardArg.Dashboard.Data) {
+			ids[panel.Get("title").MustString()] = panel.Get("id").MustInt64()
+		}
+		return ids
+	}
+
+	t.Run("should keep the IDs of the overwritten panels", func(t *testing.T) {
+		ids := importPanels(t, "existing", true, true)
+		require.Equal(t, map[string]int64{
+			"Memory":     2,
+			"CPU":        1,
+			"Disk usage": 5,
+			"Network":    4,
+			"Traffic":    10,
+		}, ids)
+	})
+
+	t.Run("should keep the incoming IDs by default", func(t *testing.T) {
+		ids := importPanels(t, "existing", false, true)
+		require.Equal(t, int64(1), ids["Memory"])
+		require.Equal(t, int64(2), ids["CPU"])
+		require.Equal(t, int64(9), ids["Traffic"])
+	})
+
+	t.Run("should keep the incoming IDs of a new dashboard", func(t *testing.T) {
+		ids := importPanels(t, "new", true, true)
+		require.Equal(t, int64(1), ids["Memory"])
+		require.Equal(t, int64(9), ids["Traffic"])
+	})
+
+	t.Run("should keep the incoming IDs without overwrite", func(t *testing.T) {
+		ids := importPanels(t, "existing", true, false)
+		require.Equal(t, int64(1), ids["Memory"])
+		require.Equal(t, int64(9), ids["Traffic"])
+	})
+}
+
+func loadTestDashboard(ctx context.Context, pluginID, path string) (*models.Dashboard, error) {
+	// It's safe to ignore gosec warning G304 since this is a test and arguments comes from test configuration.
+	// nolint:gosec
+	bytes, err := ioutil.ReadFile(filepath.Join("testdata", path))
+	if err != nil {
+		return nil, err
+	}
+
+	dashboardJSON, err := simplejson.NewJson(bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return models.NewDashboardFromJson(dashboardJSON), nil
+}
+
+type pluginDashboardManagerMock struct {
+	plugins.PluginDashboardManager
+	loadPluginDashboardFunc func(ctx context.Context, pluginID, path string) (*models.Dashboard, error)
+}
+
+func (m *pluginDashboardManagerMock) LoadPluginDashboard(ctx context.Context, pluginID, path string) (*models.Dashboard, error) {
+	if m.loadPluginDashboardFunc != nil {
+		return m.loadPluginDashboardFunc(ctx, pluginID, path)
+	}
+
+	return nil, nil
+}
+
+type dashboardServiceMock struct {
+	dashboards.DashboardService
+	importDashboardFunc           func(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error)
+	buildSaveDashboardCommandFunc func(ctx context.Context, dto *dashboards.SaveDashboardDTO, shouldValidateAlerts bool, validateProvisionedDashboard bool) (*models.SaveDashboardCommand, error)
+}
+
+func (s *dashboardServiceMock) BuildSaveDashboardCommand(ctx context.Context, dto *dashboards.SaveDashboardDTO, shouldValidateAlerts bool, validateProvisionedDashboard bool) (*models.SaveDashboardCommand, error) {
+	if s.buildSaveDashboardCommandFunc != nil {
+		return s.buildSaveDashboardCommandFunc(ctx, dto, shouldValidateAlerts, validateProvisionedDashboard)
+	}
+
+	return &models.SaveDashboardCommand{}, nil
+}
+
+func (s *dashboardServiceMock) ImportDashboard(ctx context.Context, dto *dashboards.SaveDashboardDTO) (*models.Dashboard, error) {
+	if s.importDashboardFunc != nil {
+		return s.importDashboardFunc(ctx, dto)
+	}
+
+	return nil, nil
+}
+
+type folderServiceMock struct {
+	dashboards.FolderService
+	getFolderByUIDFunc   func(ctx context.Context, user *models.SignedInUser, orgID int64, uid string) (*models.Folder, error)
+	getFolderByTitleFunc func(ctx context.Context, user *models.SignedInUser, orgID int64, title string) (*models.Folder, error)
+	createFolderFunc     func(ctx context.Context, user *models.SignedInUser, orgID int64, title, uid string) (*models.Folder, error)
+	createFolderCalled   bool
+}
+
+func (s *folderServiceMock) GetFolderByUID(ctx context.Context, user *models.SignedInUser, orgID int64, uid string) (*models.Folder, error) {
+	if s.getFolderByUIDFunc != nil {
+		return s.getFolderByUIDFunc(ctx, user, orgID, uid)
+	}
+
+	return nil, models.ErrFolderNotFound
+}
+
+func (s *folderServiceMock) GetFolderByTitle(ctx context.Context, user *models.SignedInUser, orgID int64, title string) (*models.Folder, error) {
+	if s.getFolderByTitleFunc != nil {
+		return s.getFolderByTitleFunc(ctx, user, orgID, title)
+	}
+
+	return nil, models.ErrFolderNotFound
+}
+
+func (s *folderServiceMock) CreateFolder(ctx context.Context, user *models.SignedInUser, orgID int64, title, uid string) (*models.Folder, error) {
+	s.createFolderCalled = true
+	if s.createFolderFunc != nil {
+		return s.createFolderFunc(ctx, user, orgID, title, uid)
+	}
+
+	return &models.Folder{Title: title, Uid: uid}, nil
+}
+
+type dashboardStoreMock struct {
+	getDashboardFunc   func(ctx context.Context, query *models.GetDashboardQuery) error
+	findDashboardsFunc func(ctx context.Context, query *search.FindPersistedDashboardsQuery) ([]sqlstore.DashboardSearchProjection, error)
+}
+
+func (s *dashboardStoreMock) GetDashboard(ctx context.Context, query *models.GetDashboardQuery) error {
+	if s.getDashboardFunc != nil {
+		return s.getDashboardFunc(ctx, query)
+	}
+
+	return models.ErrDashboardNotFound
+}
+
+func (s *dashboardStoreMock) FindDashboards(ctx context.Context, query *search.FindPersistedDashboardsQuery) ([]sqlstore.DashboardSearchProjection, error) {
+	if s.findDashboardsFunc != nil {
+		return s.findDashboardsFunc(ctx, query)
+	}
+
+	return nil, nil
+}
+
+type dataSourceServiceMock struct {
+	datasources.DataSourceService
+	getDataSourceFunc  func(ctx context.Context, query *models.GetDataSourceQuery) error
+	getDataSourcesFunc func(ctx context.Context, query *models.GetDataSourcesQuery) error
+}
+
+func (s *dataSourceServiceMock) GetDataSource(ctx context.Context, query *models.GetDataSourceQuery) error {
+	if s.getDataSourceFunc != nil {
+		return s.getDataSourceFunc(ctx, query)
+	}
+
+	return nil
+}
+
+func (s *dataSourceServiceMock) GetDataSources(ctx context.Context, query *models.GetDataSourcesQuery) error {
+	if s.getDataSourcesFunc != nil {
+		return s.getDataSourcesFunc(ctx, query)
+	}
+
+	return nil
+}
+
+type datasourceResolverMock struct {
+	resolveDatasourceFunc func(ctx context.Context, orgID int64, input dashboardimport.ImportDashboardInput) (*dashboardimport.DatasourceRef, error)
+}
+
+func (r *datasourceResolverMock) ResolveDatasource(ctx context.Context, orgID int64, input dashboardimport.ImportDashboardInput) (*dashboardimport.DatasourceRef, error) {
+	if r.resolveDatasourceFunc != nil {
+		return r.resolveDatasourceFunc(ctx, orgID, input)
+	}
+
+	return nil, nil
+}
+
+type libraryPanelServiceMock struct {
+	librarypanels.Service
+	connectLibraryPanelsForDashboardFunc func(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard) error
+	importLibraryPanelsForDashboardFunc  func(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard, folderID int64) (*librarypanels.ImportLibraryPanelsResult, error)
+}
+
+func (s *libraryPanelServiceMock) ConnectLibraryPanelsForDashboard(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard) error {
+	if s.connectLibraryPanelsForDashboardFunc != nil {
+		return s.connectLibraryPanelsForDashboardFunc(ctx, signedInUser, dash)
+	}
+
+	return nil
+}
+
+func (s *libraryPanelServiceMock) ImportLibraryPanelsForDashboard(ctx context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard, folderID int64) (*librarypanels.ImportLibraryPanelsResult, error) {
+	if s.importLibraryPanelsForDashboardFunc != nil {
+		return s.importLibraryPanelsForDashboardFunc(ctx, signedInUser, dash, folderID)
+	}
+
+	return &librarypanels.ImportLibraryPanelsResult{}, nil
 }
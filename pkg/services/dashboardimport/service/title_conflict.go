@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/dashboardimport"
+	"github.com/grafana/grafana/pkg/services/search"
+)
+
+// titleConflictProbeLimit bounds the dashboards probed for the titles already taken in the folder.
+const titleConflictProbeLimit = 1000
+
+// resolveTitleConflict applies the OnTitleConflict mode of the request to the generated dashboard. It returns
+// whether the dashboard is saved with overwrite.
+func (s *ImportDashboardService) resolveTitleConflict(ctx context.Context, req *dashboardimport.ImportDashboardRequest, folderID int64, dash *simplejson.Json) (bool, error) {
+	switch req.OnTitleConflict {
+	case "":
+		return req.Overwrite, nil
+	case dashboardimport.TitleConflictOverwrite:
+		return true, nil
+	case dashboardimport.TitleConflictFail, dashboardimport.TitleConflictSuffix:
+	default:
+		return false, fmt.Errorf("%w: %q", dashboardimport.ErrInvalidTitleConflictMode, req.OnTitleConflict)
+	}
+
+	title := dash.Get("title").MustString()
+	taken, err := s.findTakenSlugs(ctx, req.User, folderID, title)
+	if err != nil {
+		return false, err
+	}
+
+	if !taken[models.SlugifyTitle(title)] {
+		return req.Overwrite, nil
+	}
+
+	if req.OnTitleConflict == dashboardimport.TitleConflictFail {
+		return false, models.ErrDashboardWithSameNameInFolderExists
+	}
+
+	for n := 1; ; n++ {
+		suffixed := fmt.Sprintf("%s (Imported %d)", title, n)
+		if !taken[models.SlugifyTitle(suffixed)] {
+			dash.Set("title", suffixed)
+			break
+		}
+	}
+
+	// the renamed dashboard is a new dashboard, it must not replace the existing one by its ID or UID
+	dash.Del("id")
+	dash.Del("uid")
+	return false, nil
+}
+
+// findTakenSlugs returns the slugs of the dashboards in the folder whose title contains the title. Titles
+// conflict by their slug, like when the dashboard is saved.
+func (s *ImportDashboardService) findTakenSlugs(ctx context.Context, user *models.SignedInUser, folderID int64, title string) (map[string]bool, error) {
+	hits, err := s.dashboardStore.FindDashboards(ctx, &search.FindPersistedDashboardsQuery{
+		Title:        title,
+		OrgId:        user.OrgId,
+		SignedInUser: user,
+		Type:         string(search.DashHitDB),
+		FolderIds:    []int64{folderID},
+		Limit:        titleConflictProbeLimit,
+		Permission:   models.PERMISSION_VIEW,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	taken := make(map[string]bool, len(hits))
+	for _, hit := range hits {
+		taken[models.SlugifyTitle(hit.Title)] = true
+	}
+	return taken, nil
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/dashboardimport"
+)
+
+// defaultMaxURLDashboardSize is the size limit of the dashboards downloaded from a URL.
+const defaultMaxURLDashboardSize = 10 * 1024 * 1024
+
+// urlClient downloads dashboards from HTTP(S) URLs. The hosts can be restricted to an allowlist, which also applies
+// to the redirects followed while downloading.
+type urlClient struct {
+	httpClient   *http.Client
+	allowedHosts map[string]bool
+	maxSize      int64
+	log          log.Logger
+}
+
+func newURLClient(allowedHosts []string) *urlClient {
+	c := &urlClient{
+		allowedHosts: make(map[string]bool, len(allowedHosts)),
+		maxSize:      defaultMaxURLDashboardSize,
+		log:          log.New("dashboardimport.url"),
+	}
+	for _, host := range allowedHosts {
+		c.allowedHosts[strings.ToLower(host)] = true
+	}
+
+	c.httpClient = &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after %d redirects", len(via))
+			}
+			return c.checkURL(req.URL)
+		},
+	}
+	return c
+}
+
+// checkURL returns ErrDashboardURLNotAllowed unless the URL is an HTTP(S) URL to an allowed host. Any host is
+// allowed when the allowlist is empty.
+func (c *urlClient) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q is not http or https", dashboardimport.ErrDashboardURLNotAllowed, u.Scheme)
+	}
+
+	if u.Hostname() == "" {
+		return fmt.Errorf("%w: %s has no host", dashboardimport.ErrDashboardURLNotAllowed, u.Redacted())
+	}
+
+	if len(c.allowedHosts) > 0 && !c.allowedHosts[strings.ToLower(u.Hostname())] {
+		return fmt.Errorf("%w: host %s is not allowed", dashboardimport.ErrDashboardURLNotAllowed, u.Hostname())
+	}
+	return nil
+}
+
+func (c *urlClient) getDashboard(ctx context.Context, rawURL string) (*simplejson.Json, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", dashboardimport.ErrDashboardURLNotAllowed, err)
+	}
+
+	if err := c.checkURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			c.log.Warn("Failed to close response body", "err", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, dashboardimport.DashboardURLError{URL: u.Redacted(), StatusCode: resp.StatusCode}
+	}
+
+	// one more byte than allowed is read to tell a dashboard of exactly the limit from a larger one
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, c.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) > c.maxSize {
+		return nil, fmt.Errorf("%w: %s is larger than %d bytes", dashboardimport.ErrDashboardTooLarge, u.Redacted(), c.maxSize)
+	}
+
+	return parseDashboardBytes(body)
+}
@@ -2,7 +2,6 @@ package utils
 
 import (
 	"encoding/json"
-	"fmt"
 	"regexp"
 
 	"github.com/grafana/grafana/pkg/components/simplejson"
@@ -12,31 +11,41 @@ import (
 
 var varRegex = regexp.MustCompile(`(\$\{.+?\})`)
 
-type DashboardInputMissingError struct {
-	VariableName string
-}
-
-func (e DashboardInputMissingError) Error() string {
-	return fmt.Sprintf("Dashboard input variable: %v missing from import command", e.VariableName)
-}
+// DatasourceResolver returns the datasource the datasource input refers to, or nil if there is none. The plugin ID
+// of the input is the one the input is declared with in the template.
+type DatasourceResolver func(input dashboardimport.ImportDashboardInput) (*dashboardimport.DatasourceRef, error)
 
 type DashTemplateEvaluator struct {
 	template  *simplejson.Json
 	inputs    []dashboardimport.ImportDashboardInput
 	variables map[string]string
 	result    *simplejson.Json
+
+	// resolveDatasource resolves the datasource inputs used as UID of object datasource references,
+	// the input values are used as they are if it is nil
+	resolveDatasource   DatasourceResolver
+	datasourceVariables map[string]dashboardimport.ImportDashboardInput
+	resolvedRefs        map[string]*dashboardimport.DatasourceRef
+	err                 error
 }
 
-func NewDashTemplateEvaluator(template *simplejson.Json, inputs []dashboardimport.ImportDashboardInput) *DashTemplateEvaluator {
+func NewDashTemplateEvaluator(template *simplejson.Json, inputs []dashboardimport.ImportDashboardInput, resolveDatasource DatasourceResolver) *DashTemplateEvaluator {
 	return &DashTemplateEvaluator{
-		template: template,
-		inputs:   inputs,
+		template:          template,
+		inputs:            inputs,
+		resolveDatasource: resolveDatasource,
 	}
 }
 
-func (e *DashTemplateEvaluator) findInput(varName string, varType string) *dashboardimport.ImportDashboardInput {
+// findInput returns the first input of the type with the name, or the first wildcard input of the type scoped
+// to the plugin ID or not scoped at all.
+func (e *DashTemplateEvaluator) findInput(varName string, varType string, pluginID string) *dashboardimport.ImportDashboardInput {
 	for _, input := range e.inputs {
-		if varType == input.Type && (input.Name == varName || input.Name == "*") {
+		if varType != input.Type {
+			continue
+		}
+
+		if input.Name == varName || (input.Name == "*" && matchesPluginID(input, pluginID)) {
 			return &input
 		}
 	}
@@ -44,16 +53,24 @@ func (e *DashTemplateEvaluator) findInput(varName string, varType string) *dashb
 	return nil
 }
 
+func matchesPluginID(input dashboardimport.ImportDashboardInput, pluginID string) bool {
+	return input.PluginId == "" || pluginID == "" || input.PluginId == pluginID
+}
+
 func (e *DashTemplateEvaluator) Eval() (*simplejson.Json, error) {
 	e.result = simplejson.New()
 	e.variables = make(map[string]string)
+	e.datasourceVariables = make(map[string]dashboardimport.ImportDashboardInput)
+	e.resolvedRefs = make(map[string]*dashboardimport.DatasourceRef)
+	e.err = nil
 
 	// check that we have all inputs we need
+	missingInputs := make([]dashboardimport.ImportDashboardInput, 0)
 	for _, inputDef := range e.template.Get("__inputs").MustArray() {
 		inputDefJson := simplejson.NewFromAny(inputDef)
 		inputName := inputDefJson.Get("name").MustString()
 		inputType := inputDefJson.Get("type").MustString()
-		input := e.findInput(inputName, inputType)
+		input := e.findInput(inputName, inputType, inputDefJson.Get("pluginId").MustString())
 
 		// force expressions value to `__expr__`
 		if inputDefJson.Get("pluginId").MustString() == expr.DatasourceType {
@@ -62,14 +79,55 @@ func (e *DashTemplateEvaluator) Eval() (*simplejson.Json, error) {
 			}
 		}
 
+		// constant and textbox inputs fall back to the value they were exported with
+		if input == nil && inputType != "datasource" {
+			if defaultValue, ok := inputDefJson.CheckGet("value"); ok {
+				input = &dashboardimport.ImportDashboardInput{
+					Value: defaultValue.MustString(),
+				}
+			}
+		}
+
 		if input == nil {
-			return nil, &DashboardInputMissingError{VariableName: inputName}
+			missingInputs = append(missingInputs, dashboardimport.ImportDashboardInput{
+				Name:     inputName,
+				Type:     inputType,
+				PluginId: inputDefJson.Get("pluginId").MustString(),
+				Label:    inputDefJson.Get("label").MustString(),
+			})
+			continue
 		}
 
 		e.variables["${"+inputName+"}"] = input.Value
+		if inputType == "datasource" && input.Value != expr.DatasourceType {
+			e.datasourceVariables["${"+inputName+"}"] = dashboardimport.ImportDashboardInput{
+				Type:     inputType,
+				PluginId: inputDefJson.Get("pluginId").MustString(),
+				Name:     inputName,
+				Value:    input.Value,
+			}
+		}
 	}
 
-	return simplejson.NewFromAny(e.evalObject(e.template)), nil
+	if len(missingInputs) > 0 {
+		return nil, dashboardimport.MissingInputsError{MissingInputs: missingInputs}
+	}
+
+	result := e.evalObject(e.template)
+	if e.err != nil {
+		return nil, e.err
+	}
+	return simplejson.NewFromAny(result), nil
+}
+
+// DatasourceInputs returns the datasource inputs applied by the last Eval by input name, with the plugin ID they are
+// declared with in the template. Expression inputs are not datasources and are left out.
+func (e *DashTemplateEvaluator) DatasourceInputs() map[string]dashboardimport.ImportDashboardInput {
+	inputs := make(map[string]dashboardimport.ImportDashboardInput, len(e.datasourceVariables))
+	for _, input := range e.datasourceVariables {
+		inputs[input.Name] = input
+	}
+	return inputs
 }
 
 func (e *DashTemplateEvaluator) evalValue(source *simplejson.Json) interface{} {
@@ -109,8 +167,52 @@ func (e *DashTemplateEvaluator) evalObject(source *simplejson.Json) interface{}
 		if key == "__inputs" {
 			continue
 		}
+
+		if ref, ok := value.(map[string]interface{}); ok && key == "datasource" {
+			result[key] = e.evalDatasourceRef(ref)
+			continue
+		}
 		result[key] = e.evalValue(simplejson.NewFromAny(value))
 	}
 
 	return result
 }
+
+// evalDatasourceRef evaluates a datasource reference of the form {"type": ..., "uid": ...}. A datasource input
+// used as UID is replaced by the datasource it resolves to, as the input value might be the datasource name.
+// Inputs resolving to no datasource keep their value.
+func (e *DashTemplateEvaluator) evalDatasourceRef(ref map[string]interface{}) interface{} {
+	result := e.evalObject(simplejson.NewFromAny(ref)).(map[string]interface{})
+
+	uid, ok := ref["uid"].(string)
+	if !ok || e.resolveDatasource == nil {
+		return result
+	}
+
+	input, ok := e.datasourceVariables[uid]
+	if !ok {
+		return result
+	}
+
+	resolved, ok := e.resolvedRefs[uid]
+	if !ok {
+		var err error
+		if resolved, err = e.resolveDatasource(input); err != nil {
+			if e.err == nil {
+				e.err = err
+			}
+			return result
+		}
+		e.resolvedRefs[uid] = resolved
+	}
+
+	if resolved == nil {
+		return result
+	}
+
+	result["uid"] = resolved.UID
+	if resolved.Type != "" {
+		result["type"] = resolved.Type
+	}
+	return result
+}
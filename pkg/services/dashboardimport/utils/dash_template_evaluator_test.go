@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/grafana/grafana/pkg/components/simplejson"
@@ -37,3 +38,260 @@ func TestDashTemplateEvaluator(t *testing.T) {
 	inputs := res.Get("__inputs")
 	require.Nil(t, inputs.Interface())
 }
+
+func TestDashTemplateEvaluatorMissingInputs(t *testing.T) {
+	template, err := simplejson.NewJson([]byte(`{
+		"__inputs": [
+			{
+				"name": "DS_PROMETHEUS",
+				"label": "Prometheus",
+				"type": "datasource",
+				"pluginId": "prometheus"
+			},
+			{
+				"name": "DS_LOKI",
+				"label": "Loki",
+				"type": "datasource",
+				"pluginId": "loki"
+			},
+			{
+				"name": "VAR_PREFIX",
+				"type": "constant"
+			}
+		],
+		"test": {
+			"prop": "${DS_PROMETHEUS}_${DS_LOKI}"
+		}
+	}`))
+	require.NoError(t, err)
+
+	evaluator := &DashTemplateEvaluator{
+		template: template,
+		inputs: []dashboardimport.ImportDashboardInput{
+			{Name: "VAR_PREFIX", Type: "constant", Value: "prefix"},
+		},
+	}
+
+	_, err = evaluator.Eval()
+
+	var missingErr dashboardimport.MissingInputsError
+	require.ErrorAs(t, err, &missingErr)
+	require.Equal(t, []dashboardimport.ImportDashboardInput{
+		{Name: "DS_PROMETHEUS", Type: "datasource", PluginId: "prometheus", Label: "Prometheus"},
+		{Name: "DS_LOKI", Type: "datasource", PluginId: "loki", Label: "Loki"},
+	}, missingErr.MissingInputs)
+}
+
+func TestDashTemplateEvaluatorMixedInputs(t *testing.T) {
+	template, err := simplejson.NewJson([]byte(`{
+		"__inputs": [
+			{
+				"name": "DS_PROM",
+				"type": "datasource",
+				"pluginId": "prometheus"
+			},
+			{
+				"name": "VAR_PREFIX",
+				"type": "constant",
+				"value": "default-prefix"
+			},
+			{
+				"name": "VAR_ENV",
+				"type": "constant",
+				"value": "dev"
+			}
+		],
+		"panels": [
+			{
+				"title": "${VAR_PREFIX} requests (${VAR_ENV})",
+				"datasource": "${DS_PROM}",
+				"targets": [
+					{ "expr": "${VAR_PREFIX}_requests_total{env=\"${VAR_ENV}\"}" }
+				]
+			}
+		],
+		"templating": {
+			"list": [
+				{ "name": "prefix", "type": "constant", "query": "${VAR_PREFIX}" }
+			]
+		}
+	}`))
+	require.NoError(t, err)
+
+	evaluator := &DashTemplateEvaluator{
+		template: template,
+		inputs: []dashboardimport.ImportDashboardInput{
+			{Name: "DS_PROM", Type: "constant", Value: "not-a-datasource"},
+			{Name: "*", Type: "datasource", Value: "my-prom"},
+			{Name: "VAR_ENV", Type: "constant", Value: "prod"},
+		},
+	}
+
+	res, err := evaluator.Eval()
+	require.NoError(t, err)
+
+	panel := res.Get("panels").GetIndex(0)
+	require.Equal(t, "my-prom", panel.Get("datasource").MustString())
+	require.Equal(t, "default-prefix requests (prod)", panel.Get("title").MustString())
+	require.Equal(t, `default-prefix_requests_total{env="prod"}`, panel.Get("targets").GetIndex(0).Get("expr").MustString())
+	require.Equal(t, "default-prefix", res.Get("templating").Get("list").GetIndex(0).Get("query").MustString())
+}
+
+func TestDashTemplateEvaluatorScopedWildcardInputs(t *testing.T) {
+	template, err := simplejson.NewJson([]byte(`{
+		"__inputs": [
+			{
+				"name": "DS_PROMETHEUS",
+				"type": "datasource",
+				"pluginId": "prometheus"
+			},
+			{
+				"name": "DS_LOKI",
+				"type": "datasource",
+				"pluginId": "loki"
+			}
+		],
+		"panels": [
+			{
+				"title": "Requests",
+				"datasource": {"type": "prometheus", "uid": "${DS_PROMETHEUS}"},
+				"targets": [{"datasource": {"type": "prometheus", "uid": "${DS_PROMETHEUS}"}}]
+			},
+			{
+				"title": "Logs",
+				"datasource": {"type": "loki", "uid": "${DS_LOKI}"},
+				"targets": [{"datasource": {"type": "loki", "uid": "${DS_LOKI}"}}]
+			}
+		]
+	}`))
+	require.NoError(t, err)
+
+	eval := func(t *testing.T, inputs ...dashboardimport.ImportDashboardInput) (*simplejson.Json, error) {
+		t.Helper()
+
+		evaluator := NewDashTemplateEvaluator(template, inputs, nil)
+		return evaluator.Eval()
+	}
+
+	t.Run("should rewrite the references of the type of each wildcard input", func(t *testing.T) {
+		res, err := eval(t,
+			dashboardimport.ImportDashboardInput{Name: "*", Type: "datasource", PluginId: "loki", Value: "loki-uid"},
+			dashboardimport.ImportDashboardInput{Name: "*", Type: "datasource", PluginId: "prometheus", Value: "prom-uid"},
+		)
+		require.NoError(t, err)
+
+		prometheus := res.Get("panels").GetIndex(0)
+		require.Equal(t, "prom-uid", prometheus.GetPath("datasource", "uid").MustString())
+		require.Equal(t, "prom-uid", prometheus.Get("targets").GetIndex(0).GetPath("datasource", "uid").MustString())
+
+		loki := res.Get("panels").GetIndex(1)
+		require.Equal(t, "loki-uid", loki.GetPath("datasource", "uid").MustString())
+		require.Equal(t, "loki-uid", loki.Get("targets").GetIndex(0).GetPath("datasource", "uid").MustString())
+	})
+
+	t.Run("should report the inputs of other types as missing", func(t *testing.T) {
+		_, err := eval(t, dashboardimport.ImportDashboardInput{Name: "*", Type: "datasource", PluginId: "prometheus", Value: "prom-uid"})
+
+		var missingErr dashboardimport.MissingInputsError
+		require.ErrorAs(t, err, &missingErr)
+		require.Equal(t, []dashboardimport.ImportDashboardInput{
+			{Name: "DS_LOKI", Type: "datasource", PluginId: "loki"},
+		}, missingErr.MissingInputs)
+	})
+
+	t.Run("should rewrite the references of all types with an unscoped wildcard input", func(t *testing.T) {
+		res, err := eval(t, dashboardimport.ImportDashboardInput{Name: "*", Type: "datasource", Value: "any-uid"})
+		require.NoError(t, err)
+
+		require.Equal(t, "any-uid", res.Get("panels").GetIndex(0).GetPath("datasource", "uid").MustString())
+		require.Equal(t, "any-uid", res.Get("panels").GetIndex(1).GetPath("datasource", "uid").MustString())
+	})
+}
+
+func TestDashTemplateEvaluatorDatasourceReferences(t *testing.T) {
+	template, err := simplejson.NewJson([]byte(`{
+		"__inputs": [
+			{
+				"name": "DS_PROM",
+				"type": "datasource",
+				"pluginId": "prometheus"
+			}
+		],
+		"panels": [
+			{
+				"datasource": "${DS_PROM}",
+				"targets": [
+					{ "datasource": "${DS_PROM}", "expr": "up" }
+				]
+			},
+			{
+				"datasource": { "type": "prometheus", "uid": "${DS_PROM}" },
+				"targets": [
+					{ "datasource": { "type": "prometheus", "uid": "${DS_PROM}" }, "expr": "up" }
+				]
+			}
+		]
+	}`))
+	require.NoError(t, err)
+
+	inputs := []dashboardimport.ImportDashboardInput{
+		{Name: "*", Type: "datasource", Value: "My Prometheus"},
+	}
+
+	t.Run("should resolve object references to the datasource UID", func(t *testing.T) {
+		resolved := make([]string, 0)
+		res, err := NewDashTemplateEvaluator(template, inputs, func(input dashboardimport.ImportDashboardInput) (*dashboardimport.DatasourceRef, error) {
+			resolved = append(resolved, input.Value)
+			return &dashboardimport.DatasourceRef{UID: "prom-uid"}, nil
+		}).Eval()
+		require.NoError(t, err)
+		require.Equal(t, []string{"My Prometheus"}, resolved)
+
+		legacy := res.Get("panels").GetIndex(0)
+		require.Equal(t, "My Prometheus", legacy.Get("datasource").MustString())
+		require.Equal(t, "My Prometheus", legacy.Get("targets").GetIndex(0).Get("datasource").MustString())
+
+		panel := res.Get("panels").GetIndex(1)
+		require.Equal(t, "prom-uid", panel.GetPath("datasource", "uid").MustString())
+		require.Equal(t, "prometheus", panel.GetPath("datasource", "type").MustString())
+		require.Equal(t, "prom-uid", panel.Get("targets").GetIndex(0).GetPath("datasource", "uid").MustString())
+		require.Equal(t, "up", panel.Get("targets").GetIndex(0).Get("expr").MustString())
+	})
+
+	t.Run("should resolve the inputs with the declared plugin ID", func(t *testing.T) {
+		var resolved dashboardimport.ImportDashboardInput
+		res, err := NewDashTemplateEvaluator(template, inputs, func(input dashboardimport.ImportDashboardInput) (*dashboardimport.DatasourceRef, error) {
+			resolved = input
+			return &dashboardimport.DatasourceRef{UID: "mimir-uid", Type: "mimir"}, nil
+		}).Eval()
+		require.NoError(t, err)
+		require.Equal(t, dashboardimport.ImportDashboardInput{Name: "DS_PROM", Type: "datasource", PluginId: "prometheus", Value: "My Prometheus"}, resolved)
+
+		ref := res.Get("panels").GetIndex(1).Get("datasource")
+		require.Equal(t, "mimir-uid", ref.Get("uid").MustString())
+		require.Equal(t, "mimir", ref.Get("type").MustString())
+	})
+
+	t.Run("should keep the input value if no datasource is resolved", func(t *testing.T) {
+		res, err := NewDashTemplateEvaluator(template, inputs, func(input dashboardimport.ImportDashboardInput) (*dashboardimport.DatasourceRef, error) {
+			return nil, nil
+		}).Eval()
+		require.NoError(t, err)
+		require.Equal(t, "My Prometheus", res.Get("panels").GetIndex(1).GetPath("datasource", "uid").MustString())
+		require.Equal(t, "prometheus", res.Get("panels").GetIndex(1).GetPath("datasource", "type").MustString())
+	})
+
+	t.Run("should use the input value without resolver", func(t *testing.T) {
+		res, err := NewDashTemplateEvaluator(template, inputs, nil).Eval()
+		require.NoError(t, err)
+		require.Equal(t, "My Prometheus", res.Get("panels").GetIndex(1).GetPath("datasource", "uid").MustString())
+	})
+
+	t.Run("should fail if the datasource can not be resolved", func(t *testing.T) {
+		errLookup := errors.New("lookup failed")
+		_, err := NewDashTemplateEvaluator(template, inputs, func(input dashboardimport.ImportDashboardInput) (*dashboardimport.DatasourceRef, error) {
+			return nil, errLookup
+		}).Eval()
+		require.ErrorIs(t, err, errLookup)
+	})
+}
@@ -168,5 +168,35 @@ var (
 			State:           FeatureStateAlpha,
 			RequiresDevMode: true,
 		},
+		{
+			Name:            "fileStoreFs",
+			Description:     "Register the configured file system file storage backends",
+			State:           FeatureStateAlpha,
+			RequiresDevMode: true,
+		},
+		{
+			Name:            "fileStoreS3",
+			Description:     "Register the configured S3 file storage backends",
+			State:           FeatureStateAlpha,
+			RequiresDevMode: true,
+		},
+		{
+			Name:            "fileStoreGcs",
+			Description:     "Register the configured GCS file storage backends",
+			State:           FeatureStateAlpha,
+			RequiresDevMode: true,
+		},
+		{
+			Name:            "fileStoreAzure",
+			Description:     "Register the configured Azure Blob Storage file storage backends",
+			State:           FeatureStateAlpha,
+			RequiresDevMode: true,
+		},
+		{
+			Name:            "fileStoreDb",
+			Description:     "Register the configured database file storage backends",
+			State:           FeatureStateAlpha,
+			RequiresDevMode: true,
+		},
 	}
 )
@@ -126,4 +126,24 @@ const (
 	// FlagFileStoreApi
 	// Simple API for managing files
 	FlagFileStoreApi = "fileStoreApi"
+
+	// FlagFileStoreFs
+	// Register the configured file system file storage backends
+	FlagFileStoreFs = "fileStoreFs"
+
+	// FlagFileStoreS3
+	// Register the configured S3 file storage backends
+	FlagFileStoreS3 = "fileStoreS3"
+
+	// FlagFileStoreGcs
+	// Register the configured GCS file storage backends
+	FlagFileStoreGcs = "fileStoreGcs"
+
+	// FlagFileStoreAzure
+	// Register the configured Azure Blob Storage file storage backends
+	FlagFileStoreAzure = "fileStoreAzure"
+
+	// FlagFileStoreDb
+	// Register the configured database file storage backends
+	FlagFileStoreDb = "fileStoreDb"
 )
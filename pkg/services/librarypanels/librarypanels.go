@@ -31,7 +31,7 @@ type Service interface {
 	LoadLibraryPanelsForDashboard(c context.Context, dash *models.Dashboard) error
 	CleanLibraryPanelsForDashboard(dash *models.Dashboard) error
 	ConnectLibraryPanelsForDashboard(c context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard) error
-	ImportLibraryPanelsForDashboard(c context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard, folderID int64) error
+	ImportLibraryPanelsForDashboard(c context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard, folderID int64) (*ImportLibraryPanelsResult, error)
 }
 
 // LibraryPanelService is the service for the Panel Library feature.
@@ -250,11 +250,20 @@ func connectLibraryPanelsRecursively(c context.Context, panels []interface{}, li
 }
 
 // ImportLibraryPanelsForDashboard loops through all panels in dashboard JSON and creates any missing library panels in the database.
-func (lps *LibraryPanelService) ImportLibraryPanelsForDashboard(c context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard, folderID int64) error {
-	return importLibraryPanelsRecursively(c, lps.LibraryElementService, signedInUser, dash.Data, folderID)
+// It returns the library panels which were created and the ones which already existed.
+func (lps *LibraryPanelService) ImportLibraryPanelsForDashboard(c context.Context, signedInUser *models.SignedInUser, dash *models.Dashboard, folderID int64) (*ImportLibraryPanelsResult, error) {
+	result := &ImportLibraryPanelsResult{
+		Created:  make([]string, 0),
+		Existing: make([]string, 0),
+	}
+	if err := importLibraryPanelsRecursively(c, lps.LibraryElementService, signedInUser, dash.Data, folderID, result, make(map[string]bool)); err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
-func importLibraryPanelsRecursively(c context.Context, service libraryelements.Service, signedInUser *models.SignedInUser, parent *simplejson.Json, folderID int64) error {
+func importLibraryPanelsRecursively(c context.Context, service libraryelements.Service, signedInUser *models.SignedInUser, parent *simplejson.Json, folderID int64, result *ImportLibraryPanelsResult, seen map[string]bool) error {
 	panels := parent.Get("panels").MustArray()
 	for _, panel := range panels {
 		panelAsJSON := simplejson.NewFromAny(panel)
@@ -266,7 +275,7 @@ func importLibraryPanelsRecursively(c context.Context, service libraryelements.S
 
 		// we have a row
 		if panelType == "row" {
-			err := importLibraryPanelsRecursively(c, service, signedInUser, panelAsJSON, folderID)
+			err := importLibraryPanelsRecursively(c, service, signedInUser, panelAsJSON, folderID, result, seen)
 			if err != nil {
 				return err
 			}
@@ -283,8 +292,15 @@ func importLibraryPanelsRecursively(c context.Context, service libraryelements.S
 			return errLibraryPanelHeaderNameMissing
 		}
 
+		// the same library panel can be used several times in a dashboard
+		if seen[UID] {
+			continue
+		}
+		seen[UID] = true
+
 		_, err := service.GetElement(c, signedInUser, UID)
 		if err == nil {
+			result.Existing = append(result.Existing, UID)
 			continue
 		}
 		if errors.Is(err, libraryelements.ErrLibraryElementNotFound) {
@@ -310,6 +326,7 @@ func importLibraryPanelsRecursively(c context.Context, service libraryelements.S
 				return err
 			}
 
+			result.Created = append(result.Created, UID)
 			continue
 		}
 
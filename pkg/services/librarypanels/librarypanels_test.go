@@ -1092,7 +1092,7 @@ func TestImportLibraryPanelsForDashboard(t *testing.T) {
 			_, err := sc.elementService.GetElement(sc.ctx, sc.user, missingUID)
 			require.EqualError(t, err, libraryelements.ErrLibraryElementNotFound.Error())
 
-			err = sc.service.ImportLibraryPanelsForDashboard(sc.ctx, sc.user, dashInDB, 0)
+			_, err = sc.service.ImportLibraryPanelsForDashboard(sc.ctx, sc.user, dashInDB, 0)
 			require.NoError(t, err)
 
 			element, err := sc.elementService.GetElement(sc.ctx, sc.user, missingUID)
@@ -1141,8 +1141,10 @@ func TestImportLibraryPanelsForDashboard(t *testing.T) {
 			_, err := sc.elementService.GetElement(sc.ctx, sc.user, existingUID)
 			require.NoError(t, err)
 
-			err = sc.service.ImportLibraryPanelsForDashboard(sc.ctx, sc.user, dashInDB, sc.folder.Id)
+			importResult, err := sc.service.ImportLibraryPanelsForDashboard(sc.ctx, sc.user, dashInDB, sc.folder.Id)
 			require.NoError(t, err)
+			require.Empty(t, importResult.Created)
+			require.Equal(t, []string{existingUID}, importResult.Existing)
 
 			element, err := sc.elementService.GetElement(sc.ctx, sc.user, existingUID)
 			require.NoError(t, err)
@@ -1157,6 +1159,48 @@ func TestImportLibraryPanelsForDashboard(t *testing.T) {
 			}
 		})
 
+	scenarioWithLibraryPanel(t, "When an admin tries to import a dashboard with an existing and a missing library panel, it should report which library panels were created",
+		func(t *testing.T, sc scenarioContext) {
+			var existingUID = sc.initialResult.Result.UID
+			var missingUID = "jL6MrxCMz"
+
+			dashJSON := map[string]interface{}{
+				"panels": []interface{}{
+					map[string]interface{}{
+						"id": int64(1),
+						"libraryPanel": map[string]interface{}{
+							"uid":  existingUID,
+							"name": sc.initialResult.Result.Name,
+						},
+						"title": "Existing",
+						"type":  "text",
+					},
+					map[string]interface{}{
+						"id": int64(2),
+						"libraryPanel": map[string]interface{}{
+							"uid":  missingUID,
+							"name": "Missing Library Panel",
+						},
+						"title": "Missing",
+						"type":  "text",
+					},
+				},
+			}
+			dash := models.Dashboard{
+				Title: "Testing ImportLibraryPanelsForDashboard",
+				Data:  simplejson.NewFromAny(dashJSON),
+			}
+			dashInDB := createDashboard(t, sc.sqlStore, sc.user, &dash, sc.folder.Id)
+
+			importResult, err := sc.service.ImportLibraryPanelsForDashboard(sc.ctx, sc.user, dashInDB, sc.folder.Id)
+			require.NoError(t, err)
+			require.Equal(t, []string{missingUID}, importResult.Created)
+			require.Equal(t, []string{existingUID}, importResult.Existing)
+
+			_, err = sc.elementService.GetElement(sc.ctx, sc.user, missingUID)
+			require.NoError(t, err)
+		})
+
 	testScenario(t, "When an admin tries to import a dashboard with library panels inside and outside of rows, it should import all that do not exist",
 		func(t *testing.T, sc scenarioContext) {
 			var outsideUID = "jL6MrxCMz"
@@ -1243,7 +1287,7 @@ func TestImportLibraryPanelsForDashboard(t *testing.T) {
 			_, err = sc.elementService.GetElement(sc.ctx, sc.user, insideUID)
 			require.EqualError(t, err, libraryelements.ErrLibraryElementNotFound.Error())
 
-			err = sc.service.ImportLibraryPanelsForDashboard(sc.ctx, sc.user, dashInDB, 0)
+			_, err = sc.service.ImportLibraryPanelsForDashboard(sc.ctx, sc.user, dashInDB, 0)
 			require.NoError(t, err)
 
 			element, err := sc.elementService.GetElement(sc.ctx, sc.user, outsideUID)
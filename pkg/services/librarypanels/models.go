@@ -10,3 +10,11 @@ var (
 	// errLibraryPanelHeaderNameMissing is an error for when a library panel header is missing the name property.
 	errLibraryPanelHeaderNameMissing = errors.New("library panel header is missing required property name")
 )
+
+// ImportLibraryPanelsResult lists the UIDs of the library panels of an imported dashboard, in the order they appear in the dashboard.
+type ImportLibraryPanelsResult struct {
+	// Created are the library panels which did not exist and were created by the import.
+	Created []string
+	// Existing are the library panels which already existed and are reused by the dashboard.
+	Existing []string
+}
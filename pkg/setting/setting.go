@@ -278,6 +278,9 @@ type Cfg struct {
 
 	// Dashboards
 	DefaultHomeDashboardPath string
+	// DashboardImportAllowedHosts restricts the hosts dashboards can be imported from by URL, any host is allowed
+	// when empty.
+	DashboardImportAllowedHosts []string
 
 	// Auth
 	LoginCookieName              string
@@ -903,6 +906,7 @@ func (cfg *Cfg) Load(args CommandLineArgs) error {
 	MinRefreshInterval = valueAsString(dashboards, "min_refresh_interval", "5s")
 
 	cfg.DefaultHomeDashboardPath = dashboards.Key("default_home_dashboard_path").MustString("")
+	cfg.DashboardImportAllowedHosts = util.SplitString(dashboards.Key("import_url_allowed_hosts").MustString(""))
 
 	if err := readUserSettings(iniFile, cfg); err != nil {
 		return err